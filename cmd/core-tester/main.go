@@ -3,16 +3,31 @@ package main
 import (
 	"context"
 	"fmt"
+	"math"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/ellanetworks/core-tester/internal/config"
+	"github.com/ellanetworks/core-tester/internal/control"
+	"github.com/ellanetworks/core-tester/internal/gnb"
 	"github.com/ellanetworks/core-tester/internal/logger"
 	"github.com/ellanetworks/core-tester/internal/register"
+	"github.com/ellanetworks/core-tester/internal/serve"
+	"github.com/ellanetworks/core-tester/internal/shell"
+	"github.com/ellanetworks/core-tester/internal/trace"
 	nasLogger "github.com/free5gc/nas/logger"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 var (
+	configPath        string
+	subscribersPath   string
+	configFile        *config.File
+	configSubscriber  *config.Subscriber
 	imsi              string
 	key               string
 	opc               string
@@ -26,74 +41,171 @@ var (
 	dnn               string
 	gnbN2Address      string
 	gnbN3Address      string
+	gnbN3AddressV6    string
 	ellaCoreN2Address string
+	neighborGnbID     string
+	neighborN2Address string
+	neighborN3Address string
+	neighborN3AddrV6  string
 	pduSessionType    string
+	sscMode           uint8
+	allowedSSCModes   []uint
+	unstructuredAddr  string
+	resolvConfPath    string
+	systemdResolved   bool
+	ipv6SLAAC         bool
+	netnsPerUE        bool
+	gtpEchoInterval   time.Duration
+	releaseOnErrInd   bool
+	trafficDest       string
+	trafficProtocol   string
+	trafficRate       int
+	trafficSize       int
+	trafficDuration   time.Duration
+	n3Workers         int
+	tunQueues         int
+	n3BatchSize       int
+	userspace         bool
+	socks5Address     string
+	kernelGTP         bool
+	pcapPath          string
+	tracePath         string
+	traceFormat       string
+	metricsAddress    string
+	latencyReport     string
+	junitPath         string
+	resultsPath       string
+	otelEndpoint      string
+	otelFile          string
+	provision         bool
+	apiAddress        string
+	apiToken          string
+	apiEmail          string
+	apiPassword       string
+	apiInsecure       bool
+	controlAddress    string
+	allSubscribers    bool
+	concurrency       int
 	verbose           bool
+	logFormat         string
+	logFile           string
+	logMaxSize        int
+	logMaxBackups     int
+	logMaxAge         int
+	logLevels         map[string]string
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "ella-core-tester [command]",
 	Short: "A tool for testing Ella Core",
 	Long:  `Ella Core Tester validates functionality, connectivity, and performance.`,
-	PersistentPreRun: func(cmd *cobra.Command, args []string) {
-		if verbose {
-			logger.Init(zap.DebugLevel)
-		} else {
-			logger.Init(zap.InfoLevel)
-		}
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return initLogger()
 	},
 }
 
 var registerCmd = &cobra.Command{
-	Use:   "register",
-	Short: "Register a subscriber in Ella Core and create a GTP tunnel",
-	Long:  "Register a subscriber in Ella Core and create a GTP tunnel. The subscriber needs to already be created in Ella Core. This procedure will not try to create and delete resources in Ella Core.",
-	Args:  cobra.NoArgs,
-	Run:   Register,
+	Use:     "register",
+	Short:   "Register a subscriber in Ella Core and create a GTP tunnel",
+	Long:    "Register a subscriber in Ella Core and create a GTP tunnel. The subscriber needs to already be created in Ella Core, unless --provision creates it, with its policy, through the Ella Core API and deletes them at the end of the run.",
+	Args:    cobra.NoArgs,
+	PreRunE: applyConfig,
+	Run:     Register,
+}
+
+var serveCmd = &cobra.Command{
+	Use:     "serve",
+	Short:   "Keep a gNB associated with Ella Core and control its UEs through a local HTTP API",
+	Long:    "Keep a gNB associated with Ella Core and control its UEs through a local HTTP API: add UEs, register and deregister them, establish PDU sessions, and move them to idle and back with a Service Request. Only the control plane runs; no GTP tunnel is created.",
+	Args:    cobra.NoArgs,
+	PreRunE: applyServeConfig,
+	Run:     Serve,
+}
+
+var shellCmd = &cobra.Command{
+	Use:     "shell",
+	Short:   "Keep a gNB associated with Ella Core and run the procedures of its UEs from an interactive prompt",
+	Long:    "Keep a gNB associated with Ella Core and run the procedures of its UEs from an interactive prompt, one command at a time, such as \"ue add\", \"ue 1 register\", \"ue 1 pdu add ims\", \"ue 1 idle\", \"gnb reset\" and \"show sessions\". Only the control plane runs; no GTP tunnel is created.",
+	Args:    cobra.NoArgs,
+	PreRunE: applyServeConfig,
+	Run:     Shell,
+}
+
+var traceCmd = &cobra.Command{
+	Use:   "trace [file]",
+	Short: "Render a message trace recorded with register --trace",
+	Long:  "Render a message trace recorded with register --trace as a text ladder diagram or a Mermaid sequence diagram.",
+	Args:  cobra.ExactArgs(1),
+	RunE:  Trace,
 }
 
 func main() {
 	nasLogger.SetLogLevel(0)
 
 	rootCmd.AddCommand(registerCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(shellCmd)
+	rootCmd.AddCommand(traceCmd)
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose (debug) logging")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logger.FormatConsole, "Log format: console or json")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "Write logs to this file instead of stdout, rotating it by size")
+	rootCmd.PersistentFlags().IntVar(&logMaxSize, "log-max-size", 100, "Size in megabytes at which the log file is rotated")
+	rootCmd.PersistentFlags().IntVar(&logMaxBackups, "log-max-backups", 5, "Number of rotated log files to keep (0 keeps them all)")
+	rootCmd.PersistentFlags().IntVar(&logMaxAge, "log-max-age", 0, "Days to keep rotated log files (0 keeps them regardless of age)")
+	rootCmd.PersistentFlags().StringToStringVar(&logLevels, "log-level", nil, "Log level per component, overriding --verbose: gnb, ue and ngap, for example gnb=debug,ngap=error")
 
+	addNetworkFlags(registerCmd)
 	registerCmd.Flags().StringVar(&imsi, "imsi", "", "IMSI of the subscriber")
 	registerCmd.Flags().StringVar(&key, "key", "", "Key of the subscriber")
 	registerCmd.Flags().StringVar(&opc, "opc", "", "OPC of the subscriber")
 	registerCmd.Flags().StringVar(&sqn, "sqn", "", "SQN of the subscriber")
 	registerCmd.Flags().StringVar(&profileName, "profile-name", "", "Profile name of the subscriber")
-	registerCmd.Flags().StringVar(&mcc, "mcc", "", "MCC of the subscriber")
-	registerCmd.Flags().StringVar(&mnc, "mnc", "", "MNC of the subscriber")
-	registerCmd.Flags().Int32Var(&sst, "sst", 0, "SST of the subscriber")
-	registerCmd.Flags().StringVar(&sd, "sd", "", "SD of the subscriber")
-	registerCmd.Flags().StringVar(&tac, "tac", "", "TAC of the subscriber")
-	registerCmd.Flags().StringVar(&dnn, "dnn", "dnn", "DNN of the subscriber")
-	registerCmd.Flags().StringVar(&gnbN2Address, "gnb-n2-address", "", "gNB N2 address")
-	registerCmd.Flags().StringVar(&gnbN3Address, "gnb-n3-address", "", "gNB N3 address")
-	registerCmd.Flags().StringVar(&ellaCoreN2Address, "ella-core-n2-address", "", "Ella Core N2 address")
-	registerCmd.Flags().StringVar(&pduSessionType, "pdu-session-type", "ipv4", "PDU session type: ipv4, ipv6, or ipv4v6")
-
-	for _, name := range []string{
-		"imsi",
-		"key",
-		"opc",
-		"sqn",
-		"profile-name",
-		"mcc",
-		"mnc",
-		"sst",
-		"tac",
-		"dnn",
-		"gnb-n2-address",
-		"gnb-n3-address",
-		"ella-core-n2-address",
-		"pdu-session-type",
-	} {
-		if err := registerCmd.MarkFlagRequired(name); err != nil {
-			panic(fmt.Sprintf("failed to mark flag %q required: %v", name, err))
-		}
-	}
+	registerCmd.Flags().Uint8Var(&sscMode, "ssc-mode", 0, "SSC mode to request: 1, 2, or 3 (0 lets the network select it)")
+	registerCmd.Flags().UintSliceVar(&allowedSSCModes, "allowed-ssc-modes", []uint{1, 2, 3}, "SSC modes the network may select when --ssc-mode is not set")
+	registerCmd.Flags().StringVar(&unstructuredAddr, "unstructured-address", "udp:127.0.0.1:9000", "Local socket carrying Unstructured session payloads: udp:<host:port> or unix:<path>")
+	registerCmd.Flags().StringVar(&resolvConfPath, "resolv-conf", "", "Write the DNS servers assigned by Ella Core to this resolv.conf file")
+	registerCmd.Flags().BoolVar(&netnsPerUE, "netns", false, "Place the UE's TUN interface in its own network namespace named ue-<imsi>, with a default route through the tunnel")
+	registerCmd.Flags().BoolVar(&ipv6SLAAC, "ipv6-slaac", false, "Solicit a Router Advertisement on IPv6 sessions and configure the address, default route and MTU from it")
+	registerCmd.Flags().DurationVar(&gtpEchoInterval, "gtp-echo-interval", 0, "Send GTP-U Echo Requests to the UPF at this interval to monitor the N3 path (0 disables)")
+	registerCmd.Flags().BoolVar(&releaseOnErrInd, "release-on-error-indication", false, "Send a UE Context Release Request when the UPF reports the tunnel with a GTP-U Error Indication")
+	registerCmd.Flags().StringVar(&trafficDest, "traffic-destination", "", "Run the built-in traffic generator against this reflector (<host>:<port> for UDP, <host> for ICMP) once the tunnel is up, then exit")
+	registerCmd.Flags().StringVar(&trafficProtocol, "traffic-protocol", "udp", "Traffic generator protocol: udp or icmp")
+	registerCmd.Flags().IntVar(&trafficRate, "traffic-rate", 100, "Traffic generator rate in packets per second (at most 1000000)")
+	registerCmd.Flags().IntVar(&trafficSize, "traffic-payload-size", 64, "Traffic generator payload size in bytes (at least 24, and small enough for the packets to fit in the MTU of the PDU session)")
+	registerCmd.Flags().DurationVar(&trafficDuration, "traffic-duration", 10*time.Second, "Traffic generator duration")
+	registerCmd.Flags().IntVar(&n3Workers, "n3-workers", 1, "Number of goroutines receiving from and sending to the N3 socket")
+	registerCmd.Flags().IntVar(&tunQueues, "tun-queues", 1, "Number of queues opened on the TUN interface, each read by its own goroutine")
+	registerCmd.Flags().IntVar(&n3BatchSize, "n3-batch-size", 32, "Maximum number of GTP-U packets per recvmmsg/sendmmsg system call")
+	registerCmd.Flags().BoolVar(&userspace, "userspace", false, "Run the UE's IP stack in userspace instead of creating a TUN interface; no root privileges are needed for the user plane")
+	registerCmd.Flags().StringVar(&socks5Address, "socks5-address", "127.0.0.1:1080", "Address of the SOCKS5 proxy that connects from the UE address in --userspace mode")
+	registerCmd.Flags().BoolVar(&kernelGTP, "kernel-gtp", false, "Forward the user plane of IPv4 sessions with the Linux kernel GTP module instead of in the tester (no QFI is sent uplink)")
+	registerCmd.Flags().StringVar(&pcapPath, "pcap", "", "Write the N2 and N3 traffic to this pcapng file")
+	registerCmd.Flags().StringVar(&tracePath, "trace", "", "Record every NGAP and NAS message to this file as JSON lines")
+	registerCmd.Flags().StringVar(&metricsAddress, "metrics-address", "", "Serve Prometheus metrics on /metrics at this address, such as :9090")
+	registerCmd.Flags().StringVar(&latencyReport, "latency-report", "", "Write the latency of each procedure leg, with percentiles, to this JSON file")
+	registerCmd.Flags().StringVar(&junitPath, "junit", "", "Write the outcome and duration of each step of the run to this JUnit XML file")
+	registerCmd.Flags().StringVar(&resultsPath, "results-json", "", "Write the outcome and duration of each step of the run to this JSON file")
+	registerCmd.Flags().StringVar(&otelEndpoint, "otel-endpoint", "", "Export the UE procedures as OpenTelemetry spans to this OTLP gRPC collector, such as http://localhost:4317")
+	registerCmd.Flags().StringVar(&otelFile, "otel-file", "", "Write the UE procedures as OpenTelemetry spans to this file, as JSON lines")
+	registerCmd.Flags().BoolVar(&provision, "provision", false, "Create the policy and the subscriber through the Ella Core API before the run and delete them afterwards")
+	registerCmd.Flags().StringVar(&apiAddress, "ella-core-api-address", "", "Ella Core API address, such as https://10.0.0.1:5002")
+	registerCmd.Flags().StringVar(&apiToken, "ella-core-api-token", os.Getenv("ELLA_CORE_API_TOKEN"), "Ella Core API token (defaults to $ELLA_CORE_API_TOKEN)")
+	registerCmd.Flags().StringVar(&apiEmail, "ella-core-api-email", "", "Email of an Ella Core user, to log in when no API token is given")
+	registerCmd.Flags().StringVar(&apiPassword, "ella-core-api-password", os.Getenv("ELLA_CORE_API_PASSWORD"), "Password of the Ella Core user (defaults to $ELLA_CORE_API_PASSWORD)")
+	registerCmd.Flags().BoolVar(&apiInsecure, "ella-core-api-insecure", false, "Skip the verification of the Ella Core API TLS certificate")
+	registerCmd.Flags().BoolVar(&allSubscribers, "all-subscribers", false, "Register every subscriber of --config and --subscribers on one gNB, control plane only, and keep them registered until interrupted")
+	registerCmd.Flags().IntVar(&concurrency, "concurrency", 10, "Number of subscribers registering at the same time with --all-subscribers")
+	registerCmd.Flags().BoolVar(&systemdResolved, "systemd-resolved", false, "Configure the DNS servers assigned by Ella Core on the tunnel interface through systemd-resolved")
+
+	addNetworkFlags(serveCmd)
+	addNeighborFlags(serveCmd)
+	serveCmd.Flags().StringVar(&controlAddress, "api-address", "127.0.0.1:9876", "Address of the HTTP API controlling the UEs")
+	serveCmd.Flags().StringVar(&metricsAddress, "metrics-address", "", "Serve Prometheus metrics on /metrics at this address, such as :9090")
+
+	addNetworkFlags(shellCmd)
+	addNeighborFlags(shellCmd)
+
+	traceCmd.Flags().StringVar(&traceFormat, "format", "text", "Output format: text or mermaid")
 
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
 
@@ -107,26 +219,486 @@ func main() {
 func Register(cmd *cobra.Command, args []string) {
 	ctx := context.Background()
 
+	if allSubscribers {
+		err := control.RegisterAll(ctx, control.RegisterAllOpts{
+			Control:        controlConfig(),
+			Concurrency:    concurrency,
+			MetricsAddress: metricsAddress,
+		})
+		if err != nil {
+			logger.Logger.Fatal("Could not register subscribers", zap.Error(err))
+		}
+
+		return
+	}
+
+	allowedModes, err := toUint8s(allowedSSCModes)
+	if err != nil {
+		logger.Logger.Fatal("Invalid allowed SSC modes", zap.Error(err))
+	}
+
 	registerConfig := register.Config{
-		IMSI:              imsi,
-		Key:               key,
-		OPC:               opc,
-		SequenceNumber:    sqn,
-		ProfileName:       profileName,
+		IMSI:                imsi,
+		Key:                 key,
+		OPC:                 opc,
+		SequenceNumber:      sqn,
+		ProfileName:         profileName,
+		MCC:                 mcc,
+		MNC:                 mnc,
+		SST:                 sst,
+		SD:                  sd,
+		TAC:                 tac,
+		DNN:                 dnn,
+		GnbN2Address:        gnbN2Address,
+		GnbN3Address:        gnbN3Address,
+		GnbN3AddressV6:      gnbN3AddressV6,
+		EllaCoreN2Address:   ellaCoreN2Address,
+		PDUSessionType:      pduSessionType,
+		SSCMode:             sscMode,
+		AllowedSSCModes:     allowedModes,
+		UnstructuredAddress: unstructuredAddr,
+		ResolvConfPath:      resolvConfPath,
+		SystemdResolved:     systemdResolved,
+		IPv6SLAAC:           ipv6SLAAC,
+		Namespace:           netnsPerUE,
+		GTPEchoInterval:     gtpEchoInterval,
+
+		ReleaseOnErrorIndication: releaseOnErrInd,
+		TrafficDestination:       trafficDest,
+		TrafficProtocol:          trafficProtocol,
+		TrafficRate:              trafficRate,
+		TrafficPayloadSize:       trafficSize,
+		TrafficDuration:          trafficDuration,
+		Userspace:                userspace,
+		SOCKS5Address:            socks5Address,
+		KernelGTP:                kernelGTP,
+		PcapPath:                 pcapPath,
+		TracePath:                tracePath,
+		MetricsAddress:           metricsAddress,
+		LatencyReportPath:        latencyReport,
+		JUnitPath:                junitPath,
+		ResultsPath:              resultsPath,
+		OTelEndpoint:             otelEndpoint,
+		OTelFile:                 otelFile,
+		DataPath: gnb.DataPathOpts{
+			Workers:   n3Workers,
+			TUNQueues: tunQueues,
+			BatchSize: n3BatchSize,
+		},
+	}
+
+	if configFile != nil {
+		registerConfig.GnbID = configFile.GnodeB.ID
+		registerConfig.GnbName = configFile.GnodeB.Name
+		registerConfig.Slices = configFile.GnbSlices()
+	}
+
+	if provision {
+		registerConfig.Provision = &register.ProvisionConfig{
+			APIAddress: apiAddress,
+			Token:      apiToken,
+			Email:      apiEmail,
+			Password:   apiPassword,
+			Insecure:   apiInsecure,
+		}
+	}
+
+	if configSubscriber != nil && configSubscriber.IMSI == imsi {
+		registerConfig.SecurityCapability = configSubscriber.SecurityCapability()
+	}
+
+	err = register.Run(ctx, registerConfig)
+	if err != nil {
+		logger.Logger.Fatal("Could not register", zap.Error(err))
+	}
+}
+
+func Serve(cmd *cobra.Command, args []string) {
+	err := serve.Run(context.Background(), serve.Config{
+		Control:        controlConfig(),
+		Address:        controlAddress,
+		MetricsAddress: metricsAddress,
+	})
+	if err != nil {
+		logger.Logger.Fatal("Could not serve", zap.Error(err))
+	}
+}
+
+func Shell(cmd *cobra.Command, args []string) {
+	err := shell.Run(context.Background(), controlConfig(), cmd.InOrStdin(), cmd.OutOrStdout())
+	if err != nil {
+		logger.Logger.Fatal("Could not run shell", zap.Error(err))
+	}
+}
+
+// controlConfig returns the gNB and network settings of serve and shell.
+func controlConfig() control.Config {
+	cfg := control.Config{
 		MCC:               mcc,
 		MNC:               mnc,
+		TAC:               tac,
 		SST:               sst,
 		SD:                sd,
-		TAC:               tac,
 		DNN:               dnn,
 		GnbN2Address:      gnbN2Address,
 		GnbN3Address:      gnbN3Address,
+		GnbN3AddressV6:    gnbN3AddressV6,
 		EllaCoreN2Address: ellaCoreN2Address,
 		PDUSessionType:    pduSessionType,
 	}
 
-	err := register.Run(ctx, registerConfig)
+	if neighborN3Address != "" {
+		cfg.Neighbor = &control.NeighborConfig{
+			GnbID:          neighborGnbID,
+			GnbN2Address:   neighborN2Address,
+			GnbN3Address:   neighborN3Address,
+			GnbN3AddressV6: neighborN3AddrV6,
+		}
+	}
+
+	if f := configFile; f != nil {
+		cfg.GnbID = f.GnodeB.ID
+		cfg.GnbName = f.GnodeB.Name
+
+		if cfg.Neighbor != nil {
+			cfg.Neighbor.GnbName = f.Neighbor.Name
+		}
+		cfg.Slices = f.GnbSlices()
+
+		for i := range f.Subscribers {
+			cfg.Subscribers = append(cfg.Subscribers, f.Subscription(&f.Subscribers[i]))
+		}
+	}
+
+	return cfg
+}
+
+func Trace(cmd *cobra.Command, args []string) error {
+	f, err := os.Open(args[0])
 	if err != nil {
-		logger.Logger.Fatal("Could not register", zap.Error(err))
+		return fmt.Errorf("could not open trace: %v", err)
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	events, err := trace.Read(f)
+	if err != nil {
+		return err
 	}
+
+	switch traceFormat {
+	case "text":
+		return trace.WriteText(cmd.OutOrStdout(), events)
+	case "mermaid":
+		return trace.WriteMermaid(cmd.OutOrStdout(), events)
+	default:
+		return fmt.Errorf("invalid trace format %q: must be text or mermaid", traceFormat)
+	}
+}
+
+// requiredRegisterFlags must be set on the command line or in the config
+// file.
+var requiredRegisterFlags = []string{
+	"imsi",
+	"key",
+	"opc",
+	"sqn",
+	"profile-name",
+	"mcc",
+	"mnc",
+	"sst",
+	"tac",
+	"dnn",
+	"gnb-n2-address",
+	"gnb-n3-address",
+	"ella-core-n2-address",
+	"pdu-session-type",
+}
+
+// requiredServeFlags, also required by shell, must be set on the command line or in the config file.
+var requiredServeFlags = []string{
+	"mcc",
+	"mnc",
+	"sst",
+	"tac",
+	"gnb-n2-address",
+	"ella-core-n2-address",
+}
+
+// addNetworkFlags adds the flags describing the gNB and the network, shared
+// by register and serve.
+func addNetworkFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&configPath, "config", "", "YAML file with the gNB, network and subscriber settings; flags override its values")
+	cmd.Flags().StringVar(&subscribersPath, "subscribers", "", "CSV file or Ella Core subscriber export (.json) to select the subscriber from with --imsi")
+	cmd.Flags().StringVar(&mcc, "mcc", "", "MCC of the subscriber")
+	cmd.Flags().StringVar(&mnc, "mnc", "", "MNC of the subscriber")
+	cmd.Flags().Int32Var(&sst, "sst", 0, "SST of the subscriber")
+	cmd.Flags().StringVar(&sd, "sd", "", "SD of the subscriber")
+	cmd.Flags().StringVar(&tac, "tac", "", "TAC of the subscriber")
+	cmd.Flags().StringVar(&dnn, "dnn", "dnn", "DNN of the subscriber")
+	cmd.Flags().StringVar(&gnbN2Address, "gnb-n2-address", "", "gNB N2 address")
+	cmd.Flags().StringVar(&gnbN3Address, "gnb-n3-address", "", "gNB N3 address (IPv4 or IPv6)")
+	cmd.Flags().StringVar(&gnbN3AddressV6, "gnb-n3-address-v6", "", "IPv6 N3 address of a dual-stack gNB, next to an IPv4 --gnb-n3-address")
+	cmd.Flags().StringVar(&ellaCoreN2Address, "ella-core-n2-address", "", "Ella Core N2 address")
+	cmd.Flags().StringVar(&pduSessionType, "pdu-session-type", "ipv4", "PDU session type: ipv4, ipv6, ipv4v6, ethernet, or unstructured")
+}
+
+// addNeighborFlags adds the flags of the neighbour gNB that serve and shell
+// hand UEs over to.
+func addNeighborFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&neighborGnbID, "neighbor-gnb-id", "", "ID of the neighbour gNB (defaults to 000009)")
+	cmd.Flags().StringVar(&neighborN2Address, "neighbor-gnb-n2-address", "", "N2 address of the neighbour gNB (defaults to --gnb-n2-address)")
+	cmd.Flags().StringVar(&neighborN3Address, "neighbor-gnb-n3-address", "", "N3 address of the neighbour gNB, which starts it so that UEs can be handed over to it")
+	cmd.Flags().StringVar(&neighborN3AddrV6, "neighbor-gnb-n3-address-v6", "", "IPv6 N3 address of a dual-stack neighbour gNB, next to an IPv4 --neighbor-gnb-n3-address")
+}
+
+// applyConfig sets the register flags that were not given on the command
+// line from the config and subscribers files, then checks that the required
+// ones are set.
+func applyConfig(cmd *cobra.Command, args []string) error {
+	if allSubscribers {
+		return applyAllSubscribersConfig(cmd, args)
+	}
+
+	err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	if f := configFile; f != nil {
+		s, err := f.Subscriber(imsi)
+		if err != nil {
+			return err
+		}
+
+		configSubscriber = s
+
+		values := append(networkValues(cmd, f, s), [2]string{"ella-core-api-address", f.EllaCore.APIAddress})
+
+		if s != nil {
+			values = append(values, [][2]string{
+				{"imsi", s.IMSI},
+				{"key", s.Key},
+				{"opc", s.OPC},
+				{"sqn", s.SQN},
+				{"profile-name", s.ProfileName},
+				{"dnn", s.DNN},
+				{"pdu-session-type", s.PDUSessionType},
+			}...)
+		}
+
+		err = setFlags(cmd, values)
+		if err != nil {
+			return err
+		}
+	}
+
+	required := requiredRegisterFlags
+	if provision {
+		required = append(required[:len(required):len(required)], "ella-core-api-address")
+	}
+
+	return checkRequired(cmd, required)
+}
+
+// singleSubscriberFlags select or configure the subscriber of a register
+// run and its user plane, which --all-subscribers does not run.
+var singleSubscriberFlags = []string{
+	"imsi", "key", "opc", "sqn", "profile-name",
+	"ssc-mode", "allowed-ssc-modes", "unstructured-address", "resolv-conf", "systemd-resolved",
+	"netns", "ipv6-slaac", "gtp-echo-interval", "release-on-error-indication",
+	"traffic-destination", "userspace", "kernel-gtp", "pcap", "trace",
+	"latency-report", "junit", "results-json", "otel-endpoint", "otel-file", "provision",
+}
+
+// applyAllSubscribersConfig checks the flags of register --all-subscribers,
+// which takes the network from the flags or the config file, as serve does,
+// and the subscribers from the files.
+func applyAllSubscribersConfig(cmd *cobra.Command, args []string) error {
+	for _, name := range singleSubscriberFlags {
+		if cmd.Flags().Changed(name) {
+			return fmt.Errorf("--%s cannot be used with --all-subscribers", name)
+		}
+	}
+
+	if concurrency < 1 {
+		return fmt.Errorf("invalid concurrency %d: must be at least 1", concurrency)
+	}
+
+	err := applyServeConfig(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	if configFile == nil || len(configFile.Subscribers) == 0 {
+		return fmt.Errorf("--all-subscribers requires subscribers in the --config or --subscribers file")
+	}
+
+	return nil
+}
+
+// applyServeConfig sets the serve and shell flags that were not given on
+// the command line from the config file, then checks that the required ones
+// are set.
+func applyServeConfig(cmd *cobra.Command, _ []string) error {
+	err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	if f := configFile; f != nil {
+		err = setFlags(cmd, append(networkValues(cmd, f, nil), [][2]string{
+			{"neighbor-gnb-id", f.Neighbor.ID},
+			{"neighbor-gnb-n2-address", f.Neighbor.N2Address},
+			{"neighbor-gnb-n3-address", f.Neighbor.N3Address},
+			{"neighbor-gnb-n3-address-v6", f.Neighbor.N3AddressV6},
+		}...))
+		if err != nil {
+			return err
+		}
+	}
+
+	if neighborN3Address == "" && (neighborGnbID != "" || neighborN2Address != "" || neighborN3AddrV6 != "") {
+		return fmt.Errorf("--neighbor-gnb-n3-address is required for a neighbour gNB")
+	}
+
+	return checkRequired(cmd, requiredServeFlags)
+}
+
+// loadConfig reads the config and subscribers files into configFile.
+func loadConfig() error {
+	if configPath != "" {
+		f, err := config.Load(configPath)
+		if err != nil {
+			return err
+		}
+
+		configFile = f
+	}
+
+	if subscribersPath != "" {
+		subscribers, err := config.ReadSubscribers(subscribersPath)
+		if err != nil {
+			return err
+		}
+
+		if configFile == nil {
+			configFile = &config.File{}
+		}
+
+		err = configFile.AddSubscribers(subscribers)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// networkValues returns the network flags of the config file, with the slice
+// of subscriber s.
+func networkValues(cmd *cobra.Command, f *config.File, s *config.Subscriber) [][2]string {
+	flags := cmd.Flags()
+
+	values := [][2]string{
+		{"mcc", f.PLMN.MCC},
+		{"mnc", f.PLMN.MNC},
+		{"tac", f.TAC},
+		{"gnb-n2-address", f.GnodeB.N2Address},
+		{"gnb-n3-address", f.GnodeB.N3Address},
+		{"gnb-n3-address-v6", f.GnodeB.N3AddressV6},
+		{"ella-core-n2-address", f.EllaCore.N2Address},
+	}
+
+	// The slice is taken as a whole, from the flags or from the file.
+	if slice := f.SliceOf(s); slice != nil && !flags.Changed("sst") && !flags.Changed("sd") {
+		values = append(values, [][2]string{
+			{"sst", strconv.Itoa(int(slice.SST))},
+			{"sd", slice.SD},
+		}...)
+	}
+
+	return values
+}
+
+// setFlags sets the flags that were not given on the command line to their
+// value in the config file.
+func setFlags(cmd *cobra.Command, values [][2]string) error {
+	flags := cmd.Flags()
+
+	for _, v := range values {
+		name, value := v[0], v[1]
+		if value == "" || flags.Changed(name) {
+			continue
+		}
+
+		err := flags.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("invalid %s in config file: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+func checkRequired(cmd *cobra.Command, names []string) error {
+	flags := cmd.Flags()
+
+	var missing []string
+
+	for _, name := range names {
+		if !flags.Changed(name) {
+			missing = append(missing, "--"+name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("required settings not set: %s (set them with flags or in the --config file)", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+func initLogger() error {
+	cfg := logger.Config{
+		Level:           zapcore.InfoLevel,
+		ComponentLevels: make(map[string]zapcore.Level, len(logLevels)),
+		Format:          logFormat,
+		File:            logFile,
+		MaxSizeMB:       logMaxSize,
+		MaxBackups:      logMaxBackups,
+		MaxAgeDays:      logMaxAge,
+	}
+
+	if verbose {
+		cfg.Level = zapcore.DebugLevel
+	}
+
+	for component, value := range logLevels {
+		level, err := zapcore.ParseLevel(value)
+		if err != nil {
+			return fmt.Errorf("invalid log level for %s: %v", component, err)
+		}
+
+		cfg.ComponentLevels[component] = level
+	}
+
+	return logger.Init(cfg)
+}
+
+// toUint8s narrows the values of a uint slice flag, rejecting those that do
+// not fit rather than wrapping 257 to 1. Their range is checked by register.
+func toUint8s(values []uint) ([]uint8, error) {
+	out := make([]uint8, 0, len(values))
+	for _, v := range values {
+		if v > math.MaxUint8 {
+			return nil, fmt.Errorf("invalid value %d: must be at most %d", v, math.MaxUint8)
+		}
+
+		out = append(out, uint8(v))
+	}
+
+	return out, nil
 }
@@ -0,0 +1,376 @@
+// Package config reads the tester configuration file, which holds the
+// settings that seldom change between runs: the identity and addresses of
+// the gNodeB, the network it serves and the subscribers it registers.
+package config
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/ellanetworks/core-tester/internal/gnb"
+	"github.com/ellanetworks/core-tester/internal/register"
+	"go.yaml.in/yaml/v3"
+)
+
+type File struct {
+	GnodeB      GnodeB       `yaml:"gnb"`
+	Neighbor    GnodeB       `yaml:"neighbor-gnb"` // that serve and shell hand UEs over to
+	EllaCore    EllaCore     `yaml:"ella-core"`
+	PLMN        PLMN         `yaml:"plmn"`
+	TAC         string       `yaml:"tac"`
+	Slices      []Slice      `yaml:"slices"` // supported by the gNodeB, the first is the default of the subscribers
+	Subscribers []Subscriber `yaml:"subscribers"`
+}
+
+type GnodeB struct {
+	ID          string `yaml:"id"`
+	Name        string `yaml:"name"`
+	N2Address   string `yaml:"n2-address"`
+	N3Address   string `yaml:"n3-address"`
+	N3AddressV6 string `yaml:"n3-address-v6"`
+}
+
+type EllaCore struct {
+	N2Address  string `yaml:"n2-address"`  // host:port
+	APIAddress string `yaml:"api-address"` // URL of the HTTP API, for --provision
+}
+
+type PLMN struct {
+	MCC string `yaml:"mcc"`
+	MNC string `yaml:"mnc"`
+}
+
+type Slice struct {
+	SST int32  `yaml:"sst"`
+	SD  string `yaml:"sd"`
+}
+
+type Subscriber struct {
+	IMSI                 string               `yaml:"imsi"`
+	Key                  string               `yaml:"key"`
+	OPC                  string               `yaml:"opc"`
+	SQN                  string               `yaml:"sqn"`
+	ProfileName          string               `yaml:"profile-name"`
+	DNN                  string               `yaml:"dnn"`
+	Slice                *Slice               `yaml:"slice"`
+	PDUSessionType       string               `yaml:"pdu-session-type"`
+	SecurityCapabilities SecurityCapabilities `yaml:"security-capabilities"`
+}
+
+// SecurityCapabilities lists the algorithms the UE supports, such as nia2
+// and nea0. When both are empty, the UE supports NIA2, NEA0 and NEA2.
+type SecurityCapabilities struct {
+	Integrity []string `yaml:"integrity"`
+	Ciphering []string `yaml:"ciphering"`
+}
+
+// Load reads and validates a configuration file.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file: %v", err)
+	}
+
+	f := &File{}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	err = dec.Decode(f)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not parse config file %s: %v", path, err)
+	}
+
+	err = f.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s:\n%v", path, err)
+	}
+
+	return f, nil
+}
+
+// Validate checks the format of every setting that is present and returns
+// one line per problem. Missing settings are left to the flags.
+func (f *File) Validate() error {
+	var errs []error
+
+	check := func(field string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", field, err))
+		}
+	}
+
+	check("gnb.id", optional(f.GnodeB.ID, hexDigits(6, 8)))
+	check("gnb.n2-address", optional(f.GnodeB.N2Address, ipAddress))
+	check("gnb.n3-address", optional(f.GnodeB.N3Address, ipAddress))
+	check("gnb.n3-address-v6", optional(f.GnodeB.N3AddressV6, ipAddress))
+	check("neighbor-gnb.id", optional(f.Neighbor.ID, hexDigits(6, 8)))
+	check("neighbor-gnb.n2-address", optional(f.Neighbor.N2Address, ipAddress))
+	check("neighbor-gnb.n3-address", optional(f.Neighbor.N3Address, ipAddress))
+	check("neighbor-gnb.n3-address-v6", optional(f.Neighbor.N3AddressV6, ipAddress))
+	check("ella-core.n2-address", optional(f.EllaCore.N2Address, hostPort))
+	check("ella-core.api-address", optional(f.EllaCore.APIAddress, httpURL))
+	check("plmn.mcc", optional(f.PLMN.MCC, digits(3, 3)))
+	check("plmn.mnc", optional(f.PLMN.MNC, digits(2, 3)))
+	check("tac", optional(f.TAC, hexDigits(6, 6)))
+
+	for i, s := range f.Slices {
+		check(fmt.Sprintf("slices[%d]", i), s.validate())
+	}
+
+	seen := make(map[string]int)
+
+	for i, s := range f.Subscribers {
+		field := fmt.Sprintf("subscribers[%d]", i)
+
+		if j, ok := seen[s.IMSI]; ok && s.IMSI != "" {
+			check(field+".imsi", fmt.Errorf("%s is already listed in subscribers[%d]", s.IMSI, j))
+		}
+
+		seen[s.IMSI] = i
+
+		for _, err := range s.validate(false) {
+			errs = append(errs, fmt.Errorf("%s.%v", field, err))
+		}
+
+		if s.Slice != nil && len(f.Slices) > 0 && !slices.Contains(f.Slices, *s.Slice) {
+			check(field+".slice", fmt.Errorf("not listed in slices"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validate checks the settings of a subscriber, with one error per invalid
+// setting named after it. Credentials are only required when set.
+func (s *Subscriber) validate(credentials bool) []error {
+	check := optional
+	if credentials {
+		check = required
+	}
+
+	var errs []error
+
+	for _, setting := range []struct {
+		name string
+		err  error
+	}{
+		{"imsi", required(s.IMSI, digits(6, 15))},
+		{"key", check(s.Key, hexDigits(32, 32))},
+		{"opc", check(s.OPC, hexDigits(32, 32))},
+		{"sqn", check(s.SQN, hexDigits(12, 12))},
+	} {
+		if setting.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", setting.name, setting.err))
+		}
+	}
+
+	if s.Slice != nil {
+		err := s.Slice.validate()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("slice: %v", err))
+		}
+	}
+
+	_, err := s.SecurityCapabilities.capability()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("security-capabilities: %v", err))
+	}
+
+	return errs
+}
+
+func (s Slice) validate() error {
+	if s.SST < 0 || s.SST > 255 {
+		return fmt.Errorf("sst must be between 0 and 255, got %d", s.SST)
+	}
+
+	err := optional(s.SD, hexDigits(6, 6))
+	if err != nil {
+		return fmt.Errorf("sd: %v", err)
+	}
+
+	return nil
+}
+
+// Subscriber returns the subscriber with this IMSI or, when imsi is empty,
+// the only subscriber of the file. It returns nil when the file has no
+// subscriber to offer, so that the flags alone describe it.
+func (f *File) Subscriber(imsi string) (*Subscriber, error) {
+	if imsi == "" {
+		switch len(f.Subscribers) {
+		case 0:
+			return nil, nil
+		case 1:
+			return &f.Subscribers[0], nil
+		default:
+			return nil, fmt.Errorf("the config file lists %d subscribers: select one with --imsi", len(f.Subscribers))
+		}
+	}
+
+	for i := range f.Subscribers {
+		if f.Subscribers[i].IMSI == imsi {
+			return &f.Subscribers[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// SliceOf returns the slice of a subscriber, defaulting to the first slice of
+// the file.
+func (f *File) SliceOf(s *Subscriber) *Slice {
+	if s != nil && s.Slice != nil {
+		return s.Slice
+	}
+
+	if len(f.Slices) > 0 {
+		return &f.Slices[0]
+	}
+
+	return nil
+}
+
+// GnbSlices returns the slices the gNodeB supports.
+func (f *File) GnbSlices() []gnb.SliceOpt {
+	out := make([]gnb.SliceOpt, 0, len(f.Slices))
+	for _, s := range f.Slices {
+		out = append(out, gnb.SliceOpt{Sst: s.SST, Sd: s.SD})
+	}
+
+	return out
+}
+
+// SecurityCapability returns the algorithms of the subscriber, or nil when
+// the file leaves them to the default.
+func (s *Subscriber) SecurityCapability() *register.UeSecurityCapability {
+	capability, _ := s.SecurityCapabilities.capability()
+	return capability
+}
+
+func (c SecurityCapabilities) capability() (*register.UeSecurityCapability, error) {
+	if len(c.Integrity) == 0 && len(c.Ciphering) == 0 {
+		return nil, nil
+	}
+
+	capability := &register.UeSecurityCapability{}
+
+	integrity := map[string]*bool{
+		"nia0": &capability.Integrity.Nia0,
+		"nia1": &capability.Integrity.Nia1,
+		"nia2": &capability.Integrity.Nia2,
+		"nia3": &capability.Integrity.Nia3,
+	}
+
+	for _, name := range c.Integrity {
+		supported, ok := integrity[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("invalid integrity algorithm %q: must be nia0, nia1, nia2, or nia3", name)
+		}
+
+		*supported = true
+	}
+
+	ciphering := map[string]*bool{
+		"nea0": &capability.Ciphering.Nea0,
+		"nea1": &capability.Ciphering.Nea1,
+		"nea2": &capability.Ciphering.Nea2,
+		"nea3": &capability.Ciphering.Nea3,
+	}
+
+	for _, name := range c.Ciphering {
+		supported, ok := ciphering[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("invalid ciphering algorithm %q: must be nea0, nea1, nea2, or nea3", name)
+		}
+
+		*supported = true
+	}
+
+	if len(c.Integrity) == 0 || len(c.Ciphering) == 0 {
+		return nil, fmt.Errorf("both integrity and ciphering algorithms must be listed")
+	}
+
+	return capability, nil
+}
+
+func optional(value string, check func(string) error) error {
+	if value == "" {
+		return nil
+	}
+
+	return check(value)
+}
+
+func required(value string, check func(string) error) error {
+	if value == "" {
+		return fmt.Errorf("must be set")
+	}
+
+	return check(value)
+}
+
+func digits(minLen int, maxLen int) func(string) error {
+	return func(s string) error {
+		if len(s) < minLen || len(s) > maxLen || strings.Trim(s, "0123456789") != "" {
+			return fmt.Errorf("%q must be %s decimal digits", s, lengths(minLen, maxLen))
+		}
+
+		return nil
+	}
+}
+
+func hexDigits(minLen int, maxLen int) func(string) error {
+	return func(s string) error {
+		_, err := hex.DecodeString(s)
+		if err != nil || len(s) < minLen || len(s) > maxLen {
+			return fmt.Errorf("%q must be %s hexadecimal digits", s, lengths(minLen, maxLen))
+		}
+
+		return nil
+	}
+}
+
+func lengths(minLen int, maxLen int) string {
+	if minLen == maxLen {
+		return fmt.Sprint(minLen)
+	}
+
+	return fmt.Sprintf("%d to %d", minLen, maxLen)
+}
+
+func ipAddress(s string) error {
+	_, err := netip.ParseAddr(s)
+	if err != nil {
+		return fmt.Errorf("%q is not an IP address", s)
+	}
+
+	return nil
+}
+
+func httpURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http or https URL", s)
+	}
+
+	return nil
+}
+
+func hostPort(s string) error {
+	_, _, err := net.SplitHostPort(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a host:port address", s)
+	}
+
+	return nil
+}
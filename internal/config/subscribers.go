@@ -0,0 +1,322 @@
+package config
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ellanetworks/core-tester/internal/register"
+)
+
+// csvColumns are the columns of a subscribers CSV file, named in its header
+// in any order. The first four are required.
+var csvColumns = []string{"imsi", "key", "opc", "sqn", "profile", "dnn", "sst", "sd"}
+
+// row is a subscriber read from a file, with the line it starts on. The
+// subscriber is nil when the row could not be parsed at all.
+type row struct {
+	line       int
+	subscriber *Subscriber
+	err        error
+}
+
+// ReadSubscribers reads the subscribers of a CSV file or, when its name ends
+// in .json, of a subscriber export of Ella Core. Every invalid row is
+// reported with its line.
+func ReadSubscribers(path string) ([]Subscriber, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read subscribers file: %v", err)
+	}
+
+	var rows []row
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		rows, err = readEllaCoreExport(data)
+	} else {
+		rows, err = readCSV(data)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not parse subscribers file %s: %v", path, err)
+	}
+
+	var errs []error
+
+	seen := make(map[string]int)
+	subscribers := make([]Subscriber, 0, len(rows))
+
+	for _, r := range rows {
+		var lineErrs []error
+
+		if r.err != nil {
+			lineErrs = append(lineErrs, r.err)
+		}
+
+		if s := r.subscriber; s != nil {
+			lineErrs = append(lineErrs, s.validate(true)...)
+
+			if line, ok := seen[s.IMSI]; ok && s.IMSI != "" {
+				lineErrs = append(lineErrs, fmt.Errorf("imsi: %s is already listed on line %d", s.IMSI, line))
+			}
+
+			seen[s.IMSI] = r.line
+			subscribers = append(subscribers, *s)
+		}
+
+		for _, err := range lineErrs {
+			errs = append(errs, fmt.Errorf("line %d: %v", r.line, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid subscribers file %s:\n%v", path, errors.Join(errs...))
+	}
+
+	return subscribers, nil
+}
+
+func readCSV(data []byte) ([]row, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read header: %v", err)
+	}
+
+	columns := make(map[string]int, len(header))
+
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(csvColumns, name) {
+			return nil, fmt.Errorf("unknown column %q in header: columns are %s", name, strings.Join(csvColumns, ", "))
+		}
+
+		columns[name] = i
+	}
+
+	for _, name := range csvColumns[:4] {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing column %q in header", name)
+		}
+	}
+
+	var rows []row
+
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+
+		// Rows with the wrong number of fields are reported with the other
+		// invalid rows; any other error stops the reading.
+		var parseErr *csv.ParseError
+		if err != nil && (!errors.As(err, &parseErr) || !errors.Is(err, csv.ErrFieldCount)) {
+			return nil, err
+		}
+
+		line, _ := r.FieldPos(0)
+
+		if err != nil {
+			rows = append(rows, row{line: line, err: parseErr.Err})
+			continue
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+
+			return ""
+		}
+
+		s := &Subscriber{
+			IMSI:        field("imsi"),
+			Key:         field("key"),
+			OPC:         field("opc"),
+			SQN:         field("sqn"),
+			ProfileName: field("profile"),
+			DNN:         field("dnn"),
+		}
+
+		slice, err := parseSlice(field("sst"), field("sd"))
+		s.Slice = slice
+
+		rows = append(rows, row{line: line, subscriber: s, err: err})
+	}
+}
+
+func parseSlice(sst string, sd string) (*Slice, error) {
+	if sst == "" {
+		if sd != "" {
+			return nil, fmt.Errorf("sd: %q is set without an sst", sd)
+		}
+
+		return nil, nil
+	}
+
+	v, err := strconv.ParseInt(sst, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("sst: %q is not a number", sst)
+	}
+
+	return &Slice{SST: int32(v), SD: sd}, nil
+}
+
+// ellaCoreSubscriber is a subscriber as Ella Core lists it. Older releases
+// name the policy a profile.
+type ellaCoreSubscriber struct {
+	IMSI           string `json:"imsi"`
+	Key            string `json:"key"`
+	OPC            string `json:"opc"`
+	SequenceNumber string `json:"sequenceNumber"`
+	PolicyName     string `json:"policyName"`
+	ProfileName    string `json:"profileName"`
+}
+
+// readEllaCoreExport reads a list of subscribers, either alone or in the
+// response of the Ella Core API, which wraps it in "result" and, when
+// paginated, in "items".
+func readEllaCoreExport(data []byte) ([]row, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	err := findList(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []row
+
+	for dec.More() {
+		line := lineAt(data, dec.InputOffset())
+
+		var s ellaCoreSubscriber
+
+		err := dec.Decode(&s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		rows = append(rows, row{
+			line: line,
+			subscriber: &Subscriber{
+				IMSI:        s.IMSI,
+				Key:         s.Key,
+				OPC:         s.OPC,
+				SQN:         s.SequenceNumber,
+				ProfileName: cmp.Or(s.PolicyName, s.ProfileName),
+			},
+		})
+	}
+
+	return rows, nil
+}
+
+// findList moves dec into the list of subscribers.
+func findList(dec *json.Decoder) error {
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("could not find the list of subscribers: %v", err)
+		}
+
+		switch tok {
+		case json.Delim('['):
+			return nil
+		case json.Delim('{'):
+			err := findKey(dec, "result", "items")
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("expected a list of subscribers, got %v", tok)
+		}
+	}
+}
+
+// findKey moves dec, inside an object, to the value of the first of keys
+// found, skipping the others.
+func findKey(dec *json.Decoder, keys ...string) error {
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		if key, ok := tok.(string); ok && slices.Contains(keys, key) {
+			return nil
+		}
+
+		var skip json.RawMessage
+
+		err = dec.Decode(&skip)
+		if err != nil {
+			return err
+		}
+	}
+
+	return fmt.Errorf("expected one of %s in object", strings.Join(keys, ", "))
+}
+
+// lineAt returns the line of the first value at or after offset.
+func lineAt(data []byte, offset int64) int {
+	for int(offset) < len(data) && strings.ContainsRune(" \t\r\n,", rune(data[offset])) {
+		offset++
+	}
+
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// AddSubscribers adds subscribers read from another file to those of the
+// config file.
+func (f *File) AddSubscribers(subscribers []Subscriber) error {
+	for _, s := range subscribers {
+		existing, err := f.Subscriber(s.IMSI)
+		if err != nil {
+			return err
+		}
+
+		if existing != nil {
+			return fmt.Errorf("subscriber %s is already listed in the config file", s.IMSI)
+		}
+	}
+
+	f.Subscribers = append(f.Subscribers, subscribers...)
+
+	return nil
+}
+
+// Subscription returns the subscriber with the PLMN and, unless it has its
+// own, the default slice of the file.
+func (f *File) Subscription(s *Subscriber) register.Subscriber {
+	subscriber := register.Subscriber{
+		IMSI:               s.IMSI,
+		Key:                s.Key,
+		OPC:                s.OPC,
+		SQN:                s.SQN,
+		MCC:                f.PLMN.MCC,
+		MNC:                f.PLMN.MNC,
+		DNN:                s.DNN,
+		PDUSessionType:     s.PDUSessionType,
+		SecurityCapability: s.SecurityCapability(),
+	}
+
+	if slice := f.SliceOf(s); slice != nil {
+		subscriber.SST = slice.SST
+		subscriber.SD = slice.SD
+	}
+
+	return subscriber
+}
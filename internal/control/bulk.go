@@ -0,0 +1,114 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/metrics"
+	"go.uber.org/zap"
+)
+
+type RegisterAllOpts struct {
+	Control Config
+	// Concurrency is the number of UEs registering at the same time. It
+	// defaults to 1.
+	Concurrency int
+	// MetricsAddress, when set, serves Prometheus metrics on /metrics at this
+	// address.
+	MetricsAddress string
+}
+
+// RegisterAll registers every subscriber of the Config as a UE of one
+// gNodeB, with its default PDU session, and keeps them registered until ctx
+// is cancelled or an interrupt signal is received, then deregisters them. It
+// fails, after deregistering the others, when a UE could not register.
+func RegisterAll(ctx context.Context, opts RegisterAllOpts) error {
+	subscribers := opts.Control.Subscribers
+	if len(subscribers) == 0 {
+		return fmt.Errorf("%w: no subscribers to register", ErrInvalid)
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if opts.MetricsAddress != "" {
+		err := metrics.Serve(ctx, opts.MetricsAddress)
+		if err != nil {
+			return err
+		}
+	}
+
+	c, err := Start(opts.Control)
+	if err != nil {
+		return err
+	}
+
+	defer c.Close()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+
+	start := time.Now()
+	slots := make(chan struct{}, max(opts.Concurrency, 1))
+
+	for _, s := range subscribers {
+		if ctx.Err() != nil {
+			break
+		}
+
+		slots <- struct{}{}
+
+		wg.Add(1)
+
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			err := c.registerSubscriber(s.IMSI)
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %v", s.IMSI, err))
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	logger.Logger.Info(
+		"registered subscribers",
+		zap.Int("registered", len(subscribers)-len(errs)),
+		zap.Int("failed", len(errs)),
+		zap.Duration("duration", time.Since(start)),
+	)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d subscribers could not register:\n%v", len(errs), len(subscribers), errors.Join(errs...))
+	}
+
+	<-ctx.Done()
+
+	return nil
+}
+
+func (c *Controller) registerSubscriber(imsi string) error {
+	state, err := c.AddUE(NewUE{IMSI: imsi})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Register(state.ID)
+
+	return err
+}
@@ -0,0 +1,302 @@
+// Package control keeps a gNodeB associated with Ella Core and runs the
+// procedures of its UEs on demand, for the serve command and the shell.
+package control
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/gnb"
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/register"
+	"github.com/free5gc/ngap/ngapType"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultGnbID           = "000008"
+	defaultGnbName         = "Ella-Core-Tester"
+	defaultNeighborGnbID   = "000009"
+	defaultNeighborGnbName = "Ella-Core-Tester-Neighbor"
+	timeout                = 8 * time.Second
+)
+
+var (
+	// ErrUnknownUE is returned for a UE ID that was not added.
+	ErrUnknownUE = errors.New("unknown UE")
+	// ErrUnknownPDUSession is returned for a PDU session the UE does not
+	// have.
+	ErrUnknownPDUSession = errors.New("unknown PDU session")
+	// ErrConflict is returned when the UE already exists or its state does
+	// not allow the procedure.
+	ErrConflict = errors.New("conflict")
+	// ErrInvalid is returned for invalid parameters.
+	ErrInvalid = errors.New("invalid parameters")
+)
+
+// Config describes the gNodeB, the network it serves and the defaults of the
+// UEs added to it.
+type Config struct {
+	GnbID             string
+	GnbName           string
+	MCC               string
+	MNC               string
+	TAC               string
+	SST               int32
+	SD                string
+	Slices            []gnb.SliceOpt
+	DNN               string
+	GnbN2Address      string
+	GnbN3Address      string
+	GnbN3AddressV6    string
+	EllaCoreN2Address string
+	// PDUSessionType is the PDU session type of the UEs that do not set
+	// theirs. It defaults to ipv4.
+	PDUSessionType string
+	// Subscribers are looked up by IMSI when a UE is added without its
+	// credentials.
+	Subscribers []register.Subscriber
+	// Neighbor, when set, starts a second gNodeB that connected UEs can be
+	// handed over to.
+	Neighbor *NeighborConfig
+}
+
+// NeighborConfig describes the neighbour gNodeB. It serves the same network
+// as the first one.
+type NeighborConfig struct {
+	GnbID   string
+	GnbName string
+	// GnbN2Address defaults to the GnbN2Address of the Config.
+	GnbN2Address   string
+	GnbN3Address   string
+	GnbN3AddressV6 string
+}
+
+// Controller owns a gNodeB, its optional neighbour, and the UEs attached to
+// them. Its methods are safe for concurrent use; the procedures of a UE run
+// one at a time.
+type Controller struct {
+	cfg      Config
+	gNodeB   *gnb.GnodeB
+	neighbor *gnb.GnodeB // nil without a neighbour
+	mu       sync.Mutex
+	ues      map[int64]*managedUE // RAN UE NGAP ID -> UE
+	lastID   int64
+	started  time.Time
+}
+
+// Start connects the gNodeB, and its neighbour if one is configured, to
+// Ella Core and waits for NG Setup.
+func Start(cfg Config) (*Controller, error) {
+	cfg.PDUSessionType = cmp.Or(cfg.PDUSessionType, "ipv4")
+
+	err := register.ValidatePDUSessionType(cfg.PDUSessionType)
+	if err != nil {
+		return nil, err
+	}
+
+	gNodeB, err := startGnodeB(cfg, &gnb.StartOpts{
+		GnbID:          cmp.Or(cfg.GnbID, defaultGnbID),
+		Name:           cmp.Or(cfg.GnbName, defaultGnbName),
+		GnbN2Address:   cfg.GnbN2Address,
+		GnbN3Address:   cfg.GnbN3Address,
+		GnbN3AddressV6: cfg.GnbN3AddressV6,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var neighbor *gnb.GnodeB
+
+	if n := cfg.Neighbor; n != nil {
+		neighbor, err = startGnodeB(cfg, &gnb.StartOpts{
+			GnbID:          cmp.Or(n.GnbID, defaultNeighborGnbID),
+			Name:           cmp.Or(n.GnbName, defaultNeighborGnbName),
+			GnbN2Address:   cmp.Or(n.GnbN2Address, cfg.GnbN2Address),
+			GnbN3Address:   n.GnbN3Address,
+			GnbN3AddressV6: n.GnbN3AddressV6,
+		})
+		if err != nil {
+			gNodeB.Close()
+			return nil, fmt.Errorf("could not start neighbour gNB: %v", err)
+		}
+	}
+
+	return New(cfg, gNodeB, neighbor), nil
+}
+
+// New returns a Controller of gNodeBs that are already started. neighbor
+// may be nil.
+func New(cfg Config, gNodeB *gnb.GnodeB, neighbor *gnb.GnodeB) *Controller {
+	return &Controller{
+		cfg:      cfg,
+		gNodeB:   gNodeB,
+		neighbor: neighbor,
+		ues:      make(map[int64]*managedUE),
+		started:  time.Now(),
+	}
+}
+
+// startGnodeB starts a gNodeB of the network of the Config, identified by
+// opts.
+func startGnodeB(cfg Config, opts *gnb.StartOpts) (*gnb.GnodeB, error) {
+	opts.MCC = cfg.MCC
+	opts.MNC = cfg.MNC
+	opts.SST = cfg.SST
+	opts.SD = cfg.SD
+	opts.Slices = cfg.Slices
+	opts.DNN = cfg.DNN
+	opts.TAC = cfg.TAC
+	opts.CoreN2Address = cfg.EllaCoreN2Address
+
+	gNodeB, err := gnb.Start(opts)
+	if err != nil {
+		return nil, fmt.Errorf("error starting gNB: %v", err)
+	}
+
+	_, err = gNodeB.WaitForMessage(ngapType.NGAPPDUPresentSuccessfulOutcome, ngapType.SuccessfulOutcomePresentNGSetupResponse, 200*time.Millisecond)
+	if err != nil {
+		gNodeB.Close()
+		return nil, fmt.Errorf("did not receive NG Setup Response: %v", err)
+	}
+
+	logger.Logger.Info("gNodeB is associated with Ella Core", zap.String("gNB ID", gNodeB.GnbID))
+
+	return gNodeB, nil
+}
+
+// Close deregisters the connected UEs and closes the gNodeBs.
+func (c *Controller) Close() {
+	for _, u := range c.list() {
+		err := c.RemoveUE(u.id)
+		if err != nil {
+			logger.Logger.Warn("could not remove UE", zap.Int64("id", u.id), zap.Error(err))
+		}
+	}
+
+	for _, g := range c.gNodeBs() {
+		g.Close()
+		logger.Logger.Info("closed gNodeB", zap.String("gNB ID", g.GnbID))
+	}
+}
+
+// gNodeBs returns the gNodeB and its neighbour, if any.
+func (c *Controller) gNodeBs() []*gnb.GnodeB {
+	if c.neighbor == nil {
+		return []*gnb.GnodeB{c.gNodeB}
+	}
+
+	return []*gnb.GnodeB{c.gNodeB, c.neighbor}
+}
+
+// GnodeBState describes a gNodeB.
+type GnodeBState struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	MCC         string    `json:"mcc"`
+	MNC         string    `json:"mnc"`
+	TAC         string    `json:"tac"`
+	N3Address   string    `json:"n3_address,omitempty"`
+	N3AddressV6 string    `json:"n3_address_v6,omitempty"`
+	Since       time.Time `json:"since"`
+	UEs         int       `json:"ues"` // served by this gNodeB
+	// Neighbor is the neighbour gNodeB, if one is configured.
+	Neighbor *GnodeBState `json:"neighbor,omitempty"`
+}
+
+func (c *Controller) GnodeB() GnodeBState {
+	state := c.gNodeBState(c.gNodeB)
+
+	if c.neighbor != nil {
+		neighbor := c.gNodeBState(c.neighbor)
+		state.Neighbor = &neighbor
+	}
+
+	return state
+}
+
+func (c *Controller) gNodeBState(g *gnb.GnodeB) GnodeBState {
+	state := GnodeBState{
+		ID:    g.GnbID,
+		Name:  g.Name,
+		MCC:   g.MCC,
+		MNC:   g.MNC,
+		TAC:   g.TAC,
+		Since: c.started,
+	}
+
+	for _, u := range c.list() {
+		if u.gnb() == g {
+			state.UEs++
+		}
+	}
+
+	if g.N3Address.IsValid() {
+		state.N3Address = g.N3Address.String()
+	}
+
+	if g.N3AddressV6.IsValid() {
+		state.N3AddressV6 = g.N3AddressV6.String()
+	}
+
+	return state
+}
+
+// ResetGnodeB sends an NG Reset of the whole NG interface of each gNodeB
+// and waits for the acknowledgements. The connected UEs become idle and keep
+// their registration, as after a restart of the gNodeBs.
+func (c *Controller) ResetGnodeB() error {
+	ues := c.list()
+
+	for i, u := range ues {
+		if !u.busy.TryLock() {
+			for _, locked := range ues[:i] {
+				locked.busy.Unlock()
+			}
+
+			return fmt.Errorf("%w: UE %d is running a procedure", ErrConflict, u.id)
+		}
+	}
+
+	defer func() {
+		for _, u := range ues {
+			u.busy.Unlock()
+		}
+	}()
+
+	for _, g := range c.gNodeBs() {
+		err := resetGnodeB(g)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func resetGnodeB(g *gnb.GnodeB) error {
+	err := g.SendNGReset(&gnb.NGResetOpts{
+		Cause: ngapType.Cause{
+			Present: ngapType.CausePresentMisc,
+			Misc: &ngapType.CauseMisc{
+				Value: ngapType.CauseMiscPresentOmIntervention,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = g.WaitForMessage(ngapType.NGAPPDUPresentSuccessfulOutcome, ngapType.SuccessfulOutcomePresentNGResetAcknowledge, timeout)
+	if err != nil {
+		return fmt.Errorf("did not receive NG Reset Acknowledge: %v", err)
+	}
+
+	g.ReleaseUEContexts()
+	logger.Logger.Info("reset NG interface", zap.String("gNB ID", g.GnbID))
+
+	return nil
+}
@@ -0,0 +1,559 @@
+package control
+
+import (
+	"cmp"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ellanetworks/core-tester/internal/gnb"
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/register"
+	"github.com/ellanetworks/core-tester/internal/ue"
+	"github.com/free5gc/nas"
+	"github.com/free5gc/ngap/ngapType"
+	"go.uber.org/zap"
+)
+
+// defaultPDUSessionID is the PDU session a UE requests once registered.
+const defaultPDUSessionID = 1
+
+// stateNames names the 5GMM states of a UE.
+var stateNames = map[int]string{
+	ue.MM5G_NULL:                 "null",
+	ue.MM5G_DEREGISTERED:         "deregistered",
+	ue.MM5G_REGISTERED_INITIATED: "registering",
+	ue.MM5G_REGISTERED:           "registered",
+	ue.MM5G_SERVICE_REQ_INIT:     "service-request",
+	ue.MM5G_DEREGISTERED_INIT:    "deregistering",
+	ue.MM5G_IDLE:                 "idle",
+}
+
+type managedUE struct {
+	id      int64 // RAN UE NGAP ID
+	imsi    string
+	dnn     string
+	ue      *ue.UE
+	busy    sync.Mutex                 // held while a procedure runs
+	serving atomic.Pointer[gnb.GnodeB] // changed by a handover
+}
+
+// gnb returns the gNodeB serving the UE.
+func (u *managedUE) gnb() *gnb.GnodeB {
+	return u.serving.Load()
+}
+
+// NewUE describes a UE to add. Credentials left empty are taken from the
+// subscriber of the same IMSI in the Config, and the network settings from
+// the Config.
+type NewUE struct {
+	IMSI           string `json:"imsi"`
+	Key            string `json:"key,omitempty"`
+	OPC            string `json:"opc,omitempty"`
+	SQN            string `json:"sqn,omitempty"`
+	DNN            string `json:"dnn,omitempty"`
+	SST            int32  `json:"sst,omitempty"`
+	SD             string `json:"sd,omitempty"`
+	PDUSessionType string `json:"pdu_session_type,omitempty"`
+}
+
+// UEState describes a UE and its PDU sessions.
+type UEState struct {
+	ID          int64             `json:"id"` // RAN UE NGAP ID
+	IMSI        string            `json:"imsi"`
+	SUPI        string            `json:"supi"`
+	State       string            `json:"state"`
+	GnbID       string            `json:"gnb_id"` // of the serving gNodeB
+	AMFUENGAPID int64             `json:"amf_ue_ngap_id,omitempty"`
+	PDUSessions []PDUSessionState `json:"pdu_sessions"`
+}
+
+// PDUSessionState describes a PDU session as the UE and the gNodeB know it.
+// The user plane fields are only set while the UE is connected.
+type PDUSessionState struct {
+	ID             uint8        `json:"id"`
+	UEIP           string       `json:"ue_ip,omitempty"`
+	UEIPv6         string       `json:"ue_ipv6,omitempty"`
+	QFI            uint8        `json:"qfi"`
+	SSCMode        uint8        `json:"ssc_mode"`
+	MTU            uint16       `json:"mtu,omitempty"`
+	DNSServers     []netip.Addr `json:"dns_servers,omitempty"`
+	PCSCFAddresses []netip.Addr `json:"pcscf_addresses,omitempty"`
+	UPFAddress     string       `json:"upf_address,omitempty"`
+	ULTEID         uint32       `json:"ul_teid,omitempty"`
+	DLTEID         uint32       `json:"dl_teid,omitempty"`
+}
+
+// AddUE attaches a new UE to the gNodeB, without registering it.
+func (c *Controller) AddUE(opts NewUE) (UEState, error) {
+	subscriber := register.Subscriber{
+		IMSI:           opts.IMSI,
+		Key:            opts.Key,
+		OPC:            opts.OPC,
+		SQN:            opts.SQN,
+		MCC:            c.cfg.MCC,
+		MNC:            c.cfg.MNC,
+		DNN:            opts.DNN,
+		SST:            opts.SST,
+		SD:             opts.SD,
+		PDUSessionType: opts.PDUSessionType,
+	}
+
+	if known := c.subscriber(opts.IMSI); known != nil {
+		subscriber.Key = cmp.Or(subscriber.Key, known.Key)
+		subscriber.OPC = cmp.Or(subscriber.OPC, known.OPC)
+		subscriber.SQN = cmp.Or(subscriber.SQN, known.SQN)
+		subscriber.DNN = cmp.Or(subscriber.DNN, known.DNN)
+		subscriber.PDUSessionType = cmp.Or(subscriber.PDUSessionType, known.PDUSessionType)
+		subscriber.SecurityCapability = known.SecurityCapability
+
+		if subscriber.SST == 0 && subscriber.SD == "" {
+			subscriber.SST, subscriber.SD = known.SST, known.SD
+		}
+	}
+
+	subscriber.DNN = cmp.Or(subscriber.DNN, c.cfg.DNN)
+	subscriber.PDUSessionType = cmp.Or(subscriber.PDUSessionType, c.cfg.PDUSessionType)
+
+	if subscriber.SST == 0 && subscriber.SD == "" {
+		subscriber.SST, subscriber.SD = c.cfg.SST, c.cfg.SD
+	}
+
+	err := validateSubscriber(subscriber)
+	if err != nil {
+		return UEState{}, err
+	}
+
+	ueOpts := register.UEOpts(subscriber)
+	ueOpts.GnodeB = c.gNodeB
+	ueOpts.PDUSessionID = defaultPDUSessionID
+
+	newUE, err := ue.NewUE(ueOpts)
+	if err != nil {
+		return UEState{}, fmt.Errorf("%w: could not create UE: %v", ErrInvalid, err)
+	}
+
+	c.mu.Lock()
+
+	for _, u := range c.ues {
+		if u.imsi == opts.IMSI {
+			c.mu.Unlock()
+			return UEState{}, fmt.Errorf("%w: a UE with IMSI %s already exists with ID %d", ErrConflict, opts.IMSI, u.id)
+		}
+	}
+
+	c.lastID++
+	u := &managedUE{id: c.lastID, imsi: opts.IMSI, dnn: subscriber.DNN, ue: newUE}
+	u.serving.Store(c.gNodeB)
+	c.ues[u.id] = u
+	c.mu.Unlock()
+
+	c.gNodeB.AddUE(u.id, newUE)
+	logger.Logger.Info("added UE", zap.Int64("id", u.id), zap.String("IMSI", u.imsi))
+
+	return c.state(u), nil
+}
+
+func validateSubscriber(s register.Subscriber) error {
+	switch {
+	case len(s.IMSI) < 6:
+		return fmt.Errorf("%w: IMSI %q must be at least 6 digits", ErrInvalid, s.IMSI)
+	case s.Key == "" || s.OPC == "" || s.SQN == "":
+		return fmt.Errorf("%w: the key, OPC and SQN of %s are required", ErrInvalid, s.IMSI)
+	}
+
+	err := register.ValidatePDUSessionType(s.PDUSessionType)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	return nil
+}
+
+func (c *Controller) subscriber(imsi string) *register.Subscriber {
+	for i := range c.cfg.Subscribers {
+		if c.cfg.Subscribers[i].IMSI == imsi {
+			return &c.cfg.Subscribers[i]
+		}
+	}
+
+	return nil
+}
+
+// RemoveUE deregisters the UE if it is connected and detaches it from the
+// gNodeB. An idle UE is forgotten without deregistration.
+func (c *Controller) RemoveUE(id int64) error {
+	u, err := c.lock(id)
+	if err != nil {
+		return err
+	}
+	defer u.busy.Unlock()
+
+	if u.ue.GetStateMM() == ue.MM5G_REGISTERED {
+		err := c.deregister(u)
+		if err != nil {
+			logger.Logger.Warn("could not deregister UE before removing it", zap.Int64("id", id), zap.Error(err))
+		}
+	}
+
+	c.mu.Lock()
+	delete(c.ues, id)
+	c.mu.Unlock()
+
+	u.gnb().RemoveUE(id)
+	logger.Logger.Info("removed UE", zap.Int64("id", id), zap.String("IMSI", u.imsi))
+
+	return nil
+}
+
+// UE returns the state of a UE.
+func (c *Controller) UE(id int64) (UEState, error) {
+	u, err := c.get(id)
+	if err != nil {
+		return UEState{}, err
+	}
+
+	return c.state(u), nil
+}
+
+// UEs returns the state of every UE, by ID.
+func (c *Controller) UEs() []UEState {
+	states := []UEState{}
+	for _, u := range c.list() {
+		states = append(states, c.state(u))
+	}
+
+	return states
+}
+
+// Register registers the UE and establishes its default PDU session.
+func (c *Controller) Register(id int64) (UEState, error) {
+	return c.run(id, func(u *managedUE) error {
+		state := u.ue.GetStateMM()
+		if state != ue.MM5G_NULL && state != ue.MM5G_DEREGISTERED {
+			return fmt.Errorf("%w: UE %d is %s", ErrConflict, id, stateNames[state])
+		}
+
+		_, err := register.InitialRegistration(&register.InitialRegistrationOpts{
+			RANUENGAPID:  u.id,
+			PDUSessionID: defaultPDUSessionID,
+			UE:           u.ue,
+		})
+		if err != nil {
+			return fmt.Errorf("initial registration procedure failed: %v", err)
+		}
+
+		return nil
+	})
+}
+
+// Deregister deregisters a connected UE.
+func (c *Controller) Deregister(id int64) (UEState, error) {
+	return c.run(id, func(u *managedUE) error {
+		err := c.requireState(u, ue.MM5G_REGISTERED)
+		if err != nil {
+			return err
+		}
+
+		return c.deregister(u)
+	})
+}
+
+func (c *Controller) deregister(u *managedUE) error {
+	return register.Deregistration(&register.DeregistrationOpts{
+		UE:          u.ue,
+		AMFUENGAPID: u.gnb().GetAMFUENGAPID(u.id),
+		RANUENGAPID: u.id,
+	})
+}
+
+// Idle asks the AMF to release the connection of a registered UE, which
+// keeps its registration and PDU sessions.
+func (c *Controller) Idle(id int64) (UEState, error) {
+	return c.run(id, func(u *managedUE) error {
+		err := c.requireState(u, ue.MM5G_REGISTERED)
+		if err != nil {
+			return err
+		}
+
+		opts := &gnb.UEContextReleaseRequestOpts{
+			AMFUENGAPID: u.gnb().GetAMFUENGAPID(u.id),
+			RANUENGAPID: u.id,
+			Cause: ngapType.Cause{
+				Present: ngapType.CausePresentRadioNetwork,
+				RadioNetwork: &ngapType.CauseRadioNetwork{
+					Value: ngapType.CauseRadioNetworkPresentUserInactivity,
+				},
+			},
+		}
+
+		for sessionID := range u.gnb().GetPDUSessions(u.id) {
+			if sessionID >= 1 && sessionID <= 15 {
+				opts.PDUSessionIDs[sessionID] = true
+			}
+		}
+
+		err = u.gnb().SendUEContextReleaseRequest(opts)
+		if err != nil {
+			return err
+		}
+
+		err = u.ue.WaitForRRCRelease(timeout)
+		if err != nil {
+			return fmt.Errorf("did not receive UE Context Release Command: %v", err)
+		}
+
+		return nil
+	})
+}
+
+// ServiceRequest reconnects an idle UE and reactivates its PDU sessions.
+func (c *Controller) ServiceRequest(id int64) (UEState, error) {
+	return c.run(id, func(u *managedUE) error {
+		err := c.requireState(u, ue.MM5G_IDLE)
+		if err != nil {
+			return err
+		}
+
+		err = u.ue.SendServiceRequest(u.id)
+		if err != nil {
+			return err
+		}
+
+		_, err = u.ue.WaitForNASGMMMessage(nas.MsgTypeServiceAccept, timeout)
+		if err != nil {
+			return fmt.Errorf("did not receive Service Accept (UE is %s): %v", stateNames[u.ue.GetStateMM()], err)
+		}
+
+		return nil
+	})
+}
+
+// AddPDUSession establishes a PDU session for a connected UE, on the DNN of
+// the UE when dnn is empty.
+func (c *Controller) AddPDUSession(id int64, sessionID uint8, dnn string) (UEState, error) {
+	return c.run(id, func(u *managedUE) error {
+		err := c.requireState(u, ue.MM5G_REGISTERED)
+		if err != nil {
+			return err
+		}
+
+		if sessionID < 1 || sessionID > 15 {
+			return fmt.Errorf("%w: PDU session ID %d must be between 1 and 15", ErrInvalid, sessionID)
+		}
+
+		if _, ok := u.ue.GetPDUSessions()[sessionID]; ok {
+			return fmt.Errorf("%w: UE %d already has PDU session %d", ErrConflict, id, sessionID)
+		}
+
+		err = u.ue.SendPDUSessionEstablishmentRequest(u.gnb().GetAMFUENGAPID(u.id), u.id, sessionID, cmp.Or(dnn, u.dnn), u.ue.Snssai)
+		if err != nil {
+			return err
+		}
+
+		_, err = u.ue.WaitForNASGSMMessage(nas.MsgTypePDUSessionEstablishmentAccept, timeout)
+		if err != nil {
+			return fmt.Errorf("did not receive PDU Session Establishment Accept: %v", err)
+		}
+
+		session, err := u.ue.WaitForPDUSession(sessionID, timeout)
+		if err != nil {
+			return err
+		}
+
+		return u.ue.CheckSSCMode(session)
+	})
+}
+
+// ReleasePDUSession releases a PDU session of a connected UE.
+func (c *Controller) ReleasePDUSession(id int64, sessionID uint8) (UEState, error) {
+	return c.run(id, func(u *managedUE) error {
+		err := c.requireState(u, ue.MM5G_REGISTERED)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := u.ue.GetPDUSessions()[sessionID]; !ok {
+			return fmt.Errorf("%w: UE %d has no PDU session %d", ErrUnknownPDUSession, id, sessionID)
+		}
+
+		err = u.ue.SendPDUSessionReleaseRequest(u.gnb().GetAMFUENGAPID(u.id), u.id, sessionID)
+		if err != nil {
+			return err
+		}
+
+		_, err = u.ue.WaitForNASGSMMessage(nas.MsgTypePDUSessionReleaseCommand, timeout)
+		if err != nil {
+			return fmt.Errorf("did not receive PDU Session Release Command: %v", err)
+		}
+
+		return nil
+	})
+}
+
+// Handover moves a connected UE to the other gNodeB over Xn: the target
+// gNodeB asks the AMF to switch the PDU sessions of the UE to its tunnels,
+// then the source gNodeB forgets the UE.
+func (c *Controller) Handover(id int64) (UEState, error) {
+	return c.run(id, func(u *managedUE) error {
+		if c.neighbor == nil {
+			return fmt.Errorf("%w: no neighbour gNB is configured", ErrInvalid)
+		}
+
+		err := c.requireState(u, ue.MM5G_REGISTERED)
+		if err != nil {
+			return err
+		}
+
+		source, target := c.gNodeB, c.neighbor
+		if u.gnb() == c.neighbor {
+			source, target = c.neighbor, c.gNodeB
+		}
+
+		sessions := source.GetPDUSessions(u.id)
+		if len(sessions) == 0 {
+			return fmt.Errorf("%w: UE %d has no PDU session to hand over", ErrConflict, id)
+		}
+
+		err = target.PathSwitch(&gnb.PathSwitchOpts{
+			RANUENGAPID:          u.id,
+			SourceAMFUENGAPID:    source.GetAMFUENGAPID(u.id),
+			UE:                   u.ue,
+			UESecurityCapability: u.ue.UeSecurity.UeSecurityCapability,
+			PDUSessions:          sessions,
+			Timeout:              timeout,
+		})
+		if err != nil {
+			return fmt.Errorf("path switch to gNB %s failed: %v", target.GnbID, err)
+		}
+
+		source.RemoveUE(u.id)
+		u.ue.Gnb = target
+		u.serving.Store(target)
+
+		logger.Logger.Info("handed over UE", zap.Int64("id", id), zap.String("source gNB ID", source.GnbID), zap.String("target gNB ID", target.GnbID))
+
+		return nil
+	})
+}
+
+// NextPDUSessionID returns the lowest PDU session ID the UE does not use.
+func (c *Controller) NextPDUSessionID(id int64) (uint8, error) {
+	u, err := c.get(id)
+	if err != nil {
+		return 0, err
+	}
+
+	sessions := u.ue.GetPDUSessions()
+
+	for sessionID := uint8(1); sessionID <= 15; sessionID++ {
+		if _, ok := sessions[sessionID]; !ok {
+			return sessionID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: UE %d uses every PDU session ID", ErrConflict, id)
+}
+
+// run runs a procedure of a UE and returns the state it left the UE in.
+func (c *Controller) run(id int64, procedure func(u *managedUE) error) (UEState, error) {
+	u, err := c.lock(id)
+	if err != nil {
+		return UEState{}, err
+	}
+	defer u.busy.Unlock()
+
+	err = procedure(u)
+
+	return c.state(u), err
+}
+
+func (c *Controller) requireState(u *managedUE, state int) error {
+	current := u.ue.GetStateMM()
+	if current != state {
+		return fmt.Errorf("%w: UE %d is %s, not %s", ErrConflict, u.id, stateNames[current], stateNames[state])
+	}
+
+	return nil
+}
+
+// lock returns a UE once no other procedure runs for it.
+func (c *Controller) lock(id int64) (*managedUE, error) {
+	u, err := c.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !u.busy.TryLock() {
+		return nil, fmt.Errorf("%w: UE %d is running another procedure", ErrConflict, id)
+	}
+
+	return u, nil
+}
+
+func (c *Controller) get(id int64) (*managedUE, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	u, ok := c.ues[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrUnknownUE, id)
+	}
+
+	return u, nil
+}
+
+func (c *Controller) list() []*managedUE {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ues := make([]*managedUE, 0, len(c.ues))
+	for _, u := range c.ues {
+		ues = append(ues, u)
+	}
+
+	slices.SortFunc(ues, func(a, b *managedUE) int { return cmp.Compare(a.id, b.id) })
+
+	return ues
+}
+
+func (c *Controller) state(u *managedUE) UEState {
+	state := UEState{
+		ID:          u.id,
+		IMSI:        u.imsi,
+		SUPI:        u.ue.UeSecurity.Supi,
+		State:       stateNames[u.ue.GetStateMM()],
+		GnbID:       u.gnb().GnbID,
+		AMFUENGAPID: u.gnb().GetAMFUENGAPID(u.id),
+		PDUSessions: []PDUSessionState{},
+	}
+
+	userPlane := u.gnb().GetPDUSessions(u.id)
+
+	for sessionID, s := range u.ue.GetPDUSessions() {
+		session := PDUSessionState{
+			ID:             sessionID,
+			UEIP:           s.UEIP,
+			UEIPv6:         s.UEIPV6,
+			QFI:            s.QFI,
+			SSCMode:        s.SSCMode,
+			MTU:            s.MTU,
+			DNSServers:     s.DNSServers,
+			PCSCFAddresses: s.PCSCFAddresses,
+		}
+
+		if up, ok := userPlane[int64(sessionID)]; ok {
+			session.UPFAddress = up.UpfAddress
+			session.ULTEID = up.ULTeid
+			session.DLTEID = up.DLTeid
+		}
+
+		state.PDUSessions = append(state.PDUSessions, session)
+	}
+
+	slices.SortFunc(state.PDUSessions, func(a, b PDUSessionState) int { return cmp.Compare(a.ID, b.ID) })
+
+	return state
+}
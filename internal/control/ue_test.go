@@ -0,0 +1,220 @@
+package control
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ellanetworks/core-tester/internal/gnb"
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/register"
+	"github.com/ellanetworks/core-tester/internal/ue"
+	"go.uber.org/zap"
+)
+
+const testIMSI = "001010100007487"
+
+// newTestController returns a Controller of a gNodeB that is not connected
+// to a core: every message it sends fails.
+func newTestController(t *testing.T) *Controller {
+	t.Helper()
+
+	logger.Logger = zap.NewNop()
+	logger.GnbLogger = zap.NewNop()
+	logger.UeLogger = zap.NewNop()
+
+	return New(Config{
+		MCC:            "001",
+		MNC:            "01",
+		SST:            1,
+		DNN:            "internet",
+		PDUSessionType: "ipv4",
+		Subscribers: []register.Subscriber{{
+			IMSI: testIMSI,
+			Key:  "5122250214c33e723a5dd523fc145fc0",
+			OPC:  "981d464c7c52eb6e5036234984ad0bcf",
+			SQN:  "000000000023",
+		}},
+	}, &gnb.GnodeB{GnbID: "000008"}, nil)
+}
+
+func addTestUE(t *testing.T, c *Controller) *managedUE {
+	t.Helper()
+
+	state, err := c.AddUE(NewUE{IMSI: testIMSI})
+	if err != nil {
+		t.Fatalf("could not add UE: %v", err)
+	}
+
+	u, err := c.get(state.ID)
+	if err != nil {
+		t.Fatalf("could not get UE: %v", err)
+	}
+
+	return u
+}
+
+func TestAddUE(t *testing.T) {
+	c := newTestController(t)
+
+	state, err := c.AddUE(NewUE{IMSI: testIMSI})
+	if err != nil {
+		t.Fatalf("could not add UE: %v", err)
+	}
+
+	if state.ID != 1 || state.State != "null" || state.GnbID != "000008" || len(state.PDUSessions) != 0 {
+		t.Fatalf("unexpected state %+v", state)
+	}
+
+	_, err = c.AddUE(NewUE{IMSI: testIMSI})
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict for a second UE of the same IMSI, got %v", err)
+	}
+
+	_, err = c.AddUE(NewUE{IMSI: "001010100000001"})
+	if !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected ErrInvalid for a UE without credentials, got %v", err)
+	}
+}
+
+func TestUnknownUE(t *testing.T) {
+	c := newTestController(t)
+
+	procedures := map[string]func(int64) (UEState, error){
+		"register":        c.Register,
+		"deregister":      c.Deregister,
+		"idle":            c.Idle,
+		"service request": c.ServiceRequest,
+		"handover":        c.Handover,
+	}
+
+	for name, procedure := range procedures {
+		_, err := procedure(42)
+		if !errors.Is(err, ErrUnknownUE) {
+			t.Errorf("%s: expected ErrUnknownUE, got %v", name, err)
+		}
+	}
+
+	if err := c.RemoveUE(42); !errors.Is(err, ErrUnknownUE) {
+		t.Errorf("remove: expected ErrUnknownUE, got %v", err)
+	}
+}
+
+func TestProcedureStates(t *testing.T) {
+	tests := []struct {
+		name      string
+		state     int
+		procedure func(c *Controller, id int64) (UEState, error)
+		wantErr   error
+	}{
+		{"register a registered UE", ue.MM5G_REGISTERED, (*Controller).Register, ErrConflict},
+		{"deregister a new UE", ue.MM5G_NULL, (*Controller).Deregister, ErrConflict},
+		{"idle an idle UE", ue.MM5G_IDLE, (*Controller).Idle, ErrConflict},
+		{"service request of a registered UE", ue.MM5G_REGISTERED, (*Controller).ServiceRequest, ErrConflict},
+		{"PDU session of an idle UE", ue.MM5G_IDLE, func(c *Controller, id int64) (UEState, error) {
+			return c.AddPDUSession(id, 2, "")
+		}, ErrConflict},
+		{"release of an idle UE", ue.MM5G_IDLE, func(c *Controller, id int64) (UEState, error) {
+			return c.ReleasePDUSession(id, 1)
+		}, ErrConflict},
+		{"invalid PDU session ID", ue.MM5G_REGISTERED, func(c *Controller, id int64) (UEState, error) {
+			return c.AddPDUSession(id, 16, "")
+		}, ErrInvalid},
+		{"release of an unknown PDU session", ue.MM5G_REGISTERED, func(c *Controller, id int64) (UEState, error) {
+			return c.ReleasePDUSession(id, 1)
+		}, ErrUnknownPDUSession},
+		{"handover without a neighbour", ue.MM5G_REGISTERED, (*Controller).Handover, ErrInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t)
+			u := addTestUE(t, c)
+			u.ue.StateMM = tt.state
+
+			state, err := tt.procedure(c, u.id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+
+			// The state of the UE is returned with the error, unchanged.
+			if state.ID != u.id || state.State != stateNames[tt.state] {
+				t.Fatalf("expected UE %d to stay %s, got %+v", u.id, stateNames[tt.state], state)
+			}
+		})
+	}
+}
+
+func TestRegisterFailureKeepsState(t *testing.T) {
+	c := newTestController(t)
+	u := addTestUE(t, c)
+
+	state, err := c.Register(u.id)
+	if err == nil {
+		t.Fatal("expected an error without a core")
+	}
+
+	if errors.Is(err, ErrConflict) || errors.Is(err, ErrInvalid) || errors.Is(err, ErrUnknownUE) {
+		t.Fatalf("expected a procedure failure, got %v", err)
+	}
+
+	if state.State != "null" {
+		t.Fatalf("expected the UE to stay null, got %s", state.State)
+	}
+}
+
+func TestBusyUE(t *testing.T) {
+	c := newTestController(t)
+	u := addTestUE(t, c)
+
+	u.busy.Lock()
+	defer u.busy.Unlock()
+
+	_, err := c.Register(u.id)
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict while another procedure runs, got %v", err)
+	}
+
+	err = c.ResetGnodeB()
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict for a reset while a procedure runs, got %v", err)
+	}
+}
+
+func TestRemoveUE(t *testing.T) {
+	c := newTestController(t)
+	u := addTestUE(t, c)
+
+	err := c.RemoveUE(u.id)
+	if err != nil {
+		t.Fatalf("could not remove UE: %v", err)
+	}
+
+	if len(c.UEs()) != 0 {
+		t.Fatalf("expected no UE, got %+v", c.UEs())
+	}
+
+	// The IMSI can be added again.
+	addTestUE(t, c)
+}
+
+func TestNextPDUSessionID(t *testing.T) {
+	c := newTestController(t)
+	u := addTestUE(t, c)
+
+	u.ue.PDUSessions[1] = ue.PDUSessionInfo{}
+	u.ue.PDUSessions[2] = ue.PDUSessionInfo{}
+
+	id, err := c.NextPDUSessionID(u.id)
+	if err != nil || id != 3 {
+		t.Fatalf("expected PDU session ID 3, got %d (%v)", id, err)
+	}
+
+	for sessionID := uint8(3); sessionID <= 15; sessionID++ {
+		u.ue.PDUSessions[sessionID] = ue.PDUSessionInfo{}
+	}
+
+	_, err = c.NextPDUSessionID(u.id)
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict when every ID is used, got %v", err)
+	}
+}
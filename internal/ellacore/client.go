@@ -0,0 +1,283 @@
+// Package ellacore is a client of the Ella Core HTTP API, used to provision
+// the policy and subscribers of a run.
+package ellacore
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound matches the *APIError answered for a resource that does not
+// exist.
+var ErrNotFound = errors.New("not found")
+
+// errUnreachable marks the requests that got no answer.
+var errUnreachable = errors.New("could not reach Ella Core")
+
+// APIError is an error answered by Ella Core.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("Ella Core answered %d: %s", e.StatusCode, e.Message)
+}
+
+func (e *APIError) Is(target error) bool {
+	return target == ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+type ClientOpts struct {
+	// Address is the base URL of the API, such as https://10.0.0.1:5002.
+	Address string
+	// Token is an API token. Without it, the client logs in with Email and
+	// Password.
+	Token    string
+	Email    string
+	Password string
+	// Insecure skips the verification of the TLS certificate, for cores
+	// that use a self-signed one.
+	Insecure bool
+	// Retries is the number of times a request is retried when the core
+	// cannot be reached or answers 429 or 5xx, waiting RetryDelay, doubled
+	// at every attempt. Requests that create resources are only retried
+	// when the core cannot have processed them.
+	Retries    int
+	RetryDelay time.Duration
+	// Timeout limits each attempt of a request. It defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+type Client struct {
+	base       *url.URL
+	http       *http.Client
+	email      string
+	password   string
+	retries    int
+	retryDelay time.Duration
+	mu         sync.Mutex
+	token      string
+}
+
+func NewClient(opts *ClientOpts) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(opts.Address, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid Ella Core API address %q: must be an http or https URL", opts.Address)
+	}
+
+	if opts.Token == "" && (opts.Email == "" || opts.Password == "") {
+		return nil, fmt.Errorf("an API token or an email and password are required")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.Insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &Client{
+		base:       base,
+		http:       &http.Client{Transport: transport, Timeout: cmp.Or(opts.Timeout, 10*time.Second)},
+		email:      opts.Email,
+		password:   opts.Password,
+		retries:    opts.Retries,
+		retryDelay: opts.RetryDelay,
+		token:      opts.Token,
+	}, nil
+}
+
+type loginParams struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type loginResult struct {
+	Token string `json:"token"`
+}
+
+// login exchanges the email and password for a token.
+func (c *Client) login(ctx context.Context) error {
+	var result loginResult
+
+	err := c.send(ctx, http.MethodPost, "/api/v1/auth/login", &loginParams{Email: c.email, Password: c.password}, &result, false)
+	if err != nil {
+		return fmt.Errorf("could not log in to Ella Core: %v", err)
+	}
+
+	if result.Token == "" {
+		return fmt.Errorf("could not log in to Ella Core: no token in the answer")
+	}
+
+	c.mu.Lock()
+	c.token = result.Token
+	c.mu.Unlock()
+
+	return nil
+}
+
+// do sends a request with the token, logging in first when there is none
+// and again when the token has expired.
+func (c *Client) do(ctx context.Context, method string, path string, body any, result any) error {
+	c.mu.Lock()
+	token := c.token
+	c.mu.Unlock()
+
+	if token == "" {
+		err := c.login(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := c.send(ctx, method, path, body, result, true)
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized && c.email != "" {
+		err = c.login(ctx)
+		if err != nil {
+			return err
+		}
+
+		return c.send(ctx, method, path, body, result, true)
+	}
+
+	return err
+}
+
+// response is the envelope of every answer of the API.
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+// send sends a request, retrying it while the core cannot be reached or is
+// overloaded, and decodes the result of the answer into result.
+func (c *Client) send(ctx context.Context, method string, path string, body any, result any, auth bool) error {
+	var payload []byte
+
+	if body != nil {
+		var err error
+
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("could not encode request: %v", err)
+		}
+	}
+
+	delay := c.retryDelay
+
+	for attempt := 0; ; attempt++ {
+		err := c.sendOnce(ctx, method, path, payload, result, auth)
+		if err == nil || attempt >= c.retries || !retryable(method, err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+	}
+}
+
+func (c *Client) sendOnce(ctx context.Context, method string, path string, payload []byte, result any, auth bool) error {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.base.String()+path, body)
+	if err != nil {
+		return fmt.Errorf("could not build request: %v", err)
+	}
+
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if auth {
+		c.mu.Lock()
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		c.mu.Unlock()
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errUnreachable, err)
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	var envelope response
+
+	err = json.NewDecoder(resp.Body).Decode(&envelope)
+	if err != nil && !errors.Is(err, io.EOF) {
+		if resp.StatusCode >= 300 {
+			return &APIError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		}
+
+		return fmt.Errorf("could not decode answer of %s %s: %v", method, path, err)
+	}
+
+	if resp.StatusCode >= 300 {
+		return &APIError{StatusCode: resp.StatusCode, Message: envelope.Error}
+	}
+
+	if result != nil && len(envelope.Result) > 0 {
+		err = json.Unmarshal(envelope.Result, result)
+		if err != nil {
+			return fmt.Errorf("could not decode result of %s %s: %v", method, path, err)
+		}
+	}
+
+	return nil
+}
+
+// retryable tells whether a request may succeed if sent again. A request
+// that is not idempotent is only sent again when the core did not process
+// it: the connection could not be opened, or the core answered 429 or 503.
+// After a timeout, a POST may have created its resource already.
+func retryable(method string, err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == http.StatusTooManyRequests, apiErr.StatusCode == http.StatusServiceUnavailable:
+			return true
+		case apiErr.StatusCode >= 500:
+			return idempotent(method)
+		default:
+			return false
+		}
+	}
+
+	if !errors.Is(err, errUnreachable) {
+		return false
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+
+	return idempotent(method)
+}
+
+func idempotent(method string) bool {
+	return method != http.MethodPost && method != http.MethodPatch
+}
@@ -0,0 +1,295 @@
+package ellacore_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/ellacore"
+	"github.com/ellanetworks/core-tester/internal/ellacore/ellacoretest"
+)
+
+func newClient(t *testing.T, core *ellacoretest.Server, opts ellacore.ClientOpts) *ellacore.Client {
+	t.Helper()
+
+	opts.Address = core.URL
+
+	client, err := ellacore.NewClient(&opts)
+	if err != nil {
+		t.Fatalf("could not create client: %v", err)
+	}
+
+	return client
+}
+
+func TestNewClientValidatesOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts ellacore.ClientOpts
+	}{
+		{"no scheme", ellacore.ClientOpts{Address: "10.0.0.1:5002", Token: "token"}},
+		{"no host", ellacore.ClientOpts{Address: "https://", Token: "token"}},
+		{"no credentials", ellacore.ClientOpts{Address: "https://10.0.0.1:5002"}},
+		{"no password", ellacore.ClientOpts{Address: "https://10.0.0.1:5002", Email: ellacoretest.Email}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ellacore.NewClient(&tt.opts)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestToken(t *testing.T) {
+	core := ellacoretest.NewServer(t)
+	core.AddPolicy(ellacore.Policy{Name: "default", Var5qi: 9})
+
+	client := newClient(t, core, ellacore.ClientOpts{Token: core.Token()})
+
+	policy, err := client.GetPolicy(context.Background(), "default")
+	if err != nil {
+		t.Fatalf("could not get policy: %v", err)
+	}
+
+	if policy.Var5qi != 9 {
+		t.Fatalf("expected 5QI 9, got %d", policy.Var5qi)
+	}
+
+	if core.Logins() != 0 {
+		t.Fatalf("expected no login with a token, got %d", core.Logins())
+	}
+}
+
+func TestInvalidToken(t *testing.T) {
+	core := ellacoretest.NewServer(t)
+	client := newClient(t, core, ellacore.ClientOpts{Token: "invalid"})
+
+	_, err := client.GetPolicy(context.Background(), "default")
+
+	var apiErr *ellacore.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected a 401 API error, got %v", err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	core := ellacoretest.NewServer(t)
+	client := newClient(t, core, ellacore.ClientOpts{Email: ellacoretest.Email, Password: ellacoretest.Password})
+
+	for range 2 {
+		_, err := client.GetPolicy(context.Background(), "default")
+		if !errors.Is(err, ellacore.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+
+	if core.Logins() != 1 {
+		t.Fatalf("expected 1 login, got %d", core.Logins())
+	}
+}
+
+func TestLoginWrongPassword(t *testing.T) {
+	core := ellacoretest.NewServer(t)
+	client := newClient(t, core, ellacore.ClientOpts{Email: ellacoretest.Email, Password: "wrong"})
+
+	_, err := client.GetPolicy(context.Background(), "default")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if got := core.Requests(); !slices.Equal(got, []string{"POST /api/v1/auth/login"}) {
+		t.Fatalf("expected only the login request, got %v", got)
+	}
+}
+
+func TestReloginAfterUnauthorized(t *testing.T) {
+	core := ellacoretest.NewServer(t)
+	core.AddPolicy(ellacore.Policy{Name: "default"})
+
+	client := newClient(t, core, ellacore.ClientOpts{Email: ellacoretest.Email, Password: ellacoretest.Password})
+
+	_, err := client.GetPolicy(context.Background(), "default")
+	if err != nil {
+		t.Fatalf("could not get policy: %v", err)
+	}
+
+	core.ExpireToken()
+
+	_, err = client.GetPolicy(context.Background(), "default")
+	if err != nil {
+		t.Fatalf("could not get policy after the token expired: %v", err)
+	}
+
+	if core.Logins() != 2 {
+		t.Fatalf("expected 2 logins, got %d", core.Logins())
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		faults   []ellacoretest.Fault
+		retries  int
+		wantErr  bool
+		attempts int
+	}{
+		{
+			name:   "get retried on 429 and 5xx",
+			method: http.MethodGet, path: "/api/v1/policies/default",
+			faults:  []ellacoretest.Fault{{Status: 429}, {Status: 503}, {Status: 500}},
+			retries: 3, attempts: 4,
+		},
+		{
+			name:   "get retried after a timeout",
+			method: http.MethodGet, path: "/api/v1/policies/default",
+			faults:  []ellacoretest.Fault{{Delay: 300 * time.Millisecond}},
+			retries: 1, attempts: 2,
+		},
+		{
+			name:   "get gives up after the retries",
+			method: http.MethodGet, path: "/api/v1/policies/default",
+			faults:  []ellacoretest.Fault{{Status: 502}, {Status: 502}, {Status: 502}},
+			retries: 2, attempts: 3, wantErr: true,
+		},
+		{
+			name:   "no retry on 4xx",
+			method: http.MethodGet, path: "/api/v1/policies/default",
+			faults:  []ellacoretest.Fault{{Status: 400}},
+			retries: 3, attempts: 1, wantErr: true,
+		},
+		{
+			name:   "create retried on 429 and 503",
+			method: http.MethodPost, path: "/api/v1/policies",
+			faults:  []ellacoretest.Fault{{Status: 429}, {Status: 503}},
+			retries: 3, attempts: 3,
+		},
+		{
+			name:   "create not retried on 500",
+			method: http.MethodPost, path: "/api/v1/policies",
+			faults:  []ellacoretest.Fault{{Status: 500}},
+			retries: 3, attempts: 1, wantErr: true,
+		},
+		{
+			name:   "create not retried after a timeout",
+			method: http.MethodPost, path: "/api/v1/policies",
+			faults:  []ellacoretest.Fault{{Delay: 300 * time.Millisecond}},
+			retries: 3, attempts: 1, wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core := ellacoretest.NewServer(t)
+			core.Fail(tt.method, tt.path, tt.faults...)
+
+			client := newClient(t, core, ellacore.ClientOpts{
+				Token:      core.Token(),
+				Retries:    tt.retries,
+				RetryDelay: time.Millisecond,
+				Timeout:    100 * time.Millisecond,
+			})
+
+			var err error
+
+			if tt.method == http.MethodGet {
+				core.AddPolicy(ellacore.Policy{Name: "default"})
+				_, err = client.GetPolicy(context.Background(), "default")
+			} else {
+				err = client.CreatePolicy(context.Background(), &ellacore.Policy{Name: "default"})
+			}
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+
+			attempts := 0
+
+			for _, r := range core.Requests() {
+				if r == tt.method+" "+tt.path {
+					attempts++
+				}
+			}
+
+			if attempts != tt.attempts {
+				t.Fatalf("expected %d attempts, got %d", tt.attempts, attempts)
+			}
+		})
+	}
+}
+
+func TestRetryUnreachable(t *testing.T) {
+	core := ellacoretest.NewServer(t)
+	address := core.URL
+	core.Close()
+
+	client, err := ellacore.NewClient(&ellacore.ClientOpts{
+		Address:    address,
+		Token:      "token",
+		Retries:    2,
+		RetryDelay: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("could not create client: %v", err)
+	}
+
+	start := time.Now()
+
+	err = client.CreatePolicy(context.Background(), &ellacore.Policy{Name: "default"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// Two retries wait 1 ms then 2 ms: a refused connection is retried,
+	// even for a create, since the request never reached the core.
+	if time.Since(start) < 3*time.Millisecond {
+		t.Fatalf("expected the request to be retried, it failed after %s", time.Since(start))
+	}
+}
+
+func TestCreateAndDelete(t *testing.T) {
+	core := ellacoretest.NewServer(t)
+	client := newClient(t, core, ellacore.ClientOpts{Token: core.Token()})
+	ctx := context.Background()
+
+	err := client.CreatePolicy(ctx, &ellacore.Policy{Name: "default", DataNetworkName: "internet"})
+	if err != nil {
+		t.Fatalf("could not create policy: %v", err)
+	}
+
+	err = client.CreateSubscriber(ctx, &ellacore.Subscriber{IMSI: "001010100007487", PolicyName: "default"})
+	if err != nil {
+		t.Fatalf("could not create subscriber: %v", err)
+	}
+
+	subscriber, err := client.GetSubscriber(ctx, "001010100007487")
+	if err != nil {
+		t.Fatalf("could not get subscriber: %v", err)
+	}
+
+	if subscriber.PolicyName != "default" {
+		t.Fatalf("expected policy default, got %q", subscriber.PolicyName)
+	}
+
+	err = client.DeleteSubscriber(ctx, "001010100007487")
+	if err != nil {
+		t.Fatalf("could not delete subscriber: %v", err)
+	}
+
+	err = client.DeletePolicy(ctx, "default")
+	if err != nil {
+		t.Fatalf("could not delete policy: %v", err)
+	}
+
+	_, err = client.GetPolicy(ctx, "default")
+	if !errors.Is(err, ellacore.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after deletion, got %v", err)
+	}
+}
@@ -0,0 +1,336 @@
+// Package ellacoretest is a fake Ella Core API for tests: it keeps policies
+// and subscribers in memory, issues tokens at login, and answers chosen
+// requests with errors or delays.
+package ellacoretest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/ellacore"
+)
+
+const (
+	Email    = "admin@ellanetworks.com"
+	Password = "password"
+)
+
+// Fault replaces the answer to a request.
+type Fault struct {
+	// Status is answered instead of the normal answer, unless it is 0.
+	Status int
+	// Delay is waited before answering.
+	Delay time.Duration
+	// Apply applies the request before answering Status, as a core that
+	// fails after doing the work.
+	Apply bool
+}
+
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	token       string
+	tokens      int
+	logins      int
+	requests    []string
+	faults      map[string][]Fault // "METHOD /path" -> faults of the next requests
+	policies    map[string]ellacore.Policy
+	subscribers map[string]ellacore.Subscriber
+}
+
+// NewServer starts a fake core, closed at the end of the test. It accepts
+// the token returned by Token and the Email and Password logins.
+func NewServer(t testing.TB) *Server {
+	s := &Server{
+		faults:      make(map[string][]Fault),
+		policies:    make(map[string]ellacore.Policy),
+		subscribers: make(map[string]ellacore.Subscriber),
+	}
+	s.rotateToken()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/auth/login", s.login)
+	mux.HandleFunc("GET /api/v1/policies/{name}", s.authorized(s.getPolicy))
+	mux.HandleFunc("POST /api/v1/policies", s.authorized(s.createPolicy))
+	mux.HandleFunc("DELETE /api/v1/policies/{name}", s.authorized(s.deletePolicy))
+	mux.HandleFunc("GET /api/v1/subscribers/{imsi}", s.authorized(s.getSubscriber))
+	mux.HandleFunc("POST /api/v1/subscribers", s.authorized(s.createSubscriber))
+	mux.HandleFunc("DELETE /api/v1/subscribers/{imsi}", s.authorized(s.deleteSubscriber))
+
+	s.Server = httptest.NewServer(s.fault(mux))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// Token returns the token the API accepts.
+func (s *Server) Token() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.token
+}
+
+// ExpireToken makes the API answer 401 to the current token.
+func (s *Server) ExpireToken() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rotateToken()
+}
+
+// Logins returns the number of successful logins.
+func (s *Server) Logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.logins
+}
+
+// Requests returns the requests received, as "METHOD /path".
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+// Fail makes the next requests to method and path fail with faults, in
+// order.
+func (s *Server) Fail(method string, path string, faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := method + " " + path
+	s.faults[key] = append(s.faults[key], faults...)
+}
+
+func (s *Server) AddPolicy(policy ellacore.Policy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.policies[policy.Name] = policy
+}
+
+func (s *Server) Policy(name string) (ellacore.Policy, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	policy, ok := s.policies[name]
+
+	return policy, ok
+}
+
+func (s *Server) AddSubscriber(subscriber ellacore.Subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.subscribers[subscriber.IMSI] = subscriber
+}
+
+func (s *Server) Subscriber(imsi string) (ellacore.Subscriber, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscriber, ok := s.subscribers[imsi]
+
+	return subscriber, ok
+}
+
+func (s *Server) rotateToken() {
+	s.tokens++
+	s.token = fmt.Sprintf("token-%d", s.tokens)
+}
+
+// fault records the request and applies the next fault planned for it.
+func (s *Server) fault(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Method + " " + r.URL.Path
+
+		s.mu.Lock()
+		s.requests = append(s.requests, key)
+
+		var (
+			f       Fault
+			planned bool
+		)
+
+		if faults := s.faults[key]; len(faults) > 0 {
+			f, planned = faults[0], true
+			s.faults[key] = faults[1:]
+		}
+		s.mu.Unlock()
+
+		if !planned {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		time.Sleep(f.Delay)
+
+		if f.Status == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if f.Apply {
+			next.ServeHTTP(httptest.NewRecorder(), r)
+		}
+
+		writeError(w, f.Status, http.StatusText(f.Status))
+	})
+}
+
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if token != s.Token() {
+			writeError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	if !decode(w, r, &params) {
+		return
+	}
+
+	if params.Email != Email || params.Password != Password {
+		writeError(w, http.StatusUnauthorized, "The email or password is incorrect")
+		return
+	}
+
+	s.mu.Lock()
+	s.logins++
+	token := s.token
+	s.mu.Unlock()
+
+	writeResult(w, http.StatusOK, map[string]string{"token": token})
+}
+
+func (s *Server) getPolicy(w http.ResponseWriter, r *http.Request) {
+	policy, ok := s.Policy(r.PathValue("name"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Policy not found")
+		return
+	}
+
+	writeResult(w, http.StatusOK, policy)
+}
+
+func (s *Server) createPolicy(w http.ResponseWriter, r *http.Request) {
+	var policy ellacore.Policy
+
+	if !decode(w, r, &policy) {
+		return
+	}
+
+	if _, ok := s.Policy(policy.Name); ok {
+		writeError(w, http.StatusBadRequest, "Policy already exists")
+		return
+	}
+
+	s.AddPolicy(policy)
+	writeResult(w, http.StatusCreated, map[string]string{"message": "Policy created successfully"})
+}
+
+func (s *Server) deletePolicy(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	s.mu.Lock()
+	_, ok := s.policies[name]
+	delete(s.policies, name)
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "Policy not found")
+		return
+	}
+
+	writeResult(w, http.StatusOK, map[string]string{"message": "Policy deleted successfully"})
+}
+
+func (s *Server) getSubscriber(w http.ResponseWriter, r *http.Request) {
+	subscriber, ok := s.Subscriber(r.PathValue("imsi"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Subscriber not found")
+		return
+	}
+
+	writeResult(w, http.StatusOK, subscriber)
+}
+
+func (s *Server) createSubscriber(w http.ResponseWriter, r *http.Request) {
+	var subscriber ellacore.Subscriber
+
+	if !decode(w, r, &subscriber) {
+		return
+	}
+
+	if _, ok := s.Policy(subscriber.PolicyName); !ok {
+		writeError(w, http.StatusBadRequest, "Policy not found")
+		return
+	}
+
+	if _, ok := s.Subscriber(subscriber.IMSI); ok {
+		writeError(w, http.StatusBadRequest, "Subscriber already exists")
+		return
+	}
+
+	s.AddSubscriber(subscriber)
+	writeResult(w, http.StatusCreated, map[string]string{"message": "Subscriber created successfully"})
+}
+
+func (s *Server) deleteSubscriber(w http.ResponseWriter, r *http.Request) {
+	imsi := r.PathValue("imsi")
+
+	s.mu.Lock()
+	_, ok := s.subscribers[imsi]
+	delete(s.subscribers, imsi)
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "Subscriber not found")
+		return
+	}
+
+	writeResult(w, http.StatusOK, map[string]string{"message": "Subscriber deleted successfully"})
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request data")
+		return false
+	}
+
+	return true
+}
+
+func writeResult(w http.ResponseWriter, code int, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]any{"result": result})
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]any{"error": message})
+}
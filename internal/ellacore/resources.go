@@ -0,0 +1,83 @@
+package ellacore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+type Policy struct {
+	Name            string `json:"name"`
+	BitrateUplink   string `json:"bitrate_uplink"`
+	BitrateDownlink string `json:"bitrate_downlink"`
+	Var5qi          int32  `json:"var5qi"`
+	Arp             int32  `json:"arp"`
+	DataNetworkName string `json:"data_network_name"`
+}
+
+func (c *Client) GetPolicy(ctx context.Context, name string) (*Policy, error) {
+	var policy Policy
+
+	err := c.do(ctx, http.MethodGet, "/api/v1/policies/"+url.PathEscape(name), nil, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("could not get policy %s: %w", name, err)
+	}
+
+	return &policy, nil
+}
+
+func (c *Client) CreatePolicy(ctx context.Context, policy *Policy) error {
+	err := c.do(ctx, http.MethodPost, "/api/v1/policies", policy, nil)
+	if err != nil {
+		return fmt.Errorf("could not create policy %s: %w", policy.Name, err)
+	}
+
+	return nil
+}
+
+func (c *Client) DeletePolicy(ctx context.Context, name string) error {
+	err := c.do(ctx, http.MethodDelete, "/api/v1/policies/"+url.PathEscape(name), nil, nil)
+	if err != nil {
+		return fmt.Errorf("could not delete policy %s: %w", name, err)
+	}
+
+	return nil
+}
+
+type Subscriber struct {
+	IMSI           string `json:"imsi"`
+	Key            string `json:"key"`
+	OPC            string `json:"opc"`
+	SequenceNumber string `json:"sequenceNumber"`
+	PolicyName     string `json:"policyName"`
+}
+
+func (c *Client) GetSubscriber(ctx context.Context, imsi string) (*Subscriber, error) {
+	var subscriber Subscriber
+
+	err := c.do(ctx, http.MethodGet, "/api/v1/subscribers/"+url.PathEscape(imsi), nil, &subscriber)
+	if err != nil {
+		return nil, fmt.Errorf("could not get subscriber %s: %w", imsi, err)
+	}
+
+	return &subscriber, nil
+}
+
+func (c *Client) CreateSubscriber(ctx context.Context, subscriber *Subscriber) error {
+	err := c.do(ctx, http.MethodPost, "/api/v1/subscribers", subscriber, nil)
+	if err != nil {
+		return fmt.Errorf("could not create subscriber %s: %w", subscriber.IMSI, err)
+	}
+
+	return nil
+}
+
+func (c *Client) DeleteSubscriber(ctx context.Context, imsi string) error {
+	err := c.do(ctx, http.MethodDelete, "/api/v1/subscribers/"+url.PathEscape(imsi), nil, nil)
+	if err != nil {
+		return fmt.Errorf("could not delete subscriber %s: %w", imsi, err)
+	}
+
+	return nil
+}
@@ -68,7 +68,7 @@ func BuildInitialContextSetupResponse(opts *InitialContextSetupResponseOpts) (ng
 
 		pDUSessionResourceSetupItemCxtRes := ngapType.PDUSessionResourceSetupItemCxtRes{}
 
-		transferData, err := GetPDUSessionResourceSetupResponseTransfer(pduSession.N3GnbIp, pduSession.DLTeid, pduSession.QFI)
+		transferData, err := GetPDUSessionResourceSetupResponseTransfer(pduSession.N3GnbIp, pduSession.N3GnbIpV6, pduSession.DLTeid, pduSession.QFI)
 		if err != nil {
 			return pdu, fmt.Errorf("failed to get PDUSessionResourceSetupResponseTransfer: %v", err)
 		}
@@ -85,8 +85,10 @@ func BuildInitialContextSetupResponse(opts *InitialContextSetupResponseOpts) (ng
 	return pdu, nil
 }
 
-func GetPDUSessionResourceSetupResponseTransfer(ip netip.Addr, teid uint32, qosId int64) ([]byte, error) {
-	data, err := buildPDUSessionResourceSetupResponseTransfer(ip, teid, qosId)
+// GetPDUSessionResourceSetupResponseTransfer encodes the downlink tunnel of a
+// PDU session. ipv6 is only set by a dual-stack gNB, next to an IPv4 ip.
+func GetPDUSessionResourceSetupResponseTransfer(ip, ipv6 netip.Addr, teid uint32, qosId int64) ([]byte, error) {
+	data, err := buildPDUSessionResourceSetupResponseTransfer(ip, ipv6, teid, qosId)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build PDUSessionResourceSetupResponseTransfer: %v", err)
 	}
@@ -131,7 +133,7 @@ type PDUSessionResourceSetupResponseTransfer struct {
 	IEExtensions                       *ngapType.ProtocolExtensionContainerPDUSessionResourceSetupResponseTransferExtIEs `aper:"optional"`
 }
 
-func buildPDUSessionResourceSetupResponseTransfer(ip netip.Addr, teid uint32, qosId int64) (PDUSessionResourceSetupResponseTransfer, error) {
+func buildPDUSessionResourceSetupResponseTransfer(ip, ipv6 netip.Addr, teid uint32, qosId int64) (PDUSessionResourceSetupResponseTransfer, error) {
 	var data PDUSessionResourceSetupResponseTransfer
 
 	// QoS Flow per TNL Information
@@ -150,10 +152,15 @@ func buildPDUSessionResourceSetupResponseTransfer(ip netip.Addr, teid uint32, qo
 	dowlinkTeid := binary.BigEndian.AppendUint32(nil, teid)
 	upTransportLayerInformation.GTPTunnel.GTPTEID.Value = dowlinkTeid
 
-	if ip.Is4() {
+	// A dual-stack gNB advertises a 160-bit address, IPv4 then IPv6, and
+	// leaves the choice of family to the UPF (TS 38.414 section 5.1).
+	switch {
+	case ip.Is4() && ipv6.IsValid():
+		upTransportLayerInformation.GTPTunnel.TransportLayerAddress = ngapConvert.IPAddressToNgap(ip.String(), ipv6.WithZone("").String())
+	case ip.Is4():
 		upTransportLayerInformation.GTPTunnel.TransportLayerAddress = ngapConvert.IPAddressToNgap(ip.String(), "")
-	} else {
-		upTransportLayerInformation.GTPTunnel.TransportLayerAddress = ngapConvert.IPAddressToNgap("", ip.String())
+	default:
+		upTransportLayerInformation.GTPTunnel.TransportLayerAddress = ngapConvert.IPAddressToNgap("", ip.WithZone("").String())
 	}
 
 	// Associated QoS Flow List in QoS Flow per TNL Information
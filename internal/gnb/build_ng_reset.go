@@ -0,0 +1,57 @@
+package gnb
+
+import (
+	"fmt"
+
+	"github.com/free5gc/ngap/ngapType"
+)
+
+type NGResetOpts struct {
+	Cause ngapType.Cause
+}
+
+// BuildNGReset builds an NG Reset of the whole NG interface, which releases
+// the context of every UE of the gNodeB.
+func BuildNGReset(opts *NGResetOpts) (ngapType.NGAPPDU, error) {
+	if opts == nil {
+		return ngapType.NGAPPDU{}, fmt.Errorf("NGResetOpts is nil")
+	}
+
+	pdu := ngapType.NGAPPDU{}
+	pdu.Present = ngapType.NGAPPDUPresentInitiatingMessage
+	pdu.InitiatingMessage = new(ngapType.InitiatingMessage)
+
+	initiatingMessage := pdu.InitiatingMessage
+	initiatingMessage.ProcedureCode.Value = ngapType.ProcedureCodeNGReset
+	initiatingMessage.Criticality.Value = ngapType.CriticalityPresentReject
+
+	initiatingMessage.Value.Present = ngapType.InitiatingMessagePresentNGReset
+	initiatingMessage.Value.NGReset = new(ngapType.NGReset)
+
+	nGReset := initiatingMessage.Value.NGReset
+	nGResetIEs := &nGReset.ProtocolIEs
+
+	// Cause
+	ie := ngapType.NGResetIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDCause
+	ie.Criticality.Value = ngapType.CriticalityPresentIgnore
+	ie.Value.Present = ngapType.NGResetIEsPresentCause
+	ie.Value.Cause = &opts.Cause
+
+	nGResetIEs.List = append(nGResetIEs.List, ie)
+
+	// Reset Type
+	ie = ngapType.NGResetIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDResetType
+	ie.Criticality.Value = ngapType.CriticalityPresentReject
+	ie.Value.Present = ngapType.NGResetIEsPresentResetType
+	ie.Value.ResetType = new(ngapType.ResetType)
+
+	resetType := ie.Value.ResetType
+	resetType.Present = ngapType.ResetTypePresentNGInterface
+	resetType.NGInterface = &ngapType.ResetAll{Value: ngapType.ResetAllPresentResetAll}
+
+	nGResetIEs.List = append(nGResetIEs.List, ie)
+
+	return pdu, nil
+}
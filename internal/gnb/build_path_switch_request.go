@@ -0,0 +1,183 @@
+package gnb
+
+import (
+	"fmt"
+
+	"github.com/free5gc/aper"
+	"github.com/free5gc/nas/nasType"
+	"github.com/free5gc/ngap/ngapType"
+)
+
+type PathSwitchRequestOpts struct {
+	RANUENGAPID          int64
+	SourceAMFUENGAPID    int64
+	Mcc                  string
+	Mnc                  string
+	GnbID                string
+	Tac                  string
+	UESecurityCapability *nasType.UESecurityCapability
+	// PDUSessions are switched to the downlink tunnels of the target gNodeB
+	// they describe.
+	PDUSessions []*PDUSessionInformation
+}
+
+func BuildPathSwitchRequest(opts *PathSwitchRequestOpts) (ngapType.NGAPPDU, error) {
+	if opts == nil {
+		return ngapType.NGAPPDU{}, fmt.Errorf("PathSwitchRequestOpts is nil")
+	}
+
+	if opts.UESecurityCapability == nil {
+		return ngapType.NGAPPDU{}, fmt.Errorf("UE security capability is required to build PathSwitchRequest")
+	}
+
+	if len(opts.PDUSessions) == 0 {
+		return ngapType.NGAPPDU{}, fmt.Errorf("at least one PDU session is required to build PathSwitchRequest")
+	}
+
+	nrCellID, err := GetNRCellIdentity(opts.GnbID)
+	if err != nil {
+		return ngapType.NGAPPDU{}, fmt.Errorf("could not get nrCellID: %v", err)
+	}
+
+	tac, err := GetTacInBytes(opts.Tac)
+	if err != nil {
+		return ngapType.NGAPPDU{}, fmt.Errorf("could not get tac in bytes: %v", err)
+	}
+
+	plmnID := GetPLMNIdentity(opts.Mcc, opts.Mnc)
+
+	pdu := ngapType.NGAPPDU{}
+	pdu.Present = ngapType.NGAPPDUPresentInitiatingMessage
+	pdu.InitiatingMessage = new(ngapType.InitiatingMessage)
+
+	initiatingMessage := pdu.InitiatingMessage
+	initiatingMessage.ProcedureCode.Value = ngapType.ProcedureCodePathSwitchRequest
+	initiatingMessage.Criticality.Value = ngapType.CriticalityPresentReject
+
+	initiatingMessage.Value.Present = ngapType.InitiatingMessagePresentPathSwitchRequest
+	initiatingMessage.Value.PathSwitchRequest = new(ngapType.PathSwitchRequest)
+
+	ies := &initiatingMessage.Value.PathSwitchRequest.ProtocolIEs
+
+	// RAN UE NGAP ID
+	ie := ngapType.PathSwitchRequestIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDRANUENGAPID
+	ie.Criticality.Value = ngapType.CriticalityPresentReject
+	ie.Value.Present = ngapType.PathSwitchRequestIEsPresentRANUENGAPID
+	ie.Value.RANUENGAPID = &ngapType.RANUENGAPID{Value: opts.RANUENGAPID}
+
+	ies.List = append(ies.List, ie)
+
+	// Source AMF UE NGAP ID
+	ie = ngapType.PathSwitchRequestIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDSourceAMFUENGAPID
+	ie.Criticality.Value = ngapType.CriticalityPresentReject
+	ie.Value.Present = ngapType.PathSwitchRequestIEsPresentSourceAMFUENGAPID
+	ie.Value.SourceAMFUENGAPID = &ngapType.AMFUENGAPID{Value: opts.SourceAMFUENGAPID}
+
+	ies.List = append(ies.List, ie)
+
+	// User Location Information
+	ie = ngapType.PathSwitchRequestIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDUserLocationInformation
+	ie.Criticality.Value = ngapType.CriticalityPresentIgnore
+	ie.Value.Present = ngapType.PathSwitchRequestIEsPresentUserLocationInformation
+	ie.Value.UserLocationInformation = new(ngapType.UserLocationInformation)
+
+	userLocationInformation := ie.Value.UserLocationInformation
+	userLocationInformation.Present = ngapType.UserLocationInformationPresentUserLocationInformationNR
+	userLocationInformation.UserLocationInformationNR = new(ngapType.UserLocationInformationNR)
+
+	userLocationInformationNR := userLocationInformation.UserLocationInformationNR
+	userLocationInformationNR.NRCGI.PLMNIdentity = plmnID
+	userLocationInformationNR.NRCGI.NRCellIdentity = nrCellID
+
+	userLocationInformationNR.TAI.PLMNIdentity = plmnID
+	userLocationInformationNR.TAI.TAC.Value = tac
+
+	ies.List = append(ies.List, ie)
+
+	// UE Security Capabilities
+	ie = ngapType.PathSwitchRequestIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDUESecurityCapabilities
+	ie.Criticality.Value = ngapType.CriticalityPresentIgnore
+	ie.Value.Present = ngapType.PathSwitchRequestIEsPresentUESecurityCapabilities
+	ie.Value.UESecurityCapabilities = ueSecurityCapabilities(opts.UESecurityCapability)
+
+	ies.List = append(ies.List, ie)
+
+	// PDU Session Resource To Be Switched in Downlink List
+	ie = ngapType.PathSwitchRequestIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDPDUSessionResourceToBeSwitchedDLList
+	ie.Criticality.Value = ngapType.CriticalityPresentReject
+	ie.Value.Present = ngapType.PathSwitchRequestIEsPresentPDUSessionResourceToBeSwitchedDLList
+	ie.Value.PDUSessionResourceToBeSwitchedDLList = new(ngapType.PDUSessionResourceToBeSwitchedDLList)
+
+	toBeSwitchedList := ie.Value.PDUSessionResourceToBeSwitchedDLList
+
+	for _, pduSession := range opts.PDUSessions {
+		transfer, err := buildPathSwitchRequestTransfer(pduSession)
+		if err != nil {
+			return ngapType.NGAPPDU{}, fmt.Errorf("could not build PathSwitchRequestTransfer of PDU session %d: %v", pduSession.PDUSessionID, err)
+		}
+
+		item := ngapType.PDUSessionResourceToBeSwitchedDLItem{}
+		item.PDUSessionID.Value = pduSession.PDUSessionID
+		item.PathSwitchRequestTransfer = transfer
+
+		toBeSwitchedList.List = append(toBeSwitchedList.List, item)
+	}
+
+	ies.List = append(ies.List, ie)
+
+	return pdu, nil
+}
+
+// buildPathSwitchRequestTransfer describes the downlink tunnel of a PDU
+// session on the target gNodeB, as the setup response transfer does.
+func buildPathSwitchRequestTransfer(pduSession *PDUSessionInformation) (aper.OctetString, error) {
+	setup, err := buildPDUSessionResourceSetupResponseTransfer(pduSession.N3GnbIp, pduSession.N3GnbIpV6, pduSession.DLTeid, pduSession.QFI)
+	if err != nil {
+		return nil, err
+	}
+
+	transfer := ngapType.PathSwitchRequestTransfer{}
+	transfer.DLNGUUPTNLInformation = setup.QosFlowPerTNLInformation.UPTransportLayerInformation
+
+	acceptedItem := ngapType.QosFlowAcceptedItem{}
+	acceptedItem.QosFlowIdentifier.Value = pduSession.QFI
+	transfer.QosFlowAcceptedList.List = append(transfer.QosFlowAcceptedList.List, acceptedItem)
+
+	encoded, err := aper.MarshalWithParams(transfer, "valueExt")
+	if err != nil {
+		return nil, fmt.Errorf("could not encode PathSwitchRequestTransfer: %v", err)
+	}
+
+	return encoded, nil
+}
+
+// ueSecurityCapabilities converts the NAS security capability of a UE to
+// NGAP, where the first three bits of each list are the 128-bit algorithms
+// 1 to 3 (TS 38.413 9.3.1.86).
+func ueSecurityCapabilities(nasCap *nasType.UESecurityCapability) *ngapType.UESecurityCapabilities {
+	algorithms := func(a1, a2, a3 uint8) aper.BitString {
+		return aper.BitString{
+			Bytes:     []byte{a1<<7 | a2<<6 | a3<<5, 0x00},
+			BitLength: 16,
+		}
+	}
+
+	caps := &ngapType.UESecurityCapabilities{}
+	caps.NRencryptionAlgorithms.Value = algorithms(nasCap.GetEA1_128_5G(), nasCap.GetEA2_128_5G(), nasCap.GetEA3_128_5G())
+	caps.NRintegrityProtectionAlgorithms.Value = algorithms(nasCap.GetIA1_128_5G(), nasCap.GetIA2_128_5G(), nasCap.GetIA3_128_5G())
+	caps.EUTRAencryptionAlgorithms.Value = algorithms(0, 0, 0)
+	caps.EUTRAintegrityProtectionAlgorithms.Value = algorithms(0, 0, 0)
+
+	// The EPS algorithms follow the 5G ones when the UE supports EPS.
+	if nasCap.GetLen() >= 4 {
+		caps.EUTRAencryptionAlgorithms.Value = algorithms(nasCap.GetEEA1_128(), nasCap.GetEEA2_128(), nasCap.GetEEA3_128())
+		caps.EUTRAintegrityProtectionAlgorithms.Value = algorithms(nasCap.GetEIA1_128(), nasCap.GetEIA2_128(), nasCap.GetEIA3_128())
+	}
+
+	return caps
+}
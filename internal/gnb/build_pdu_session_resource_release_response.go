@@ -0,0 +1,82 @@
+package gnb
+
+import (
+	"fmt"
+
+	"github.com/free5gc/aper"
+	"github.com/free5gc/ngap/ngapType"
+)
+
+type PDUSessionResourceReleaseResponseOpts struct {
+	AMFUENGAPID   int64
+	RANUENGAPID   int64
+	PDUSessionIDs []int64
+}
+
+func BuildPDUSessionResourceReleaseResponse(opts *PDUSessionResourceReleaseResponseOpts) (ngapType.NGAPPDU, error) {
+	if opts == nil {
+		return ngapType.NGAPPDU{}, fmt.Errorf("PDUSessionResourceReleaseResponseOpts is nil")
+	}
+
+	if len(opts.PDUSessionIDs) == 0 {
+		return ngapType.NGAPPDU{}, fmt.Errorf("at least one PDU session is required to build PDUSessionResourceReleaseResponse")
+	}
+
+	// The transfer has no mandatory IE, so every session carries the same.
+	transfer, err := aper.MarshalWithParams(ngapType.PDUSessionResourceReleaseResponseTransfer{}, "valueExt")
+	if err != nil {
+		return ngapType.NGAPPDU{}, fmt.Errorf("could not encode PDUSessionResourceReleaseResponseTransfer: %v", err)
+	}
+
+	pdu := ngapType.NGAPPDU{}
+	pdu.Present = ngapType.NGAPPDUPresentSuccessfulOutcome
+	pdu.SuccessfulOutcome = new(ngapType.SuccessfulOutcome)
+
+	successfulOutcome := pdu.SuccessfulOutcome
+	successfulOutcome.ProcedureCode.Value = ngapType.ProcedureCodePDUSessionResourceRelease
+	successfulOutcome.Criticality.Value = ngapType.CriticalityPresentReject
+
+	successfulOutcome.Value.Present = ngapType.SuccessfulOutcomePresentPDUSessionResourceReleaseResponse
+	successfulOutcome.Value.PDUSessionResourceReleaseResponse = new(ngapType.PDUSessionResourceReleaseResponse)
+
+	ies := &successfulOutcome.Value.PDUSessionResourceReleaseResponse.ProtocolIEs
+
+	// AMF UE NGAP ID
+	ie := ngapType.PDUSessionResourceReleaseResponseIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDAMFUENGAPID
+	ie.Criticality.Value = ngapType.CriticalityPresentIgnore
+	ie.Value.Present = ngapType.PDUSessionResourceReleaseResponseIEsPresentAMFUENGAPID
+	ie.Value.AMFUENGAPID = &ngapType.AMFUENGAPID{Value: opts.AMFUENGAPID}
+
+	ies.List = append(ies.List, ie)
+
+	// RAN UE NGAP ID
+	ie = ngapType.PDUSessionResourceReleaseResponseIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDRANUENGAPID
+	ie.Criticality.Value = ngapType.CriticalityPresentIgnore
+	ie.Value.Present = ngapType.PDUSessionResourceReleaseResponseIEsPresentRANUENGAPID
+	ie.Value.RANUENGAPID = &ngapType.RANUENGAPID{Value: opts.RANUENGAPID}
+
+	ies.List = append(ies.List, ie)
+
+	// PDU Session Resource Released List
+	ie = ngapType.PDUSessionResourceReleaseResponseIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDPDUSessionResourceReleasedListRelRes
+	ie.Criticality.Value = ngapType.CriticalityPresentIgnore
+	ie.Value.Present = ngapType.PDUSessionResourceReleaseResponseIEsPresentPDUSessionResourceReleasedListRelRes
+	ie.Value.PDUSessionResourceReleasedListRelRes = new(ngapType.PDUSessionResourceReleasedListRelRes)
+
+	releasedList := ie.Value.PDUSessionResourceReleasedListRelRes
+
+	for _, pduSessionID := range opts.PDUSessionIDs {
+		item := ngapType.PDUSessionResourceReleasedItemRelRes{}
+		item.PDUSessionID.Value = pduSessionID
+		item.PDUSessionResourceReleaseResponseTransfer = transfer
+
+		releasedList.List = append(releasedList.List, item)
+	}
+
+	ies.List = append(ies.List, ie)
+
+	return pdu, nil
+}
@@ -74,7 +74,7 @@ func BuildPDUSessionResourceSetupResponse(opts *PDUSessionResourceSetupResponseO
 		// PDU Session ID : This is an unique identifier generated by UE. Can’t be same as any existing PDU session.
 		pDUSessionResourceSetupItemSURes.PDUSessionID.Value = pduSession.PDUSessionID
 
-		respTransf, err := GetPDUSessionResourceSetupResponseTransfer(pduSession.N3GnbIp, pduSession.DLTeid, pduSession.QFI)
+		respTransf, err := GetPDUSessionResourceSetupResponseTransfer(pduSession.N3GnbIp, pduSession.N3GnbIpV6, pduSession.DLTeid, pduSession.QFI)
 		if err != nil {
 			return pdu, fmt.Errorf("failed to get PDUSessionResourceSetupResponseTransfer: %v", err)
 		}
@@ -0,0 +1,92 @@
+package gnb
+
+import (
+	"fmt"
+
+	"github.com/free5gc/ngap/ngapType"
+)
+
+type UEContextReleaseRequestOpts struct {
+	AMFUENGAPID   int64
+	RANUENGAPID   int64
+	PDUSessionIDs [16]bool
+	Cause         ngapType.Cause
+}
+
+func BuildUEContextReleaseRequest(opts *UEContextReleaseRequestOpts) (ngapType.NGAPPDU, error) {
+	if opts == nil {
+		return ngapType.NGAPPDU{}, fmt.Errorf("UEContextReleaseRequestOpts is nil")
+	}
+
+	pdu := ngapType.NGAPPDU{}
+	pdu.Present = ngapType.NGAPPDUPresentInitiatingMessage
+	pdu.InitiatingMessage = new(ngapType.InitiatingMessage)
+
+	initiatingMessage := pdu.InitiatingMessage
+	initiatingMessage.ProcedureCode.Value = ngapType.ProcedureCodeUEContextReleaseRequest
+	initiatingMessage.Criticality.Value = ngapType.CriticalityPresentIgnore
+
+	initiatingMessage.Value.Present = ngapType.InitiatingMessagePresentUEContextReleaseRequest
+	initiatingMessage.Value.UEContextReleaseRequest = new(ngapType.UEContextReleaseRequest)
+
+	ueContextReleaseRequest := initiatingMessage.Value.UEContextReleaseRequest
+	ueContextReleaseRequestIEs := &ueContextReleaseRequest.ProtocolIEs
+
+	// AMF UE NGAP ID
+	ie := ngapType.UEContextReleaseRequestIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDAMFUENGAPID
+	ie.Criticality.Value = ngapType.CriticalityPresentReject
+	ie.Value.Present = ngapType.UEContextReleaseRequestIEsPresentAMFUENGAPID
+	ie.Value.AMFUENGAPID = new(ngapType.AMFUENGAPID)
+
+	aMFUENGAPID := ie.Value.AMFUENGAPID
+	aMFUENGAPID.Value = opts.AMFUENGAPID
+
+	ueContextReleaseRequestIEs.List = append(ueContextReleaseRequestIEs.List, ie)
+
+	// RAN UE NGAP ID
+	ie = ngapType.UEContextReleaseRequestIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDRANUENGAPID
+	ie.Criticality.Value = ngapType.CriticalityPresentReject
+	ie.Value.Present = ngapType.UEContextReleaseRequestIEsPresentRANUENGAPID
+	ie.Value.RANUENGAPID = new(ngapType.RANUENGAPID)
+
+	rANUENGAPID := ie.Value.RANUENGAPID
+	rANUENGAPID.Value = opts.RANUENGAPID
+
+	ueContextReleaseRequestIEs.List = append(ueContextReleaseRequestIEs.List, ie)
+
+	// PDU Session Resource List
+	ie = ngapType.UEContextReleaseRequestIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDPDUSessionResourceListCxtRelReq
+	ie.Criticality.Value = ngapType.CriticalityPresentReject
+	ie.Value.Present = ngapType.UEContextReleaseRequestIEsPresentPDUSessionResourceListCxtRelReq
+	ie.Value.PDUSessionResourceListCxtRelReq = new(ngapType.PDUSessionResourceListCxtRelReq)
+
+	pDUSessionResourceListCxtRelReq := ie.Value.PDUSessionResourceListCxtRelReq
+
+	for i, pduSessionID := range opts.PDUSessionIDs {
+		if !pduSessionID {
+			continue
+		}
+
+		pDUSessionResourceItem := ngapType.PDUSessionResourceItemCxtRelReq{}
+		pDUSessionResourceItem.PDUSessionID.Value = int64(i)
+		pDUSessionResourceListCxtRelReq.List = append(pDUSessionResourceListCxtRelReq.List, pDUSessionResourceItem)
+	}
+
+	if len(pDUSessionResourceListCxtRelReq.List) > 0 {
+		ueContextReleaseRequestIEs.List = append(ueContextReleaseRequestIEs.List, ie)
+	}
+
+	// Cause
+	ie = ngapType.UEContextReleaseRequestIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDCause
+	ie.Criticality.Value = ngapType.CriticalityPresentIgnore
+	ie.Value.Present = ngapType.UEContextReleaseRequestIEsPresentCause
+	ie.Value.Cause = &opts.Cause
+
+	ueContextReleaseRequestIEs.List = append(ueContextReleaseRequestIEs.List, ie)
+
+	return pdu, nil
+}
@@ -0,0 +1,67 @@
+package gnb
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ishidawataru/sctp"
+	"go.uber.org/zap"
+)
+
+// captureN2 records an NGAP message exchanged with the AMF in the packet
+// capture, if one is enabled.
+func (g *GnodeB) captureN2(outbound bool, stream uint16, ppid uint32, payload []byte) {
+	if g.capture == nil {
+		return
+	}
+
+	src, dst := g.n2Local, g.n2Remote
+	if !outbound {
+		src, dst = dst, src
+	}
+
+	err := g.capture.WriteSCTP(src, dst, stream, ppid, payload)
+	if err != nil {
+		logger.GnbLogger.Warn("could not capture NGAP message", zap.Error(err))
+	}
+}
+
+// captureN3 records a GTP-U packet exchanged with a UPF on an N3 socket in
+// the packet capture, if one is enabled.
+func (g *GnodeB) captureN3(s *n3Socket, peer net.Addr, outbound bool, payload []byte) {
+	if g.capture == nil {
+		return
+	}
+
+	udpAddr, ok := peer.(*net.UDPAddr)
+	if !ok {
+		return
+	}
+
+	src := netip.AddrPortFrom(s.addr, gtpuPort)
+
+	dst := udpAddr.AddrPort()
+	dst = netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port())
+
+	if !outbound {
+		src, dst = dst, src
+	}
+
+	err := g.capture.WriteUDP(src, dst, payload)
+	if err != nil {
+		logger.GnbLogger.Warn("could not capture GTP-U packet", zap.Error(err))
+	}
+}
+
+// sctpAddrPort returns the primary address of an SCTP endpoint.
+func sctpAddrPort(addr net.Addr) netip.AddrPort {
+	sctpAddr, ok := addr.(*sctp.SCTPAddr)
+	if !ok || len(sctpAddr.IPAddrs) == 0 {
+		return netip.AddrPort{}
+	}
+
+	ip, _ := netip.AddrFromSlice(sctpAddr.IPAddrs[0].IP)
+
+	return netip.AddrPortFrom(ip.Unmap(), uint16(sctpAddr.Port))
+}
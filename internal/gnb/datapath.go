@@ -0,0 +1,250 @@
+package gnb
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"go.uber.org/zap"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	maxPacketSize    = 2000
+	defaultBatchSize = 32
+)
+
+// DataPathOpts sizes the N3 user plane. The zero value runs a single worker
+// in each direction on single-queue TUN devices.
+type DataPathOpts struct {
+	Workers   int // goroutines receiving from and sending to the N3 socket
+	TUNQueues int // queues opened on each TUN device, each with its own reader
+	BatchSize int // packets per recvmmsg/sendmmsg call
+}
+
+func (o DataPathOpts) withDefaults() DataPathOpts {
+	if o.Workers <= 0 {
+		o.Workers = 1
+	}
+
+	if o.TUNQueues <= 0 {
+		o.TUNQueues = 1
+	}
+
+	if o.BatchSize <= 0 {
+		o.BatchSize = defaultBatchSize
+	}
+
+	return o
+}
+
+// batchConn is implemented by ipv4.PacketConn and ipv6.PacketConn, which use
+// recvmmsg and sendmmsg on Linux.
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+func newBatchConn(conn *net.UDPConn, addr netip.Addr) batchConn {
+	if addr.Is4() {
+		return ipv4.NewPacketConn(conn)
+	}
+
+	return ipv6.NewPacketConn(conn)
+}
+
+// n3Socket is a GTP-U endpoint of the gNodeB. A dual-stack gNodeB has one
+// per address family, each with its own workers.
+type n3Socket struct {
+	addr   netip.Addr
+	conn   *net.UDPConn
+	batch  batchConn
+	uplink chan *uplinkPacket
+}
+
+func listenN3(addr netip.Addr, queueLen int) (*n3Socket, error) {
+	conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(addr, gtpuPort)))
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on GTP-U UDP address %s: %v", addr, err)
+	}
+
+	return &n3Socket{
+		addr:   addr,
+		conn:   conn,
+		batch:  newBatchConn(conn, addr),
+		uplink: make(chan *uplinkPacket, queueLen),
+	}, nil
+}
+
+// n3SocketFor returns the N3 socket of the peer's address family, or nil if
+// the gNodeB has no N3 address in that family.
+func (g *GnodeB) n3SocketFor(peer netip.Addr) *n3Socket {
+	peer = peer.Unmap()
+
+	for _, s := range g.n3 {
+		if s.addr.Is4() == peer.Is4() {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// upfEndpoint resolves the N3 address of a UPF and the socket that tunnels
+// towards it use.
+func (g *GnodeB) upfEndpoint(upfIP string) (*net.UDPAddr, *n3Socket, error) {
+	addr, err := netip.ParseAddr(upfIP)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse UPF address %q: %v", upfIP, err)
+	}
+
+	addr = addr.Unmap()
+
+	s := g.n3SocketFor(addr)
+	if s == nil {
+		return nil, nil, fmt.Errorf("gNodeB has no N3 address in the address family of UPF %s", addr)
+	}
+
+	return net.UDPAddrFromAddrPort(netip.AddrPortFrom(addr, gtpuPort)), s, nil
+}
+
+// n3Addresses returns the N3 addresses of the gNodeB. N3AddressV6 is only set
+// next to an IPv4 N3Address, so a dual-stack gNodeB lists IPv6 first and
+// prefers IPv6 transport when the UPF offers both.
+func (g *GnodeB) n3Addresses() []netip.Addr {
+	var addrs []netip.Addr
+
+	for _, addr := range []netip.Addr{g.N3AddressV6, g.N3Address} {
+		if addr.IsValid() {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs
+}
+
+// uplinkPacket is an encapsulated uplink T-PDU waiting for an uplink sender.
+type uplinkPacket struct {
+	tunnel *Tunnel
+	buf    [maxPacketSize]byte
+	len    int
+}
+
+// startDataPath starts the receive workers and uplink senders of every N3
+// socket.
+func (g *GnodeB) startDataPath() {
+	for _, s := range g.n3 {
+		for range g.dataPath.Workers {
+			go g.gtpReader(s)
+			go g.uplinkSender(s)
+		}
+	}
+}
+
+// uplinkSender collects the uplink packets available from the TUN readers
+// for one N3 socket and sends them to the UPFs with as few system calls as
+// possible.
+func (g *GnodeB) uplinkSender(s *n3Socket) {
+	batch := make([]*uplinkPacket, 0, g.dataPath.BatchSize)
+
+	msgs := make([]ipv4.Message, g.dataPath.BatchSize)
+	for i := range msgs {
+		msgs[i].Buffers = make([][]byte, 1)
+	}
+
+	for {
+		select {
+		case <-g.closed:
+			return
+		case p := <-s.uplink:
+			batch = append(batch, p)
+		}
+
+	drain:
+		for len(batch) < cap(batch) {
+			select {
+			case p := <-s.uplink:
+				batch = append(batch, p)
+			default:
+				break drain
+			}
+		}
+
+		g.sendUplinkBatch(s, batch, msgs[:len(batch)])
+
+		for i, p := range batch {
+			g.uplinkPool.Put(p)
+			batch[i] = nil
+		}
+
+		batch = batch[:0]
+	}
+}
+
+func (g *GnodeB) sendUplinkBatch(s *n3Socket, batch []*uplinkPacket, msgs []ipv4.Message) {
+	for i, p := range batch {
+		msgs[i].Buffers[0] = p.buf[:p.len]
+		msgs[i].Addr = p.tunnel.upfAddr
+	}
+
+	for sent := 0; sent < len(msgs); {
+		n, err := s.batch.WriteBatch(msgs[sent:], 0)
+
+		// sendmmsg reports -1 with its error when it sent nothing.
+		n = max(n, 0)
+
+		if n == 0 && err == nil {
+			err = fmt.Errorf("sendmmsg sent no packet")
+		}
+
+		for _, p := range batch[sent : sent+n] {
+			p.tunnel.counters.countUplink(p.len - gtpHeaderLen)
+			g.captureN3(s, p.tunnel.upfAddr, true, p.buf[:p.len])
+		}
+
+		sent += n
+
+		if err != nil {
+			if isClosedErr(err) {
+				return
+			}
+
+			logger.GnbLogger.Error("error writing to GTP-U socket", zap.Error(err))
+
+			// The first unsent packet is the one that failed; skip it and
+			// carry on with the rest of the batch.
+			if sent < len(msgs) {
+				batch[sent].tunnel.counters.countUplinkError()
+				sent++
+			}
+		}
+	}
+
+	logger.GnbLogger.Debug("Sent packets to GTP", zap.Int("count", len(msgs)))
+}
+
+// publishTunnels replaces the lock-free TEID lookup table with a copy of the
+// current tunnels. It must be called with g.mu held whenever g.tunnels
+// changes.
+func (g *GnodeB) publishTunnels() {
+	table := make(map[uint32]*Tunnel, len(g.tunnels))
+	for teid, t := range g.tunnels {
+		table[teid] = t
+	}
+
+	g.tunnelTable.Store(&table)
+}
+
+// lookupTunnel finds the tunnel of a downlink TEID without taking g.mu.
+func (g *GnodeB) lookupTunnel(teid uint32) (*Tunnel, bool) {
+	table := g.tunnelTable.Load()
+	if table == nil {
+		return nil, false
+	}
+
+	t, ok := (*table)[teid]
+
+	return t, ok
+}
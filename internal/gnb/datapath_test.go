@@ -0,0 +1,135 @@
+package gnb
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"go.uber.org/zap"
+	"golang.org/x/net/ipv4"
+)
+
+// fakeBatchConn returns one scripted result per WriteBatch call, then sends
+// every message.
+type fakeBatchConn struct {
+	results []batchResult
+	calls   int
+}
+
+type batchResult struct {
+	n   int
+	err error
+}
+
+func (c *fakeBatchConn) ReadBatch([]ipv4.Message, int) (int, error) {
+	return 0, nil
+}
+
+func (c *fakeBatchConn) WriteBatch(ms []ipv4.Message, _ int) (int, error) {
+	c.calls++
+
+	if len(c.results) == 0 {
+		return len(ms), nil
+	}
+
+	r := c.results[0]
+	c.results = c.results[1:]
+
+	return r.n, r.err
+}
+
+func uplinkBatch(t *testing.T, size int) ([]*uplinkPacket, []ipv4.Message) {
+	t.Helper()
+
+	upf := &net.UDPAddr{IP: net.IPv4(10, 3, 0, 2), Port: gtpuPort}
+
+	batch := make([]*uplinkPacket, size)
+	msgs := make([]ipv4.Message, size)
+
+	for i := range batch {
+		batch[i] = &uplinkPacket{tunnel: &Tunnel{upfAddr: upf}, len: gtpHeaderLen + 10}
+		msgs[i].Buffers = make([][]byte, 1)
+	}
+
+	return batch, msgs
+}
+
+func TestSendUplinkBatch(t *testing.T) {
+	logger.GnbLogger = zap.NewNop()
+
+	tests := []struct {
+		name    string
+		results []batchResult
+		sent    []uint64 // uplink packets counted per tunnel
+		errors  []uint64 // uplink errors counted per tunnel
+	}{
+		{
+			name:   "all sent",
+			sent:   []uint64{1, 1, 1},
+			errors: []uint64{0, 0, 0},
+		},
+		{
+			name:    "first packet fails",
+			results: []batchResult{{n: -1, err: syscall.ENETUNREACH}},
+			sent:    []uint64{0, 1, 1},
+			errors:  []uint64{1, 0, 0},
+		},
+		{
+			name:    "packet after a partial send fails",
+			results: []batchResult{{n: 1, err: nil}, {n: -1, err: syscall.ENOBUFS}},
+			sent:    []uint64{1, 0, 1},
+			errors:  []uint64{0, 1, 0},
+		},
+		{
+			name:    "every packet fails",
+			results: []batchResult{{n: -1, err: syscall.EPERM}, {n: -1, err: syscall.EPERM}, {n: -1, err: syscall.EPERM}},
+			sent:    []uint64{0, 0, 0},
+			errors:  []uint64{1, 1, 1},
+		},
+		{
+			name:    "closed socket",
+			results: []batchResult{{n: -1, err: net.ErrClosed}},
+			sent:    []uint64{0, 0, 0},
+			errors:  []uint64{0, 0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeBatchConn{results: tt.results}
+			batch, msgs := uplinkBatch(t, 3)
+
+			g := &GnodeB{}
+			g.sendUplinkBatch(&n3Socket{batch: conn}, batch, msgs)
+
+			for i, p := range batch {
+				if got := p.tunnel.counters.uplinkPackets.Load(); got != tt.sent[i] {
+					t.Errorf("packet %d: expected %d sent, got %d", i, tt.sent[i], got)
+				}
+
+				if got := p.tunnel.counters.uplinkErrors.Load(); got != tt.errors[i] {
+					t.Errorf("packet %d: expected %d errors, got %d", i, tt.errors[i], got)
+				}
+			}
+		})
+	}
+}
+
+func TestSendUplinkBatchNoProgress(t *testing.T) {
+	logger.GnbLogger = zap.NewNop()
+
+	conn := &fakeBatchConn{results: []batchResult{{n: 0, err: nil}}}
+	batch, msgs := uplinkBatch(t, 2)
+
+	g := &GnodeB{}
+	g.sendUplinkBatch(&n3Socket{batch: conn}, batch, msgs)
+
+	if conn.calls != 2 {
+		t.Fatalf("expected the batch to be retried once past the stuck packet, got %d calls", conn.calls)
+	}
+
+	if batch[0].tunnel.counters.uplinkErrors.Load() != 1 {
+		t.Errorf("expected the stuck packet to be counted as an error")
+	}
+}
@@ -6,27 +6,65 @@ package gnb
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/metrics"
 	"github.com/songgao/water"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
 	"go.uber.org/zap"
+	"golang.org/x/net/ipv4"
 )
 
 const (
 	gtpHeaderLen int    = 16
 	gtpExtLen    uint16 = 8
+	gtpuPort            = 2152
 )
 
+// GTP-U message types, TS 29.281 table 6.1-1
+const (
+	gtpMessageEchoRequest     uint8 = 1
+	gtpMessageEchoResponse    uint8 = 2
+	gtpMessageErrorIndication uint8 = 26
+	gtpMessageEndMarker       uint8 = 254
+	gtpMessageTPDU            uint8 = 0xFF
+)
+
+// tunnelEndpoint is the UE side of a tunnel: a TUN or TAP device for IP and
+// Ethernet sessions, or a datagram socket for Unstructured sessions.
+type tunnelEndpoint interface {
+	Read(p []byte) (int, error)
+	Write(p []byte) (int, error)
+	Close() error
+}
+
 type Tunnel struct {
-	Name    string
-	tunIF   *water.Interface
-	upfAddr *net.UDPAddr
-	ulteid  uint32
-	dlteid  uint32
-	qfi     uint8
+	Name      string
+	Namespace string           // network namespace holding the TUN device, empty for the host namespace
+	device    bool             // the endpoint is a kernel TUN or TAP device
+	endpoint  tunnelEndpoint   // downlink packets are written here
+	queues    []tunnelEndpoint // uplink packets are read from each queue, endpoint included
+	upfAddr   *net.UDPAddr
+	n3        *n3Socket // N3 socket of the UPF's address family
+	ulteid    uint32
+	dlteid    uint32
+	qfi       uint8
+
+	// End Markers received on this tunnel, guarded by the gNodeB mutex.
+	endMarkers    int
+	lastEndMarker time.Time
+
+	counters     tunnelCounters
+	downlinkHook atomic.Pointer[func(packet []byte) bool]
 }
 
 type NewTunnelOpts struct {
@@ -38,83 +76,375 @@ type NewTunnelOpts struct {
 	DLteid           uint32
 	MTU              uint16
 	QFI              uint8
+	Ethernet         bool   // create a TAP device carrying Ethernet frames instead of a TUN device
+	Namespace        string // move the device into this new network namespace and route all traffic through it
 }
 
 func (g *GnodeB) AddTunnel(opts *NewTunnelOpts) (*Tunnel, error) {
+	upfAddr, n3, err := g.upfEndpoint(opts.UpfIP)
+	if err != nil {
+		return nil, err
+	}
+
 	config := water.Config{
 		DeviceType: water.TUN,
 	}
 
+	if opts.Ethernet {
+		config.DeviceType = water.TAP
+	}
+
 	config.Name = opts.TunInterfaceName
+	config.MultiQueue = g.dataPath.TUNQueues > 1
+
+	ifces, err := openTunQueues(config, g.dataPath.TUNQueues)
+	if err != nil {
+		return nil, err
+	}
 
-	ifce, err := water.New(config)
+	name := ifces[0].Name()
+
+	queues := make([]tunnelEndpoint, 0, len(ifces))
+	for _, ifce := range ifces {
+		queues = append(queues, ifce)
+	}
+
+	err = configureTunInterface(name, opts)
 	if err != nil {
-		return nil, fmt.Errorf("could not open TUN interface: %v", err)
+		closeTunQueues(name, queues)
+
+		if opts.Namespace != "" {
+			if nsErr := netns.DeleteNamed(opts.Namespace); nsErr != nil {
+				logger.GnbLogger.Error("error deleting network namespace", zap.String("netns", opts.Namespace), zap.Error(nsErr))
+			}
+		}
+
+		return nil, err
+	}
+
+	tunnel := &Tunnel{
+		Name:      name,
+		Namespace: opts.Namespace,
+		device:    true,
+		endpoint:  queues[0],
+		queues:    queues,
+		ulteid:    opts.ULteid,
+		dlteid:    opts.DLteid,
+		upfAddr:   upfAddr,
+		n3:        n3,
+		qfi:       opts.QFI,
+	}
+
+	g.startTunnel(tunnel)
+
+	return tunnel, nil
+}
+
+// openTunQueues opens the device n times. With multi-queue enabled, every
+// file descriptor is a separate queue of the same interface, so the kernel
+// spreads uplink flows across the readers.
+func openTunQueues(config water.Config, n int) ([]*water.Interface, error) {
+	ifces := make([]*water.Interface, 0, n)
+
+	for i := 0; i < n; i++ {
+		ifce, err := water.New(config)
+		if err != nil {
+			for _, opened := range ifces {
+				if closeErr := opened.Close(); closeErr != nil {
+					logger.GnbLogger.Error("error closing TUN interface", zap.String("if", opened.Name()), zap.Error(closeErr))
+				}
+			}
+
+			return nil, fmt.Errorf("could not open TUN interface: %v", err)
+		}
+
+		// The remaining queues attach to the interface the first one created.
+		config.Name = ifce.Name()
+		ifces = append(ifces, ifce)
+	}
+
+	return ifces, nil
+}
+
+func closeTunQueues(name string, queues []tunnelEndpoint) {
+	for _, queue := range queues {
+		if err := queue.Close(); err != nil {
+			logger.GnbLogger.Error("error closing TUN interface", zap.String("if", name), zap.Error(err))
+		}
 	}
+}
 
-	eth, err := netlink.LinkByName(ifce.Name())
+// configureTunInterface brings the TUN device up with the UE addresses. When
+// a namespace is requested, the device is first moved into a new network
+// namespace where it also becomes the default route.
+func configureTunInterface(name string, opts *NewTunnelOpts) error {
+	eth, err := netlink.LinkByName(name)
 	if err != nil {
-		return nil, fmt.Errorf("cannot read TUN interface: %v", err)
+		return fmt.Errorf("cannot read TUN interface: %v", err)
+	}
+
+	var h *netlink.Handle
+
+	if opts.Namespace == "" {
+		h, err = netlink.NewHandle()
+		if err != nil {
+			return fmt.Errorf("could not open netlink handle: %v", err)
+		}
+	} else {
+		ns, err := createNamespace(opts.Namespace)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			if err := ns.Close(); err != nil {
+				logger.GnbLogger.Warn("could not close network namespace handle", zap.Error(err))
+			}
+		}()
+
+		err = netlink.LinkSetNsFd(eth, int(ns))
+		if err != nil {
+			return fmt.Errorf("could not move TUN interface to network namespace %s: %v", opts.Namespace, err)
+		}
+
+		h, err = netlink.NewHandleAt(ns)
+		if err != nil {
+			return fmt.Errorf("could not open netlink handle in network namespace %s: %v", opts.Namespace, err)
+		}
+
+		eth, err = h.LinkByName(name)
+		if err != nil {
+			return fmt.Errorf("cannot read TUN interface in network namespace %s: %v", opts.Namespace, err)
+		}
+
+		lo, err := h.LinkByName("lo")
+		if err != nil {
+			return fmt.Errorf("cannot read loopback interface in network namespace %s: %v", opts.Namespace, err)
+		}
+
+		err = h.LinkSetUp(lo)
+		if err != nil {
+			return fmt.Errorf("could not set loopback interface UP in network namespace %s: %v", opts.Namespace, err)
+		}
 	}
 
-	err = netlink.LinkSetUp(eth)
+	defer h.Close()
+
+	err = h.LinkSetUp(eth)
 	if err != nil {
-		return nil, fmt.Errorf("could not set TUN interface UP: %v", err)
+		return fmt.Errorf("could not set TUN interface UP: %v", err)
 	}
 
-	err = delAutoLinkLocal(eth)
+	err = delAutoLinkLocal(h, eth)
 	if err != nil {
-		return nil, fmt.Errorf("could not clean up auto-assigned link-local addresses: %v", err)
+		return fmt.Errorf("could not clean up auto-assigned link-local addresses: %v", err)
 	}
 
 	if opts.UEIP != "" {
 		ueAddr, err := netlink.ParseAddr(opts.UEIP)
 		if err != nil {
-			return nil, fmt.Errorf("could not parse UE IPv4 address: %v", err)
+			return fmt.Errorf("could not parse UE IPv4 address: %v", err)
 		}
 
-		err = netlink.AddrAdd(eth, ueAddr)
+		err = h.AddrAdd(eth, ueAddr)
 		if err != nil {
-			return nil, fmt.Errorf("could not assign UE IPv4 address to TUN interface: %v", err)
+			return fmt.Errorf("could not assign UE IPv4 address to TUN interface: %v", err)
 		}
 	}
 
 	if opts.UEIPV6 != "" {
 		ueAddrV6, err := netlink.ParseAddr(opts.UEIPV6)
 		if err != nil {
-			return nil, fmt.Errorf("could not parse UE IPv6 address: %v", err)
+			return fmt.Errorf("could not parse UE IPv6 address: %v", err)
+		}
+
+		err = h.AddrAdd(eth, ueAddrV6)
+		if err != nil {
+			return fmt.Errorf("could not assign UE IPv6 address to TUN interface: %v", err)
+		}
+	}
+
+	if opts.MTU != 0 {
+		err = h.LinkSetMTU(eth, int(opts.MTU))
+		if err != nil {
+			return fmt.Errorf("could not set MTU on TUN interface: %v", err)
+		}
+	}
+
+	if opts.Namespace == "" {
+		return nil
+	}
+
+	if opts.UEIP != "" {
+		err = h.RouteAdd(&netlink.Route{
+			LinkIndex: eth.Attrs().Index,
+			Dst:       &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
+		})
+		if err != nil {
+			return fmt.Errorf("could not add IPv4 default route in network namespace %s: %v", opts.Namespace, err)
 		}
+	}
 
-		err = netlink.AddrAdd(eth, ueAddrV6)
+	if opts.UEIPV6 != "" {
+		err = h.RouteAdd(&netlink.Route{
+			LinkIndex: eth.Attrs().Index,
+			Dst:       &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+		})
 		if err != nil {
-			return nil, fmt.Errorf("could not assign UE IPv6 address to TUN interface: %v", err)
+			return fmt.Errorf("could not add IPv6 default route in network namespace %s: %v", opts.Namespace, err)
 		}
 	}
 
-	err = netlink.LinkSetMTU(eth, int(opts.MTU))
+	return nil
+}
+
+type NewUnstructuredTunnelOpts struct {
+	Network string // "udp" or "unixgram"
+	Address string // local address the UE application sends its payloads to
+	UpfIP   string
+	ULteid  uint32
+	DLteid  uint32
+	QFI     uint8
+}
+
+// AddUnstructuredTunnel carries an Unstructured PDU session over a local
+// datagram socket. Each datagram received on the socket is sent as one T-PDU,
+// and downlink T-PDUs are delivered to the peer that last sent a datagram.
+func (g *GnodeB) AddUnstructuredTunnel(opts *NewUnstructuredTunnelOpts) (*Tunnel, error) {
+	switch opts.Network {
+	case "udp", "udp4", "udp6", "unixgram":
+	default:
+		return nil, fmt.Errorf("unsupported network %q for Unstructured tunnel: must be udp or unixgram", opts.Network)
+	}
+
+	upfAddr, n3, err := g.upfEndpoint(opts.UpfIP)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenPacket(opts.Network, opts.Address)
 	if err != nil {
-		return nil, fmt.Errorf("could not set MTU on TUN interface: %v", err)
+		return nil, fmt.Errorf("could not listen on %s address %s: %v", opts.Network, opts.Address, err)
 	}
 
 	tunnel := &Tunnel{
-		Name:   ifce.Name(),
-		tunIF:  ifce,
-		ulteid: opts.ULteid,
-		dlteid: opts.DLteid,
-		upfAddr: &net.UDPAddr{
-			IP:   net.ParseIP(opts.UpfIP),
-			Port: 2152,
-		},
-		qfi: opts.QFI,
+		Name:     opts.Network + ":" + conn.LocalAddr().String(),
+		endpoint: &socketEndpoint{conn: conn},
+		ulteid:   opts.ULteid,
+		dlteid:   opts.DLteid,
+		upfAddr:  upfAddr,
+		n3:       n3,
+		qfi:      opts.QFI,
+	}
+
+	g.startTunnel(tunnel)
+
+	return tunnel, nil
+}
+
+type NewEndpointTunnelOpts struct {
+	Name     string             // identifies the tunnel in logs
+	Endpoint io.ReadWriteCloser // yields uplink IP packets and accepts downlink ones
+	UpfIP    string
+	ULteid   uint32
+	DLteid   uint32
+	QFI      uint8
+}
+
+// AddEndpointTunnel carries a session over a packet endpoint provided by the
+// caller, such as a userspace IP stack, instead of a kernel device. It needs
+// no privileges.
+func (g *GnodeB) AddEndpointTunnel(opts *NewEndpointTunnelOpts) (*Tunnel, error) {
+	if opts.Endpoint == nil {
+		return nil, fmt.Errorf("endpoint is required")
+	}
+
+	upfAddr, n3, err := g.upfEndpoint(opts.UpfIP)
+	if err != nil {
+		return nil, err
+	}
+
+	tunnel := &Tunnel{
+		Name:     opts.Name,
+		endpoint: opts.Endpoint,
+		ulteid:   opts.ULteid,
+		dlteid:   opts.DLteid,
+		upfAddr:  upfAddr,
+		n3:       n3,
+		qfi:      opts.QFI,
+	}
+
+	g.startTunnel(tunnel)
+
+	return tunnel, nil
+}
+
+func (g *GnodeB) startTunnel(tunnel *Tunnel) {
+	if len(tunnel.queues) == 0 {
+		tunnel.queues = []tunnelEndpoint{tunnel.endpoint}
 	}
 
 	g.mu.Lock()
-	g.tunnels[opts.DLteid] = tunnel
+	if _, ok := g.tunnels[tunnel.dlteid]; !ok {
+		metrics.Tunnels.Inc()
+	}
+
+	g.tunnels[tunnel.dlteid] = tunnel
+	g.publishTunnels()
 	g.mu.Unlock()
 
-	go tunToGtp(g.N3Conn, tunnel)
+	for _, queue := range tunnel.queues {
+		go g.tunToGtp(tunnel, queue)
+	}
+}
+
+var errNoUnstructuredPeer = errors.New("no application has sent data on the Unstructured socket yet")
 
-	return tunnel, nil
+// socketEndpoint adapts a datagram socket to a tunnelEndpoint.
+type socketEndpoint struct {
+	conn net.PacketConn
+	mu   sync.Mutex
+	peer net.Addr
+}
+
+func (s *socketEndpoint) Read(p []byte) (int, error) {
+	n, addr, err := s.conn.ReadFrom(p)
+	if err != nil {
+		return n, err
+	}
+
+	// Unbound unixgram clients have no address we could reply to.
+	if addr != nil && addr.String() != "" {
+		s.mu.Lock()
+		s.peer = addr
+		s.mu.Unlock()
+	}
+
+	return n, nil
+}
+
+func (s *socketEndpoint) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	peer := s.peer
+	s.mu.Unlock()
+
+	if peer == nil {
+		return 0, errNoUnstructuredPeer
+	}
+
+	return s.conn.WriteTo(p, peer)
+}
+
+func (s *socketEndpoint) Close() error {
+	err := s.conn.Close()
+
+	if addr, ok := s.conn.LocalAddr().(*net.UnixAddr); ok {
+		if rmErr := os.Remove(addr.Name); rmErr != nil && !os.IsNotExist(rmErr) && err == nil {
+			err = rmErr
+		}
+	}
+
+	return err
 }
 
 func (g *GnodeB) CloseTunnel(dlteid uint32) error {
@@ -126,9 +456,32 @@ func (g *GnodeB) CloseTunnel(dlteid uint32) error {
 		return fmt.Errorf("no tunnel with DL TEID %d", dlteid)
 	}
 
-	err := t.tunIF.Close()
-	if err != nil {
-		logger.GnbLogger.Error("error closing TUN interface", zap.String("if", t.Name), zap.Error(err))
+	t.close()
+
+	delete(g.tunnels, dlteid)
+	g.publishTunnels()
+	metrics.Tunnels.Dec()
+
+	return nil
+}
+
+// close releases the UE endpoint of the tunnel, deleting its TUN device and
+// network namespace.
+func (t *Tunnel) close() {
+	closeTunQueues(t.Name, t.queues)
+
+	if !t.device {
+		return
+	}
+
+	// Closing the file descriptor removes a device that was moved into a
+	// namespace; only the host namespace is checked for leftovers.
+	if t.Namespace != "" {
+		if err := netns.DeleteNamed(t.Namespace); err != nil {
+			logger.GnbLogger.Error("error deleting network namespace", zap.String("netns", t.Namespace), zap.Error(err))
+		}
+
+		return
 	}
 
 	link, err := netlink.LinkByName(t.Name)
@@ -137,17 +490,18 @@ func (g *GnodeB) CloseTunnel(dlteid uint32) error {
 			logger.GnbLogger.Error("error deleting TUN interface", zap.String("if", t.Name), zap.Error(err))
 		}
 	}
-
-	delete(g.tunnels, dlteid)
-
-	return nil
 }
 
-func (g *GnodeB) GTPReader() { // nolint:gocognit
-	buf := make([]byte, 2000)
+// gtpReader receives batches of GTP-U packets from an N3 socket. Several
+// readers may run on the same socket, one per data path worker.
+func (g *GnodeB) gtpReader(s *n3Socket) {
+	msgs := make([]ipv4.Message, g.dataPath.BatchSize)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{make([]byte, maxPacketSize)}
+	}
 
 	for {
-		n, _, err := g.N3Conn.ReadFrom(buf)
+		n, err := s.batch.ReadBatch(msgs, 0)
 		if err != nil {
 			if isClosedErr(err) {
 				return
@@ -158,99 +512,152 @@ func (g *GnodeB) GTPReader() { // nolint:gocognit
 			continue
 		}
 
-		if n < 8 {
-			continue // too short
+		for _, msg := range msgs[:n] {
+			g.captureN3(s, msg.Addr, false, msg.Buffers[0][:msg.N])
+			g.handleN3Packet(s, msg.Buffers[0][:msg.N], msg.Addr)
 		}
+	}
+}
 
-		// GTPv1-U header
-		if buf[0]&0x30 != 0x30 || buf[1] != 0xFF {
-			continue // not a T-PDU
-		}
+func (g *GnodeB) handleN3Packet(s *n3Socket, buf []byte, from net.Addr) {
+	n := len(buf)
+	if n < 8 {
+		return // too short
+	}
 
-		teid := binary.BigEndian.Uint32(buf[4:8])
+	// GTPv1-U header
+	if buf[0]&0x30 != 0x30 {
+		return // not GTPv1
+	}
 
-		g.mu.Lock()
+	switch buf[1] {
+	case gtpMessageEchoRequest:
+		g.handleEchoRequest(s, buf, from)
+		return
+	case gtpMessageEchoResponse:
+		g.handleEchoResponse(buf, from)
+		return
+	case gtpMessageErrorIndication:
+		g.handleErrorIndication(buf, from)
+		return
+	case gtpMessageEndMarker:
+		g.handleEndMarker(buf)
+		return
+	case gtpMessageTPDU:
+	default:
+		return
+	}
 
-		t, ok := g.tunnels[teid]
-		g.mu.Unlock()
+	teid := binary.BigEndian.Uint32(buf[4:8])
 
-		if !ok {
-			logger.GnbLogger.Warn("unknown TEID, dropping packet", zap.Uint32("teid", teid))
-			continue
-		}
+	t, ok := g.lookupTunnel(teid)
+	if !ok {
+		logger.GnbLogger.Warn("unknown TEID, dropping packet", zap.Uint32("teid", teid))
+		return
+	}
 
-		payloadStart := 8
-		if buf[0]&0x07 > 0 {
-			if payloadStart+3 > n {
-				logger.GnbLogger.Warn("GTP packet too short for optional fields", zap.Int("length", n))
-				continue
-			}
+	payloadStart, err := gtpPayloadOffset(buf)
+	if err != nil {
+		logger.GnbLogger.Warn("dropping malformed GTP-U packet", zap.Error(err))
+		return
+	}
+
+	t.counters.countDownlink(n - payloadStart)
+
+	if hook := t.downlinkHook.Load(); hook != nil && (*hook)(buf[payloadStart:]) {
+		return
+	}
 
-			payloadStart += 3
+	_, err = t.endpoint.Write(buf[payloadStart:])
+	if err != nil {
+		if errors.Is(err, errNoUnstructuredPeer) {
+			logger.GnbLogger.Debug("dropping downlink packet", zap.String("if", t.Name), zap.Error(err))
+			return
 		}
 
-		if buf[0]&0x04 > 0 {
-			for {
-				if payloadStart >= n {
-					logger.GnbLogger.Warn("GTP extension header exceeds packet bounds", zap.Int("payloadStart", payloadStart), zap.Int("length", n))
-					break
-				}
+		logger.GnbLogger.Error("error writing to TUN interface", zap.Error(err))
 
-				if buf[payloadStart] == 0x00 {
-					payloadStart++
-					break
-				}
+		return
+	}
 
-				if payloadStart+1 >= n {
-					logger.GnbLogger.Warn("GTP extension header length byte out of bounds", zap.Int("payloadStart", payloadStart), zap.Int("length", n))
-					break
-				}
+	logger.GnbLogger.Debug("Sent packet to TUN",
+		zap.String("if", t.Name),
+		zap.Uint32("teid", teid),
+		zap.Int("length", n-payloadStart),
+	)
+}
 
-				extLen := int(buf[payloadStart+1]) * 4
-				if extLen == 0 {
-					logger.GnbLogger.Warn("GTP extension header has zero length, dropping packet")
-					break
-				}
+// gtpPayloadOffset returns the offset of the payload or first IE of a GTP-U
+// message, past the optional sequence number fields and any extension
+// headers.
+func gtpPayloadOffset(b []byte) (int, error) {
+	n := len(b)
 
-				payloadStart += extLen
-			}
+	payloadStart := 8
+	if b[0]&0x07 > 0 {
+		if payloadStart+4 > n {
+			return 0, fmt.Errorf("packet too short for optional fields: %d bytes", n)
 		}
 
-		if payloadStart > n {
-			logger.GnbLogger.Warn("GTP payload start exceeds packet bounds", zap.Int("payloadStart", payloadStart), zap.Int("length", n))
-			continue
-		}
+		payloadStart += 3
+	}
 
-		_, err = t.tunIF.Write(buf[payloadStart:n])
-		if err != nil {
-			logger.GnbLogger.Error("error writing to TUN interface", zap.Error(err))
-			continue
+	if b[0]&0x04 > 0 {
+		for {
+			if payloadStart >= n {
+				return 0, fmt.Errorf("extension header at %d exceeds packet length %d", payloadStart, n)
+			}
+
+			if b[payloadStart] == 0x00 {
+				payloadStart++
+				break
+			}
+
+			if payloadStart+1 >= n {
+				return 0, fmt.Errorf("extension header length at %d exceeds packet length %d", payloadStart+1, n)
+			}
+
+			extLen := int(b[payloadStart+1]) * 4
+			if extLen == 0 {
+				return 0, fmt.Errorf("extension header at %d has zero length", payloadStart)
+			}
+
+			payloadStart += extLen
 		}
+	}
 
-		logger.GnbLogger.Debug("Sent packet to TUN",
-			zap.String("if", t.Name),
-			zap.Uint32("teid", teid),
-			zap.Int("length", n-payloadStart),
-		)
+	if payloadStart > n {
+		return 0, fmt.Errorf("payload start %d exceeds packet length %d", payloadStart, n)
 	}
+
+	return payloadStart, nil
 }
 
-func tunToGtp(conn *net.UDPConn, t *Tunnel) {
-	packet := make([]byte, 2000)
-	packet[0] = 0x34                                  // Version 1, Protocol type GTP, next extension header present
-	packet[1] = 0xFF                                  // Message type T-PDU
-	binary.BigEndian.PutUint16(packet[2:4], 0)        // Length
-	binary.BigEndian.PutUint32(packet[4:8], t.ulteid) // TEID
-	binary.BigEndian.PutUint32(packet[8:12], 0)       // padding
-	packet[11] = 0x85                                 // ext header type: PDU Session container
-	packet[12] = 0x01                                 // ext header length
-	packet[13] = 0x10                                 // UL PDU Session Information
-	packet[14] = t.qfi                                // QFI
-	packet[15] = 0x00                                 // No more ext headers
+// putUplinkHeader writes the GTP-U header of an uplink T-PDU carrying n
+// bytes of payload, with a PDU Session Container for the tunnel's QFI.
+func putUplinkHeader(packet []byte, t *Tunnel, n int) {
+	packet[0] = 0x34                                             // Version 1, Protocol type GTP, next extension header present
+	packet[1] = 0xFF                                             // Message type T-PDU
+	binary.BigEndian.PutUint16(packet[2:4], uint16(n)+gtpExtLen) // Length
+	binary.BigEndian.PutUint32(packet[4:8], t.ulteid)            // TEID
+	binary.BigEndian.PutUint32(packet[8:12], 0)                  // padding
+	packet[11] = 0x85                                            // ext header type: PDU Session container
+	packet[12] = 0x01                                            // ext header length
+	packet[13] = 0x10                                            // UL PDU Session Information
+	packet[14] = t.qfi                                           // QFI
+	packet[15] = 0x00                                            // No more ext headers
+}
 
+// tunToGtp reads uplink packets from one queue of the tunnel's device and
+// hands them to the uplink senders, which encapsulate them in batches.
+func (g *GnodeB) tunToGtp(t *Tunnel, queue tunnelEndpoint) {
 	for {
-		n, err := t.tunIF.Read(packet[gtpHeaderLen:])
+		p := g.uplinkPool.Get().(*uplinkPacket)
+
+		n, err := queue.Read(p.buf[gtpHeaderLen:])
 		if err != nil {
+			g.uplinkPool.Put(p)
+
 			if isClosedErr(err) {
 				return
 			}
@@ -261,40 +668,33 @@ func tunToGtp(conn *net.UDPConn, t *Tunnel) {
 		}
 
 		if n == 0 {
+			g.uplinkPool.Put(p)
 			logger.GnbLogger.Info("read 0 bytes")
+
 			continue
 		}
 
-		binary.BigEndian.PutUint16(packet[2:4], uint16(n)+gtpExtLen)
-
-		_, err = conn.WriteToUDP(packet[:n+gtpHeaderLen], t.upfAddr)
-		if err != nil {
-			if isClosedErr(err) {
-				return
-			}
-
-			logger.GnbLogger.Error("error writing to GTP-U socket", zap.Error(err))
+		putUplinkHeader(p.buf[:], t, n)
+		p.tunnel = t
+		p.len = gtpHeaderLen + n
 
-			continue
+		select {
+		case t.n3.uplink <- p:
+		case <-g.closed:
+			return
 		}
-
-		logger.GnbLogger.Debug(
-			"Sent packet to GTP",
-			zap.Int("length", n),
-			zap.Int("TEID", int(t.ulteid)),
-		)
 	}
 }
 
-func delAutoLinkLocal(eth netlink.Link) error {
-	addrs, err := netlink.AddrList(eth, netlink.FAMILY_V6)
+func delAutoLinkLocal(h *netlink.Handle, eth netlink.Link) error {
+	addrs, err := h.AddrList(eth, netlink.FAMILY_V6)
 	if err != nil {
 		return fmt.Errorf("could not list IPv6 addresses: %v", err)
 	}
 
 	for _, addr := range addrs {
 		if addr.IP.IsLinkLocalUnicast() && !addr.IP.Equal(net.ParseIP("fe80::")) {
-			if err := netlink.AddrDel(eth, &addr); err != nil {
+			if err := h.AddrDel(eth, &addr); err != nil {
 				return fmt.Errorf("could not delete auto-assigned link-local address %s: %v", addr.IP.String(), err)
 			}
 
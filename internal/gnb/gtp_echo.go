@@ -0,0 +1,311 @@
+package gnb
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	gtpFlagSequence          = 0x02
+	gtpIERecovery            = 14
+	gtpEchoLen               = 14 // mandatory header, sequence number fields and Recovery IE
+	gtpEchoMaxMissed         = 3  // unanswered Echo Requests before a path is declared down
+	gtpEchoResponseMinLength = 12
+)
+
+// PathState tells whether a UPF answers Echo Requests.
+type PathState int
+
+const (
+	PathUnknown PathState = iota // no answer yet, and fewer than gtpEchoMaxMissed requests lost
+	PathUp
+	PathDown
+)
+
+func (s PathState) String() string {
+	switch s {
+	case PathUp:
+		return "up"
+	case PathDown:
+		return "down"
+	default:
+		return "unknown"
+	}
+}
+
+// PathStatus is the GTP-U path state towards one UPF, as measured by the
+// Echo Request/Response exchange (TS 29.281 section 7.2).
+type PathStatus struct {
+	Peer             netip.Addr
+	State            PathState
+	RTT              time.Duration
+	LastResponse     time.Time
+	MissedResponses  int
+	RestartCounter   uint8
+	RequestsSent     uint64
+	ResponsesMatched uint64
+}
+
+type pendingEcho struct {
+	peer   netip.Addr
+	sentAt time.Time
+}
+
+// buildEchoMessage encodes an Echo Request or Echo Response. Both carry a
+// sequence number and a Recovery IE; the tester never restarts mid-run, so
+// its restart counter is always 0.
+func buildEchoMessage(msgType uint8, seq uint16) []byte {
+	b := make([]byte, gtpEchoLen)
+	b[0] = 0x30 | gtpFlagSequence                    // Version 1, Protocol type GTP, S flag
+	b[1] = msgType                                   // Message type
+	binary.BigEndian.PutUint16(b[2:4], gtpEchoLen-8) // Length after the mandatory header
+	binary.BigEndian.PutUint32(b[4:8], 0)            // TEID is 0 for path management
+	binary.BigEndian.PutUint16(b[8:10], seq)         // Sequence number
+	b[10] = 0x00                                     // N-PDU number
+	b[11] = 0x00                                     // No extension headers
+	b[12] = gtpIERecovery                            // Recovery IE
+	b[13] = 0x00                                     // Restart counter
+
+	return b
+}
+
+func (g *GnodeB) handleEchoRequest(s *n3Socket, req []byte, from net.Addr) {
+	udpAddr, ok := from.(*net.UDPAddr)
+	if !ok {
+		return
+	}
+
+	var seq uint16
+	if req[0]&gtpFlagSequence != 0 && len(req) >= gtpEchoResponseMinLength {
+		seq = binary.BigEndian.Uint16(req[8:10])
+	}
+
+	resp := buildEchoMessage(gtpMessageEchoResponse, seq)
+
+	_, err := s.conn.WriteToUDP(resp, udpAddr)
+	if err != nil {
+		if !isClosedErr(err) {
+			logger.GnbLogger.Error("could not send GTP-U Echo Response", zap.String("peer", udpAddr.String()), zap.Error(err))
+		}
+
+		return
+	}
+
+	g.captureN3(s, udpAddr, true, resp)
+
+	logger.GnbLogger.Debug("Answered GTP-U Echo Request", zap.String("peer", udpAddr.String()), zap.Uint16("sequence", seq))
+}
+
+func (g *GnodeB) handleEchoResponse(resp []byte, from net.Addr) {
+	if resp[0]&gtpFlagSequence == 0 || len(resp) < gtpEchoResponseMinLength {
+		logger.GnbLogger.Warn("GTP-U Echo Response without sequence number", zap.String("peer", from.String()))
+		return
+	}
+
+	seq := binary.BigEndian.Uint16(resp[8:10])
+
+	var restartCounter uint8
+
+	if len(resp) >= gtpEchoLen && resp[12] == gtpIERecovery {
+		restartCounter = resp[13]
+	}
+
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	pending, ok := g.pendingEchoes[seq]
+	if !ok {
+		logger.GnbLogger.Debug("unexpected GTP-U Echo Response", zap.String("peer", from.String()), zap.Uint16("sequence", seq))
+		return
+	}
+
+	// Another host answering with the same sequence number must not bring
+	// the path up; the request stays pending for the UPF's own answer.
+	if peerAddr(from) != pending.peer {
+		logger.GnbLogger.Warn(
+			"GTP-U Echo Response from another peer than the request",
+			zap.String("peer", from.String()),
+			zap.String("expected", pending.peer.String()),
+			zap.Uint16("sequence", seq),
+		)
+
+		return
+	}
+
+	delete(g.pendingEchoes, seq)
+
+	path, ok := g.paths[pending.peer]
+	if !ok {
+		return
+	}
+
+	if path.ResponsesMatched > 0 && restartCounter != path.RestartCounter {
+		logger.GnbLogger.Warn(
+			"UPF restart counter changed, GTP-U peer has restarted",
+			zap.String("peer", pending.peer.String()),
+			zap.Uint8("previous", path.RestartCounter),
+			zap.Uint8("current", restartCounter),
+		)
+	}
+
+	if path.State != PathUp {
+		logger.GnbLogger.Info("GTP-U path is up", zap.String("peer", pending.peer.String()))
+	}
+
+	path.State = PathUp
+	path.RTT = now.Sub(pending.sentAt)
+	path.LastResponse = now
+	path.MissedResponses = 0
+	path.RestartCounter = restartCounter
+	path.ResponsesMatched++
+
+	logger.GnbLogger.Debug(
+		"Received GTP-U Echo Response",
+		zap.String("peer", pending.peer.String()),
+		zap.Uint16("sequence", seq),
+		zap.Duration("RTT", path.RTT),
+	)
+}
+
+// peerAddr returns the IP address of a UDP peer, or the zero Addr.
+func peerAddr(from net.Addr) netip.Addr {
+	udpAddr, ok := from.(*net.UDPAddr)
+	if !ok {
+		return netip.Addr{}
+	}
+
+	return udpAddr.AddrPort().Addr().Unmap()
+}
+
+// StartEchoProber sends a GTP-U Echo Request to every UPF that one of the
+// gNodeB's tunnels points at, once per interval, until the gNodeB is closed.
+// A path is reported down after several consecutive unanswered requests.
+func (g *GnodeB) StartEchoProber(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-g.closed:
+				return
+			case <-ticker.C:
+				g.probePaths(interval)
+			}
+		}
+	}()
+}
+
+func (g *GnodeB) probePaths(timeout time.Duration) {
+	now := time.Now()
+
+	g.mu.Lock()
+
+	// Requests sent at an earlier tick are considered lost. The ticker may
+	// fire a little early, so a request is only spared when it is less than
+	// half an interval old.
+	for seq, pending := range g.pendingEchoes {
+		if now.Sub(pending.sentAt) < timeout/2 {
+			continue
+		}
+
+		delete(g.pendingEchoes, seq)
+
+		path := g.paths[pending.peer]
+		if path == nil {
+			continue
+		}
+
+		path.MissedResponses++
+
+		// A UPF that never answered is down as well as one that stopped.
+		if path.State != PathDown && path.MissedResponses >= gtpEchoMaxMissed {
+			path.State = PathDown
+
+			logger.GnbLogger.Error(
+				"GTP-U path is down, UPF does not answer Echo Requests",
+				zap.String("peer", pending.peer.String()),
+				zap.Int("missed", path.MissedResponses),
+				zap.Bool("ever answered", path.ResponsesMatched > 0),
+			)
+		}
+	}
+
+	peers := make(map[netip.Addr]*net.UDPAddr)
+
+	for _, t := range g.tunnels {
+		addr, ok := netip.AddrFromSlice(t.upfAddr.IP)
+		if !ok {
+			continue
+		}
+
+		addr = addr.Unmap()
+		peers[addr] = t.upfAddr
+
+		if _, ok := g.paths[addr]; !ok {
+			g.paths[addr] = &PathStatus{Peer: addr}
+		}
+	}
+
+	requests := make(map[uint16]*net.UDPAddr, len(peers))
+
+	for addr, udpAddr := range peers {
+		g.echoSequence++
+		g.pendingEchoes[g.echoSequence] = pendingEcho{peer: addr, sentAt: now}
+		g.paths[addr].RequestsSent++
+		requests[g.echoSequence] = udpAddr
+	}
+
+	g.mu.Unlock()
+
+	for seq, udpAddr := range requests {
+		s := g.n3SocketFor(udpAddr.AddrPort().Addr())
+		if s == nil {
+			continue
+		}
+
+		req := buildEchoMessage(gtpMessageEchoRequest, seq)
+
+		_, err := s.conn.WriteToUDP(req, udpAddr)
+		if err != nil {
+			if isClosedErr(err) {
+				return
+			}
+
+			logger.GnbLogger.Error("could not send GTP-U Echo Request", zap.String("peer", udpAddr.String()), zap.Error(err))
+
+			continue
+		}
+
+		g.captureN3(s, udpAddr, true, req)
+
+		logger.GnbLogger.Debug("Sent GTP-U Echo Request", zap.String("peer", udpAddr.String()), zap.Uint16("sequence", seq))
+	}
+}
+
+// GetPathStatuses returns the GTP-U path state of every probed UPF, sorted by
+// address.
+func (g *GnodeB) GetPathStatuses() []PathStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	statuses := make([]PathStatus, 0, len(g.paths))
+	for _, p := range g.paths {
+		statuses = append(statuses, *p)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Peer.Less(statuses[j].Peer)
+	})
+
+	return statuses
+}
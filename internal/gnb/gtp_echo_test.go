@@ -0,0 +1,179 @@
+package gnb
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"go.uber.org/zap"
+)
+
+func TestBuildEchoMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		msgType uint8
+		seq     uint16
+		want    []byte
+	}{
+		{
+			name:    "request",
+			msgType: gtpMessageEchoRequest,
+			seq:     1,
+			want:    []byte{0x32, 0x01, 0x00, 0x06, 0, 0, 0, 0, 0x00, 0x01, 0x00, 0x00, 0x0e, 0x00},
+		},
+		{
+			name:    "response",
+			msgType: gtpMessageEchoResponse,
+			seq:     0xbeef,
+			want:    []byte{0x32, 0x02, 0x00, 0x06, 0, 0, 0, 0, 0xbe, 0xef, 0x00, 0x00, 0x0e, 0x00},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildEchoMessage(tt.msgType, tt.seq); !bytes.Equal(got, tt.want) {
+				t.Errorf("expected % x, got % x", tt.want, got)
+			}
+		})
+	}
+}
+
+var (
+	echoUPF   = netip.MustParseAddr("10.3.0.2")
+	echoOther = netip.MustParseAddr("10.3.0.3")
+)
+
+// newEchoTestGnodeB returns a gNodeB with one tunnel towards echoUPF and no
+// N3 socket, so that probePaths records its requests without sending them.
+func newEchoTestGnodeB() *GnodeB {
+	logger.GnbLogger = zap.NewNop()
+
+	return &GnodeB{
+		tunnels: map[uint32]*Tunnel{
+			1: {upfAddr: net.UDPAddrFromAddrPort(netip.AddrPortFrom(echoUPF, gtpuPort))},
+		},
+		paths:         make(map[netip.Addr]*PathStatus),
+		pendingEchoes: make(map[uint16]pendingEcho),
+	}
+}
+
+func echoResponse(seq uint16, restartCounter uint8) []byte {
+	resp := buildEchoMessage(gtpMessageEchoResponse, seq)
+	resp[13] = restartCounter
+
+	return resp
+}
+
+func TestHandleEchoResponse(t *testing.T) {
+	tests := []struct {
+		name           string
+		resp           []byte
+		from           netip.Addr
+		state          PathState
+		restartCounter uint8
+	}{
+		{name: "matching response", resp: echoResponse(1, 0), from: echoUPF, state: PathUp},
+		{name: "restart counter", resp: echoResponse(1, 7), from: echoUPF, state: PathUp, restartCounter: 7},
+		{name: "without Recovery IE", resp: echoResponse(1, 7)[:gtpEchoResponseMinLength], from: echoUPF, state: PathUp},
+		{name: "without sequence number", resp: append([]byte{0x30}, echoResponse(1, 0)[1:]...), from: echoUPF},
+		{name: "truncated", resp: echoResponse(1, 0)[:gtpEchoResponseMinLength-1], from: echoUPF},
+		{name: "unknown sequence number", resp: echoResponse(2, 0), from: echoUPF},
+		{name: "from another peer", resp: echoResponse(1, 0), from: echoOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newEchoTestGnodeB()
+			g.probePaths(time.Second)
+
+			g.handleEchoResponse(tt.resp, net.UDPAddrFromAddrPort(netip.AddrPortFrom(tt.from, gtpuPort)))
+
+			path := g.paths[echoUPF]
+			if path.State != tt.state {
+				t.Errorf("expected path %s, got %s", tt.state, path.State)
+			}
+
+			if path.RestartCounter != tt.restartCounter {
+				t.Errorf("expected restart counter %d, got %d", tt.restartCounter, path.RestartCounter)
+			}
+
+			_, pending := g.pendingEchoes[1]
+			if pending == (tt.state == PathUp) {
+				t.Errorf("expected the request to be pending only while unanswered")
+			}
+		})
+	}
+}
+
+func TestProbePathsTransitions(t *testing.T) {
+	const interval = time.Second
+
+	g := newEchoTestGnodeB()
+
+	// tick probes the paths as the ticker would, one interval after the
+	// previous tick, firing slightly early.
+	tick := func() {
+		for seq, pending := range g.pendingEchoes {
+			pending.sentAt = pending.sentAt.Add(-interval + 10*time.Microsecond)
+			g.pendingEchoes[seq] = pending
+		}
+
+		g.probePaths(interval)
+	}
+
+	tick()
+	g.handleEchoResponse(echoResponse(1, 0), net.UDPAddrFromAddrPort(netip.AddrPortFrom(echoUPF, gtpuPort)))
+
+	steps := []struct {
+		state  PathState
+		missed int
+	}{
+		{state: PathUp, missed: 0}, // the answered request is not missed
+		{state: PathUp, missed: 1},
+		{state: PathUp, missed: 2},
+		{state: PathDown, missed: gtpEchoMaxMissed},
+		{state: PathDown, missed: gtpEchoMaxMissed + 1},
+	}
+
+	for i, step := range steps {
+		tick()
+
+		path := g.paths[echoUPF]
+		if path.State != step.state || path.MissedResponses != step.missed {
+			t.Errorf("tick %d: expected path %s with %d missed, got %s with %d", i+2, step.state, step.missed, path.State, path.MissedResponses)
+		}
+	}
+
+	seq := g.echoSequence
+	g.handleEchoResponse(echoResponse(seq, 0), net.UDPAddrFromAddrPort(netip.AddrPortFrom(echoUPF, gtpuPort)))
+
+	if path := g.paths[echoUPF]; path.State != PathUp || path.MissedResponses != 0 {
+		t.Errorf("expected the answered path to be up again, got %s with %d missed", path.State, path.MissedResponses)
+	}
+}
+
+func TestProbePathsNeverAnswered(t *testing.T) {
+	g := newEchoTestGnodeB()
+
+	for range gtpEchoMaxMissed {
+		g.probePaths(time.Second)
+
+		if g.paths[echoUPF].State != PathUnknown {
+			t.Fatalf("expected the path to stay unknown before %d misses", gtpEchoMaxMissed)
+		}
+
+		for seq, pending := range g.pendingEchoes {
+			pending.sentAt = pending.sentAt.Add(-time.Second)
+			g.pendingEchoes[seq] = pending
+		}
+	}
+
+	g.probePaths(time.Second)
+
+	if g.paths[echoUPF].State != PathDown {
+		t.Errorf("expected a UPF that never answered to be down, got %s", g.paths[echoUPF].State)
+	}
+}
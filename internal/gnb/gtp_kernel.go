@@ -0,0 +1,252 @@
+package gnb
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"go.uber.org/zap"
+)
+
+// gtpV0Port is the GTPv0 port. The Linux GTP device always takes a GTPv0
+// socket, even when only GTPv1-U is used.
+const gtpV0Port = 3386
+
+// kernelGTPRouteTable is the routing table holding the default route through
+// the kernel GTP device. Each UE address gets a policy rule pointing at it,
+// so that only the traffic sent from a UE address enters the tunnels.
+const kernelGTPRouteTable = 2152
+
+// kernelGTP is a Linux GTP device (drivers/net/gtp.c) that encapsulates and
+// decapsulates user plane traffic in the kernel, on the gNodeB's N3 socket.
+type kernelGTP struct {
+	link   netlink.Link
+	v0Conn *net.UDPConn
+}
+
+// enableKernelGTP creates the GTP device in the SGSN role, where the kernel
+// matches uplink packets to a PDP context by their source address. GTP-U
+// messages other than T-PDUs for known TEIDs still reach GTPReader.
+func (g *GnodeB) enableKernelGTP(name string) error {
+	v0Conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: g.N3Address.AsSlice(), Port: gtpV0Port})
+	if err != nil {
+		return fmt.Errorf("could not listen on GTPv0 port: %v", err)
+	}
+
+	link := &netlink.GTP{
+		LinkAttrs: netlink.LinkAttrs{Name: name},
+		Role:      nl.GTP_ROLE_SGSN,
+	}
+
+	err = withSocketFD(v0Conn, func(fd0 int) error {
+		return withSocketFD(g.N3Conn, func(fd1 int) error {
+			link.FD0 = fd0
+			link.FD1 = fd1
+
+			return netlink.LinkAdd(link)
+		})
+	})
+	if err != nil {
+		closeErr := v0Conn.Close()
+		if closeErr != nil {
+			logger.GnbLogger.Warn("could not close GTPv0 socket", zap.Error(closeErr))
+		}
+
+		return fmt.Errorf("could not create kernel GTP device %s (is the gtp module loaded?): %v", name, err)
+	}
+
+	g.kernelGTP = &kernelGTP{link: link, v0Conn: v0Conn}
+
+	err = netlink.LinkSetUp(link)
+	if err != nil {
+		g.disableKernelGTP()
+		return fmt.Errorf("could not bring up kernel GTP device %s: %v", name, err)
+	}
+
+	// The route goes away with the device.
+	err = netlink.RouteAdd(&netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
+		Table:     kernelGTPRouteTable,
+	})
+	if err != nil {
+		g.disableKernelGTP()
+		return fmt.Errorf("could not add default route through kernel GTP device %s: %v", name, err)
+	}
+
+	logger.GnbLogger.Info("Created kernel GTP device", zap.String("if", name))
+
+	return nil
+}
+
+func (g *GnodeB) disableKernelGTP() {
+	if g.kernelGTP == nil {
+		return
+	}
+
+	err := netlink.LinkDel(g.kernelGTP.link)
+	if err != nil {
+		logger.GnbLogger.Error("could not delete kernel GTP device", zap.String("if", g.kernelGTP.link.Attrs().Name), zap.Error(err))
+	}
+
+	err = g.kernelGTP.v0Conn.Close()
+	if err != nil {
+		logger.GnbLogger.Error("could not close GTPv0 socket", zap.Error(err))
+	}
+
+	g.kernelGTP = nil
+}
+
+func withSocketFD(conn *net.UDPConn, fn func(fd int) error) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var fnErr error
+
+	err = raw.Control(func(fd uintptr) {
+		fnErr = fn(int(fd))
+	})
+	if err != nil {
+		return err
+	}
+
+	return fnErr
+}
+
+type NewKernelTunnelOpts struct {
+	UEIP   netip.Addr
+	UpfIP  string
+	ULteid uint32
+	DLteid uint32
+}
+
+// AddKernelTunnel installs a PDP context for the UE on the kernel GTP device,
+// assigns the UE address to the device and routes the traffic sent from the
+// UE address through it with a policy rule, so that it is forwarded by the
+// kernel. The Linux GTP device only supports
+// IPv4 UE addresses and does not add the PDU Session Container extension
+// header, so the UPF sees no QFI on uplink packets.
+func (g *GnodeB) AddKernelTunnel(opts *NewKernelTunnelOpts) (*Tunnel, error) {
+	if g.kernelGTP == nil {
+		return nil, fmt.Errorf("kernel GTP offload is not enabled on this gNodeB")
+	}
+
+	if !opts.UEIP.Is4() {
+		return nil, fmt.Errorf("the kernel GTP device only supports IPv4 UE addresses, not %s", opts.UEIP)
+	}
+
+	upfAddr, n3, err := g.upfEndpoint(opts.UpfIP)
+	if err != nil {
+		return nil, err
+	}
+
+	upfIP := upfAddr.IP.To4()
+	if upfIP == nil {
+		return nil, fmt.Errorf("the kernel GTP device only supports IPv4 UPF addresses, not %s", upfAddr.IP)
+	}
+
+	link := g.kernelGTP.link
+
+	pdp := &netlink.PDP{
+		Version:     1,
+		PeerAddress: upfIP,
+		MSAddress:   opts.UEIP.AsSlice(),
+		ITEI:        opts.DLteid,
+		OTEI:        opts.ULteid,
+	}
+
+	err = netlink.GTPPDPAdd(link, pdp)
+	if err != nil {
+		return nil, fmt.Errorf("could not add PDP context: %v", err)
+	}
+
+	addr := &netlink.Addr{IPNet: &net.IPNet{IP: opts.UEIP.AsSlice(), Mask: net.CIDRMask(32, 32)}}
+
+	err = netlink.AddrAdd(link, addr)
+	if err != nil {
+		if delErr := netlink.GTPPDPDel(link, pdp); delErr != nil {
+			logger.GnbLogger.Error("could not delete PDP context", zap.Error(delErr))
+		}
+
+		return nil, fmt.Errorf("could not assign UE IP address to kernel GTP device: %v", err)
+	}
+
+	rule := netlink.NewRule()
+	rule.Src = addr.IPNet
+	rule.Table = kernelGTPRouteTable
+
+	err = netlink.RuleAdd(rule)
+	if err != nil {
+		if delErr := netlink.AddrDel(link, addr); delErr != nil {
+			logger.GnbLogger.Error("could not remove UE IP address", zap.Error(delErr))
+		}
+
+		if delErr := netlink.GTPPDPDel(link, pdp); delErr != nil {
+			logger.GnbLogger.Error("could not delete PDP context", zap.Error(delErr))
+		}
+
+		return nil, fmt.Errorf("could not add routing rule for UE IP address %s: %v", opts.UEIP, err)
+	}
+
+	tunnel := &Tunnel{
+		Name:     link.Attrs().Name,
+		endpoint: &kernelEndpoint{link: link, pdp: pdp, addr: addr, rule: rule, closed: make(chan struct{})},
+		ulteid:   opts.ULteid,
+		dlteid:   opts.DLteid,
+		upfAddr:  upfAddr,
+		n3:       n3,
+	}
+
+	g.startTunnel(tunnel)
+
+	return tunnel, nil
+}
+
+// kernelEndpoint stands for a PDP context of the kernel GTP device. The
+// kernel moves the packets, so reads block until the tunnel is closed and
+// the T-PDUs it leaves to userspace are dropped.
+type kernelEndpoint struct {
+	link      netlink.Link
+	pdp       *netlink.PDP
+	addr      *netlink.Addr
+	rule      *netlink.Rule
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (k *kernelEndpoint) Read(p []byte) (int, error) {
+	<-k.closed
+	return 0, net.ErrClosed
+}
+
+func (k *kernelEndpoint) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("downlink packet not handled by the kernel GTP device")
+}
+
+func (k *kernelEndpoint) Close() error {
+	var err error
+
+	k.closeOnce.Do(func() {
+		close(k.closed)
+
+		if ruleErr := netlink.RuleDel(k.rule); ruleErr != nil {
+			err = fmt.Errorf("could not delete routing rule: %v", ruleErr)
+		}
+
+		if addrErr := netlink.AddrDel(k.link, k.addr); addrErr != nil {
+			err = fmt.Errorf("could not remove UE IP address: %v", addrErr)
+		}
+
+		if pdpErr := netlink.GTPPDPDel(k.link, k.pdp); pdpErr != nil {
+			err = fmt.Errorf("could not delete PDP context: %v", pdpErr)
+		}
+	})
+
+	return err
+}
@@ -0,0 +1,343 @@
+package gnb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/free5gc/ngap/ngapType"
+	"go.uber.org/zap"
+)
+
+// GTP-U information elements, TS 29.281 section 8
+const (
+	gtpIETEIDDataI       = 16
+	gtpIEPeerAddress     = 133
+	gtpIETLVTypeBoundary = 128 // IEs of this type and above are TLV encoded
+)
+
+// maxErrorIndications is the number of Error Indications kept for
+// WaitForErrorIndication.
+const maxErrorIndications = 64
+
+// ErrorIndication is a GTP-U Error Indication (TS 29.281 section 7.3.1)
+// received from a UPF, telling the gNodeB that one of its uplink TEIDs is
+// unknown at the peer.
+type ErrorIndication struct {
+	TEID         uint32     // TEID Data I, the uplink TEID rejected by the peer
+	PeerAddress  netip.Addr // GTP-U Peer Address, the address the rejected packet was sent to
+	Source       netip.Addr
+	Tunnel       *Tunnel // nil when no tunnel uses the TEID
+	RANUENGAPID  int64
+	PDUSessionID int64
+	ReceivedAt   time.Time
+}
+
+func parseErrorIndication(b []byte) (uint32, netip.Addr, error) {
+	offset, err := gtpPayloadOffset(b)
+	if err != nil {
+		return 0, netip.Addr{}, err
+	}
+
+	var (
+		teid        uint32
+		haveTEID    bool
+		peerAddress netip.Addr
+	)
+
+	for ies := b[offset:]; len(ies) > 0; {
+		ieType := ies[0]
+
+		if ieType < gtpIETLVTypeBoundary {
+			if ieType != gtpIETEIDDataI {
+				return 0, netip.Addr{}, fmt.Errorf("unexpected TV information element %d", ieType)
+			}
+
+			if len(ies) < 5 {
+				return 0, netip.Addr{}, fmt.Errorf("TEID Data I is truncated")
+			}
+
+			teid = binary.BigEndian.Uint32(ies[1:5])
+			haveTEID = true
+			ies = ies[5:]
+
+			continue
+		}
+
+		if len(ies) < 3 {
+			return 0, netip.Addr{}, fmt.Errorf("information element %d is truncated", ieType)
+		}
+
+		ieLen := int(binary.BigEndian.Uint16(ies[1:3]))
+		if len(ies) < 3+ieLen {
+			return 0, netip.Addr{}, fmt.Errorf("information element %d length %d exceeds message", ieType, ieLen)
+		}
+
+		if ieType == gtpIEPeerAddress {
+			addr, ok := netip.AddrFromSlice(ies[3 : 3+ieLen])
+			if !ok {
+				return 0, netip.Addr{}, fmt.Errorf("GTP-U Peer Address has invalid length %d", ieLen)
+			}
+
+			peerAddress = addr
+		}
+
+		ies = ies[3+ieLen:]
+	}
+
+	if !haveTEID {
+		return 0, netip.Addr{}, fmt.Errorf("missing mandatory TEID Data I")
+	}
+
+	if !peerAddress.IsValid() {
+		return 0, netip.Addr{}, fmt.Errorf("missing mandatory GTP-U Peer Address")
+	}
+
+	return teid, peerAddress, nil
+}
+
+func (g *GnodeB) handleErrorIndication(b []byte, from net.Addr) {
+	teid, peerAddress, err := parseErrorIndication(b)
+	if err != nil {
+		logger.GnbLogger.Warn("dropping malformed GTP-U Error Indication", zap.String("peer", from.String()), zap.Error(err))
+		return
+	}
+
+	event := ErrorIndication{
+		TEID:        teid,
+		PeerAddress: peerAddress,
+		ReceivedAt:  time.Now(),
+	}
+
+	if udpAddr, ok := from.(*net.UDPAddr); ok {
+		event.Source = udpAddr.AddrPort().Addr().Unmap()
+	}
+
+	g.mu.Lock()
+
+	// TS 29.281 section 7.3.1: the GTP-U Peer Address is the destination of
+	// the G-PDU that was refused, which identifies the tunnel even when the
+	// UPF answers from another address.
+	event.Tunnel = g.uplinkTunnel(teid, peerAddress)
+	if event.Tunnel == nil && event.Source.IsValid() {
+		event.Tunnel = g.uplinkTunnel(teid, event.Source)
+	}
+
+	if event.Tunnel != nil {
+		for ranUeID, sessions := range g.PDUSessions {
+			for pduSessionID, session := range sessions {
+				if session.DLTeid == event.Tunnel.dlteid {
+					event.RANUENGAPID = ranUeID
+					event.PDUSessionID = pduSessionID
+				}
+			}
+		}
+	}
+
+	// A UPF answers every uplink packet of an unknown tunnel with an Error
+	// Indication; only the latest are kept for WaitForErrorIndication.
+	if len(g.errorIndications) >= maxErrorIndications {
+		g.errorIndications = slices.Delete(g.errorIndications, 0, len(g.errorIndications)-maxErrorIndications+1)
+	}
+
+	g.errorIndications = append(g.errorIndications, event)
+	g.cond.Broadcast()
+	g.mu.Unlock()
+
+	if event.Tunnel == nil {
+		logger.GnbLogger.Warn(
+			"Received GTP-U Error Indication for an unknown TEID",
+			zap.String("peer", event.Source.String()),
+			zap.Uint32("TEID", teid),
+			zap.String("GTP-U peer address", peerAddress.String()),
+		)
+
+		return
+	}
+
+	logger.GnbLogger.Warn(
+		"Received GTP-U Error Indication, the UPF does not know the tunnel",
+		zap.String("peer", event.Source.String()),
+		zap.String("if", event.Tunnel.Name),
+		zap.Uint32("TEID", teid),
+		zap.Int64("RAN UE NGAP ID", event.RANUENGAPID),
+		zap.Int64("PDU Session ID", event.PDUSessionID),
+	)
+
+	if !g.releaseOnErrorIndication || event.RANUENGAPID == 0 {
+		return
+	}
+
+	// The N3 reader must not wait for the AMF association.
+	go g.releaseAfterErrorIndication(event)
+}
+
+// releaseAfterErrorIndication requests the release of the context of the UE
+// whose tunnel a GTP-U Error Indication reported.
+func (g *GnodeB) releaseAfterErrorIndication(event ErrorIndication) {
+	if event.PDUSessionID < 1 || event.PDUSessionID > 15 {
+		logger.GnbLogger.Warn("not releasing UE context: invalid PDU session ID", zap.Int64("PDU Session ID", event.PDUSessionID))
+		return
+	}
+
+	// The release is requested once until the AMF releases the context.
+	g.mu.Lock()
+
+	if g.releaseRequested[event.RANUENGAPID] {
+		g.mu.Unlock()
+		return
+	}
+
+	if g.releaseRequested == nil {
+		g.releaseRequested = make(map[int64]bool)
+	}
+
+	g.releaseRequested[event.RANUENGAPID] = true
+	g.mu.Unlock()
+
+	// TS 38.413 section 8.3.2: the NG-RAN node requests the release of the
+	// UE context when its user plane resources are no longer usable.
+	opts := &UEContextReleaseRequestOpts{
+		AMFUENGAPID: g.GetAMFUENGAPID(event.RANUENGAPID),
+		RANUENGAPID: event.RANUENGAPID,
+		Cause: ngapType.Cause{
+			Present: ngapType.CausePresentTransport,
+			Transport: &ngapType.CauseTransport{
+				Value: ngapType.CauseTransportPresentTransportResourceUnavailable,
+			},
+		},
+	}
+	opts.PDUSessionIDs[event.PDUSessionID] = true
+
+	err := g.SendUEContextReleaseRequest(opts)
+	if err != nil {
+		logger.GnbLogger.Error("could not send UE Context Release Request after GTP-U Error Indication", zap.Error(err))
+
+		g.mu.Lock()
+		delete(g.releaseRequested, event.RANUENGAPID)
+		g.mu.Unlock()
+
+		return
+	}
+
+	logger.GnbLogger.Info(
+		"Sent UE Context Release Request after GTP-U Error Indication",
+		zap.Int64("RAN UE NGAP ID", event.RANUENGAPID),
+		zap.Int64("AMF UE NGAP ID", opts.AMFUENGAPID),
+	)
+}
+
+// uplinkTunnel returns the tunnel of an uplink TEID towards a UPF address,
+// or nil. Called with g.mu held.
+func (g *GnodeB) uplinkTunnel(teid uint32, upf netip.Addr) *Tunnel {
+	for _, t := range g.tunnels {
+		if t.ulteid == teid && t.upfAddr.IP.Equal(net.IP(upf.Unmap().AsSlice())) {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// WaitForErrorIndication returns the oldest GTP-U Error Indication that has
+// not been returned yet, waiting up to timeout for one to arrive.
+func (g *GnodeB) WaitForErrorIndication(timeout time.Duration) (ErrorIndication, error) {
+	deadline := time.Now().Add(timeout)
+
+	timer := time.AfterFunc(timeout, func() {
+		g.cond.Broadcast()
+	})
+	defer timer.Stop()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for {
+		if len(g.errorIndications) > 0 {
+			event := g.errorIndications[0]
+			g.errorIndications = g.errorIndications[1:]
+
+			return event, nil
+		}
+
+		if time.Now().After(deadline) {
+			return ErrorIndication{}, fmt.Errorf("timeout waiting for GTP-U Error Indication")
+		}
+
+		g.cond.Wait()
+	}
+}
+
+// handleEndMarker records an End Marker (TS 29.281 section 7.3.2), sent by
+// the UPF on the old downlink path once it has switched a session to a new
+// one, for example during a handover.
+func (g *GnodeB) handleEndMarker(b []byte) {
+	teid := binary.BigEndian.Uint32(b[4:8])
+
+	g.mu.Lock()
+
+	t, ok := g.tunnels[teid]
+	if ok {
+		t.endMarkers++
+		t.lastEndMarker = time.Now()
+		g.cond.Broadcast()
+	}
+
+	g.mu.Unlock()
+
+	if !ok {
+		logger.GnbLogger.Warn("Received GTP-U End Marker for an unknown TEID", zap.Uint32("teid", teid))
+		return
+	}
+
+	logger.GnbLogger.Info("Received GTP-U End Marker", zap.String("if", t.Name), zap.Uint32("teid", teid))
+}
+
+// EndMarkers returns the number of End Markers received on the tunnel with
+// the given downlink TEID and the time of the last one.
+func (g *GnodeB) EndMarkers(dlteid uint32) (int, time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	t, ok := g.tunnels[dlteid]
+	if !ok {
+		return 0, time.Time{}
+	}
+
+	return t.endMarkers, t.lastEndMarker
+}
+
+// WaitForEndMarker waits until an End Marker has been received on the tunnel
+// with the given downlink TEID.
+func (g *GnodeB) WaitForEndMarker(dlteid uint32, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	timer := time.AfterFunc(timeout, func() {
+		g.cond.Broadcast()
+	})
+	defer timer.Stop()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for {
+		t, ok := g.tunnels[dlteid]
+		if !ok {
+			return fmt.Errorf("no tunnel with DL TEID %d", dlteid)
+		}
+
+		if t.endMarkers > 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout waiting for GTP-U End Marker on DL TEID %d", dlteid)
+		}
+
+		g.cond.Wait()
+	}
+}
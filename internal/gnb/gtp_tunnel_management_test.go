@@ -0,0 +1,65 @@
+package gnb
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"go.uber.org/zap"
+)
+
+// errorIndication encodes a GTP-U Error Indication for an uplink TEID sent
+// to peer.
+func errorIndication(teid uint32, peer netip.Addr) []byte {
+	b := []byte{0x30, gtpMessageErrorIndication, 0, 0, 0, 0, 0, 0}
+	b = append(b, gtpIETEIDDataI)
+	b = binary.BigEndian.AppendUint32(b, teid)
+	b = append(b, gtpIEPeerAddress)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(peer.AsSlice())))
+	b = append(b, peer.AsSlice()...)
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)-8))
+
+	return b
+}
+
+func TestHandleErrorIndication(t *testing.T) {
+	logger.GnbLogger = zap.NewNop()
+
+	upf := netip.MustParseAddr("10.3.0.2")
+	tunnel := &Tunnel{upfAddr: &net.UDPAddr{IP: upf.AsSlice(), Port: gtpuPort}, ulteid: 0x20, dlteid: 0x10}
+
+	tests := []struct {
+		name   string
+		teid   uint32
+		peer   netip.Addr
+		source netip.Addr
+		tunnel *Tunnel
+	}{
+		{name: "from the UPF", teid: 0x20, peer: upf, source: upf, tunnel: tunnel},
+		{name: "from another address of the UPF", teid: 0x20, peer: upf, source: netip.MustParseAddr("10.3.0.9"), tunnel: tunnel},
+		{name: "for another UPF", teid: 0x20, peer: netip.MustParseAddr("10.4.0.2"), source: netip.MustParseAddr("10.4.0.2")},
+		{name: "unknown TEID", teid: 0x21, peer: upf, source: upf},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &GnodeB{tunnels: map[uint32]*Tunnel{tunnel.dlteid: tunnel}}
+			g.cond = sync.NewCond(&g.mu)
+
+			g.handleErrorIndication(errorIndication(tt.teid, tt.peer), net.UDPAddrFromAddrPort(netip.AddrPortFrom(tt.source, gtpuPort)))
+
+			event, err := g.WaitForErrorIndication(time.Second)
+			if err != nil {
+				t.Fatalf("expected an Error Indication: %v", err)
+			}
+
+			if event.Tunnel != tt.tunnel {
+				t.Errorf("expected tunnel %v, got %v", tt.tunnel, event.Tunnel)
+			}
+		})
+	}
+}
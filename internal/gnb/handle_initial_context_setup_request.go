@@ -45,6 +45,10 @@ func handleInitialContextSetupRequest(gnb *GnodeB, initialContextSetupRequest *n
 		zap.Int64("RANUENGAPID", ranueNGAPID.Value),
 	)
 
+	// After a Service Request, this is the first message of the new UE
+	// association.
+	gnb.UpdateNGAPIDs(ranueNGAPID.Value, amfueNGAPID.Value)
+
 	if ueAggregateMaximumBitRate != nil {
 		gnb.StoreUEAmbr(ranueNGAPID.Value, &UEAmbrInformation{
 			UplinkBps:   ueAggregateMaximumBitRate.UEAggregateMaximumBitRateUL.Value,
@@ -91,6 +95,7 @@ func handleInitialContextSetupRequest(gnb *GnodeB, initialContextSetupRequest *n
 					PDUSessionID: s.PDUSessionID,
 					DLTeid:       s.DLTeid,
 					N3GnbIp:      gnb.N3Address,
+					N3GnbIpV6:    gnb.N3AddressV6,
 					QosId:        s.QosId,
 					QFI:          s.QFI,
 					FiveQi:       s.FiveQi,
@@ -114,6 +119,10 @@ func handleInitialContextSetupRequest(gnb *GnodeB, initialContextSetupRequest *n
 		"Sent Initial Context Setup Response",
 	)
 
+	if nasPDU == nil {
+		return nil
+	}
+
 	ue, err := gnb.LoadUE(ranueNGAPID.Value)
 	if err != nil {
 		return fmt.Errorf("cannot find UE for DownlinkNASTransport message: %v", err)
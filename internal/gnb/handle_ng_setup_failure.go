@@ -6,7 +6,7 @@ import (
 	"go.uber.org/zap"
 )
 
-func handleNGSetupFailure(nGSetupFailure *ngapType.NGSetupFailure) error {
+func handleNGSetupFailure(gnb *GnodeB, nGSetupFailure *ngapType.NGSetupFailure) error {
 	var cause *ngapType.Cause
 
 	for _, ie := range nGSetupFailure.ProtocolIEs.List {
@@ -16,6 +16,12 @@ func handleNGSetupFailure(nGSetupFailure *ngapType.NGSetupFailure) error {
 		}
 	}
 
+	gnb.mu.Lock()
+	started := gnb.ngSetupStarted
+	gnb.mu.Unlock()
+
+	gnb.spanNGSetup(started, causeToString(*cause))
+
 	logger.GnbLogger.Debug("Received NGSetupFailure",
 		zap.String("Cause", causeToString(*cause)),
 	)
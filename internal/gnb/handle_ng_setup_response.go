@@ -2,11 +2,22 @@ package gnb
 
 import (
 	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/metrics"
 	"github.com/free5gc/ngap/ngapType"
 	"go.uber.org/zap"
 )
 
-func handleNGSetupResponse(nGSetupResponse *ngapType.NGSetupResponse) error {
+func handleNGSetupResponse(gnb *GnodeB, nGSetupResponse *ngapType.NGSetupResponse) error {
+	gnb.mu.Lock()
+	started := gnb.ngSetupStarted
+	gnb.mu.Unlock()
+
+	if !started.IsZero() {
+		metrics.ObserveProcedure(metrics.ProcedureNGSetup, started)
+	}
+
+	gnb.spanNGSetup(started, "")
+
 	var (
 		amfName             *ngapType.AMFName
 		guamiList           *ngapType.ServedGUAMIList
@@ -0,0 +1,89 @@
+package gnb
+
+import (
+	"fmt"
+
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/free5gc/ngap/ngapType"
+	"go.uber.org/zap"
+)
+
+func handlePDUSessionResourceReleaseCommand(gnb *GnodeB, pduSessionResourceReleaseCommand *ngapType.PDUSessionResourceReleaseCommand) error {
+	var (
+		amfueNGAPID   *ngapType.AMFUENGAPID
+		ranueNGAPID   *ngapType.RANUENGAPID
+		nasPDU        *ngapType.NASPDU
+		toReleaseList *ngapType.PDUSessionResourceToReleaseListRelCmd
+	)
+
+	for _, ie := range pduSessionResourceReleaseCommand.ProtocolIEs.List {
+		switch ie.Id.Value {
+		case ngapType.ProtocolIEIDAMFUENGAPID:
+			amfueNGAPID = ie.Value.AMFUENGAPID
+		case ngapType.ProtocolIEIDRANUENGAPID:
+			ranueNGAPID = ie.Value.RANUENGAPID
+		case ngapType.ProtocolIEIDNASPDU:
+			nasPDU = ie.Value.NASPDU
+		case ngapType.ProtocolIEIDPDUSessionResourceToReleaseListRelCmd:
+			toReleaseList = ie.Value.PDUSessionResourceToReleaseListRelCmd
+		}
+	}
+
+	if amfueNGAPID == nil {
+		return fmt.Errorf("missing AMF UE NGAP ID in PDUSessionResourceReleaseCommand")
+	}
+
+	if ranueNGAPID == nil {
+		return fmt.Errorf("missing RAN UE NGAP ID in PDUSessionResourceReleaseCommand")
+	}
+
+	if toReleaseList == nil {
+		return fmt.Errorf("missing PDU Session Resource To Release List in PDUSessionResourceReleaseCommand")
+	}
+
+	logger.GnbLogger.Debug(
+		"Received PDU Session Resource Release Command",
+		zap.String("GNB ID", gnb.GnbID),
+		zap.Int64("RAN UE NGAP ID", ranueNGAPID.Value),
+		zap.Int64("AMF UE NGAP ID", amfueNGAPID.Value),
+	)
+
+	var released []int64
+
+	for _, pduSession := range toReleaseList.List {
+		gnb.releasePDUSession(ranueNGAPID.Value, pduSession.PDUSessionID.Value)
+		released = append(released, pduSession.PDUSessionID.Value)
+	}
+
+	// The resources are released before the UE learns of it, so that the
+	// session is gone from the gNodeB once the UE confirms the release.
+	if nasPDU != nil {
+		ue, err := gnb.LoadUE(ranueNGAPID.Value)
+		if err != nil {
+			return fmt.Errorf("could not load UE with RAN UE NGAP ID %d: %v", ranueNGAPID.Value, err)
+		}
+
+		err = ue.SendDownlinkNAS(nasPDU.Value, amfueNGAPID.Value, ranueNGAPID.Value)
+		if err != nil {
+			return fmt.Errorf("HandleDownlinkNASTransport failed: %v", err)
+		}
+	}
+
+	err := gnb.SendPDUSessionResourceReleaseResponse(&PDUSessionResourceReleaseResponseOpts{
+		AMFUENGAPID:   amfueNGAPID.Value,
+		RANUENGAPID:   ranueNGAPID.Value,
+		PDUSessionIDs: released,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send PDUSessionResourceReleaseResponse: %v", err)
+	}
+
+	logger.GnbLogger.Debug(
+		"Sent PDU Session Resource Release Response",
+		zap.String("GNB ID", gnb.GnbID),
+		zap.Int64("RAN UE NGAP ID", ranueNGAPID.Value),
+		zap.Int64s("PDU Session IDs", released),
+	)
+
+	return nil
+}
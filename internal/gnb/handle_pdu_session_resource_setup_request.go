@@ -125,6 +125,7 @@ func handlePDUSessionResourceSetupRequest(gnb *GnodeB, pduSessionResourceSetupRe
 				PDUSessionID: s.PDUSessionID,
 				DLTeid:       s.DLTeid,
 				N3GnbIp:      gnb.N3Address,
+				N3GnbIpV6:    gnb.N3AddressV6,
 				QFI:          1,
 			}
 		}
@@ -154,6 +155,7 @@ type PDUSessionInformation struct {
 	DLTeid       uint32
 	UpfAddress   string
 	N3GnbIp      netip.Addr
+	N3GnbIpV6    netip.Addr // advertised next to an IPv4 N3GnbIp by a dual-stack gNB
 	QosId        int64
 	QFI          int64
 	FiveQi       int64
@@ -209,7 +211,7 @@ func getPDUSessionInfoFromSetupRequestTransfer(gnb *GnodeB, transfer aper.OctetS
 		return nil, fmt.Errorf("gnb is nil, cannot determine N3 address family")
 	}
 
-	upfIp, err := ParseUPFAddress(upfAddress, gnb.N3Address)
+	upfIp, err := ParseUPFAddress(upfAddress, gnb.n3Addresses()...)
 	if err != nil {
 		return nil, fmt.Errorf("could not parse UPF address: %v", err)
 	}
@@ -218,6 +220,7 @@ func getPDUSessionInfoFromSetupRequestTransfer(gnb *GnodeB, transfer aper.OctetS
 		ULTeid:     ulTeid,
 		UpfAddress: upfIp,
 		N3GnbIp:    gnb.N3Address,
+		N3GnbIpV6:  gnb.N3AddressV6,
 		QosId:      qosId,
 		QFI:        qosId,
 		FiveQi:     fiveQi,
@@ -227,20 +230,20 @@ func getPDUSessionInfoFromSetupRequestTransfer(gnb *GnodeB, transfer aper.OctetS
 }
 
 // ParseUPFAddress selects the UPF IP address from a 3GPP TransportLayerAddress BIT STRING
-// that matches the IP family of the given gNB N3 address.
+// in the first IP family of the given gNB N3 addresses that the UPF offers.
 //
 // The encoding per 3GPP TS 38.414 is:
 //   - 4 bytes  → IPv4 only
 //   - 16 bytes → IPv6 only
 //   - 20 bytes → dual-stack: first 4 bytes are IPv4, next 16 bytes are IPv6
 //
-// Returns an error when the UPF provides no address for the requested family.
-func ParseUPFAddress(upfAddressBytes []byte, n3Addr netip.Addr) (string, error) {
+// Returns an error when the UPF provides no address in any of the families.
+func ParseUPFAddress(upfAddressBytes []byte, n3Addrs ...netip.Addr) (string, error) {
 	if len(upfAddressBytes) == 0 {
 		return "", fmt.Errorf("UPF transport layer address is empty")
 	}
 
-	if !n3Addr.IsValid() {
+	if len(n3Addrs) == 0 {
 		return "", fmt.Errorf("gNB N3 address is not set")
 	}
 
@@ -267,21 +270,20 @@ func ParseUPFAddress(upfAddressBytes []byte, n3Addr netip.Addr) (string, error)
 		return "", fmt.Errorf("unexpected UPF transport layer address length: %d bytes", len(upfAddressBytes))
 	}
 
-	if n3Addr.Is4() {
-		if !ipv4Addr.IsValid() {
-			return "", fmt.Errorf("gNB N3 address is IPv4 but UPF provided no IPv4 address")
+	for _, n3Addr := range n3Addrs {
+		switch {
+		case n3Addr.Is4() && ipv4Addr.IsValid():
+			return ipv4Addr.String(), nil
+		case n3Addr.Is6() && ipv6Addr.IsValid():
+			return ipv6Addr.String(), nil
+		case !n3Addr.IsValid():
+			return "", fmt.Errorf("gNB N3 address is not set")
 		}
-
-		return ipv4Addr.String(), nil
 	}
 
-	if n3Addr.Is6() {
-		if !ipv6Addr.IsValid() {
-			return "", fmt.Errorf("gNB N3 address is IPv6 but UPF provided no IPv6 address")
-		}
-
-		return ipv6Addr.String(), nil
+	if ipv4Addr.IsValid() {
+		return "", fmt.Errorf("UPF provided only an IPv4 address but the gNB has no IPv4 N3 address")
 	}
 
-	return "", fmt.Errorf("gNB N3 address is neither IPv4 nor IPv6: %s", n3Addr)
+	return "", fmt.Errorf("UPF provided only an IPv6 address but the gNB has no IPv6 N3 address")
 }
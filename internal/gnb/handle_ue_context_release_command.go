@@ -33,6 +33,7 @@ func handleUEContextReleaseCommand(gnb *GnodeB, uEContextReleaseCommand *ngapTyp
 		return fmt.Errorf("cannot find UE for UEContextReleaseCommand message: %v", err)
 	}
 
+	gnb.releaseUEContext(ueNgapIDs.UENGAPIDPair.RANUENGAPID.Value)
 	ue.RRCRelease()
 
 	err = gnb.SendUEContextReleaseComplete(&UEContextReleaseCompleteOpts{
@@ -29,6 +29,9 @@ func HandleFrame(gnb *GnodeB, sctpFrame SCTPFrame) error {
 		return fmt.Errorf("could not decode NGAP: %v", err)
 	}
 
+	gnb.traceNGAP(false, pdu)
+	gnb.timeNGAP(false, pdu)
+
 	switch pdu.Present {
 	case ngapType.NGAPPDUPresentInitiatingMessage:
 		err := handleNGAPInitiatingMessage(gnb, pdu)
@@ -40,7 +43,7 @@ func HandleFrame(gnb *GnodeB, sctpFrame SCTPFrame) error {
 
 		return nil
 	case ngapType.NGAPPDUPresentSuccessfulOutcome:
-		err := handleNGAPSuccessfulOutcome(pdu)
+		err := handleNGAPSuccessfulOutcome(gnb, pdu)
 		if err != nil {
 			return fmt.Errorf("could not handle NGAP SuccessfulOutcome: %v", err)
 		}
@@ -49,7 +52,7 @@ func HandleFrame(gnb *GnodeB, sctpFrame SCTPFrame) error {
 
 		return nil
 	case ngapType.NGAPPDUPresentUnsuccessfulOutcome:
-		err := handleNGAPUnsuccessfulOutcome(pdu)
+		err := handleNGAPUnsuccessfulOutcome(gnb, pdu)
 		if err != nil {
 			return fmt.Errorf("could not handle NGAP UnsuccessfulOutcome: %v", err)
 		}
@@ -71,6 +74,8 @@ func handleNGAPInitiatingMessage(gnb *GnodeB, pdu *ngapType.NGAPPDU) error {
 		return handleInitialContextSetupRequest(gnb, pdu.InitiatingMessage.Value.InitialContextSetupRequest)
 	case ngapType.InitiatingMessagePresentPDUSessionResourceSetupRequest:
 		return handlePDUSessionResourceSetupRequest(gnb, pdu.InitiatingMessage.Value.PDUSessionResourceSetupRequest)
+	case ngapType.InitiatingMessagePresentPDUSessionResourceReleaseCommand:
+		return handlePDUSessionResourceReleaseCommand(gnb, pdu.InitiatingMessage.Value.PDUSessionResourceReleaseCommand)
 	case ngapType.InitiatingMessagePresentUEContextReleaseCommand:
 		return handleUEContextReleaseCommand(gnb, pdu.InitiatingMessage.Value.UEContextReleaseCommand)
 	case ngapType.InitiatingMessagePresentPaging:
@@ -82,10 +87,10 @@ func handleNGAPInitiatingMessage(gnb *GnodeB, pdu *ngapType.NGAPPDU) error {
 	}
 }
 
-func handleNGAPSuccessfulOutcome(pdu *ngapType.NGAPPDU) error {
+func handleNGAPSuccessfulOutcome(gnb *GnodeB, pdu *ngapType.NGAPPDU) error {
 	switch pdu.SuccessfulOutcome.Value.Present {
 	case ngapType.SuccessfulOutcomePresentNGSetupResponse:
-		return handleNGSetupResponse(pdu.SuccessfulOutcome.Value.NGSetupResponse)
+		return handleNGSetupResponse(gnb, pdu.SuccessfulOutcome.Value.NGSetupResponse)
 	case ngapType.SuccessfulOutcomePresentNGResetAcknowledge:
 		return handleNGResetAcknowledge(pdu.SuccessfulOutcome.Value.NGResetAcknowledge)
 	case ngapType.SuccessfulOutcomePresentPathSwitchRequestAcknowledge:
@@ -95,10 +100,10 @@ func handleNGAPSuccessfulOutcome(pdu *ngapType.NGAPPDU) error {
 	}
 }
 
-func handleNGAPUnsuccessfulOutcome(pdu *ngapType.NGAPPDU) error {
+func handleNGAPUnsuccessfulOutcome(gnb *GnodeB, pdu *ngapType.NGAPPDU) error {
 	switch pdu.UnsuccessfulOutcome.Value.Present {
 	case ngapType.UnsuccessfulOutcomePresentNGSetupFailure:
-		return handleNGSetupFailure(pdu.UnsuccessfulOutcome.Value.NGSetupFailure)
+		return handleNGSetupFailure(gnb, pdu.UnsuccessfulOutcome.Value.NGSetupFailure)
 	case ngapType.UnsuccessfulOutcomePresentPathSwitchRequestFailure:
 		return nil // Handled via WaitForMessage
 	default:
@@ -0,0 +1,97 @@
+package gnb
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/vishvananda/netns"
+	"go.uber.org/zap"
+)
+
+// createNamespace creates a named network namespace (visible to
+// `ip netns exec`) and returns a handle to it without switching the calling
+// goroutine into it.
+func createNamespace(name string) (netns.NsHandle, error) {
+	runtime.LockOSThread()
+
+	origin, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return netns.None(), fmt.Errorf("could not get current network namespace: %v", err)
+	}
+
+	defer func() {
+		if err := origin.Close(); err != nil {
+			logger.GnbLogger.Warn("could not close network namespace handle", zap.String("netns", name), zap.Error(err))
+		}
+	}()
+
+	ns, err := netns.NewNamed(name)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return netns.None(), fmt.Errorf("could not create network namespace %s: %v", name, err)
+	}
+
+	// NewNamed leaves the thread in the new namespace. If it cannot be moved
+	// back, keep it locked so that the runtime discards it.
+	err = netns.Set(origin)
+	if err != nil {
+		return netns.None(), fmt.Errorf("could not return to the original network namespace: %v", err)
+	}
+
+	runtime.UnlockOSThread()
+
+	return ns, nil
+}
+
+// Do runs fn on an OS thread switched into the tunnel's network namespace, so
+// that sockets and netlink requests made by fn apply to the UE's namespace.
+// Without a namespace, fn runs directly.
+func (t *Tunnel) Do(fn func() error) error {
+	if t.Namespace == "" {
+		return fn()
+	}
+
+	ns, err := netns.GetFromName(t.Namespace)
+	if err != nil {
+		return fmt.Errorf("could not open network namespace %s: %v", t.Namespace, err)
+	}
+
+	defer func() {
+		if err := ns.Close(); err != nil {
+			logger.GnbLogger.Warn("could not close network namespace handle", zap.String("netns", t.Namespace), zap.Error(err))
+		}
+	}()
+
+	runtime.LockOSThread()
+
+	origin, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("could not get current network namespace: %v", err)
+	}
+
+	defer func() {
+		if err := origin.Close(); err != nil {
+			logger.GnbLogger.Warn("could not close network namespace handle", zap.String("netns", t.Namespace), zap.Error(err))
+		}
+	}()
+
+	err = netns.Set(ns)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("could not enter network namespace %s: %v", t.Namespace, err)
+	}
+
+	fnErr := fn()
+
+	err = netns.Set(origin)
+	if err != nil {
+		return fmt.Errorf("could not return to the original network namespace: %v", err)
+	}
+
+	runtime.UnlockOSThread()
+
+	return fnErr
+}
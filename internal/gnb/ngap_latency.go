@@ -0,0 +1,153 @@
+package gnb
+
+import (
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/latency"
+	"github.com/ellanetworks/core-tester/internal/trace"
+	"github.com/free5gc/ngap/ngapType"
+)
+
+// ngapLeg is an NGAP message the gNodeB sends and the answer of the AMF that
+// ends it.
+type ngapLeg struct {
+	request string
+	answer  string
+}
+
+// ngapLegs are the legs timed on N2, in the order they happen. Unlike the
+// NAS legs of the UE, they include the time the AMF takes to build and
+// encode the NGAP message, and the SCTP transfer.
+var ngapLegs = []ngapLeg{
+	{request: "NG Setup Request", answer: "NG Setup Response"},
+	{request: "Initial UE Message", answer: "Downlink NAS Transport"},        // registration
+	{request: "Initial UE Message", answer: "Initial Context Setup Request"}, // service request
+	{request: "Uplink NAS Transport", answer: "Downlink NAS Transport"},
+	{request: "Uplink NAS Transport", answer: "Initial Context Setup Request"},
+	{request: "Uplink NAS Transport", answer: "PDU Session Resource Setup Request"},
+	{request: "Uplink NAS Transport", answer: "PDU Session Resource Release Command"},
+	{request: "Path Switch Request", answer: "Path Switch Request Acknowledge"},
+	{request: "UE Context Release Request", answer: "UE Context Release Command"},
+	{request: "NG Reset", answer: "NG Reset Acknowledge"},
+}
+
+// nonUEAssociated keys the pending request of the procedures that are not
+// associated with a UE, such as NG Setup.
+const nonUEAssociated int64 = -1
+
+// pendingNGAP is the last request the gNodeB sent for a UE.
+type pendingNGAP struct {
+	request string
+	start   time.Time
+}
+
+// supiSender is a UE that can tell the gNodeB its SUPI, to label its
+// latency samples as the UE labels its own.
+type supiSender interface {
+	GetSupi() string
+}
+
+// timeNGAP starts a leg when the gNodeB sends its request, and records its
+// duration when the next message of the AMF for the same UE is its answer.
+// Any other message of the AMF ends the leg without recording it.
+func (g *GnodeB) timeNGAP(uplink bool, pdu *ngapType.NGAPPDU) {
+	if g.latency == nil {
+		return
+	}
+
+	now := time.Now()
+
+	msgType, value, ok := ngapMessage(pdu)
+	if !ok {
+		return
+	}
+
+	name := getMessageName(pdu.Present, msgType)
+
+	key, ok := ngapRANUENGAPID(value)
+	if !ok {
+		key = nonUEAssociated
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if uplink {
+		for _, leg := range ngapLegs {
+			if leg.request != name {
+				continue
+			}
+
+			if g.ngapLegStarts == nil {
+				g.ngapLegStarts = make(map[int64]pendingNGAP)
+			}
+
+			g.ngapLegStarts[key] = pendingNGAP{request: name, start: now}
+
+			return
+		}
+
+		return
+	}
+
+	pending, ok := g.ngapLegStarts[key]
+	if !ok {
+		return
+	}
+
+	delete(g.ngapLegStarts, key)
+
+	for _, leg := range ngapLegs {
+		if leg.request != pending.request || leg.answer != name {
+			continue
+		}
+
+		var imsi string
+		if ue, ok := g.UEPool[key].(supiSender); ok {
+			imsi = ue.GetSupi()
+		}
+
+		g.latency.Record(latency.Sample{
+			IMSI:     imsi,
+			Leg:      "NGAP " + leg.request + " → " + leg.answer,
+			Start:    pending.start,
+			Duration: now.Sub(pending.start),
+		})
+
+		return
+	}
+}
+
+// ngapMessage returns the type of the message an NGAP PDU carries and a
+// pointer to its value, a CHOICE of the messages of that PDU type.
+func ngapMessage(pdu *ngapType.NGAPPDU) (int, any, bool) {
+	switch pdu.Present {
+	case ngapType.NGAPPDUPresentInitiatingMessage:
+		return pdu.InitiatingMessage.Value.Present, &pdu.InitiatingMessage.Value, true
+	case ngapType.NGAPPDUPresentSuccessfulOutcome:
+		return pdu.SuccessfulOutcome.Value.Present, &pdu.SuccessfulOutcome.Value, true
+	case ngapType.NGAPPDUPresentUnsuccessfulOutcome:
+		return pdu.UnsuccessfulOutcome.Value.Present, &pdu.UnsuccessfulOutcome.Value, true
+	default:
+		return 0, nil, false
+	}
+}
+
+// ngapRANUENGAPID returns the RAN UE NGAP ID IE of a message value returned
+// by ngapMessage.
+func ngapRANUENGAPID(value any) (int64, bool) {
+	_, message, ok := trace.Choice(value)
+	if !ok {
+		return 0, false
+	}
+
+	for _, ie := range ngapIEValues(message) {
+		if _, ieValue, ok := trace.Choice(ie); ok {
+			if id, ok := ieValue.(*ngapType.RANUENGAPID); ok {
+				return id.Value, true
+			}
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,178 @@
+package gnb
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/air"
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/free5gc/aper"
+	"github.com/free5gc/nas/nasType"
+	"github.com/free5gc/ngap"
+	"github.com/free5gc/ngap/ngapType"
+	"go.uber.org/zap"
+)
+
+// PathSwitchOpts describes a connected UE that moves to this gNodeB from a
+// neighbour over Xn.
+type PathSwitchOpts struct {
+	RANUENGAPID          int64
+	SourceAMFUENGAPID    int64
+	UE                   air.DownlinkSender
+	UESecurityCapability *nasType.UESecurityCapability
+	// PDUSessions are the PDU sessions of the UE on the source gNodeB.
+	PDUSessions map[int64]*PDUSessionInformation
+	Timeout     time.Duration
+}
+
+// PathSwitch takes a UE over from a neighbour gNodeB: it asks the AMF to
+// switch the downlink of the PDU sessions to new tunnels of this gNodeB
+// (TS 23.502 4.9.1.2.2) and keeps the sessions the AMF switched. The UE is
+// forgotten again when the AMF refuses the switch.
+func (g *GnodeB) PathSwitch(opts *PathSwitchOpts) error {
+	if !g.N3Address.IsValid() {
+		return fmt.Errorf("N3 address not configured, cannot switch the user plane")
+	}
+
+	var toSwitch []*PDUSessionInformation
+
+	for _, pduSessionID := range slices.Sorted(maps.Keys(opts.PDUSessions)) {
+		session := *opts.PDUSessions[pduSessionID]
+		session.DLTeid = g.GenerateTEID()
+		session.N3GnbIp = g.N3Address
+		session.N3GnbIpV6 = g.N3AddressV6
+
+		toSwitch = append(toSwitch, &session)
+	}
+
+	g.AddUE(opts.RANUENGAPID, opts.UE)
+
+	err := g.SendPathSwitchRequest(&PathSwitchRequestOpts{
+		RANUENGAPID:          opts.RANUENGAPID,
+		SourceAMFUENGAPID:    opts.SourceAMFUENGAPID,
+		Mcc:                  g.MCC,
+		Mnc:                  g.MNC,
+		GnbID:                g.GnbID,
+		Tac:                  g.TAC,
+		UESecurityCapability: opts.UESecurityCapability,
+		PDUSessions:          toSwitch,
+	})
+	if err != nil {
+		g.RemoveUE(opts.RANUENGAPID)
+		return err
+	}
+
+	frame, outcome, err := g.waitForFrame(cmp.Or(opts.Timeout, 5*time.Second),
+		messageType{pduType: ngapType.NGAPPDUPresentSuccessfulOutcome, msgType: ngapType.SuccessfulOutcomePresentPathSwitchRequestAcknowledge},
+		messageType{pduType: ngapType.NGAPPDUPresentUnsuccessfulOutcome, msgType: ngapType.UnsuccessfulOutcomePresentPathSwitchRequestFailure},
+	)
+	if err != nil {
+		g.RemoveUE(opts.RANUENGAPID)
+		return err
+	}
+
+	if outcome.pduType == ngapType.NGAPPDUPresentUnsuccessfulOutcome {
+		g.RemoveUE(opts.RANUENGAPID)
+		return fmt.Errorf("AMF answered Path Switch Request Failure")
+	}
+
+	pdu, err := ngap.Decoder(frame.Data)
+	if err != nil {
+		g.RemoveUE(opts.RANUENGAPID)
+		return fmt.Errorf("could not decode Path Switch Request Acknowledge: %v", err)
+	}
+
+	err = g.handlePathSwitchRequestAcknowledge(opts.RANUENGAPID, pdu.SuccessfulOutcome.Value.PathSwitchRequestAcknowledge, toSwitch)
+	if err != nil {
+		g.RemoveUE(opts.RANUENGAPID)
+		return err
+	}
+
+	return nil
+}
+
+// handlePathSwitchRequestAcknowledge stores the new AMF UE NGAP ID of the UE
+// and the PDU sessions the AMF switched, with the uplink tunnel it may have
+// changed.
+func (g *GnodeB) handlePathSwitchRequestAcknowledge(ranUENGAPID int64, ack *ngapType.PathSwitchRequestAcknowledge, requested []*PDUSessionInformation) error {
+	var (
+		amfueNGAPID  *ngapType.AMFUENGAPID
+		switchedList *ngapType.PDUSessionResourceSwitchedList
+	)
+
+	for _, ie := range ack.ProtocolIEs.List {
+		switch ie.Id.Value {
+		case ngapType.ProtocolIEIDAMFUENGAPID:
+			amfueNGAPID = ie.Value.AMFUENGAPID
+		case ngapType.ProtocolIEIDPDUSessionResourceSwitchedList:
+			switchedList = ie.Value.PDUSessionResourceSwitchedList
+		}
+	}
+
+	if amfueNGAPID == nil {
+		return fmt.Errorf("missing AMF UE NGAP ID in PathSwitchRequestAcknowledge")
+	}
+
+	if switchedList == nil {
+		return fmt.Errorf("missing PDU Session Resource Switched List in PathSwitchRequestAcknowledge")
+	}
+
+	g.UpdateNGAPIDs(ranUENGAPID, amfueNGAPID.Value)
+
+	for _, item := range switchedList.List {
+		i := slices.IndexFunc(requested, func(s *PDUSessionInformation) bool { return s.PDUSessionID == item.PDUSessionID.Value })
+		if i < 0 {
+			return fmt.Errorf("AMF switched PDU session %d, which was not requested", item.PDUSessionID.Value)
+		}
+
+		session := requested[i]
+
+		err := g.updateULTunnel(session, item.PathSwitchRequestAcknowledgeTransfer)
+		if err != nil {
+			return fmt.Errorf("could not read PathSwitchRequestAcknowledgeTransfer of PDU session %d: %v", session.PDUSessionID, err)
+		}
+
+		g.StorePDUSession(ranUENGAPID, session)
+	}
+
+	logger.GnbLogger.Debug(
+		"Received Path Switch Request Acknowledge",
+		zap.String("GNB ID", g.GnbID),
+		zap.Int64("RAN UE NGAP ID", ranUENGAPID),
+		zap.Int64("AMF UE NGAP ID", amfueNGAPID.Value),
+		zap.Int("Switched PDU Sessions", len(switchedList.List)),
+	)
+
+	return nil
+}
+
+// updateULTunnel applies the uplink tunnel of a PDU session the AMF
+// acknowledged, when it set one.
+func (g *GnodeB) updateULTunnel(session *PDUSessionInformation, transfer aper.OctetString) error {
+	ack := &ngapType.PathSwitchRequestAcknowledgeTransfer{}
+
+	err := aper.UnmarshalWithParams(transfer, ack, "valueExt")
+	if err != nil {
+		return err
+	}
+
+	if ack.ULNGUUPTNLInformation == nil || ack.ULNGUUPTNLInformation.GTPTunnel == nil {
+		return nil
+	}
+
+	tunnel := ack.ULNGUUPTNLInformation.GTPTunnel
+
+	upfAddress, err := ParseUPFAddress(tunnel.TransportLayerAddress.Value.Bytes, g.n3Addresses()...)
+	if err != nil {
+		return err
+	}
+
+	session.ULTeid = binary.BigEndian.Uint32(tunnel.GTPTEID.Value)
+	session.UpfAddress = upfAddress
+
+	return nil
+}
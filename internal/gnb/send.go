@@ -13,25 +13,30 @@ type NGAPProcedure string
 const (
 	// Non-UE associated NGAP procedures
 	NGAPProcedureNGSetupRequest NGAPProcedure = "NGSetupRequest"
+	NGAPProcedureNGReset        NGAPProcedure = "NGReset"
 
 	// UE-associated NGAP procedures
-	NGAPProcedureInitialUEMessage                NGAPProcedure = "InitialUEMessage"
-	NGAPProcedureUplinkNASTransport              NGAPProcedure = "UplinkNASTransport"
-	NGAPProcedureInitialContextSetupResponse     NGAPProcedure = "InitialContextSetupResponse"
-	NGAPProcedurePDUSessionResourceSetupResponse NGAPProcedure = "PDUSessionResourceSetupResponse"
-	NGAPProcedureUEContextReleaseComplete        NGAPProcedure = "UEContextReleaseComplete"
+	NGAPProcedureInitialUEMessage                  NGAPProcedure = "InitialUEMessage"
+	NGAPProcedureUplinkNASTransport                NGAPProcedure = "UplinkNASTransport"
+	NGAPProcedureInitialContextSetupResponse       NGAPProcedure = "InitialContextSetupResponse"
+	NGAPProcedurePDUSessionResourceSetupResponse   NGAPProcedure = "PDUSessionResourceSetupResponse"
+	NGAPProcedurePDUSessionResourceReleaseResponse NGAPProcedure = "PDUSessionResourceReleaseResponse"
+	NGAPProcedurePathSwitchRequest                 NGAPProcedure = "PathSwitchRequest"
+	NGAPProcedureUEContextReleaseComplete          NGAPProcedure = "UEContextReleaseComplete"
+	NGAPProcedureUEContextReleaseRequest           NGAPProcedure = "UEContextReleaseRequest"
 )
 
 func getSCTPStreamID(msgType NGAPProcedure) (uint16, error) {
 	switch msgType {
 	// Non-UE procedures
-	case NGAPProcedureNGSetupRequest:
+	case NGAPProcedureNGSetupRequest, NGAPProcedureNGReset:
 		return 0, nil
 
 	// UE-associated procedures
 	case NGAPProcedureInitialUEMessage, NGAPProcedureUplinkNASTransport,
 		NGAPProcedureInitialContextSetupResponse, NGAPProcedurePDUSessionResourceSetupResponse,
-		NGAPProcedureUEContextReleaseComplete:
+		NGAPProcedurePDUSessionResourceReleaseResponse, NGAPProcedurePathSwitchRequest,
+		NGAPProcedureUEContextReleaseComplete, NGAPProcedureUEContextReleaseRequest:
 		return 1, nil
 	default:
 		return 0, fmt.Errorf("NGAP message type (%s) not supported", msgType)
@@ -47,6 +52,15 @@ func (g *GnodeB) SendNGSetupRequest(opts *NGSetupRequestOpts) error {
 	return g.SendMessage(pdu, NGAPProcedureNGSetupRequest)
 }
 
+func (g *GnodeB) SendNGReset(opts *NGResetOpts) error {
+	pdu, err := BuildNGReset(opts)
+	if err != nil {
+		return fmt.Errorf("couldn't build NGReset: %w", err)
+	}
+
+	return g.SendMessage(pdu, NGAPProcedureNGReset)
+}
+
 func (g *GnodeB) SendUplinkNASTransport(opts *UplinkNasTransportOpts) error {
 	pdu, err := BuildUplinkNasTransport(opts)
 	if err != nil {
@@ -74,6 +88,24 @@ func (g *GnodeB) SendPDUSessionResourceSetupResponse(opts *PDUSessionResourceSet
 	return g.SendMessage(pdu, NGAPProcedurePDUSessionResourceSetupResponse)
 }
 
+func (g *GnodeB) SendPDUSessionResourceReleaseResponse(opts *PDUSessionResourceReleaseResponseOpts) error {
+	pdu, err := BuildPDUSessionResourceReleaseResponse(opts)
+	if err != nil {
+		return fmt.Errorf("couldn't build PDUSessionResourceReleaseResponse: %w", err)
+	}
+
+	return g.SendMessage(pdu, NGAPProcedurePDUSessionResourceReleaseResponse)
+}
+
+func (g *GnodeB) SendPathSwitchRequest(opts *PathSwitchRequestOpts) error {
+	pdu, err := BuildPathSwitchRequest(opts)
+	if err != nil {
+		return fmt.Errorf("couldn't build PathSwitchRequest: %w", err)
+	}
+
+	return g.SendMessage(pdu, NGAPProcedurePathSwitchRequest)
+}
+
 func (g *GnodeB) SendUEContextReleaseComplete(opts *UEContextReleaseCompleteOpts) error {
 	pdu, err := BuildUEContextReleaseComplete(opts)
 	if err != nil {
@@ -88,17 +120,36 @@ func (g *GnodeB) SendUEContextReleaseComplete(opts *UEContextReleaseCompleteOpts
 	return nil
 }
 
+func (g *GnodeB) SendUEContextReleaseRequest(opts *UEContextReleaseRequestOpts) error {
+	pdu, err := BuildUEContextReleaseRequest(opts)
+	if err != nil {
+		return fmt.Errorf("couldn't build UEContextReleaseRequest: %w", err)
+	}
+
+	err = g.SendMessage(pdu, NGAPProcedureUEContextReleaseRequest)
+	if err != nil {
+		return fmt.Errorf("couldn't send UEContextReleaseRequest: %w", err)
+	}
+
+	return nil
+}
+
 func (g *GnodeB) SendMessage(pdu ngapType.NGAPPDU, procedure NGAPProcedure) error {
 	bytes, err := ngap.Encoder(pdu)
 	if err != nil {
 		return fmt.Errorf("couldn't encode message for procedure %s: %w", procedure, err)
 	}
 
+	// Started before sending, so that the answer cannot arrive first.
+	g.timeNGAP(true, &pdu)
+
 	err = g.SendToRan(bytes, procedure)
 	if err != nil {
 		return fmt.Errorf("couldn't send packet to ran: %w", err)
 	}
 
+	g.traceNGAP(true, &pdu)
+
 	return nil
 }
 
@@ -128,5 +179,7 @@ func (g *GnodeB) SendToRan(packet []byte, msgType NGAPProcedure) error {
 		return fmt.Errorf("send write to sctp connection: %w", err)
 	}
 
+	g.captureN2(true, sid, info.PPID, packet)
+
 	return nil
 }
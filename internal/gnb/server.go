@@ -3,18 +3,24 @@ package gnb
 import (
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"net/netip"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ellanetworks/core-tester/internal/air"
+	"github.com/ellanetworks/core-tester/internal/latency"
 	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/metrics"
+	"github.com/ellanetworks/core-tester/internal/pcap"
+	"github.com/ellanetworks/core-tester/internal/trace"
 	"github.com/free5gc/aper"
 	"github.com/free5gc/nas/nasType"
+	"github.com/free5gc/ngap"
 	"github.com/ishidawataru/sctp"
-	"github.com/vishvananda/netlink"
 	"go.uber.org/zap"
 )
 
@@ -42,8 +48,32 @@ type GnodeB struct {
 	mu                sync.Mutex
 	cond              *sync.Cond
 	N3Address         netip.Addr
+	N3AddressV6       netip.Addr                                 // IPv6 N3 address of a dual-stack gNodeB, next to an IPv4 N3Address
 	PDUSessions       map[int64]map[int64]*PDUSessionInformation // RANUENGAPID -> PDUSessionID -> PDUSessionInformation
 	UEAmbr            map[int64]*UEAmbrInformation               // RANUENGAPID -> UE AMBR
+	paths             map[netip.Addr]*PathStatus                 // UPF N3 address -> GTP-U path state
+	pendingEchoes     map[uint16]pendingEcho                     // sequence number -> outstanding Echo Request
+	echoSequence      uint16
+	ngSetupStarted    time.Time                          // when the NG Setup Request was sent
+	errorIndications  []ErrorIndication                  // the latest maxErrorIndications, for WaitForErrorIndication
+	releaseRequested  map[int64]bool                     // RANUENGAPID -> UE Context Release Request sent after an Error Indication
+	tunnelTable       atomic.Pointer[map[uint32]*Tunnel] // copy of tunnels for the data path
+	dataPath          DataPathOpts
+	n3                []*n3Socket // one per N3 address, N3Conn first
+	uplinkPool        sync.Pool
+	kernelGTP         *kernelGTP
+	capture           *pcap.Writer
+	trace             *trace.Recorder
+	latency           *latency.Recorder
+	ngapLegStarts     map[int64]pendingNGAP // RANUENGAPID, or nonUEAssociated -> last request sent
+	n2Local           netip.AddrPort
+	n2Remote          netip.AddrPort
+	closed            chan struct{}
+	closeOnce         sync.Once
+
+	// releaseOnErrorIndication sends a UE Context Release Request when an
+	// Error Indication matches one of the UE's tunnels.
+	releaseOnErrorIndication bool
 }
 
 func (g *GnodeB) StorePDUSession(ranUeId int64, pduSessionInfo *PDUSessionInformation) {
@@ -106,7 +136,35 @@ func (g *GnodeB) GetPDUSessions(ranUeId int64) map[int64]*PDUSessionInformation
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	return g.PDUSessions[ranUeId]
+	return maps.Clone(g.PDUSessions[ranUeId])
+}
+
+// releasePDUSession forgets a PDU session of a UE and closes its tunnel, if
+// one was created.
+func (g *GnodeB) releasePDUSession(ranUeId int64, pduSessionID int64) {
+	g.mu.Lock()
+
+	session, ok := g.PDUSessions[ranUeId][pduSessionID]
+	if !ok {
+		g.mu.Unlock()
+		logger.GnbLogger.Warn("AMF released a PDU session the gNodeB does not hold", zap.Int64("RAN UE NGAP ID", ranUeId), zap.Int64("PDU Session ID", pduSessionID))
+
+		return
+	}
+
+	delete(g.PDUSessions[ranUeId], pduSessionID)
+
+	_, hasTunnel := g.tunnels[session.DLTeid]
+	g.mu.Unlock()
+
+	if !hasTunnel {
+		return
+	}
+
+	err := g.CloseTunnel(session.DLTeid)
+	if err != nil {
+		logger.GnbLogger.Warn("could not close the tunnel of a released PDU session", zap.Int64("PDU Session ID", pduSessionID), zap.Error(err))
+	}
 }
 
 func (g *GnodeB) WaitForPDUSession(ranUeId int64, pduSessionID int64, timeout time.Duration) (*PDUSessionInformation, error) {
@@ -166,6 +224,20 @@ func (g *GnodeB) LoadUE(ranUeId int64) (air.DownlinkSender, error) {
 }
 
 func (g *GnodeB) WaitForMessage(pduType int, msgType int, timeout time.Duration) (SCTPFrame, error) {
+	frame, _, err := g.waitForFrame(timeout, messageType{pduType: pduType, msgType: msgType})
+
+	return frame, err
+}
+
+type messageType struct {
+	pduType int
+	msgType int
+}
+
+// waitForFrame waits for the first of several NGAP messages, such as the
+// successful and unsuccessful outcomes of a procedure, and returns the frame
+// with its type.
+func (g *GnodeB) waitForFrame(timeout time.Duration, types ...messageType) (SCTPFrame, messageType, error) {
 	deadline := time.Now().Add(timeout)
 
 	timer := time.AfterFunc(timeout, func() {
@@ -177,26 +249,35 @@ func (g *GnodeB) WaitForMessage(pduType int, msgType int, timeout time.Duration)
 	defer g.mu.Unlock()
 
 	for {
-		msgTypeMap, ok := g.receivedFrames[pduType]
-		if ok {
-			frames, ok := msgTypeMap[msgType]
+		for _, t := range types {
+			msgTypeMap, ok := g.receivedFrames[t.pduType]
+			if !ok {
+				continue
+			}
+
+			frames, ok := msgTypeMap[t.msgType]
 			if ok && len(frames) > 0 {
 				frame := frames[0]
 
 				if len(frames) == 1 {
-					delete(msgTypeMap, msgType)
+					delete(msgTypeMap, t.msgType)
 				} else {
-					msgTypeMap[msgType] = frames[1:]
+					msgTypeMap[t.msgType] = frames[1:]
 				}
 
-				g.receivedFrames[pduType] = msgTypeMap
+				g.receivedFrames[t.pduType] = msgTypeMap
 
-				return frame, nil
+				return frame, t, nil
 			}
 		}
 
 		if time.Now().After(deadline) {
-			return SCTPFrame{}, fmt.Errorf("timeout waiting for NGAP message %v", getMessageName(pduType, msgType))
+			names := make([]string, 0, len(types))
+			for _, t := range types {
+				names = append(names, getMessageName(t.pduType, t.msgType))
+			}
+
+			return SCTPFrame{}, messageType{}, fmt.Errorf("timeout waiting for NGAP message %v", strings.Join(names, " or "))
 		}
 
 		g.cond.Wait()
@@ -221,6 +302,26 @@ type StartOpts struct {
 	CoreN2Address string
 	GnbN2Address  string
 	GnbN3Address  string
+	// GnbN3AddressV6 makes the gNodeB dual-stack: it adds an IPv6 N3
+	// address to an IPv4 GnbN3Address. An IPv6-only gNodeB sets its address
+	// in GnbN3Address instead.
+	GnbN3AddressV6 string
+	// ReleaseOnErrorIndication requests the release of a UE's context when
+	// the UPF reports one of its tunnels with a GTP-U Error Indication.
+	ReleaseOnErrorIndication bool
+	DataPath                 DataPathOpts
+	// KernelGTPDevice, when set, creates a Linux GTP device of this name on
+	// the N3 socket so that tunnels added with AddKernelTunnel are forwarded
+	// by the kernel.
+	KernelGTPDevice string
+	// Capture, when set, records every NGAP message and GTP-U packet of the
+	// gNodeB. The caller closes it after the gNodeB.
+	Capture *pcap.Writer
+	// Trace, when set, records every NGAP message sent and received.
+	Trace *trace.Recorder
+	// Latency, when set, records how long the AMF takes to answer each
+	// NGAP request.
+	Latency *latency.Recorder
 }
 
 func Start(opts *StartOpts) (*GnodeB, error) {
@@ -249,46 +350,103 @@ func Start(opts *StartOpts) (*GnodeB, error) {
 		return nil, fmt.Errorf("could not subscribe SCTP events: %w", err)
 	}
 
-	var n3Conn *net.UDPConn
-
-	var gnbN3IPAddress netip.Addr
+	var gnbN3IPAddress, gnbN3IPv6Address netip.Addr
 
 	if opts.GnbN3Address != "" {
-		laddr := &net.UDPAddr{
-			IP:   net.ParseIP(opts.GnbN3Address),
-			Port: 2152,
+		gnbN3IPAddress, err = netip.ParseAddr(opts.GnbN3Address)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse gNB N3 IP address: %v", err)
 		}
 
-		n3Conn, err = net.ListenUDP("udp", laddr)
+		gnbN3IPAddress = gnbN3IPAddress.Unmap()
+	}
+
+	if opts.GnbN3AddressV6 != "" {
+		if !gnbN3IPAddress.Is4() {
+			return nil, fmt.Errorf("a dual-stack gNB needs an IPv4 N3 address next to the IPv6 one")
+		}
+
+		gnbN3IPv6Address, err = netip.ParseAddr(opts.GnbN3AddressV6)
 		if err != nil {
-			return nil, fmt.Errorf("could not listen on GTP-U UDP address %s: %v", opts.GnbN3Address, err)
+			return nil, fmt.Errorf("could not parse gNB N3 IPv6 address: %v", err)
 		}
 
-		gnbN3IPAddress, err = netip.ParseAddr(opts.GnbN3Address)
+		if !gnbN3IPv6Address.Is6() || gnbN3IPv6Address.Is4In6() {
+			return nil, fmt.Errorf("gNB N3 IPv6 address %s is not an IPv6 address", gnbN3IPv6Address)
+		}
+	}
+
+	dataPath := opts.DataPath.withDefaults()
+
+	var n3Sockets []*n3Socket
+
+	for _, addr := range []netip.Addr{gnbN3IPAddress, gnbN3IPv6Address} {
+		if !addr.IsValid() {
+			continue
+		}
+
+		s, err := listenN3(addr, dataPath.Workers*dataPath.BatchSize)
 		if err != nil {
-			return nil, fmt.Errorf("could not parse gNB N3 IP address: %v", err)
+			for _, s := range n3Sockets {
+				if closeErr := s.conn.Close(); closeErr != nil {
+					logger.GnbLogger.Warn("could not close GTP-U UDP connection", zap.Error(closeErr))
+				}
+			}
+
+			return nil, err
 		}
+
+		n3Sockets = append(n3Sockets, s)
+	}
+
+	var n3Conn *net.UDPConn
+	if len(n3Sockets) > 0 {
+		n3Conn = n3Sockets[0].conn
 	}
 
 	gnodeB := &GnodeB{
-		GnbID:     opts.GnbID,
-		MCC:       opts.MCC,
-		MNC:       opts.MNC,
-		SST:       opts.SST,
-		SD:        opts.SD,
-		Slices:    opts.Slices,
-		DNN:       opts.DNN,
-		TAC:       opts.TAC,
-		Name:      opts.Name,
-		N2Conn:    n2Conn,
-		N3Conn:    n3Conn,
-		tunnels:   make(map[uint32]*Tunnel),
-		N3Address: gnbN3IPAddress,
+		GnbID:         opts.GnbID,
+		MCC:           opts.MCC,
+		MNC:           opts.MNC,
+		SST:           opts.SST,
+		SD:            opts.SD,
+		Slices:        opts.Slices,
+		DNN:           opts.DNN,
+		TAC:           opts.TAC,
+		Name:          opts.Name,
+		N2Conn:        n2Conn,
+		N3Conn:        n3Conn,
+		tunnels:       make(map[uint32]*Tunnel),
+		N3Address:     gnbN3IPAddress,
+		N3AddressV6:   gnbN3IPv6Address,
+		n3:            n3Sockets,
+		capture:       opts.Capture,
+		trace:         opts.Trace,
+		latency:       opts.Latency,
+		n2Local:       sctpAddrPort(n2Conn.LocalAddr()),
+		n2Remote:      sctpAddrPort(n2Conn.RemoteAddr()),
+		paths:         make(map[netip.Addr]*PathStatus),
+		pendingEchoes: make(map[uint16]pendingEcho),
+		closed:        make(chan struct{}),
+
+		releaseOnErrorIndication: opts.ReleaseOnErrorIndication,
+		dataPath:                 dataPath,
 	}
 	gnodeB.cond = sync.NewCond(&gnodeB.mu)
+	gnodeB.uplinkPool.New = func() any { return new(uplinkPacket) }
+	gnodeB.startDataPath()
+
+	if opts.KernelGTPDevice != "" {
+		if !gnbN3IPAddress.Is4() {
+			gnodeB.Close()
+			return nil, fmt.Errorf("kernel GTP offload requires an IPv4 gNB N3 address")
+		}
 
-	if n3Conn != nil {
-		go gnodeB.GTPReader()
+		err = gnodeB.enableKernelGTP(opts.KernelGTPDevice)
+		if err != nil {
+			gnodeB.Close()
+			return nil, err
+		}
 	}
 
 	gnodeB.ListenAndServe(n2Conn)
@@ -303,6 +461,10 @@ func Start(opts *StartOpts) (*GnodeB, error) {
 		Slices: gnodeB.Slices,
 	}
 
+	gnodeB.mu.Lock()
+	gnodeB.ngSetupStarted = time.Now()
+	gnodeB.mu.Unlock()
+
 	err = gnodeB.SendNGSetupRequest(ngSetupOpts)
 	if err != nil {
 		return nil, fmt.Errorf("could not send NGSetupRequest: %v", err)
@@ -340,6 +502,48 @@ func (g *GnodeB) AddUE(ranUENGAPID int64, ue air.DownlinkSender) {
 	g.UEPool[ranUENGAPID] = ue
 }
 
+// RemoveUE forgets a UE and its context.
+func (g *GnodeB) RemoveUE(ranUENGAPID int64) {
+	g.releaseUEContext(ranUENGAPID)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.UEPool, ranUENGAPID)
+}
+
+// releaseUEContext forgets the NGAP association and the PDU sessions of a UE
+// whose context the AMF released. The UE stays in the pool.
+func (g *GnodeB) releaseUEContext(ranUENGAPID int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.NGAPIDs, ranUENGAPID)
+	delete(g.PDUSessions, ranUENGAPID)
+	delete(g.UEAmbr, ranUENGAPID)
+	delete(g.releaseRequested, ranUENGAPID)
+}
+
+// ReleaseUEContexts releases the context of every UE associated with the
+// AMF, as after an NG Reset, and releases their RRC connection.
+func (g *GnodeB) ReleaseUEContexts() {
+	g.mu.Lock()
+
+	ues := make(map[int64]air.DownlinkSender, len(g.NGAPIDs))
+	for ranUENGAPID := range g.NGAPIDs {
+		if ue, ok := g.UEPool[ranUENGAPID]; ok {
+			ues[ranUENGAPID] = ue
+		}
+	}
+
+	g.mu.Unlock()
+
+	for ranUENGAPID, ue := range ues {
+		g.releaseUEContext(ranUENGAPID)
+		ue.RRCRelease()
+	}
+}
+
 func (g *GnodeB) ListenAndServe(conn *sctp.SCTPConn) {
 	go func() {
 		buf := make([]byte, SCTPReadBufferSize)
@@ -366,6 +570,12 @@ func (g *GnodeB) ListenAndServe(conn *sctp.SCTPConn) {
 				return
 			}
 
+			if info != nil {
+				g.captureN2(false, info.Stream, info.PPID, buf[:n])
+			} else {
+				g.captureN2(false, 0, ngap.PPID, buf[:n])
+			}
+
 			cp := append([]byte(nil), buf[:n]...) // copy to isolate from buffer reuse
 
 			sctpFrame := SCTPFrame{
@@ -383,6 +593,8 @@ func (g *GnodeB) ListenAndServe(conn *sctp.SCTPConn) {
 }
 
 func (g *GnodeB) Close() {
+	g.closeOnce.Do(func() { close(g.closed) })
+
 	g.mu.Lock()
 
 	tunnelsToClose := make(map[uint32]*Tunnel, len(g.tunnels))
@@ -392,22 +604,17 @@ func (g *GnodeB) Close() {
 	g.mu.Unlock()
 
 	for _, t := range tunnelsToClose {
-		if err := t.tunIF.Close(); err != nil {
-			logger.GnbLogger.Error("error closing TUN interface", zap.String("if", t.Name), zap.Error(err))
-		}
-
-		link, err := netlink.LinkByName(t.Name)
-		if err == nil {
-			if err = netlink.LinkDel(link); err != nil {
-				logger.GnbLogger.Error("error deleting TUN interface", zap.String("if", t.Name), zap.Error(err))
-			}
-		}
+		t.close()
 	}
 
 	g.mu.Lock()
+	metrics.Tunnels.Sub(float64(len(g.tunnels)))
 	g.tunnels = make(map[uint32]*Tunnel)
+	g.publishTunnels()
 	g.mu.Unlock()
 
+	g.disableKernelGTP()
+
 	if g.N2Conn != nil {
 		err := g.N2Conn.Close()
 		if err != nil {
@@ -415,10 +622,10 @@ func (g *GnodeB) Close() {
 		}
 	}
 
-	if g.N3Conn != nil {
-		err := g.N3Conn.Close()
+	for _, s := range g.n3 {
+		err := s.conn.Close()
 		if err != nil {
-			logger.GnbLogger.Error("could not close GTP-U UDP connection", zap.Error(err))
+			logger.GnbLogger.Error("could not close GTP-U UDP connection", zap.String("address", s.addr.String()), zap.Error(err))
 		}
 	}
 }
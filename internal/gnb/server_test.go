@@ -0,0 +1,38 @@
+package gnb
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"go.uber.org/zap"
+)
+
+func TestReleasePDUSession(t *testing.T) {
+	logger.GnbLogger = zap.NewNop()
+
+	g := &GnodeB{}
+	g.cond = sync.NewCond(&g.mu)
+	g.StorePDUSession(1, &PDUSessionInformation{PDUSessionID: 5, DLTeid: 0x10})
+
+	tests := []struct {
+		name         string
+		ranUENGAPID  int64
+		pduSessionID int64
+	}{
+		{name: "unknown UE", ranUENGAPID: 2, pduSessionID: 5},
+		{name: "unknown session", ranUENGAPID: 1, pduSessionID: 6},
+		{name: "session without a tunnel", ranUENGAPID: 1, pduSessionID: 5},
+		{name: "session already released", ranUENGAPID: 1, pduSessionID: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g.releasePDUSession(tt.ranUENGAPID, tt.pduSessionID)
+		})
+	}
+
+	if sessions := g.GetPDUSessions(1); len(sessions) != 0 {
+		t.Errorf("expected the session to be released, got %v", sessions)
+	}
+}
@@ -0,0 +1,33 @@
+package gnb
+
+import (
+	"context"
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/ellanetworks/core-tester/internal/gnb")
+
+// spanNGSetup records the NG Setup procedure that started with the request
+// sent at started and ends now, as failed when cause is set.
+func (g *GnodeB) spanNGSetup(started time.Time, cause string) {
+	if started.IsZero() {
+		return
+	}
+
+	_, span := tracer.Start(context.Background(), "NG Setup",
+		oteltrace.WithSpanKind(oteltrace.SpanKindClient),
+		oteltrace.WithTimestamp(started),
+		oteltrace.WithAttributes(telemetry.AttributeGnbID.String(g.GnbID)),
+	)
+
+	if cause != "" {
+		span.SetStatus(codes.Error, cause)
+	}
+
+	span.End()
+}
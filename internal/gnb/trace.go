@@ -0,0 +1,84 @@
+package gnb
+
+import (
+	"reflect"
+
+	"github.com/ellanetworks/core-tester/internal/trace"
+	"github.com/free5gc/ngap/ngapType"
+)
+
+// traceNGAP records an NGAP message exchanged with the AMF, with its IEs
+// keyed by the name of their value, such as RANUENGAPID or NASPDU.
+func (g *GnodeB) traceNGAP(uplink bool, pdu *ngapType.NGAPPDU) {
+	if !g.trace.Enabled() {
+		return
+	}
+
+	msgType, value, ok := ngapMessage(pdu)
+	if !ok {
+		return
+	}
+
+	event := trace.Event{
+		Protocol:  trace.ProtocolNGAP,
+		Direction: trace.Downlink,
+		From:      trace.AMF,
+		To:        trace.GNB,
+		Message:   getMessageName(pdu.Present, msgType),
+		IEs:       make(map[string]any),
+	}
+
+	if uplink {
+		event.Direction, event.From, event.To = trace.Uplink, trace.GNB, trace.AMF
+	}
+
+	if _, message, ok := trace.Choice(value); ok {
+		for _, ie := range ngapIEValues(message) {
+			name, ieValue, ok := trace.Choice(ie)
+			if !ok {
+				continue
+			}
+
+			event.IEs[name] = trace.Plain(ieValue)
+
+			switch id := ieValue.(type) {
+			case *ngapType.RANUENGAPID:
+				event.RANUENGAPID = &id.Value
+			case *ngapType.AMFUENGAPID:
+				event.AMFUENGAPID = &id.Value
+			}
+		}
+	}
+
+	g.trace.Record(event)
+}
+
+// ngapIEValues returns a pointer to the Value of every IE in the
+// ProtocolIEs list of a decoded NGAP message.
+func ngapIEValues(message any) []any {
+	msg := reflect.Indirect(reflect.ValueOf(message))
+	if msg.Kind() != reflect.Struct {
+		return nil
+	}
+
+	ies := msg.FieldByName("ProtocolIEs")
+	if ies.Kind() != reflect.Struct {
+		return nil
+	}
+
+	list := ies.FieldByName("List")
+	if list.Kind() != reflect.Slice {
+		return nil
+	}
+
+	values := make([]any, 0, list.Len())
+
+	for i := range list.Len() {
+		value := list.Index(i).FieldByName("Value")
+		if value.Kind() == reflect.Struct {
+			values = append(values, value.Addr().Interface())
+		}
+	}
+
+	return values
+}
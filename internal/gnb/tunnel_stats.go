@@ -0,0 +1,92 @@
+package gnb
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/metrics"
+	"go.uber.org/zap"
+)
+
+type tunnelCounters struct {
+	uplinkPackets   atomic.Uint64
+	uplinkBytes     atomic.Uint64
+	uplinkErrors    atomic.Uint64
+	downlinkPackets atomic.Uint64
+	downlinkBytes   atomic.Uint64
+}
+
+func (c *tunnelCounters) countUplink(n int) {
+	c.uplinkPackets.Add(1)
+	c.uplinkBytes.Add(uint64(n))
+	metrics.CountUplink(n)
+}
+
+func (c *tunnelCounters) countUplinkError() {
+	c.uplinkErrors.Add(1)
+	metrics.CountUplinkError()
+}
+
+func (c *tunnelCounters) countDownlink(n int) {
+	c.downlinkPackets.Add(1)
+	c.downlinkBytes.Add(uint64(n))
+	metrics.CountDownlink(n)
+}
+
+// TunnelStats counts the user plane traffic of a tunnel. Byte counts cover
+// the inner packets, without the GTP-U encapsulation.
+type TunnelStats struct {
+	UplinkPackets   uint64
+	UplinkBytes     uint64
+	UplinkErrors    uint64
+	DownlinkPackets uint64
+	DownlinkBytes   uint64
+}
+
+func (t *Tunnel) Stats() TunnelStats {
+	return TunnelStats{
+		UplinkPackets:   t.counters.uplinkPackets.Load(),
+		UplinkBytes:     t.counters.uplinkBytes.Load(),
+		UplinkErrors:    t.counters.uplinkErrors.Load(),
+		DownlinkPackets: t.counters.downlinkPackets.Load(),
+		DownlinkBytes:   t.counters.downlinkBytes.Load(),
+	}
+}
+
+// SetDownlinkHook installs a function that sees every downlink packet of the
+// tunnel before it reaches the UE side. Packets for which it returns true are
+// consumed and not delivered. Passing nil removes the hook.
+func (t *Tunnel) SetDownlinkHook(hook func(packet []byte) bool) {
+	if hook == nil {
+		t.downlinkHook.Store(nil)
+		return
+	}
+
+	t.downlinkHook.Store(&hook)
+}
+
+// SendUplink encapsulates a packet originated by the UE and sends it to the
+// UPF, as if it had been read from the tunnel's device.
+func (g *GnodeB) SendUplink(t *Tunnel, packet []byte) error {
+	buf := make([]byte, gtpHeaderLen+len(packet))
+	putUplinkHeader(buf, t, len(packet))
+	copy(buf[gtpHeaderLen:], packet)
+
+	_, err := t.n3.conn.WriteToUDP(buf, t.upfAddr)
+	if err != nil {
+		t.counters.countUplinkError()
+		return fmt.Errorf("could not write to GTP-U socket: %v", err)
+	}
+
+	t.counters.countUplink(len(packet))
+	g.captureN3(t.n3, t.upfAddr, true, buf)
+
+	logger.GnbLogger.Debug(
+		"Sent packet to GTP",
+		zap.Int("length", len(packet)),
+		zap.Int("TEID", int(t.ulteid)),
+	)
+
+	return nil
+}
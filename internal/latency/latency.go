@@ -0,0 +1,169 @@
+// Package latency collects the time the core takes to answer each leg of a
+// procedure, per UE, and summarizes it as percentiles.
+package latency
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Sample is the duration of one leg for one UE: from the message the UE sent
+// to the answer it received.
+type Sample struct {
+	IMSI     string
+	Leg      string
+	Start    time.Time
+	Duration time.Duration
+}
+
+// Recorder collects samples from any number of UEs. A nil Recorder records
+// nothing. It is safe for concurrent use.
+type Recorder struct {
+	mu      sync.Mutex
+	samples []Sample
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) Record(s Sample) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.samples = append(r.samples, s)
+}
+
+// LegSummary holds the distribution of the samples of one leg, in
+// milliseconds.
+type LegSummary struct {
+	Leg   string  `json:"leg"`
+	Count int     `json:"count"`
+	Min   float64 `json:"min_ms"`
+	Mean  float64 `json:"mean_ms"`
+	P50   float64 `json:"p50_ms"`
+	P95   float64 `json:"p95_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+// UESample is a Sample as written in the JSON report.
+type UESample struct {
+	IMSI     string    `json:"imsi"`
+	Leg      string    `json:"leg"`
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"duration_ms"`
+}
+
+// Report summarizes the legs in the order they were first seen, followed by
+// every sample.
+type Report struct {
+	Legs    []LegSummary `json:"legs"`
+	Samples []UESample   `json:"samples"`
+}
+
+func (r *Recorder) Report() Report {
+	report := Report{
+		Legs:    []LegSummary{},
+		Samples: []UESample{},
+	}
+
+	if r == nil {
+		return report
+	}
+
+	r.mu.Lock()
+	samples := slices.Clone(r.samples)
+	r.mu.Unlock()
+
+	var legs []string
+
+	durations := make(map[string][]time.Duration)
+
+	for _, s := range samples {
+		if _, ok := durations[s.Leg]; !ok {
+			legs = append(legs, s.Leg)
+		}
+
+		durations[s.Leg] = append(durations[s.Leg], s.Duration)
+
+		report.Samples = append(report.Samples, UESample{
+			IMSI:     s.IMSI,
+			Leg:      s.Leg,
+			Start:    s.Start,
+			Duration: milliseconds(s.Duration),
+		})
+	}
+
+	for _, leg := range legs {
+		report.Legs = append(report.Legs, summarize(leg, durations[leg]))
+	}
+
+	return report
+}
+
+func summarize(leg string, d []time.Duration) LegSummary {
+	slices.Sort(d)
+
+	var total time.Duration
+	for _, v := range d {
+		total += v
+	}
+
+	return LegSummary{
+		Leg:   leg,
+		Count: len(d),
+		Min:   milliseconds(d[0]),
+		Mean:  milliseconds(total / time.Duration(len(d))),
+		P50:   milliseconds(percentile(d, 50)),
+		P95:   milliseconds(percentile(d, 95)),
+		P99:   milliseconds(percentile(d, 99)),
+		Max:   milliseconds(d[len(d)-1]),
+	}
+}
+
+// percentile returns the nearest-rank percentile p of the sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+
+	return sorted[max(rank, 1)-1]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// WriteTable writes the leg summaries as an aligned text table.
+func (r Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "LEG\tCOUNT\tMIN\tP50\tP95\tP99\tMAX")
+
+	for _, l := range r.Legs {
+		fmt.Fprintf(tw, "%s\t%d\t%.3fms\t%.3fms\t%.3fms\t%.3fms\t%.3fms\n", l.Leg, l.Count, l.Min, l.P50, l.P95, l.P99, l.Max)
+	}
+
+	return tw.Flush()
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	err := enc.Encode(r)
+	if err != nil {
+		return fmt.Errorf("could not encode latency report: %v", err)
+	}
+
+	return nil
+}
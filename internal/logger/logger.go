@@ -1,10 +1,30 @@
 package logger
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"strings"
 
+	aperLogger "github.com/free5gc/aper/logger"
+	ngapLogger "github.com/free5gc/ngap/logger"
+	"github.com/sirupsen/logrus"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Components whose level can be set apart from the others.
+const (
+	ComponentGnb  = "gnb"
+	ComponentUe   = "ue"
+	ComponentNGAP = "ngap" // the NGAP and APER codecs
+)
+
+// Log formats.
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
 )
 
 var (
@@ -13,24 +33,138 @@ var (
 	UeLogger  *zap.Logger
 )
 
-func Init(logLevel zapcore.Level) {
+// Config selects where logs go and how much is logged.
+type Config struct {
+	Level zapcore.Level
+	// ComponentLevels overrides Level for the components that are set. The
+	// NGAP codec is held at warning level unless set, since it traces every
+	// field it encodes at debug level.
+	ComponentLevels map[string]zapcore.Level
+	// Format is FormatConsole, the default, or FormatJSON.
+	Format string
+	// File, when set, receives the logs instead of stdout. It is rotated
+	// when it reaches MaxSizeMB, keeping MaxBackups old files for at most
+	// MaxAgeDays; zero keeps them all.
+	File       string
+	MaxSizeMB  int
+	MaxBackups int
+	MaxAgeDays int
+}
+
+func Init(cfg Config) error {
+	for component := range cfg.ComponentLevels {
+		switch component {
+		case ComponentGnb, ComponentUe, ComponentNGAP:
+		default:
+			return fmt.Errorf("invalid log component %q: must be %s, %s, or %s", component, ComponentGnb, ComponentUe, ComponentNGAP)
+		}
+	}
+
 	encCfg := zap.NewDevelopmentEncoderConfig()
-	encCfg.EncodeLevel = zapcore.CapitalColorLevelEncoder
 
-	consoleEncoder := zapcore.NewConsoleEncoder(encCfg)
+	var encoder zapcore.Encoder
 
-	core := zapcore.NewCore(
-		consoleEncoder,
-		zapcore.AddSync(os.Stdout),
-		logLevel,
-	)
+	switch cfg.Format {
+	case "", FormatConsole:
+		// Colors would end up as escape codes in a file.
+		encCfg.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		if cfg.File != "" {
+			encCfg.EncodeLevel = zapcore.CapitalLevelEncoder
+		}
 
-	Logger = zap.New(core)
-	GnbLogger = zap.New(core)
-	UeLogger = zap.New(core)
+		encoder = zapcore.NewConsoleEncoder(encCfg)
+	case FormatJSON:
+		encCfg = zap.NewProductionEncoderConfig()
+		encCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoder = zapcore.NewJSONEncoder(encCfg)
+	default:
+		return fmt.Errorf("invalid log format %q: must be %s or %s", cfg.Format, FormatConsole, FormatJSON)
+	}
+
+	output := zapcore.Lock(os.Stdout)
+	if cfg.File != "" {
+		output = zapcore.AddSync(&lumberjack.Logger{
+			Filename:   cfg.File,
+			MaxSize:    cfg.MaxSizeMB,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAgeDays,
+		})
+	}
+
+	level := func(component string, fallback zapcore.Level) zapcore.Level {
+		if l, ok := cfg.ComponentLevels[component]; ok {
+			return l
+		}
+
+		return fallback
+	}
+
+	Logger = zap.New(zapcore.NewCore(encoder, output, cfg.Level))
+	GnbLogger = zap.New(zapcore.NewCore(encoder, output, level(ComponentGnb, cfg.Level)))
+	UeLogger = zap.New(zapcore.NewCore(encoder, output, level(ComponentUe, cfg.Level)))
 
 	zap.ReplaceGlobals(Logger)
 
 	GnbLogger = GnbLogger.With(zap.String("Component", "GNB"))
 	UeLogger = UeLogger.With(zap.String("Component", "UE"))
+
+	codecLevel := level(ComponentNGAP, max(cfg.Level, zapcore.WarnLevel))
+	codecLogger := zap.New(zapcore.NewCore(encoder, output, codecLevel)).With(zap.String("Component", "NGAP"))
+
+	for _, l := range []*logrus.Logger{ngapLogger.GetLogger(), aperLogger.GetLogger()} {
+		l.SetOutput(io.Discard)
+		l.SetLevel(logrusLevel(codecLevel))
+		l.ReplaceHooks(logrus.LevelHooks{})
+		l.AddHook(logrusHook{log: codecLogger})
+	}
+
+	return nil
+}
+
+// logrusHook writes the entries of the free5gc codecs, which log with
+// logrus, to the tester's log.
+type logrusHook struct {
+	log *zap.Logger
+}
+
+func (h logrusHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h logrusHook) Fire(e *logrus.Entry) error {
+	fields := make([]zap.Field, 0, len(e.Data))
+
+	for k, v := range e.Data {
+		if k != "component" {
+			fields = append(fields, zap.Any(k, v))
+		}
+	}
+
+	msg := strings.TrimSpace(e.Message)
+
+	switch e.Level {
+	case logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel:
+		h.log.Error(msg, fields...)
+	case logrus.WarnLevel:
+		h.log.Warn(msg, fields...)
+	case logrus.InfoLevel:
+		h.log.Info(msg, fields...)
+	default:
+		h.log.Debug(msg, fields...)
+	}
+
+	return nil
+}
+
+func logrusLevel(l zapcore.Level) logrus.Level {
+	switch {
+	case l <= zapcore.DebugLevel:
+		return logrus.DebugLevel
+	case l == zapcore.InfoLevel:
+		return logrus.InfoLevel
+	case l == zapcore.WarnLevel:
+		return logrus.WarnLevel
+	default:
+		return logrus.ErrorLevel
+	}
 }
@@ -0,0 +1,173 @@
+// Package metrics exposes the procedures, latencies and user plane counters
+// of the tester to Prometheus.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+const namespace = "ella_core_tester"
+
+// Procedures whose latency is observed, from the message that starts them
+// to the one that completes them as seen by the tester.
+const (
+	ProcedureNGSetup                 = "ng_setup"                  // NG Setup Request to NG Setup Response
+	ProcedureAuthentication          = "authentication"            // Registration Request to Security Mode Command
+	ProcedureSecurityMode            = "security_mode"             // Security Mode Complete to Registration Accept
+	ProcedureRegistration            = "registration"              // Registration Request to Registration Accept
+	ProcedurePDUSessionEstablishment = "pdu_session_establishment" // PDU Session Establishment Request to Accept
+	ProcedurePDUSessionRelease       = "pdu_session_release"       // PDU Session Release Request to Command
+	ProcedureDeregistration          = "deregistration"            // Deregistration Request to UE Context Release Command
+	ProcedureServiceRequest          = "service_request"           // Service Request to Service Accept
+)
+
+// Directions of the GTP-U counters.
+const (
+	Uplink   = "uplink"
+	Downlink = "downlink"
+)
+
+// CauseTimeout labels the registrations that got no answer from the core.
+const CauseTimeout = "Timeout"
+
+var registry = prometheus.NewRegistry()
+
+var (
+	RegistrationAttempts = register(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registration_attempts_total",
+		Help:      "Registration Requests sent.",
+	}))
+	RegistrationSuccesses = register(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registration_successes_total",
+		Help:      "Registrations accepted by the core.",
+	}))
+	RegistrationFailures = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registration_failures_total",
+		Help:      "Registrations rejected by the core or left unanswered, by 5GMM cause.",
+	}, []string{"cause"}))
+	ProcedureDuration = register(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "procedure_duration_seconds",
+		Help:      "Time from the message starting a procedure to the message completing it.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"procedure"}))
+	ActiveUEs = register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_ues",
+		Help:      "UEs registered with the core.",
+	}))
+	Tunnels = register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tunnels",
+		Help:      "GTP-U tunnels open on the gNodeB.",
+	}))
+	gtpuPackets = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gtpu_packets_total",
+		Help:      "User plane packets carried over GTP-U, by direction.",
+	}, []string{"direction"}))
+	gtpuBytes = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gtpu_bytes_total",
+		Help:      "Bytes of the user plane packets carried over GTP-U, without the encapsulation, by direction.",
+	}, []string{"direction"}))
+	gtpuErrors = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gtpu_errors_total",
+		Help:      "User plane packets that could not be sent over GTP-U.",
+	}, []string{"direction"}))
+)
+
+// The GTP-U series are resolved once, since they are counted per packet.
+// Resolving them also exposes them from the first scrape, so that rate()
+// sees a zero rather than a gap.
+var (
+	uplinkPackets   = gtpuPackets.WithLabelValues(Uplink)
+	uplinkBytes     = gtpuBytes.WithLabelValues(Uplink)
+	uplinkErrors    = gtpuErrors.WithLabelValues(Uplink)
+	downlinkPackets = gtpuPackets.WithLabelValues(Downlink)
+	downlinkBytes   = gtpuBytes.WithLabelValues(Downlink)
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+func register[T prometheus.Collector](c T) T {
+	registry.MustRegister(c)
+	return c
+}
+
+// CountUplink counts a user plane packet of n bytes sent to the UPF.
+func CountUplink(n int) {
+	uplinkPackets.Inc()
+	uplinkBytes.Add(float64(n))
+}
+
+// CountUplinkError counts a user plane packet that could not be sent.
+func CountUplinkError() {
+	uplinkErrors.Inc()
+}
+
+// CountDownlink counts a user plane packet of n bytes received from the UPF.
+func CountDownlink(n int) {
+	downlinkPackets.Inc()
+	downlinkBytes.Add(float64(n))
+}
+
+// ObserveProcedure records the duration of a procedure started at start.
+func ObserveProcedure(procedure string, start time.Time) {
+	ProcedureDuration.WithLabelValues(procedure).Observe(time.Since(start).Seconds())
+}
+
+// Serve exposes the metrics on /metrics at address until ctx is cancelled.
+func Serve(ctx context.Context, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("could not listen for metrics scrapes on %s: %v", address, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		if err := server.Close(); err != nil {
+			logger.Logger.Error("could not close metrics server", zap.Error(err))
+		}
+	}()
+
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Logger.Error("metrics server stopped", zap.Error(err))
+		}
+	}()
+
+	logger.Logger.Info("Serving Prometheus metrics", zap.String("address", "http://"+listener.Addr().String()+"/metrics"))
+
+	return nil
+}
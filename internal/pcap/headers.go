@@ -0,0 +1,130 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"net/netip"
+)
+
+const (
+	protocolUDP  = 17
+	protocolSCTP = 132
+
+	ipv4HeaderLen     = 20
+	ipv6HeaderLen     = 40
+	udpHeaderLen      = 8
+	sctpHeaderLen     = 12
+	sctpDataHeaderLen = 16
+	defaultTTL        = 64
+
+	sctpChunkData      = 0
+	sctpDataFlagsWhole = 0x03 // beginning and ending fragment: an unfragmented message
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// buildIP wraps a transport segment in an IPv4 or IPv6 header. Mixed
+// families cannot happen on a socket, so the family of src is used.
+func buildIP(src, dst netip.Addr, protocol uint8, segment []byte) []byte {
+	if src.Is4() {
+		b := make([]byte, ipv4HeaderLen+len(segment))
+		b[0] = 0x45 // version 4, IHL 5
+		binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+		b[8] = defaultTTL
+		b[9] = protocol
+		copy(b[12:16], src.AsSlice())
+		copy(b[16:20], dst.Unmap().AsSlice())
+		binary.BigEndian.PutUint16(b[10:12], ^checksum(b[:ipv4HeaderLen], 0))
+		copy(b[ipv4HeaderLen:], segment)
+
+		return b
+	}
+
+	b := make([]byte, ipv6HeaderLen+len(segment))
+	b[0] = 0x60 // version 6
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(segment)))
+	b[6] = protocol
+	b[7] = defaultTTL
+	copy(b[8:24], src.AsSlice())
+	copy(b[24:40], dst.AsSlice())
+	copy(b[ipv6HeaderLen:], segment)
+
+	return b
+}
+
+// buildUDP builds a UDP datagram with its checksum over the pseudo-header.
+func buildUDP(src, dst netip.AddrPort, payload []byte) []byte {
+	b := make([]byte, udpHeaderLen+len(payload))
+	binary.BigEndian.PutUint16(b[0:2], src.Port())
+	binary.BigEndian.PutUint16(b[2:4], dst.Port())
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(b)))
+	copy(b[udpHeaderLen:], payload)
+
+	sum := pseudoHeaderSum(src.Addr(), dst.Addr(), protocolUDP, len(b))
+
+	c := ^checksum(b, sum)
+	if c == 0 {
+		c = 0xFFFF
+	}
+
+	binary.BigEndian.PutUint16(b[6:8], c)
+
+	return b
+}
+
+// buildSCTP builds an SCTP packet holding a single unfragmented DATA chunk
+// (RFC 9260 section 3.3.1). The verification tag is left at zero since the
+// real one is not exposed by the socket.
+func buildSCTP(srcPort, dstPort uint16, tsn uint32, stream uint16, ppid uint32, payload []byte) []byte {
+	chunkLen := sctpDataHeaderLen + len(payload)
+	padded := (chunkLen + 3) &^ 3
+
+	b := make([]byte, sctpHeaderLen+padded)
+	binary.BigEndian.PutUint16(b[0:2], srcPort)
+	binary.BigEndian.PutUint16(b[2:4], dstPort)
+
+	chunk := b[sctpHeaderLen:]
+	chunk[0] = sctpChunkData
+	chunk[1] = sctpDataFlagsWhole
+	binary.BigEndian.PutUint16(chunk[2:4], uint16(chunkLen))
+	binary.BigEndian.PutUint32(chunk[4:8], tsn)
+	binary.BigEndian.PutUint16(chunk[8:10], stream)
+	// The socket reports the PPID in network byte order, as stored in memory.
+	binary.NativeEndian.PutUint32(chunk[12:16], ppid)
+	copy(chunk[sctpDataHeaderLen:], payload)
+
+	// The CRC32c is stored least significant byte first (RFC 9260 appendix A).
+	binary.LittleEndian.PutUint32(b[8:12], crc32.Checksum(b, castagnoli))
+
+	return b
+}
+
+func pseudoHeaderSum(src, dst netip.Addr, protocol uint8, length int) uint32 {
+	var sum uint32
+
+	for _, addr := range [][]byte{src.AsSlice(), dst.Unmap().AsSlice()} {
+		for i := 0; i+1 < len(addr); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(addr[i:]))
+		}
+	}
+
+	return sum + uint32(protocol) + uint32(length)
+}
+
+// checksum computes the ones' complement sum of b (RFC 1071), starting from
+// sum, without the final complement.
+func checksum(b []byte, sum uint32) uint16 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+
+	return uint16(sum)
+}
@@ -0,0 +1,240 @@
+// Package pcap records the N2 and N3 traffic of the tester in a pcapng file.
+// The tester only sees the payloads of its sockets, so every packet is
+// written with synthetic IP, SCTP or UDP headers that carry the real
+// addresses and ports, letting Wireshark dissect NGAP and GTP-U as if the
+// capture had been taken on the wire. The plaintext of protected NAS
+// messages, which only the UE can recover, is added next to them.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+// pcapng block types and constants, draft-ietf-opsawg-pcapng section 4.
+const (
+	blockSectionHeader   = 0x0A0D0D0A
+	blockInterfaceDesc   = 0x00000001
+	blockEnhancedPacket  = 0x00000006
+	byteOrderMagic       = 0x1A2B3C4D
+	linkTypeRaw          = 101 // raw IPv4 or IPv6 packets
+	linkTypeUpperPDU     = 252 // Wireshark exported PDUs
+	optionEnd            = 0
+	optionInterfaceName  = 2
+	optionPacketFlags    = 2
+	sectionLengthUnknown = 0xFFFFFFFFFFFFFFFF
+
+	flagInbound  = 1
+	flagOutbound = 2
+)
+
+// Interfaces of the capture, in the order of their description blocks.
+const (
+	interfaceIP  = 0
+	interfaceNAS = 1
+)
+
+// Exported PDU tags, from Wireshark's epan/exported_pdu.h.
+const (
+	exportedPDUTagEnd           = 0
+	exportedPDUTagDissectorName = 12
+	nasDissector                = "nas-5gs"
+)
+
+// Writer appends packets to a pcapng file. It is safe for concurrent use.
+type Writer struct {
+	mu   sync.Mutex
+	f    *os.File
+	w    *bufio.Writer
+	tsns map[netip.AddrPort]uint32 // next SCTP TSN, per sending endpoint
+}
+
+// Create creates the capture file and writes its section header, a raw IP
+// interface for the N2 and N3 packets and an exported PDU interface for the
+// plaintext of protected NAS messages.
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not create capture file: %v", err)
+	}
+
+	w := &Writer{
+		f:    f,
+		w:    bufio.NewWriter(f),
+		tsns: make(map[netip.AddrPort]uint32),
+	}
+
+	_ = w.writeBlock(blockSectionHeader, func(b []byte) []byte {
+		b = binary.LittleEndian.AppendUint32(b, byteOrderMagic)
+		b = binary.LittleEndian.AppendUint16(b, 1) // major version
+		b = binary.LittleEndian.AppendUint16(b, 0) // minor version
+
+		return binary.LittleEndian.AppendUint64(b, sectionLengthUnknown)
+	})
+
+	_ = w.writeBlock(blockInterfaceDesc, func(b []byte) []byte {
+		b = binary.LittleEndian.AppendUint16(b, linkTypeRaw)
+		b = binary.LittleEndian.AppendUint16(b, 0) // reserved
+		b = binary.LittleEndian.AppendUint32(b, 0) // no snapshot length limit
+		b = appendOption(b, optionInterfaceName, []byte("ella-core-tester"))
+
+		return appendOption(b, optionEnd, nil)
+	})
+
+	_ = w.writeBlock(blockInterfaceDesc, func(b []byte) []byte {
+		b = binary.LittleEndian.AppendUint16(b, linkTypeUpperPDU)
+		b = binary.LittleEndian.AppendUint16(b, 0) // reserved
+		b = binary.LittleEndian.AppendUint32(b, 0) // no snapshot length limit
+		b = appendOption(b, optionInterfaceName, []byte("plaintext NAS"))
+
+		return appendOption(b, optionEnd, nil)
+	})
+
+	err = w.w.Flush()
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("could not write capture file header: %v", err)
+	}
+
+	return w, nil
+}
+
+// WriteSCTP records an SCTP DATA chunk, such as an NGAP message, sent from
+// src to dst. ppid is in network byte order, as carried in the chunk. N2
+// messages are rare and valuable when debugging, so they are flushed to the
+// file at once.
+func (w *Writer) WriteSCTP(src, dst netip.AddrPort, stream uint16, ppid uint32, payload []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	tsn := w.tsns[src]
+	w.tsns[src] = tsn + 1
+
+	segment := buildSCTP(src.Port(), dst.Port(), tsn, stream, ppid, payload)
+
+	err := w.writePacket(interfaceIP, 0, buildIP(src.Addr(), dst.Addr(), protocolSCTP, segment))
+	if err != nil {
+		return err
+	}
+
+	return w.w.Flush()
+}
+
+// WriteUDP records a UDP datagram, such as a GTP-U packet, sent from src to
+// dst. Datagrams are buffered until the next N2 message or Close.
+func (w *Writer) WriteUDP(src, dst netip.AddrPort, payload []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	segment := buildUDP(src, dst, payload)
+
+	return w.writePacket(interfaceIP, 0, buildIP(src.Addr(), dst.Addr(), protocolUDP, segment))
+}
+
+// WriteNAS records the plaintext of a NAS message that travelled protected
+// on N2, as an exported PDU handed to Wireshark's 5GS NAS dissector. The
+// packet flags tell the uplink messages, outbound from the UE, from the
+// downlink ones. It is flushed at once, like the NGAP message carrying it.
+func (w *Writer) WriteNAS(uplink bool, plain []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var b []byte
+
+	b = binary.BigEndian.AppendUint16(b, exportedPDUTagDissectorName)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(pad([]byte(nasDissector)))))
+	b = append(b, pad([]byte(nasDissector))...)
+	b = binary.BigEndian.AppendUint16(b, exportedPDUTagEnd)
+	b = binary.BigEndian.AppendUint16(b, 0)
+	b = append(b, plain...)
+
+	flags := uint32(flagInbound)
+	if uplink {
+		flags = flagOutbound
+	}
+
+	err := w.writePacket(interfaceNAS, flags, b)
+	if err != nil {
+		return err
+	}
+
+	return w.w.Flush()
+}
+
+// Close flushes the buffered packets and closes the file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.w.Flush()
+	if err != nil {
+		_ = w.f.Close()
+		return fmt.Errorf("could not flush capture file: %v", err)
+	}
+
+	return w.f.Close()
+}
+
+// writePacket writes an Enhanced Packet Block, with the direction flags
+// option when flags is not zero.
+func (w *Writer) writePacket(iface uint32, flags uint32, packet []byte) error {
+	ts := uint64(time.Now().UnixMicro()) // default resolution of 10^-6 s
+
+	return w.writeBlock(blockEnhancedPacket, func(b []byte) []byte {
+		b = binary.LittleEndian.AppendUint32(b, iface)
+		b = binary.LittleEndian.AppendUint32(b, uint32(ts>>32))
+		b = binary.LittleEndian.AppendUint32(b, uint32(ts))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(packet))) // captured length
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(packet))) // original length
+		b = append(b, pad(packet)...)
+
+		if flags == 0 {
+			return b
+		}
+
+		b = appendOption(b, optionPacketFlags, binary.LittleEndian.AppendUint32(nil, flags))
+
+		return appendOption(b, optionEnd, nil)
+	})
+}
+
+// writeBlock writes a block whose body is produced by fill, framing it with
+// the block type and both copies of the total length. Errors of the header
+// blocks surface in the Flush that follows them.
+func (w *Writer) writeBlock(blockType uint32, fill func(b []byte) []byte) error {
+	b := make([]byte, 8, 64)
+	b = fill(b)
+	b = binary.LittleEndian.AppendUint32(b, 0)
+
+	binary.LittleEndian.PutUint32(b[0:4], blockType)
+	binary.LittleEndian.PutUint32(b[4:8], uint32(len(b)))
+	binary.LittleEndian.PutUint32(b[len(b)-4:], uint32(len(b)))
+
+	_, err := w.w.Write(b)
+	if err != nil {
+		return fmt.Errorf("could not write to capture file: %v", err)
+	}
+
+	return nil
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+
+	return append(b, pad(value)...)
+}
+
+// pad returns b followed by zeros up to a multiple of 4 bytes.
+func pad(b []byte) []byte {
+	if len(b)%4 == 0 {
+		return b
+	}
+
+	return append(b[:len(b):len(b)], make([]byte, 4-len(b)%4)...)
+}
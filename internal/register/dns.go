@@ -0,0 +1,142 @@
+package register
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// writeResolvConf writes the DNS servers assigned by the network to a
+// resolv.conf dedicated to the tunnel, so that resolvers pointed at it (for
+// example through a bind mount or `ip netns exec`) use the core's servers.
+// The returned function puts back the file that was there before, such as
+// the host's /etc/resolv.conf, or removes the file and the directory this
+// call created.
+func writeResolvConf(path string, servers []netip.Addr) (func() error, error) {
+	var b strings.Builder
+
+	b.WriteString("# Generated by Ella Core Tester from the PDU Session Establishment Accept\n")
+
+	for _, server := range servers {
+		fmt.Fprintf(&b, "nameserver %s\n", server)
+	}
+
+	dir := filepath.Dir(path)
+
+	_, err := os.Stat(dir)
+	createdDir := errors.Is(err, fs.ErrNotExist)
+
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create directory for %s: %v", path, err)
+	}
+
+	// removeDir undoes MkdirAll when the resolv.conf cannot be written, so
+	// that a failed attempt leaves no trace under /etc/netns.
+	removeDir := func() {
+		if createdDir {
+			_ = os.Remove(dir)
+		}
+	}
+
+	restore, err := backUp(path)
+	if err != nil {
+		removeDir()
+		return nil, err
+	}
+
+	err = os.WriteFile(path, []byte(b.String()), 0o644)
+	if err != nil {
+		// A write that failed part way has truncated the original.
+		_ = restore()
+
+		removeDir()
+
+		return nil, fmt.Errorf("could not write %s: %v", path, err)
+	}
+
+	return func() error {
+		err := restore()
+		if err != nil {
+			return err
+		}
+
+		if createdDir {
+			err = os.Remove(dir)
+			if err != nil {
+				return fmt.Errorf("could not remove %s: %v", dir, err)
+			}
+		}
+
+		return nil
+	}, nil
+}
+
+// backUp keeps the content and mode of the file at path, and returns a
+// function that writes them back, or removes the file if there was none.
+func backUp(path string) (func() error, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return func() error {
+			err := os.Remove(path)
+			if err != nil {
+				return fmt.Errorf("could not remove %s: %v", path, err)
+			}
+
+			return nil
+		}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", path, err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+
+	original, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not back up %s: %v", path, err)
+	}
+
+	return func() error {
+		err := os.WriteFile(path, original, info.Mode().Perm())
+		if err != nil {
+			return fmt.Errorf("could not restore %s: %v", path, err)
+		}
+
+		return nil
+	}, nil
+}
+
+// configureResolvedLink registers the DNS servers as the per-link DNS
+// configuration of the tunnel interface in systemd-resolved.
+func configureResolvedLink(ctx context.Context, ifName string, servers []netip.Addr) error {
+	args := []string{"dns", ifName}
+	for _, server := range servers {
+		args = append(args, server.String())
+	}
+
+	out, err := exec.CommandContext(ctx, "resolvectl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("resolvectl %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+func revertResolvedLink(ctx context.Context, ifName string) error {
+	out, err := exec.CommandContext(ctx, "resolvectl", "revert", ifName).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("resolvectl revert %s failed: %v: %s", ifName, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/ellanetworks/core-tester/internal/results"
 	"github.com/ellanetworks/core-tester/internal/ue"
 	"github.com/free5gc/nas"
 	"github.com/free5gc/nas/nasMessage"
@@ -11,37 +12,50 @@ import (
 
 const timeoutPerMessage = 8 * time.Second
 
-type initialRegistrationOpts struct {
+type InitialRegistrationOpts struct {
 	RANUENGAPID  int64
 	PDUSessionID uint8
 	UE           *ue.UE
+	Steps        *results.Suite
 }
 
-func initialRegistration(opts *initialRegistrationOpts) (*nas.Message, error) {
+// InitialRegistration registers the UE and waits for the PDU session it
+// requests once registered and for the Configuration Update Command that
+// follows.
+func InitialRegistration(opts *InitialRegistrationOpts) (*nas.Message, error) {
+	start := time.Now()
+
 	err := opts.UE.SendRegistrationRequest(opts.RANUENGAPID, nasMessage.RegistrationType5GSInitialRegistration)
 	if err != nil {
+		opts.Steps.Record("Registration", start, err)
 		return nil, fmt.Errorf("could not build Registration Request NAS PDU: %v", err)
 	}
 
 	_, err = opts.UE.WaitForNASGMMMessage(nas.MsgTypeRegistrationAccept, timeoutPerMessage)
+	opts.Steps.Record("Registration", start, err)
+
 	if err != nil {
+		opts.UE.AbandonRegistration()
 		return nil, fmt.Errorf("did not receive Registration Accept after initial registration: %v", err)
 	}
 
-	msg, err := opts.UE.WaitForNASGSMMessage(nas.MsgTypePDUSessionEstablishmentAccept, timeoutPerMessage)
-	if err != nil {
-		return nil, fmt.Errorf("timeout waiting for PDU session establishment accept: %v", err)
-	}
+	start = time.Now()
+
+	msg, err := waitForPDUSession(opts)
+	opts.Steps.Record("PDU Session Establishment", start, err)
 
-	_, err = opts.UE.WaitForPDUSession(opts.PDUSessionID, timeoutPerMessage)
 	if err != nil {
-		return nil, fmt.Errorf("timeout waiting for PDU session: %v", err)
+		return nil, err
 	}
 
 	// Sleep to ensure gNodeB sends the PDU Session Resource Setup Response before proceeding
 	time.Sleep(50 * time.Millisecond)
 
+	start = time.Now()
+
 	_, err = opts.UE.WaitForNASGMMMessage(nas.MsgTypeConfigurationUpdateCommand, timeoutPerMessage)
+	opts.Steps.Record("Configuration Update", start, err)
+
 	if err != nil {
 		return nil, fmt.Errorf("did not receive Configuration Update Command after registration: %v", err)
 	}
@@ -49,13 +63,36 @@ func initialRegistration(opts *initialRegistrationOpts) (*nas.Message, error) {
 	return msg, nil
 }
 
-type deregistrationOpts struct {
+// waitForPDUSession waits for the PDU session the UE requested once
+// registered, and checks the SSC mode the network selected.
+func waitForPDUSession(opts *InitialRegistrationOpts) (*nas.Message, error) {
+	msg, err := opts.UE.WaitForNASGSMMessage(nas.MsgTypePDUSessionEstablishmentAccept, timeoutPerMessage)
+	if err != nil {
+		return nil, fmt.Errorf("timeout waiting for PDU session establishment accept: %v", err)
+	}
+
+	session, err := opts.UE.WaitForPDUSession(opts.PDUSessionID, timeoutPerMessage)
+	if err != nil {
+		return nil, fmt.Errorf("timeout waiting for PDU session: %v", err)
+	}
+
+	err = opts.UE.CheckSSCMode(session)
+	if err != nil {
+		return nil, fmt.Errorf("SSC mode validation failed: %v", err)
+	}
+
+	return msg, nil
+}
+
+type DeregistrationOpts struct {
 	UE          *ue.UE
 	AMFUENGAPID int64
 	RANUENGAPID int64
 }
 
-func deregistration(opts *deregistrationOpts) error {
+// Deregistration deregisters the UE and waits for the release of its
+// connection.
+func Deregistration(opts *DeregistrationOpts) error {
 	err := opts.UE.SendDeregistrationRequest(opts.AMFUENGAPID, opts.RANUENGAPID)
 	if err != nil {
 		return fmt.Errorf("could not build Deregistration Request NAS PDU: %v", err)
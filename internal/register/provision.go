@@ -0,0 +1,169 @@
+package register
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/ellacore"
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/results"
+	"go.uber.org/zap"
+)
+
+// Settings of the policy created for the profile of the subscriber when
+// Ella Core does not have it yet.
+const (
+	policyBitrate = "100 Mbps"
+	policy5QI     = 9
+	policyARP     = 1
+)
+
+// ProvisionConfig gives access to the Ella Core API, through which the run
+// creates the policy and the subscriber it needs and deletes them at the
+// end.
+type ProvisionConfig struct {
+	APIAddress string
+	// Token is an API token. Without it, the tester logs in with Email and
+	// Password.
+	Token    string
+	Email    string
+	Password string
+	// Insecure skips the verification of the API's TLS certificate.
+	Insecure bool
+}
+
+// provision creates the policy of the profile, unless it already exists,
+// and the subscriber. It returns the function that deletes what it created.
+func provision(ctx context.Context, cfg Config, steps *results.Suite) (func(), error) {
+	start := time.Now()
+
+	client, err := ellacore.NewClient(&ellacore.ClientOpts{
+		Address:    cfg.Provision.APIAddress,
+		Token:      cfg.Provision.Token,
+		Email:      cfg.Provision.Email,
+		Password:   cfg.Provision.Password,
+		Insecure:   cfg.Provision.Insecure,
+		Retries:    3,
+		RetryDelay: 500 * time.Millisecond,
+	})
+	if err != nil {
+		steps.Record("Provision", start, err)
+		return nil, fmt.Errorf("could not create Ella Core API client: %v", err)
+	}
+
+	var created []func(context.Context) error
+
+	deprovision := func() {
+		start := time.Now()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		var errs []error
+
+		for i := len(created) - 1; i >= 0; i-- {
+			errs = append(errs, created[i](ctx))
+		}
+
+		err := errors.Join(errs...)
+		steps.Record("Deprovision", start, err)
+
+		if err != nil {
+			logger.Logger.Error("could not delete provisioned resources", zap.Error(err))
+			return
+		}
+
+		logger.Logger.Info("deleted provisioned resources")
+	}
+
+	err = provisionPolicy(ctx, client, cfg, &created)
+	if err == nil {
+		err = provisionSubscriber(ctx, client, cfg, &created)
+	}
+
+	steps.Record("Provision", start, err)
+
+	if err != nil {
+		deprovision()
+		return nil, err
+	}
+
+	return deprovision, nil
+}
+
+func provisionPolicy(ctx context.Context, client *ellacore.Client, cfg Config, created *[]func(context.Context) error) error {
+	_, err := client.GetPolicy(ctx, cfg.ProfileName)
+	if err == nil {
+		logger.Logger.Info("using existing policy", zap.String("name", cfg.ProfileName))
+		return nil
+	}
+
+	if !errors.Is(err, ellacore.ErrNotFound) {
+		return err
+	}
+
+	err = client.CreatePolicy(ctx, &ellacore.Policy{
+		Name:            cfg.ProfileName,
+		BitrateUplink:   policyBitrate,
+		BitrateDownlink: policyBitrate,
+		Var5qi:          policy5QI,
+		Arp:             policyARP,
+		DataNetworkName: cfg.DNN,
+	})
+	if err != nil && !adopt(ctx, func(ctx context.Context) error {
+		_, err := client.GetPolicy(ctx, cfg.ProfileName)
+		return err
+	}) {
+		return err
+	}
+
+	logger.Logger.Info("created policy", zap.String("name", cfg.ProfileName), zap.String("DNN", cfg.DNN))
+
+	*created = append(*created, func(ctx context.Context) error {
+		return client.DeletePolicy(ctx, cfg.ProfileName)
+	})
+
+	return nil
+}
+
+func provisionSubscriber(ctx context.Context, client *ellacore.Client, cfg Config, created *[]func(context.Context) error) error {
+	_, err := client.GetSubscriber(ctx, cfg.IMSI)
+	if err == nil {
+		return fmt.Errorf("could not provision subscriber %s: it already exists in Ella Core", cfg.IMSI)
+	}
+
+	if !errors.Is(err, ellacore.ErrNotFound) {
+		return err
+	}
+
+	err = client.CreateSubscriber(ctx, &ellacore.Subscriber{
+		IMSI:           cfg.IMSI,
+		Key:            cfg.Key,
+		OPC:            cfg.OPC,
+		SequenceNumber: cfg.SequenceNumber,
+		PolicyName:     cfg.ProfileName,
+	})
+	if err != nil && !adopt(ctx, func(ctx context.Context) error {
+		_, err := client.GetSubscriber(ctx, cfg.IMSI)
+		return err
+	}) {
+		return err
+	}
+
+	logger.Logger.Info("created subscriber", zap.String("IMSI", cfg.IMSI))
+
+	*created = append(*created, func(ctx context.Context) error {
+		return client.DeleteSubscriber(ctx, cfg.IMSI)
+	})
+
+	return nil
+}
+
+// adopt tells whether a resource that did not exist before a failed create
+// exists now: the request reached the core, for example before the client
+// timed out, and the resource is to be deleted with the others.
+func adopt(ctx context.Context, get func(context.Context) error) bool {
+	return get(ctx) == nil
+}
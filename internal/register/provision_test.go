@@ -0,0 +1,177 @@
+package register
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ellanetworks/core-tester/internal/ellacore"
+	"github.com/ellanetworks/core-tester/internal/ellacore/ellacoretest"
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/results"
+	"go.uber.org/zap"
+)
+
+const testIMSI = "001010100007487"
+
+func provisionConfig(core *ellacoretest.Server) Config {
+	logger.Logger = zap.NewNop()
+
+	return Config{
+		IMSI:           testIMSI,
+		Key:            "5122250214c33e723a5dd523fc145fc0",
+		OPC:            "981d464c7c52eb6e5036234984ad0bcf",
+		SequenceNumber: "16f3b3f70fc2",
+		ProfileName:    "default",
+		DNN:            "internet",
+		Provision: &ProvisionConfig{
+			APIAddress: core.URL,
+			Token:      core.Token(),
+		},
+	}
+}
+
+// mutations returns the creates and deletes the core received.
+func mutations(core *ellacoretest.Server) []string {
+	var out []string
+
+	for _, r := range core.Requests() {
+		if !strings.HasPrefix(r, http.MethodGet) {
+			out = append(out, r)
+		}
+	}
+
+	return out
+}
+
+func TestProvisionCreatesAndDeletes(t *testing.T) {
+	core := ellacoretest.NewServer(t)
+	steps := results.NewSuite("test")
+
+	deprovision, err := provision(context.Background(), provisionConfig(core), steps)
+	if err != nil {
+		t.Fatalf("could not provision: %v", err)
+	}
+
+	policy, ok := core.Policy("default")
+	if !ok || policy.DataNetworkName != "internet" || policy.Var5qi != policy5QI {
+		t.Fatalf("unexpected policy %+v (found %t)", policy, ok)
+	}
+
+	subscriber, ok := core.Subscriber(testIMSI)
+	if !ok || subscriber.PolicyName != "default" || subscriber.SequenceNumber != "16f3b3f70fc2" {
+		t.Fatalf("unexpected subscriber %+v (found %t)", subscriber, ok)
+	}
+
+	deprovision()
+
+	want := []string{
+		"POST /api/v1/policies",
+		"POST /api/v1/subscribers",
+		"DELETE /api/v1/subscribers/" + testIMSI,
+		"DELETE /api/v1/policies/default",
+	}
+	if got := mutations(core); !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	for _, step := range steps.Steps() {
+		if !step.Passed {
+			t.Fatalf("step %s failed: %s", step.Name, step.Failure)
+		}
+	}
+}
+
+func TestProvisionKeepsExistingPolicy(t *testing.T) {
+	core := ellacoretest.NewServer(t)
+	core.AddPolicy(ellacore.Policy{Name: "default", DataNetworkName: "enterprise"})
+
+	deprovision, err := provision(context.Background(), provisionConfig(core), nil)
+	if err != nil {
+		t.Fatalf("could not provision: %v", err)
+	}
+
+	deprovision()
+
+	want := []string{
+		"POST /api/v1/subscribers",
+		"DELETE /api/v1/subscribers/" + testIMSI,
+	}
+	if got := mutations(core); !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	if policy, ok := core.Policy("default"); !ok || policy.DataNetworkName != "enterprise" {
+		t.Fatalf("expected the existing policy to be kept, got %+v (found %t)", policy, ok)
+	}
+}
+
+func TestProvisionExistingSubscriber(t *testing.T) {
+	core := ellacoretest.NewServer(t)
+	core.AddSubscriber(ellacore.Subscriber{IMSI: testIMSI})
+
+	_, err := provision(context.Background(), provisionConfig(core), nil)
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected an error about the existing subscriber, got %v", err)
+	}
+
+	// The policy created for the run is deleted again.
+	want := []string{
+		"POST /api/v1/policies",
+		"DELETE /api/v1/policies/default",
+	}
+	if got := mutations(core); !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	if _, ok := core.Subscriber(testIMSI); !ok {
+		t.Fatal("expected the existing subscriber to be kept")
+	}
+}
+
+func TestProvisionAdoptsResourceOfFailedCreate(t *testing.T) {
+	core := ellacoretest.NewServer(t)
+	core.Fail(http.MethodPost, "/api/v1/subscribers", ellacoretest.Fault{Status: http.StatusInternalServerError, Apply: true})
+
+	deprovision, err := provision(context.Background(), provisionConfig(core), nil)
+	if err != nil {
+		t.Fatalf("could not provision: %v", err)
+	}
+
+	deprovision()
+
+	if _, ok := core.Subscriber(testIMSI); ok {
+		t.Fatal("expected the subscriber created by the failed request to be deleted")
+	}
+
+	if _, ok := core.Policy("default"); ok {
+		t.Fatal("expected the policy to be deleted")
+	}
+}
+
+func TestProvisionFailedCreate(t *testing.T) {
+	core := ellacoretest.NewServer(t)
+	core.Fail(http.MethodPost, "/api/v1/subscribers", ellacoretest.Fault{Status: http.StatusInternalServerError})
+
+	_, err := provision(context.Background(), provisionConfig(core), nil)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if _, ok := core.Policy("default"); ok {
+		t.Fatal("expected the policy to be deleted after the failure")
+	}
+}
+
+func TestProvisionUnreachable(t *testing.T) {
+	core := ellacoretest.NewServer(t)
+	cfg := provisionConfig(core)
+	core.Close()
+
+	_, err := provision(context.Background(), cfg, nil)
+	if err == nil || !strings.Contains(err.Error(), "could not reach Ella Core") {
+		t.Fatalf("expected an unreachable error, got %v", err)
+	}
+}
@@ -1,16 +1,29 @@
 package register
 
 import (
+	"cmp"
 	"context"
 	"fmt"
+	"net"
+	"net/netip"
+	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/ellanetworks/core-tester/internal/gnb"
+	"github.com/ellanetworks/core-tester/internal/latency"
 	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/metrics"
+	"github.com/ellanetworks/core-tester/internal/pcap"
+	"github.com/ellanetworks/core-tester/internal/results"
+	"github.com/ellanetworks/core-tester/internal/telemetry"
+	"github.com/ellanetworks/core-tester/internal/trace"
+	"github.com/ellanetworks/core-tester/internal/traffic"
 	"github.com/ellanetworks/core-tester/internal/ue"
-	"github.com/ellanetworks/core-tester/internal/ue/sidf"
+	"github.com/ellanetworks/core-tester/internal/uestack"
 	"github.com/free5gc/nas/nasMessage"
 	"github.com/free5gc/ngap/ngapType"
 	"go.uber.org/zap"
@@ -19,6 +32,7 @@ import (
 const (
 	ranUENGAPID  = 1
 	gnbID        = "000008"
+	gnbName      = "Ella-Core-Tester"
 	pduSessionID = 1
 )
 
@@ -43,14 +57,104 @@ type Config struct {
 	DNN               string
 	GnbN2Address      string
 	GnbN3Address      string
+	GnbN3AddressV6    string // IPv6 N3 address of a dual-stack gNB, next to an IPv4 GnbN3Address
 	EllaCoreN2Address string
 	PDUSessionType    string
+	// GnbID and GnbName identify the gNodeB in NG Setup. They default to
+	// 000008 and Ella-Core-Tester.
+	GnbID   string
+	GnbName string
+	// Slices lists the slices the gNodeB supports, when it supports more than
+	// the SST and SD of the subscriber.
+	Slices []gnb.SliceOpt
+	// SecurityCapability lists the algorithms the UE supports. It defaults to
+	// NIA2, NEA0 and NEA2.
+	SecurityCapability *UeSecurityCapability
+	SSCMode            uint8
+	AllowedSSCModes    []uint8
+	// UnstructuredAddress is the local socket that carries the payload of an
+	// Unstructured session, as "udp:<host:port>" or "unix:<path>".
+	UnstructuredAddress string
+	// ResolvConfPath, when set, receives the DNS servers assigned by the core.
+	ResolvConfPath string
+	// SystemdResolved configures the assigned DNS servers on the tunnel link
+	// through resolvectl.
+	SystemdResolved bool
+	// IPv6SLAAC runs router solicitation on IPv6 and IPv4v6 sessions and
+	// configures the address, default route and MTU from the advertisement.
+	IPv6SLAAC bool
+	// Namespace places the UE's TUN device in its own network namespace,
+	// named ue-<IMSI>, with a default route through the tunnel.
+	Namespace bool
+	// GTPEchoInterval is the period of GTP-U Echo Requests sent to the UPF.
+	// Zero disables path probing.
+	GTPEchoInterval time.Duration
+	// ReleaseOnErrorIndication requests the release of the UE context when
+	// the UPF answers uplink traffic with a GTP-U Error Indication.
+	ReleaseOnErrorIndication bool
+	// TrafficDestination, when set, runs the built-in traffic generator
+	// against this reflector once the tunnel is up, then shuts down. It is
+	// "<host>:<port>" for UDP and "<host>" for ICMP.
+	TrafficDestination string
+	TrafficProtocol    string
+	TrafficRate        int
+	TrafficPayloadSize int
+	TrafficDuration    time.Duration
+	// DataPath sizes the gNodeB's N3 workers, TUN queues and I/O batches.
+	DataPath gnb.DataPathOpts
+	// Userspace runs the UE's IP stack in the tester instead of creating a
+	// TUN device, and serves a SOCKS5 proxy on SOCKS5Address whose
+	// connections originate from the UE address. It needs no privileges
+	// for the user plane.
+	Userspace     bool
+	SOCKS5Address string
+	// KernelGTP hands the user plane of IPv4 sessions to the Linux GTP
+	// module instead of encapsulating packets in the tester.
+	KernelGTP bool
+	// PcapPath, when set, receives a pcapng capture of the N2 and N3 traffic
+	// and the plaintext of the protected NAS messages. Packets the kernel
+	// GTP device forwards are not seen by the tester.
+	PcapPath string
+	// TracePath, when set, receives every NGAP and NAS message of the run as
+	// JSON lines, with the plaintext IEs of the NAS messages.
+	TracePath string
+	// MetricsAddress, when set, serves Prometheus metrics on /metrics at this
+	// address for the duration of the run.
+	MetricsAddress string
+	// LatencyReportPath, when set, receives the latency of each leg of the
+	// procedures as JSON. The percentile table is printed in any case.
+	LatencyReportPath string
+	// JUnitPath and ResultsPath, when set, receive the outcome and duration
+	// of each step of the run as JUnit XML and JSON.
+	JUnitPath   string
+	ResultsPath string
+	// OTelEndpoint and OTelFile, when set, receive the procedures of the UE
+	// as OpenTelemetry spans, through OTLP gRPC and as JSON lines.
+	OTelEndpoint string
+	OTelFile     string
+	// Provision, when set, creates the policy and the subscriber through the
+	// Ella Core API before the run and deletes them afterwards.
+	Provision *ProvisionConfig
 }
 
 // Run performs the full register-and-tunnel flow and blocks until ctx is
 // cancelled or an interrupt signal is received.
 func Run(ctx context.Context, cfg Config) error {
-	if err := validatePDUSessionType(cfg.PDUSessionType); err != nil {
+	if err := ValidatePDUSessionType(cfg.PDUSessionType); err != nil {
+		return err
+	}
+
+	if err := validateSSCModes(cfg.SSCMode, cfg.AllowedSSCModes); err != nil {
+		return err
+	}
+
+	unstructuredNetwork, unstructuredAddress, err := parseUnstructuredAddress(cfg.PDUSessionType, cfg.UnstructuredAddress)
+	if err != nil {
+		return err
+	}
+
+	trafficDestination, err := parseTrafficDestination(cfg.PDUSessionType, cfg.TrafficProtocol, cfg.TrafficDestination)
+	if err != nil {
 		return err
 	}
 
@@ -58,19 +162,153 @@ func Run(ctx context.Context, cfg Config) error {
 		return fmt.Errorf("invalid IMSI %q: must be at least 6 digits", cfg.IMSI)
 	}
 
+	if err := validateUserspace(cfg); err != nil {
+		return err
+	}
+
+	if err := validateKernelGTP(cfg); err != nil {
+		return err
+	}
+
+	var kernelGTPDevice string
+	if cfg.KernelGTP {
+		kernelGTPDevice = gtpInterfaceName
+	}
+
+	if cfg.MetricsAddress != "" {
+		err := metrics.Serve(ctx, cfg.MetricsAddress)
+		if err != nil {
+			return err
+		}
+	}
+
+	stopTelemetry, err := telemetry.Start(ctx, telemetry.Config{
+		Endpoint: cfg.OTelEndpoint,
+		File:     cfg.OTelFile,
+	})
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err := stopTelemetry(sctx)
+		if err != nil {
+			logger.Logger.Error("could not export spans", zap.Error(err))
+		}
+	}()
+
+	steps := results.NewSuite("register")
+
+	defer func() {
+		err := steps.WriteFiles(cfg.JUnitPath, cfg.ResultsPath)
+		if err != nil {
+			logger.Logger.Error("could not write test results", zap.Error(err))
+		}
+	}()
+
+	latencies := latency.NewRecorder()
+
+	defer func() {
+		err := writeLatencyReport(latencies.Report(), cfg.LatencyReportPath)
+		if err != nil {
+			logger.Logger.Error("could not write latency report", zap.Error(err))
+		}
+	}()
+
+	if cfg.Provision != nil {
+		deprovision, err := provision(ctx, cfg, steps)
+		if err != nil {
+			return err
+		}
+
+		defer deprovision()
+	}
+
+	var recorder *trace.Recorder
+
+	if cfg.TracePath != "" {
+		traceFile, err := os.Create(cfg.TracePath)
+		if err != nil {
+			return fmt.Errorf("could not create trace file: %v", err)
+		}
+
+		recorder = trace.NewRecorder(traceFile)
+
+		defer func() {
+			if err := recorder.Err(); err != nil {
+				logger.Logger.Error("trace is incomplete", zap.Error(err))
+			}
+
+			if err := traceFile.Close(); err != nil {
+				logger.Logger.Error("could not close trace file", zap.Error(err))
+			}
+
+			logger.Logger.Info("wrote message trace", zap.String("path", cfg.TracePath))
+		}()
+	}
+
+	var capture *pcap.Writer
+
+	if cfg.PcapPath != "" {
+		capture, err = pcap.Create(cfg.PcapPath)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			if err := capture.Close(); err != nil {
+				logger.Logger.Error("could not close packet capture", zap.Error(err))
+			}
+
+			logger.Logger.Info("wrote packet capture", zap.String("path", cfg.PcapPath))
+		}()
+	}
+
+	var namespace string
+
+	if cfg.Namespace {
+		if cfg.SystemdResolved {
+			return fmt.Errorf("systemd-resolved DNS configuration is not available for UEs in their own network namespace")
+		}
+
+		namespace = "ue-" + cfg.IMSI
+	}
+
+	resolvConfPath := cfg.ResolvConfPath
+	if resolvConfPath == "" && namespace != "" {
+		// The file `ip netns exec` bind-mounts over /etc/resolv.conf.
+		resolvConfPath = filepath.Join("/etc/netns", namespace, "resolv.conf")
+	}
+
+	start := time.Now()
+
 	gNodeB, err := gnb.Start(&gnb.StartOpts{
-		GnbID:         gnbID,
-		MCC:           cfg.MCC,
-		MNC:           cfg.MNC,
-		SST:           cfg.SST,
-		SD:            cfg.SD,
-		DNN:           cfg.DNN,
-		TAC:           cfg.TAC,
-		Name:          "Ella-Core-Tester",
-		CoreN2Address: cfg.EllaCoreN2Address,
-		GnbN2Address:  cfg.GnbN2Address,
-		GnbN3Address:  cfg.GnbN3Address,
+		GnbID:          cmp.Or(cfg.GnbID, gnbID),
+		MCC:            cfg.MCC,
+		MNC:            cfg.MNC,
+		SST:            cfg.SST,
+		SD:             cfg.SD,
+		Slices:         cfg.Slices,
+		DNN:            cfg.DNN,
+		TAC:            cfg.TAC,
+		Name:           cmp.Or(cfg.GnbName, gnbName),
+		CoreN2Address:  cfg.EllaCoreN2Address,
+		GnbN2Address:   cfg.GnbN2Address,
+		GnbN3Address:   cfg.GnbN3Address,
+		GnbN3AddressV6: cfg.GnbN3AddressV6,
+
+		ReleaseOnErrorIndication: cfg.ReleaseOnErrorIndication,
+		DataPath:                 cfg.DataPath,
+		KernelGTPDevice:          kernelGTPDevice,
+		Capture:                  capture,
+		Trace:                    recorder,
+		Latency:                  latencies,
 	})
+	steps.Record("Start gNodeB", start, err)
+
 	if err != nil {
 		return fmt.Errorf("error starting gNB: %v", err)
 	}
@@ -82,45 +320,46 @@ func Run(ctx context.Context, cfg Config) error {
 		logger.Logger.Info("closed gNodeB")
 	}()
 
+	// The NG Setup step does not include the time spent starting the gNodeB.
+	start = time.Now()
+
 	_, err = gNodeB.WaitForMessage(ngapType.NGAPPDUPresentSuccessfulOutcome, ngapType.SuccessfulOutcomePresentNGSetupResponse, 200*time.Millisecond)
+	steps.Record("NG Setup", start, err)
+
 	if err != nil {
 		return fmt.Errorf("did not receive SCTP frame: %v", err)
 	}
 
 	logger.Logger.Info("received NGSetupResponse")
 
-	pduSessionType := convertPDUSessionType(cfg.PDUSessionType)
-
-	newUE, err := ue.NewUE(&ue.UEOpts{
-		GnodeB:         gNodeB,
-		PDUSessionID:   1,
-		PDUSessionType: pduSessionType,
-		Msin:           cfg.IMSI[5:],
-		K:              cfg.Key,
-		OpC:            cfg.OPC,
-		Amf:            "80000000000000000000000000000000",
-		Sqn:            cfg.SequenceNumber,
-		Mcc:            cfg.MCC,
-		Mnc:            cfg.MNC,
-		HomeNetworkPublicKey: sidf.HomeNetworkPublicKey{
-			ProtectionScheme: sidf.NullScheme,
-			PublicKeyID:      "0",
-		},
-		RoutingIndicator: "0000",
-		DNN:              cfg.DNN,
-		Sst:              cfg.SST,
-		Sd:               cfg.SD,
-		IMEISV:           "3569380356438091",
-		UeSecurityCapability: getUESecurityCapability(&UeSecurityCapability{
-			Integrity: IntegrityAlgorithms{
-				Nia2: true,
-			},
-			Ciphering: CipheringAlgorithms{
-				Nea0: true,
-				Nea2: true,
-			},
-		}),
+	// A nil *pcap.Writer must not become a non-nil interface.
+	var nasCapture ue.NASCapture
+	if capture != nil {
+		nasCapture = capture
+	}
+
+	ueOpts := UEOpts(Subscriber{
+		IMSI:               cfg.IMSI,
+		Key:                cfg.Key,
+		OPC:                cfg.OPC,
+		SQN:                cfg.SequenceNumber,
+		MCC:                cfg.MCC,
+		MNC:                cfg.MNC,
+		DNN:                cfg.DNN,
+		SST:                cfg.SST,
+		SD:                 cfg.SD,
+		PDUSessionType:     cfg.PDUSessionType,
+		SecurityCapability: cfg.SecurityCapability,
 	})
+	ueOpts.GnodeB = gNodeB
+	ueOpts.PDUSessionID = 1
+	ueOpts.SSCMode = cfg.SSCMode
+	ueOpts.AllowedSSCModes = cfg.AllowedSSCModes
+	ueOpts.NASCapture = nasCapture
+	ueOpts.Trace = recorder
+	ueOpts.Latency = latencies
+
+	newUE, err := ue.NewUE(ueOpts)
 	if err != nil {
 		return fmt.Errorf("could not create UE: %v", err)
 	}
@@ -128,21 +367,26 @@ func Run(ctx context.Context, cfg Config) error {
 	gNodeB.AddUE(ranUENGAPID, newUE)
 	logger.Logger.Info("added new UE to gNodeB")
 
-	_, err = initialRegistration(&initialRegistrationOpts{
+	_, err = InitialRegistration(&InitialRegistrationOpts{
 		RANUENGAPID:  ranUENGAPID,
 		PDUSessionID: pduSessionID,
 		UE:           newUE,
+		Steps:        steps,
 	})
 	if err != nil {
 		return fmt.Errorf("initial registration procedure failed: %v", err)
 	}
 
 	defer func() {
-		err = deregistration(&deregistrationOpts{
+		start := time.Now()
+
+		err = Deregistration(&DeregistrationOpts{
 			AMFUENGAPID: gNodeB.GetAMFUENGAPID(ranUENGAPID),
 			RANUENGAPID: ranUENGAPID,
 			UE:          newUE,
 		})
+		steps.Record("Deregistration", start, err)
+
 		if err != nil {
 			logger.Logger.Error("could not deregister UE", zap.Error(err))
 		}
@@ -175,16 +419,68 @@ func Run(ctx context.Context, cfg Config) error {
 		ueIPV6 = uePduSession.UEIPV6 + "/64"
 	}
 
-	_, err = gNodeB.AddTunnel(&gnb.NewTunnelOpts{
-		UEIP:             ueIP,
-		UEIPV6:           ueIPV6,
-		UpfIP:            pduSession.UpfAddress,
-		TunInterfaceName: gtpInterfaceName,
-		ULteid:           pduSession.ULTeid,
-		DLteid:           pduSession.DLTeid,
-		MTU:              uePduSession.MTU,
-		QFI:              uePduSession.QFI,
-	})
+	var (
+		tunnel  *gnb.Tunnel
+		ueStack *uestack.Stack
+	)
+
+	start = time.Now()
+
+	switch {
+	case uePduSession.PDUSessionVersion == nasMessage.PDUSessionTypeUnstructured:
+		tunnel, err = gNodeB.AddUnstructuredTunnel(&gnb.NewUnstructuredTunnelOpts{
+			Network: unstructuredNetwork,
+			Address: unstructuredAddress,
+			UpfIP:   pduSession.UpfAddress,
+			ULteid:  pduSession.ULTeid,
+			DLteid:  pduSession.DLTeid,
+			QFI:     uePduSession.QFI,
+		})
+	case cfg.KernelGTP:
+		ueAddr, parseErr := netip.ParseAddr(uePduSession.UEIP)
+		if parseErr != nil {
+			steps.Record("Create GTP Tunnel", start, parseErr)
+			return fmt.Errorf("could not parse UE IP address: %v", parseErr)
+		}
+
+		tunnel, err = gNodeB.AddKernelTunnel(&gnb.NewKernelTunnelOpts{
+			UEIP:   ueAddr,
+			UpfIP:  pduSession.UpfAddress,
+			ULteid: pduSession.ULTeid,
+			DLteid: pduSession.DLTeid,
+		})
+	case cfg.Userspace:
+		ueStack, err = newUEStack(&uePduSession)
+		if err != nil {
+			steps.Record("Create GTP Tunnel", start, err)
+			return fmt.Errorf("could not create userspace IP stack: %v", err)
+		}
+
+		tunnel, err = gNodeB.AddEndpointTunnel(&gnb.NewEndpointTunnelOpts{
+			Name:     "userspace:" + cfg.IMSI,
+			Endpoint: ueStack,
+			UpfIP:    pduSession.UpfAddress,
+			ULteid:   pduSession.ULTeid,
+			DLteid:   pduSession.DLTeid,
+			QFI:      uePduSession.QFI,
+		})
+	default:
+		tunnel, err = gNodeB.AddTunnel(&gnb.NewTunnelOpts{
+			UEIP:             ueIP,
+			UEIPV6:           ueIPV6,
+			UpfIP:            pduSession.UpfAddress,
+			TunInterfaceName: gtpInterfaceName,
+			ULteid:           pduSession.ULTeid,
+			DLteid:           pduSession.DLTeid,
+			MTU:              uePduSession.MTU,
+			QFI:              uePduSession.QFI,
+			Ethernet:         uePduSession.PDUSessionVersion == nasMessage.PDUSessionTypeEthernet,
+			Namespace:        namespace,
+		})
+	}
+
+	steps.Record("Create GTP Tunnel", start, err)
+
 	if err != nil {
 		return fmt.Errorf("could not create GTP tunnel (name: %s, DL TEID: %d): %v", gtpInterfaceName, pduSession.DLTeid, err)
 	}
@@ -200,42 +496,403 @@ func Run(ctx context.Context, cfg Config) error {
 
 	logger.Logger.Info(
 		"Created GTP tunnel",
-		zap.String("interface", gtpInterfaceName),
+		zap.String("interface", tunnel.Name),
+		zap.String("network namespace", tunnel.Namespace),
+		zap.String("PDU Session Type", cfg.PDUSessionType),
 		zap.String("UE IP", ueIP),
 		zap.String("UE IP (IPv6)", ueIPV6),
 		zap.String("gNB IP", cfg.GnbN3Address),
+		zap.String("gNB IP (IPv6)", cfg.GnbN3AddressV6),
 		zap.String("UPF IP", pduSession.UpfAddress),
 		zap.Uint32("LTEID", pduSession.ULTeid),
 		zap.Uint32("RTEID", pduSession.DLTeid),
 		zap.Uint16("GTPU Port", gtpuPort),
 		zap.Uint16("MTU", uePduSession.MTU),
+		zap.Any("DNS Servers", uePduSession.DNSServers),
+		zap.Any("P-CSCF Addresses", uePduSession.PCSCFAddresses),
 	)
 
+	var ueGlobalIPv6 netip.Addr
+
+	if cfg.IPv6SLAAC && ueIPV6 != "" {
+		linkLocal, err := netip.ParseAddr(uePduSession.UEIPV6)
+		if err != nil {
+			return fmt.Errorf("could not parse UE link-local IPv6 address: %v", err)
+		}
+
+		var ra *RouterAdvertisement
+
+		start := time.Now()
+
+		err = tunnel.Do(func() error {
+			ra, err = autoconfigureIPv6(tunnel.Name, linkLocal)
+			return err
+		})
+		steps.Record("IPv6 Autoconfiguration", start, err)
+
+		if err != nil {
+			return fmt.Errorf("IPv6 stateless address autoconfiguration failed: %v", err)
+		}
+
+		ueGlobalIPv6 = globalAddress(ra.Prefix, linkLocal)
+	}
+
+	if len(uePduSession.DNSServers) > 0 {
+		if resolvConfPath != "" {
+			restoreResolvConf, err := writeResolvConf(resolvConfPath, uePduSession.DNSServers)
+			if err != nil {
+				return fmt.Errorf("could not write DNS servers to resolv.conf: %v", err)
+			}
+
+			defer func() {
+				if err := restoreResolvConf(); err != nil {
+					logger.Logger.Error("could not restore resolv.conf", zap.String("path", resolvConfPath), zap.Error(err))
+				}
+			}()
+
+			logger.Logger.Info("Wrote DNS servers to resolv.conf", zap.String("path", resolvConfPath))
+		}
+
+		if cfg.SystemdResolved {
+			err = configureResolvedLink(ctx, tunnel.Name, uePduSession.DNSServers)
+			if err != nil {
+				return fmt.Errorf("could not configure DNS servers in systemd-resolved: %v", err)
+			}
+
+			defer func() {
+				if err := revertResolvedLink(ctx, tunnel.Name); err != nil {
+					logger.Logger.Error("could not revert systemd-resolved link configuration", zap.Error(err))
+				}
+			}()
+
+			logger.Logger.Info("Configured DNS servers in systemd-resolved", zap.String("interface", tunnel.Name))
+		}
+	}
+
+	if cfg.GTPEchoInterval > 0 {
+		gNodeB.StartEchoProber(cfg.GTPEchoInterval)
+		logger.Logger.Info("Started GTP-U path probing", zap.Duration("interval", cfg.GTPEchoInterval))
+	}
+
+	if ueStack != nil {
+		listener, err := net.Listen("tcp", cfg.SOCKS5Address)
+		if err != nil {
+			return fmt.Errorf("could not listen for SOCKS5 clients on %s: %v", cfg.SOCKS5Address, err)
+		}
+
+		defer func() {
+			if err := listener.Close(); err != nil {
+				logger.Logger.Error("could not close SOCKS5 listener", zap.Error(err))
+			}
+		}()
+
+		go func() {
+			if err := ueStack.ServeSOCKS5(listener); err != nil {
+				logger.Logger.Error("SOCKS5 proxy stopped", zap.Error(err))
+			}
+		}()
+
+		logger.Logger.Info("Serving SOCKS5 proxy from the UE address", zap.String("address", listener.Addr().String()))
+	}
+
 	sctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	<-sctx.Done()
+	if trafficDestination.IsValid() {
+		source := ueGlobalIPv6
+		start := time.Now()
+
+		if trafficDestination.Addr().Is4() {
+			var parseErr error
+
+			source, parseErr = netip.ParseAddr(uePduSession.UEIP)
+			if parseErr != nil {
+				steps.Record("Traffic", start, parseErr)
+				return fmt.Errorf("could not parse UE IP address: %v", parseErr)
+			}
+		} else if !source.IsValid() {
+			return fmt.Errorf("IPv6 traffic needs a global UE address: enable IPv6 stateless address autoconfiguration")
+		}
+
+		report, err := traffic.Run(sctx, gNodeB, tunnel, traffic.Config{
+			Protocol:    cfg.TrafficProtocol,
+			Source:      source,
+			Destination: trafficDestination,
+			Rate:        cfg.TrafficRate,
+			PayloadSize: cfg.TrafficPayloadSize,
+			Duration:    cfg.TrafficDuration,
+			MTU:         uePduSession.MTU,
+		})
+		steps.Record("Traffic", start, err)
+
+		if err != nil {
+			return fmt.Errorf("traffic generation failed: %v", err)
+		}
+
+		logTrafficReport(report)
+	} else {
+		<-sctx.Done()
+	}
+
 	logger.Logger.Info("shutting down")
 
+	stats := tunnel.Stats()
+	logger.Logger.Info(
+		"Tunnel counters",
+		zap.String("interface", tunnel.Name),
+		zap.Uint64("uplink packets", stats.UplinkPackets),
+		zap.Uint64("uplink bytes", stats.UplinkBytes),
+		zap.Uint64("uplink errors", stats.UplinkErrors),
+		zap.Uint64("downlink packets", stats.DownlinkPackets),
+		zap.Uint64("downlink bytes", stats.DownlinkBytes),
+	)
+
+	for _, path := range gNodeB.GetPathStatuses() {
+		logger.Logger.Info(
+			"GTP-U path status",
+			zap.String("UPF", path.Peer.String()),
+			zap.Stringer("state", path.State),
+			zap.Duration("last RTT", path.RTT),
+			zap.Uint64("echo requests", path.RequestsSent),
+			zap.Uint64("echo responses", path.ResponsesMatched),
+		)
+	}
+
+	return nil
+}
+
+// validateUserspace rejects the options that need a kernel interface when the
+// UE's IP stack runs in userspace.
+func validateUserspace(cfg Config) error {
+	if !cfg.Userspace {
+		return nil
+	}
+
+	switch {
+	case cfg.PDUSessionType == "ethernet" || cfg.PDUSessionType == "unstructured":
+		return fmt.Errorf("the userspace data plane requires an IP PDU session, not %s", cfg.PDUSessionType)
+	case cfg.PDUSessionType == "ipv6" || cfg.PDUSessionType == "ipv4v6":
+		// The network only assigns the interface identifier of an IPv6
+		// session; the prefix comes from a Router Advertisement, which the
+		// userspace stack does not solicit.
+		return fmt.Errorf("the userspace data plane only supports ipv4 PDU sessions, not %s", cfg.PDUSessionType)
+	case cfg.Namespace:
+		return fmt.Errorf("the userspace data plane has no interface to place in a network namespace")
+	case cfg.SystemdResolved:
+		return fmt.Errorf("the userspace data plane has no interface to configure in systemd-resolved")
+	case cfg.IPv6SLAAC:
+		return fmt.Errorf("IPv6 stateless address autoconfiguration is not available with the userspace data plane")
+	case cfg.SOCKS5Address == "":
+		return fmt.Errorf("a SOCKS5 listen address is required with the userspace data plane")
+	}
+
 	return nil
 }
 
+// validateKernelGTP rejects the options that the Linux GTP device cannot
+// serve.
+func validateKernelGTP(cfg Config) error {
+	if !cfg.KernelGTP {
+		return nil
+	}
+
+	switch {
+	case cfg.PDUSessionType != "ipv4":
+		return fmt.Errorf("kernel GTP offload only supports ipv4 PDU sessions, not %s", cfg.PDUSessionType)
+	case cfg.Userspace:
+		return fmt.Errorf("kernel GTP offload and the userspace data plane are mutually exclusive")
+	case cfg.Namespace:
+		return fmt.Errorf("kernel GTP offload does not support per-UE network namespaces")
+	case cfg.TrafficDestination != "":
+		return fmt.Errorf("the built-in traffic generator does not see packets forwarded by the kernel; send traffic from the UE address instead")
+	}
+
+	return nil
+}
+
+func newUEStack(session *ue.PDUSessionInfo) (*uestack.Stack, error) {
+	opts := &uestack.Opts{
+		MTU:        session.MTU,
+		DNSServers: session.DNSServers,
+	}
+
+	if session.UEIP != "" {
+		addr, err := netip.ParseAddr(session.UEIP)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse UE IPv4 address: %v", err)
+		}
+
+		opts.IPv4 = addr
+	}
+
+	if session.UEIPV6 != "" {
+		addr, err := netip.ParseAddr(session.UEIPV6)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse UE IPv6 address: %v", err)
+		}
+
+		opts.IPv6 = addr
+	}
+
+	return uestack.New(opts)
+}
+
+// parseTrafficDestination checks the traffic generator options against the
+// PDU session type and resolves the reflector address.
+func parseTrafficDestination(sessionType string, protocol string, destination string) (netip.AddrPort, error) {
+	if destination == "" {
+		return netip.AddrPort{}, nil
+	}
+
+	switch sessionType {
+	case "ethernet", "unstructured":
+		return netip.AddrPort{}, fmt.Errorf("the traffic generator requires an IP PDU session, not %s", sessionType)
+	}
+
+	if protocol != traffic.ProtocolUDP && protocol != traffic.ProtocolICMP {
+		return netip.AddrPort{}, fmt.Errorf("invalid traffic protocol %q: must be udp or icmp", protocol)
+	}
+
+	var addrPort netip.AddrPort
+
+	if protocol == traffic.ProtocolICMP {
+		addr, err := netip.ParseAddr(destination)
+		if err != nil {
+			return netip.AddrPort{}, fmt.Errorf("invalid ICMP traffic destination %q: %v", destination, err)
+		}
+
+		addrPort = netip.AddrPortFrom(addr, 0)
+	} else {
+		var err error
+
+		addrPort, err = netip.ParseAddrPort(destination)
+		if err != nil {
+			return netip.AddrPort{}, fmt.Errorf("invalid UDP traffic destination %q: must be <host>:<port>: %v", destination, err)
+		}
+	}
+
+	if addrPort.Addr().Is6() && sessionType == "ipv4" || addrPort.Addr().Is4() && sessionType == "ipv6" {
+		return netip.AddrPort{}, fmt.Errorf("traffic destination %s does not match PDU session type %s", destination, sessionType)
+	}
+
+	return addrPort, nil
+}
+
+// writeLatencyReport prints the percentiles of each leg and writes the full
+// report to path, if set.
+func writeLatencyReport(report latency.Report, path string) error {
+	if len(report.Legs) == 0 {
+		return nil
+	}
+
+	err := report.WriteTable(os.Stdout)
+	if err != nil {
+		return fmt.Errorf("could not print latency table: %v", err)
+	}
+
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create latency report: %v", err)
+	}
+
+	err = report.WriteJSON(f)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("could not close latency report: %v", err)
+	}
+
+	logger.Logger.Info("wrote latency report", zap.String("path", path))
+
+	return nil
+}
+
+func logTrafficReport(r *traffic.Report) {
+	logger.Logger.Info(
+		"Traffic report",
+		zap.String("protocol", r.Protocol),
+		zap.String("destination", r.Destination),
+		zap.Duration("duration", r.Duration),
+		zap.Uint64("sent", r.Sent),
+		zap.Uint64("received", r.Received),
+		zap.Uint64("duplicates", r.Duplicates),
+		zap.Uint64("late", r.Late),
+		zap.Uint64("lost", r.Lost),
+		zap.Float64("loss %", r.LossPercent),
+		zap.Float64("uplink Mbps", r.UplinkBps/1e6),
+		zap.Float64("downlink Mbps", r.DownlinkBps/1e6),
+		zap.Duration("RTT min", r.RTTMin),
+		zap.Duration("RTT avg", r.RTTAvg),
+		zap.Duration("RTT max", r.RTTMax),
+		zap.Duration("jitter", r.Jitter),
+	)
+}
+
 func convertPDUSessionType(sessionType string) uint8 {
 	switch sessionType {
 	case "ipv6":
 		return nasMessage.PDUSessionTypeIPv6
 	case "ipv4v6":
 		return nasMessage.PDUSessionTypeIPv4IPv6
+	case "ethernet":
+		return nasMessage.PDUSessionTypeEthernet
+	case "unstructured":
+		return nasMessage.PDUSessionTypeUnstructured
 	default:
 		return nasMessage.PDUSessionTypeIPv4
 	}
 }
 
-func validatePDUSessionType(sessionType string) error {
+// ValidatePDUSessionType checks the name of a PDU session type.
+func ValidatePDUSessionType(sessionType string) error {
 	switch sessionType {
-	case "ipv4", "ipv6", "ipv4v6":
+	case "ipv4", "ipv6", "ipv4v6", "ethernet", "unstructured":
 		return nil
 	default:
-		return fmt.Errorf("invalid PDU session type %q: must be ipv4, ipv6, or ipv4v6", sessionType)
+		return fmt.Errorf("invalid PDU session type %q: must be ipv4, ipv6, ipv4v6, ethernet, or unstructured", sessionType)
+	}
+}
+
+func validateSSCModes(requested uint8, allowed []uint8) error {
+	if requested > 3 {
+		return fmt.Errorf("invalid SSC mode %d: must be 1, 2, 3, or 0 to let the network select it", requested)
+	}
+
+	for _, mode := range allowed {
+		if mode < 1 || mode > 3 {
+			return fmt.Errorf("invalid allowed SSC mode %d: must be 1, 2, or 3", mode)
+		}
+	}
+
+	return nil
+}
+
+// parseUnstructuredAddress splits an Unstructured socket address of the form
+// "udp:<host:port>" or "unix:<path>" into a network and an address.
+func parseUnstructuredAddress(sessionType string, address string) (string, string, error) {
+	if sessionType != "unstructured" {
+		return "", "", nil
+	}
+
+	network, addr, ok := strings.Cut(address, ":")
+	if !ok || addr == "" {
+		return "", "", fmt.Errorf("invalid Unstructured address %q: must be udp:<host:port> or unix:<path>", address)
+	}
+
+	switch network {
+	case "udp":
+		return "udp", addr, nil
+	case "unix":
+		return "unixgram", addr, nil
+	default:
+		return "", "", fmt.Errorf("invalid Unstructured address %q: network must be udp or unix", address)
 	}
 }
@@ -0,0 +1,299 @@
+package register
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	ndpHopLimit             = 255
+	ndpOptionPrefixInfo     = 3
+	ndpOptionMTU            = 5
+	ndpPrefixFlagAutonomous = 0x40
+	raRetransmitInterval    = 4 * time.Second // RtrSolicitationInterval, RFC 4861 section 10
+	maxRouterSolicitations  = 3               // MAX_RTR_SOLICITATIONS, RFC 4861 section 10
+
+	// ipv6DefaultRouteMetric keeps the tunnel's default route behind any
+	// default route the host already has. The route has no gateway: the
+	// tunnel is a point-to-point link to the advertising router.
+	ipv6DefaultRouteMetric = 4096
+)
+
+// RouterAdvertisement holds the fields of a Router Advertisement (RFC 4861
+// section 4.2) that a UE needs to autoconfigure its IPv6 address.
+type RouterAdvertisement struct {
+	Source            netip.Addr
+	RouterLifetime    time.Duration
+	Prefix            netip.Prefix // first prefix with the autonomous flag
+	ValidLifetime     time.Duration
+	PreferredLifetime time.Duration
+	MTU               uint32
+}
+
+// autoconfigureIPv6 performs stateless address autoconfiguration (RFC 4862)
+// on the tunnel interface: it solicits a Router Advertisement from the
+// network, then configures the global address formed from the advertised
+// prefix and the interface identifier, the default route and the link MTU.
+func autoconfigureIPv6(ifName string, linkLocal netip.Addr) (*RouterAdvertisement, error) {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return nil, fmt.Errorf("could not find interface %s: %v", ifName, err)
+	}
+
+	// The kernel would otherwise process the same Router Advertisement and
+	// configure addresses on its own.
+	err = os.WriteFile(fmt.Sprintf("/proc/sys/net/ipv6/conf/%s/accept_ra", ifName), []byte("0"), 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("could not disable kernel Router Advertisement processing: %v", err)
+	}
+
+	ra, err := solicitRouterAdvertisement(ifName, linkLocal)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ra.Prefix.IsValid() {
+		return nil, fmt.Errorf("router advertisement from %s carries no prefix for autonomous configuration", ra.Source)
+	}
+
+	if ra.ValidLifetime == 0 {
+		return nil, fmt.Errorf("advertised prefix %s has a valid lifetime of 0", ra.Prefix)
+	}
+
+	if ra.Prefix.Bits() != 64 {
+		return nil, fmt.Errorf("advertised prefix %s is not a /64 and cannot be combined with a 64-bit interface identifier", ra.Prefix)
+	}
+
+	global := netip.PrefixFrom(globalAddress(ra.Prefix, linkLocal), ra.Prefix.Bits())
+
+	err = netlink.AddrAdd(link, &netlink.Addr{
+		IPNet: &net.IPNet{
+			IP:   global.Addr().AsSlice(),
+			Mask: net.CIDRMask(global.Bits(), 128),
+		},
+		ValidLft:    int(ra.ValidLifetime.Seconds()),
+		PreferedLft: int(ra.PreferredLifetime.Seconds()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not assign global IPv6 address %s: %v", global, err)
+	}
+
+	if ra.RouterLifetime > 0 {
+		err = netlink.RouteAdd(&netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Dst:       &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+			Priority:  ipv6DefaultRouteMetric,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not add IPv6 default route on %s: %v", ifName, err)
+		}
+	}
+
+	if ra.MTU != 0 {
+		err = netlink.LinkSetMTU(link, int(ra.MTU))
+		if err != nil {
+			return nil, fmt.Errorf("could not set advertised MTU %d: %v", ra.MTU, err)
+		}
+	}
+
+	logger.Logger.Info(
+		"Completed IPv6 stateless address autoconfiguration",
+		zap.String("interface", ifName),
+		zap.String("address", global.String()),
+		zap.String("router", ra.Source.String()),
+		zap.Duration("router lifetime", ra.RouterLifetime),
+		zap.Uint32("MTU", ra.MTU),
+	)
+
+	return ra, nil
+}
+
+// globalAddress combines an advertised /64 prefix with the interface
+// identifier of the link-local address.
+func globalAddress(prefix netip.Prefix, linkLocal netip.Addr) netip.Addr {
+	addr := prefix.Addr().As16()
+	iid := linkLocal.As16()
+	copy(addr[8:], iid[8:])
+
+	return netip.AddrFrom16(addr)
+}
+
+// solicitRouterAdvertisement sends Router Solicitations to the all-routers
+// multicast address and waits for a valid Router Advertisement.
+func solicitRouterAdvertisement(ifName string, linkLocal netip.Addr) (*RouterAdvertisement, error) {
+	conn, err := icmp.ListenPacket("ip6:ipv6-icmp", linkLocal.WithZone(ifName).String())
+	if err != nil {
+		return nil, fmt.Errorf("could not open ICMPv6 socket on %s: %v", ifName, err)
+	}
+
+	defer func() {
+		if err := conn.Close(); err != nil {
+			logger.Logger.Warn("could not close ICMPv6 socket", zap.Error(err))
+		}
+	}()
+
+	pc := conn.IPv6PacketConn()
+
+	err = pc.SetMulticastHopLimit(ndpHopLimit)
+	if err != nil {
+		return nil, fmt.Errorf("could not set multicast hop limit: %v", err)
+	}
+
+	err = pc.SetControlMessage(ipv6.FlagHopLimit, true)
+	if err != nil {
+		return nil, fmt.Errorf("could not request hop limit control messages: %v", err)
+	}
+
+	var filter ipv6.ICMPFilter
+
+	filter.SetAll(true)
+	filter.Accept(ipv6.ICMPTypeRouterAdvertisement)
+
+	err = pc.SetICMPFilter(&filter)
+	if err != nil {
+		return nil, fmt.Errorf("could not set ICMPv6 filter: %v", err)
+	}
+
+	rs, err := (&icmp.Message{
+		Type: ipv6.ICMPTypeRouterSolicitation,
+		Body: &icmp.RawBody{Data: make([]byte, 4)}, // reserved
+	}).Marshal(nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not build Router Solicitation: %v", err)
+	}
+
+	allRouters := &net.IPAddr{IP: net.ParseIP("ff02::2"), Zone: ifName}
+	buf := make([]byte, 1500)
+
+	for attempt := 1; attempt <= maxRouterSolicitations; attempt++ {
+		if _, err := pc.WriteTo(rs, nil, allRouters); err != nil {
+			return nil, fmt.Errorf("could not send Router Solicitation: %v", err)
+		}
+
+		logger.Logger.Debug("Sent Router Solicitation", zap.String("interface", ifName), zap.Int("attempt", attempt))
+
+		deadline := time.Now().Add(raRetransmitInterval)
+
+		err = pc.SetReadDeadline(deadline)
+		if err != nil {
+			return nil, fmt.Errorf("could not set read deadline: %v", err)
+		}
+
+		for {
+			n, cm, src, err := pc.ReadFrom(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+
+				return nil, fmt.Errorf("could not read Router Advertisement: %v", err)
+			}
+
+			ra, err := parseRouterAdvertisement(buf[:n], cm, src)
+			if err != nil {
+				logger.Logger.Warn("ignoring invalid Router Advertisement", zap.Error(err))
+				continue
+			}
+
+			return ra, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no Router Advertisement received on %s after %d Router Solicitations", ifName, maxRouterSolicitations)
+}
+
+// parseRouterAdvertisement validates and decodes a Router Advertisement
+// according to RFC 4861 sections 4.2, 4.6 and 6.1.2.
+func parseRouterAdvertisement(b []byte, cm *ipv6.ControlMessage, src net.Addr) (*RouterAdvertisement, error) {
+	ipAddr, ok := src.(*net.IPAddr)
+	if !ok {
+		return nil, fmt.Errorf("unexpected source address type %T", src)
+	}
+
+	source, ok := netip.AddrFromSlice(ipAddr.IP)
+	if !ok || !source.Is6() || !source.IsLinkLocalUnicast() {
+		return nil, fmt.Errorf("source %s is not a link-local IPv6 address", ipAddr.IP)
+	}
+
+	if cm != nil && cm.HopLimit != ndpHopLimit {
+		return nil, fmt.Errorf("hop limit is %d, must be %d", cm.HopLimit, ndpHopLimit)
+	}
+
+	// type(1) code(1) checksum(2) hop limit(1) flags(1) router lifetime(2)
+	// reachable time(4) retrans timer(4)
+	if len(b) < 16 {
+		return nil, fmt.Errorf("message is too short: %d bytes", len(b))
+	}
+
+	if b[0] != byte(ipv6.ICMPTypeRouterAdvertisement) || b[1] != 0 {
+		return nil, fmt.Errorf("unexpected ICMPv6 type %d code %d", b[0], b[1])
+	}
+
+	ra := &RouterAdvertisement{
+		Source:         source,
+		RouterLifetime: time.Duration(binary.BigEndian.Uint16(b[6:8])) * time.Second,
+	}
+
+	options := b[16:]
+	for len(options) > 0 {
+		if len(options) < 2 || options[1] == 0 {
+			return nil, fmt.Errorf("malformed option")
+		}
+
+		optLen := int(options[1]) * 8
+		if optLen > len(options) {
+			return nil, fmt.Errorf("option %d exceeds message length", options[0])
+		}
+
+		opt := options[:optLen]
+
+		switch opt[0] {
+		case ndpOptionPrefixInfo:
+			if optLen != 32 {
+				return nil, fmt.Errorf("prefix information option has invalid length %d", optLen)
+			}
+
+			var prefix [16]byte
+			copy(prefix[:], opt[16:32])
+
+			p, err := netip.AddrFrom16(prefix).Prefix(int(opt[2]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid prefix length %d: %v", opt[2], err)
+			}
+
+			// Only the first autonomous prefix is used: RFC 4862 section
+			// 5.5.3 ignores the options without the A flag, such as an
+			// on-link only prefix advertised before it.
+			if !ra.Prefix.IsValid() && opt[3]&ndpPrefixFlagAutonomous != 0 {
+				ra.Prefix = p
+				ra.ValidLifetime = time.Duration(binary.BigEndian.Uint32(opt[4:8])) * time.Second
+				ra.PreferredLifetime = time.Duration(binary.BigEndian.Uint32(opt[8:12])) * time.Second
+			}
+		case ndpOptionMTU:
+			if optLen != 8 {
+				return nil, fmt.Errorf("MTU option has invalid length %d", optLen)
+			}
+
+			ra.MTU = binary.BigEndian.Uint32(opt[4:8])
+			if ra.MTU < 1280 {
+				return nil, fmt.Errorf("advertised MTU %d is below the IPv6 minimum of 1280", ra.MTU)
+			}
+		}
+
+		options = options[optLen:]
+	}
+
+	return ra, nil
+}
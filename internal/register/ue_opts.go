@@ -0,0 +1,63 @@
+package register
+
+import (
+	"github.com/ellanetworks/core-tester/internal/ue"
+	"github.com/ellanetworks/core-tester/internal/ue/sidf"
+)
+
+// Subscriber holds the identity, credentials and subscription of a UE.
+type Subscriber struct {
+	IMSI string
+	Key  string
+	OPC  string
+	SQN  string
+	MCC  string
+	MNC  string
+	DNN  string
+	SST  int32
+	SD   string
+	// PDUSessionType is ipv4, ipv6, ipv4v6, ethernet or unstructured. It
+	// defaults to ipv4.
+	PDUSessionType string
+	// SecurityCapability lists the algorithms the UE supports. It defaults
+	// to NIA2, NEA0 and NEA2.
+	SecurityCapability *UeSecurityCapability
+}
+
+// UEOpts returns the options of a UE that registers as the subscriber. The
+// gNodeB, the PDU session and the recorders are left to the caller.
+func UEOpts(s Subscriber) *ue.UEOpts {
+	securityCapability := s.SecurityCapability
+	if securityCapability == nil {
+		securityCapability = &UeSecurityCapability{
+			Integrity: IntegrityAlgorithms{
+				Nia2: true,
+			},
+			Ciphering: CipheringAlgorithms{
+				Nea0: true,
+				Nea2: true,
+			},
+		}
+	}
+
+	return &ue.UEOpts{
+		Msin: s.IMSI[5:],
+		K:    s.Key,
+		OpC:  s.OPC,
+		Amf:  "80000000000000000000000000000000",
+		Sqn:  s.SQN,
+		Mcc:  s.MCC,
+		Mnc:  s.MNC,
+		HomeNetworkPublicKey: sidf.HomeNetworkPublicKey{
+			ProtectionScheme: sidf.NullScheme,
+			PublicKeyID:      "0",
+		},
+		RoutingIndicator:     "0000",
+		DNN:                  s.DNN,
+		Sst:                  s.SST,
+		Sd:                   s.SD,
+		IMEISV:               "3569380356438091",
+		PDUSessionType:       convertPDUSessionType(s.PDUSessionType),
+		UeSecurityCapability: getUESecurityCapability(securityCapability),
+	}
+}
@@ -0,0 +1,241 @@
+// Package results records the outcome of each step of a scenario, so that
+// CI pipelines can show them as individual test cases in JUnit XML or JSON.
+package results
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Step is the outcome of one step of a scenario.
+type Step struct {
+	Name     string    `json:"name"`
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"duration_seconds"`
+	Passed   bool      `json:"passed"`
+	Failure  string    `json:"failure,omitempty"`
+}
+
+// Suite collects the steps of a scenario in the order they complete. A nil
+// Suite records nothing. It is safe for concurrent use.
+type Suite struct {
+	Name  string
+	start time.Time
+	mu    sync.Mutex
+	steps []Step
+}
+
+func NewSuite(name string) *Suite {
+	return &Suite{Name: name, start: time.Now()}
+}
+
+// Record adds a step that started at start and ended now, failed if err is
+// not nil.
+func (s *Suite) Record(name string, start time.Time, err error) {
+	if s == nil {
+		return
+	}
+
+	step := Step{
+		Name:     name,
+		Start:    start,
+		Duration: time.Since(start).Seconds(),
+		Passed:   err == nil,
+	}
+
+	if err != nil {
+		step.Failure = err.Error()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.steps = append(s.steps, step)
+}
+
+// Steps returns the steps recorded so far.
+func (s *Suite) Steps() []Step {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.steps)
+}
+
+// Summary is the JSON form of a Suite.
+type Summary struct {
+	Name     string    `json:"name"`
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"duration_seconds"`
+	Passed   bool      `json:"passed"`
+	Tests    int       `json:"tests"`
+	Failures int       `json:"failures"`
+	Steps    []Step    `json:"steps"`
+}
+
+func (s *Suite) Summary() Summary {
+	steps := s.Steps()
+
+	summary := Summary{
+		Name:     s.Name,
+		Start:    s.start,
+		Duration: time.Since(s.start).Seconds(),
+		Passed:   true,
+		Tests:    len(steps),
+		Steps:    steps,
+	}
+
+	if summary.Steps == nil {
+		summary.Steps = []Step{}
+	}
+
+	for _, step := range steps {
+		if !step.Passed {
+			summary.Passed = false
+			summary.Failures++
+		}
+	}
+
+	return summary
+}
+
+// WriteJSON writes the summary of the suite as indented JSON.
+func (s *Suite) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+
+	err := enc.Encode(s.Summary())
+	if err != nil {
+		return fmt.Errorf("could not encode JSON results: %v", err)
+	}
+
+	return nil
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the suite as JUnit XML, one test case per step.
+func (s *Suite) WriteJUnit(w io.Writer) error {
+	summary := s.Summary()
+
+	suite := junitTestSuite{
+		Name:      summary.Name,
+		Tests:     summary.Tests,
+		Failures:  summary.Failures,
+		Time:      seconds(summary.Duration),
+		Timestamp: summary.Start.Format(time.RFC3339),
+	}
+
+	for _, step := range summary.Steps {
+		tc := junitTestCase{
+			Name:      step.Name,
+			ClassName: summary.Name,
+			Time:      seconds(step.Duration),
+		}
+
+		if !step.Passed {
+			tc.Failure = &junitFailure{Message: step.Failure, Text: step.Failure}
+		}
+
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	out, err := xml.MarshalIndent(junitTestSuites{
+		Name:     summary.Name,
+		Tests:    summary.Tests,
+		Failures: summary.Failures,
+		Time:     suite.Time,
+		Suites:   []junitTestSuite{suite},
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode JUnit results: %v", err)
+	}
+
+	_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, out)
+
+	return err
+}
+
+func seconds(s float64) string {
+	return fmt.Sprintf("%.3f", s)
+}
+
+// WriteFiles writes the JUnit XML and JSON results to the paths that are
+// set.
+func (s *Suite) WriteFiles(junitPath string, jsonPath string) error {
+	if junitPath != "" {
+		err := writeFile(junitPath, s.WriteJUnit)
+		if err != nil {
+			return err
+		}
+	}
+
+	if jsonPath != "" {
+		err := writeFile(jsonPath, s.WriteJSON)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create results file: %v", err)
+	}
+
+	err = write(f)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("could not close results file: %v", err)
+	}
+
+	return nil
+}
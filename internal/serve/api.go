@@ -0,0 +1,247 @@
+package serve
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/ellanetworks/core-tester/internal/control"
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"go.uber.org/zap"
+)
+
+// response is the envelope of every answer, as in the Ella Core API.
+type response struct {
+	Result any    `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type newPDUSession struct {
+	ID  uint8  `json:"id,omitempty"` // the lowest free ID when unset
+	DNN string `json:"dnn,omitempty"`
+}
+
+type handler struct {
+	controller *control.Controller
+}
+
+func newHandler(controller *control.Controller) http.Handler {
+	h := &handler{controller: controller}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/gnb", h.getGnodeB)
+	mux.HandleFunc("POST /api/v1/gnb/reset", h.resetGnodeB)
+	mux.HandleFunc("GET /api/v1/ues", h.listUEs)
+	mux.HandleFunc("POST /api/v1/ues", h.addUE)
+	mux.HandleFunc("GET /api/v1/ues/{id}", h.getUE)
+	mux.HandleFunc("DELETE /api/v1/ues/{id}", h.removeUE)
+	mux.HandleFunc("POST /api/v1/ues/{id}/register", h.procedure(controller.Register))
+	mux.HandleFunc("POST /api/v1/ues/{id}/deregister", h.procedure(controller.Deregister))
+	mux.HandleFunc("POST /api/v1/ues/{id}/idle", h.procedure(controller.Idle))
+	mux.HandleFunc("POST /api/v1/ues/{id}/service-request", h.procedure(controller.ServiceRequest))
+	mux.HandleFunc("POST /api/v1/ues/{id}/handover", h.procedure(controller.Handover))
+	mux.HandleFunc("POST /api/v1/ues/{id}/pdu-sessions", h.addPDUSession)
+	mux.HandleFunc("DELETE /api/v1/ues/{id}/pdu-sessions/{session}", h.releasePDUSession)
+
+	return mux
+}
+
+func (h *handler) getGnodeB(w http.ResponseWriter, _ *http.Request) {
+	writeResult(w, http.StatusOK, h.controller.GnodeB())
+}
+
+func (h *handler) resetGnodeB(w http.ResponseWriter, _ *http.Request) {
+	err := h.controller.ResetGnodeB()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeResult(w, http.StatusOK, h.controller.UEs())
+}
+
+func (h *handler) listUEs(w http.ResponseWriter, _ *http.Request) {
+	writeResult(w, http.StatusOK, h.controller.UEs())
+}
+
+func (h *handler) addUE(w http.ResponseWriter, r *http.Request) {
+	var params control.NewUE
+
+	err := decode(r, &params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	state, err := h.controller.AddUE(params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeResult(w, http.StatusCreated, state)
+}
+
+func (h *handler) getUE(w http.ResponseWriter, r *http.Request) {
+	id, err := ueID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	state, err := h.controller.UE(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeResult(w, http.StatusOK, state)
+}
+
+func (h *handler) removeUE(w http.ResponseWriter, r *http.Request) {
+	id, err := ueID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = h.controller.RemoveUE(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// procedure answers with the state the procedure left the UE in, and with
+// the reason it failed.
+func (h *handler) procedure(run func(id int64) (control.UEState, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := ueID(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		state, err := run(id)
+		writeProcedureResult(w, state, err)
+	}
+}
+
+func (h *handler) addPDUSession(w http.ResponseWriter, r *http.Request) {
+	id, err := ueID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var params newPDUSession
+
+	err = decode(r, &params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if params.ID == 0 {
+		params.ID, err = h.controller.NextPDUSessionID(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	state, err := h.controller.AddPDUSession(id, params.ID, params.DNN)
+	writeProcedureResult(w, state, err)
+}
+
+func (h *handler) releasePDUSession(w http.ResponseWriter, r *http.Request) {
+	id, err := ueID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	sessionID, err := strconv.ParseUint(r.PathValue("session"), 10, 8)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: invalid PDU session ID %q", control.ErrInvalid, r.PathValue("session")))
+		return
+	}
+
+	state, err := h.controller.ReleasePDUSession(id, uint8(sessionID))
+	writeProcedureResult(w, state, err)
+}
+
+func ueID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid UE ID %q", control.ErrInvalid, r.PathValue("id"))
+	}
+
+	return id, nil
+}
+
+// decode reads the JSON body of a request. An empty body leaves v unchanged.
+func decode(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: could not decode request: %v", control.ErrInvalid, err)
+	}
+
+	return nil
+}
+
+func writeProcedureResult(w http.ResponseWriter, state control.UEState, err error) {
+	if err != nil && state.ID == 0 {
+		writeError(w, err)
+		return
+	}
+
+	if err != nil {
+		write(w, status(err), response{Result: state, Error: err.Error()})
+		return
+	}
+
+	writeResult(w, http.StatusOK, state)
+}
+
+func writeResult(w http.ResponseWriter, code int, result any) {
+	write(w, code, response{Result: result})
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	write(w, status(err), response{Error: err.Error()})
+}
+
+// status maps the errors of the controller to HTTP status codes. A
+// procedure that fails for another reason, such as a reject or a timeout of
+// the core, is a server error.
+func status(err error) int {
+	switch {
+	case errors.Is(err, control.ErrUnknownUE), errors.Is(err, control.ErrUnknownPDUSession):
+		return http.StatusNotFound
+	case errors.Is(err, control.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, control.ErrInvalid):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func write(w http.ResponseWriter, code int, resp response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		logger.Logger.Warn("could not write API response", zap.Error(err))
+	}
+}
@@ -0,0 +1,192 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ellanetworks/core-tester/internal/control"
+	"github.com/ellanetworks/core-tester/internal/gnb"
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/register"
+	"go.uber.org/zap"
+)
+
+const testIMSI = "001010100007487"
+
+// newTestServer serves the API of a gNodeB that is not connected to a core:
+// every procedure that reaches the network fails.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	logger.Logger = zap.NewNop()
+	logger.GnbLogger = zap.NewNop()
+	logger.UeLogger = zap.NewNop()
+
+	controller := control.New(control.Config{
+		MCC:            "001",
+		MNC:            "01",
+		SST:            1,
+		DNN:            "internet",
+		PDUSessionType: "ipv4",
+		Subscribers: []register.Subscriber{{
+			IMSI: testIMSI,
+			Key:  "5122250214c33e723a5dd523fc145fc0",
+			OPC:  "981d464c7c52eb6e5036234984ad0bcf",
+			SQN:  "000000000023",
+		}},
+	}, &gnb.GnodeB{GnbID: "000008"}, nil)
+
+	server := httptest.NewServer(newHandler(controller))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+type testResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+func do(t *testing.T, server *httptest.Server, method string, path string, body string) (int, testResponse) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not send request: %v", err)
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	var r testResponse
+
+	if resp.StatusCode != http.StatusNoContent {
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("expected a JSON answer, got %q", ct)
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&r)
+		if err != nil {
+			t.Fatalf("could not decode answer: %v", err)
+		}
+	}
+
+	return resp.StatusCode, r
+}
+
+func TestAddAndGetUE(t *testing.T) {
+	server := newTestServer(t)
+
+	code, resp := do(t, server, http.MethodPost, "/api/v1/ues", `{"imsi": "`+testIMSI+`"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", code, resp.Error)
+	}
+
+	var state control.UEState
+
+	err := json.Unmarshal(resp.Result, &state)
+	if err != nil {
+		t.Fatalf("could not decode UE: %v", err)
+	}
+
+	if state.ID != 1 || state.IMSI != testIMSI || state.State != "null" || state.GnbID != "000008" {
+		t.Fatalf("unexpected UE %+v", state)
+	}
+
+	code, resp = do(t, server, http.MethodGet, "/api/v1/ues/1", "")
+	if code != http.StatusOK || resp.Error != "" {
+		t.Fatalf("expected 200, got %d: %s", code, resp.Error)
+	}
+
+	code, resp = do(t, server, http.MethodGet, "/api/v1/ues", "")
+	if code != http.StatusOK || !strings.HasPrefix(string(resp.Result), "[{") {
+		t.Fatalf("expected a list of one UE, got %d: %s", code, resp.Result)
+	}
+
+	code, _ = do(t, server, http.MethodDelete, "/api/v1/ues/1", "")
+	if code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", code)
+	}
+
+	code, _ = do(t, server, http.MethodGet, "/api/v1/ues/1", "")
+	if code != http.StatusNotFound {
+		t.Fatalf("expected 404 after removal, got %d", code)
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		body      string
+		code      int
+		withState bool // the answer carries the state of the UE
+	}{
+		{"unknown UE", http.MethodGet, "/api/v1/ues/42", "", http.StatusNotFound, false},
+		{"invalid UE ID", http.MethodGet, "/api/v1/ues/one", "", http.StatusBadRequest, false},
+		{"unknown field", http.MethodPost, "/api/v1/ues", `{"imsi": "` + testIMSI + `", "color": "blue"}`, http.StatusBadRequest, false},
+		{"malformed body", http.MethodPost, "/api/v1/ues", `{"imsi": `, http.StatusBadRequest, false},
+		{"no credentials", http.MethodPost, "/api/v1/ues", `{"imsi": "001010100000001"}`, http.StatusBadRequest, false},
+		{"duplicate IMSI", http.MethodPost, "/api/v1/ues", `{"imsi": "` + testIMSI + `"}`, http.StatusConflict, false},
+		{"procedure of an unknown UE", http.MethodPost, "/api/v1/ues/42/register", "", http.StatusNotFound, false},
+		{"deregister a new UE", http.MethodPost, "/api/v1/ues/1/deregister", "", http.StatusConflict, true},
+		{"release of a new UE", http.MethodDelete, "/api/v1/ues/1/pdu-sessions/1", "", http.StatusConflict, true},
+		{"invalid PDU session ID", http.MethodDelete, "/api/v1/ues/1/pdu-sessions/x", "", http.StatusBadRequest, false},
+		{"handover without a neighbour", http.MethodPost, "/api/v1/ues/1/handover", "", http.StatusBadRequest, true},
+		{"register without a core", http.MethodPost, "/api/v1/ues/1/register", "", http.StatusInternalServerError, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t)
+
+			code, _ := do(t, server, http.MethodPost, "/api/v1/ues", `{"imsi": "`+testIMSI+`"}`)
+			if code != http.StatusCreated {
+				t.Fatalf("could not add UE: %d", code)
+			}
+
+			code, resp := do(t, server, tt.method, tt.path, tt.body)
+			if code != tt.code {
+				t.Fatalf("expected %d, got %d: %s", tt.code, code, resp.Error)
+			}
+
+			if resp.Error == "" {
+				t.Fatal("expected the reason of the failure in error")
+			}
+
+			if (len(resp.Result) > 0) != tt.withState {
+				t.Fatalf("expected result %t, got %s", tt.withState, resp.Result)
+			}
+		})
+	}
+}
+
+func TestGnodeB(t *testing.T) {
+	server := newTestServer(t)
+
+	code, resp := do(t, server, http.MethodGet, "/api/v1/gnb", "")
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+
+	var state control.GnodeBState
+
+	err := json.Unmarshal(resp.Result, &state)
+	if err != nil {
+		t.Fatalf("could not decode gNB: %v", err)
+	}
+
+	if state.ID != "000008" || state.Neighbor != nil {
+		t.Fatalf("unexpected gNB %+v", state)
+	}
+}
@@ -0,0 +1,87 @@
+// Package serve runs the tester as a daemon: it keeps the gNodeB associated
+// with Ella Core and exposes its UEs through a local HTTP API, so that tests
+// written in any language can drive the procedures.
+package serve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/control"
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/metrics"
+	"go.uber.org/zap"
+)
+
+type Config struct {
+	Control control.Config
+	// Address is the address of the HTTP API, such as 127.0.0.1:8080.
+	Address string
+	// MetricsAddress, when set, serves Prometheus metrics on /metrics at this
+	// address.
+	MetricsAddress string
+}
+
+// Run associates the gNodeB and serves the API until ctx is cancelled or an
+// interrupt signal is received, then deregisters the UEs.
+func Run(ctx context.Context, cfg Config) error {
+	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if cfg.MetricsAddress != "" {
+		err := metrics.Serve(ctx, cfg.MetricsAddress)
+		if err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return fmt.Errorf("could not listen for API requests on %s: %v", cfg.Address, err)
+	}
+
+	controller, err := control.Start(cfg.Control)
+	if err != nil {
+		_ = listener.Close()
+		return err
+	}
+
+	defer controller.Close()
+
+	server := &http.Server{
+		Handler:           newHandler(controller),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serveErr := make(chan error, 1)
+
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	logger.Logger.Info("Serving control API", zap.String("address", "http://"+listener.Addr().String()+"/api/v1"))
+
+	select {
+	case <-ctx.Done():
+	case err := <-serveErr:
+		return fmt.Errorf("control API stopped: %v", err)
+	}
+
+	logger.Logger.Info("shutting down")
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelShutdown()
+
+	err = server.Shutdown(shutdownCtx)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Logger.Error("could not stop control API", zap.Error(err))
+	}
+
+	return nil
+}
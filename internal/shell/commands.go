@@ -0,0 +1,387 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/control"
+)
+
+// usage lists the commands, as printed by help.
+const usage = `Commands:
+  gnb                          show the gNB
+  gnb reset                    reset the NG interface; connected UEs become idle
+  ue add <imsi> [key=...] [opc=...] [sqn=...] [dnn=...] [sst=...] [sd=...] [type=...]
+                               add a UE; credentials default to the subscribers file
+  ue list                      list the UEs
+  ue <id>                      show a UE and its PDU sessions
+  ue <id> register             register the UE and establish its first PDU session
+  ue <id> deregister           deregister the UE
+  ue <id> pdu add [dnn] [id=...]
+                               establish a PDU session
+  ue <id> pdu release <sid>    release a PDU session
+  ue <id> idle                 release the UE context, moving the UE to idle
+  ue <id> service-request      bring an idle UE back with a Service Request
+  ue <id> handover             hand the UE over to the other gNB
+  ue <id> remove               deregister the UE if needed and remove it
+  show ues                     list the UEs
+  show sessions                list the PDU sessions of every UE
+  help                         show this help
+  exit                         deregister the UEs and exit`
+
+type shell struct {
+	controller *control.Controller
+	out        io.Writer
+}
+
+// exec runs a command and tells whether the shell should exit.
+func (s *shell) exec(words []string) (bool, error) {
+	if len(words) == 0 {
+		return false, nil
+	}
+
+	switch words[0] {
+	case "exit", "quit":
+		return true, nil
+	case "help", "?":
+		fmt.Fprintln(s.out, usage)
+		return false, nil
+	case "gnb":
+		return false, s.gnb(words[1:])
+	case "ue":
+		return false, s.ue(words[1:])
+	case "show":
+		return false, s.show(words[1:])
+	default:
+		return false, fmt.Errorf("unknown command %q, type \"help\" for the list of commands", words[0])
+	}
+}
+
+func (s *shell) gnb(args []string) error {
+	switch {
+	case len(args) == 0:
+		s.writeGnodeB(s.controller.GnodeB())
+		return nil
+	case len(args) == 1 && args[0] == "reset":
+		err := s.controller.ResetGnodeB()
+		if err != nil {
+			return err
+		}
+
+		return s.writeUEs(s.controller.UEs())
+	default:
+		return fmt.Errorf("usage: gnb [reset]")
+	}
+}
+
+func (s *shell) show(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: show ues|sessions|gnb")
+	}
+
+	switch args[0] {
+	case "ues":
+		return s.writeUEs(s.controller.UEs())
+	case "sessions":
+		return s.writeSessions(s.controller.UEs())
+	case "gnb":
+		s.writeGnodeB(s.controller.GnodeB())
+		return nil
+	default:
+		return fmt.Errorf("usage: show ues|sessions|gnb")
+	}
+}
+
+func (s *shell) ue(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: ue add|list|<id> ...")
+	}
+
+	switch args[0] {
+	case "add":
+		return s.addUE(args[1:])
+	case "list":
+		return s.writeUEs(s.controller.UEs())
+	}
+
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid UE ID %q", args[0])
+	}
+
+	if len(args) == 1 {
+		state, err := s.controller.UE(id)
+		if err != nil {
+			return err
+		}
+
+		s.writeUE(state)
+
+		return nil
+	}
+
+	var procedure func(int64) (control.UEState, error)
+
+	switch args[1] {
+	case "register":
+		procedure = s.controller.Register
+	case "deregister":
+		procedure = s.controller.Deregister
+	case "idle":
+		procedure = s.controller.Idle
+	case "service-request":
+		procedure = s.controller.ServiceRequest
+	case "handover":
+		procedure = s.controller.Handover
+	case "pdu":
+		return s.pduSession(id, args[2:])
+	case "remove":
+		err := s.controller.RemoveUE(id)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(s.out, "removed UE %d\n", id)
+
+		return nil
+	default:
+		return fmt.Errorf("unknown UE command %q, type \"help\" for the list of commands", args[1])
+	}
+
+	return s.runProcedure(func() (control.UEState, error) { return procedure(id) })
+}
+
+func (s *shell) addUE(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: ue add <imsi> [key=...] [opc=...] [sqn=...] [dnn=...] [sst=...] [sd=...] [type=...]")
+	}
+
+	params := control.NewUE{IMSI: args[0]}
+
+	for _, arg := range args[1:] {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("invalid argument %q: must be name=value", arg)
+		}
+
+		switch name {
+		case "key":
+			params.Key = value
+		case "opc":
+			params.OPC = value
+		case "sqn":
+			params.SQN = value
+		case "dnn":
+			params.DNN = value
+		case "sst":
+			sst, err := strconv.ParseInt(value, 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid SST %q", value)
+			}
+
+			params.SST = int32(sst)
+		case "sd":
+			params.SD = value
+		case "type":
+			params.PDUSessionType = value
+		default:
+			return fmt.Errorf("unknown argument %q", name)
+		}
+	}
+
+	state, err := s.controller.AddUE(params)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(s.out, "added UE %d (%s)\n", state.ID, state.IMSI)
+
+	return nil
+}
+
+func (s *shell) pduSession(id int64, args []string) error {
+	if len(args) > 0 && args[0] == "release" {
+		return s.releasePDUSession(id, args[1:])
+	}
+
+	if len(args) == 0 || args[0] != "add" || len(args) > 3 {
+		return fmt.Errorf("usage: ue <id> pdu add [dnn] [id=...] | ue <id> pdu release <sid>")
+	}
+
+	var (
+		dnn       string
+		sessionID uint8
+	)
+
+	for _, arg := range args[1:] {
+		value, ok := strings.CutPrefix(arg, "id=")
+		if !ok {
+			dnn = arg
+			continue
+		}
+
+		parsed, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return fmt.Errorf("invalid PDU session ID %q", value)
+		}
+
+		sessionID = uint8(parsed)
+	}
+
+	if sessionID == 0 {
+		var err error
+
+		sessionID, err = s.controller.NextPDUSessionID(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.runProcedure(func() (control.UEState, error) {
+		return s.controller.AddPDUSession(id, sessionID, dnn)
+	})
+}
+
+func (s *shell) releasePDUSession(id int64, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: ue <id> pdu release <sid>")
+	}
+
+	sessionID, err := strconv.ParseUint(args[0], 10, 8)
+	if err != nil {
+		return fmt.Errorf("invalid PDU session ID %q", args[0])
+	}
+
+	return s.runProcedure(func() (control.UEState, error) {
+		return s.controller.ReleasePDUSession(id, uint8(sessionID))
+	})
+}
+
+// runProcedure runs a procedure and shows the state it left the UE in, also
+// when it failed.
+func (s *shell) runProcedure(procedure func() (control.UEState, error)) error {
+	start := time.Now()
+	state, err := procedure()
+
+	if state.ID != 0 {
+		s.writeUE(state)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(s.out, "done in %s\n", time.Since(start).Round(time.Millisecond))
+
+	return nil
+}
+
+func (s *shell) writeGnodeB(state control.GnodeBState) {
+	fmt.Fprintf(s.out, "gNB %s (%s), PLMN %s-%s, TAC %s, associated since %s, %d UEs\n",
+		state.ID, state.Name, state.MCC, state.MNC, state.TAC, state.Since.Format(time.TimeOnly), state.UEs)
+
+	if n := state.Neighbor; n != nil {
+		fmt.Fprintf(s.out, "neighbour gNB %s (%s), %d UEs\n", n.ID, n.Name, n.UEs)
+	}
+}
+
+func (s *shell) writeUE(state control.UEState) {
+	fmt.Fprintf(s.out, "UE %d: IMSI %s, %s on gNB %s", state.ID, state.IMSI, state.State, state.GnbID)
+
+	if state.AMFUENGAPID != 0 {
+		fmt.Fprintf(s.out, ", AMF UE NGAP ID %d", state.AMFUENGAPID)
+	}
+
+	fmt.Fprintln(s.out)
+
+	for _, session := range state.PDUSessions {
+		fmt.Fprintf(s.out, "  PDU session %d: %s\n", session.ID, describeSession(session))
+	}
+}
+
+func (s *shell) writeUEs(states []control.UEState) error {
+	if len(states) == 0 {
+		fmt.Fprintln(s.out, "no UEs")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(s.out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "ID\tIMSI\tSTATE\tAMF UE NGAP ID\tPDU SESSIONS")
+
+	for _, state := range states {
+		ids := make([]string, 0, len(state.PDUSessions))
+		for _, session := range state.PDUSessions {
+			ids = append(ids, strconv.Itoa(int(session.ID)))
+		}
+
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", state.ID, state.IMSI, state.State, optional(state.AMFUENGAPID), strings.Join(ids, ","))
+	}
+
+	return tw.Flush()
+}
+
+func (s *shell) writeSessions(states []control.UEState) error {
+	tw := tabwriter.NewWriter(s.out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "UE\tID\tUE IP\tQFI\tSSC\tUPF\tUL TEID\tDL TEID")
+
+	for _, state := range states {
+		for _, session := range state.PDUSessions {
+			fmt.Fprintf(tw, "%d\t%d\t%s\t%d\t%d\t%s\t%s\t%s\n",
+				state.ID, session.ID, addresses(session), session.QFI, session.SSCMode,
+				orDash(session.UPFAddress), optional(int64(session.ULTEID)), optional(int64(session.DLTEID)))
+		}
+	}
+
+	return tw.Flush()
+}
+
+func describeSession(session control.PDUSessionState) string {
+	desc := fmt.Sprintf("UE IP %s, QFI %d, SSC mode %d", addresses(session), session.QFI, session.SSCMode)
+
+	if session.UPFAddress != "" {
+		desc += fmt.Sprintf(", UPF %s, UL TEID %d, DL TEID %d", session.UPFAddress, session.ULTEID, session.DLTEID)
+	} else {
+		desc += ", no user plane"
+	}
+
+	return desc
+}
+
+func addresses(session control.PDUSessionState) string {
+	var ips []string
+
+	for _, ip := range []string{session.UEIP, session.UEIPv6} {
+		if ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+
+	if len(ips) == 0 {
+		return "-"
+	}
+
+	return strings.Join(ips, ",")
+}
+
+// optional formats an ID, or a dash when it is not set.
+func optional(v int64) string {
+	if v == 0 {
+		return "-"
+	}
+
+	return strconv.FormatInt(v, 10)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
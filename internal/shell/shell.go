@@ -0,0 +1,78 @@
+// Package shell is an interactive prompt attached to a gNodeB associated
+// with Ella Core, which runs the procedures of its UEs one command at a time,
+// to try message sequences by hand.
+package shell
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/ellanetworks/core-tester/internal/control"
+)
+
+const prompt = "core-tester> "
+
+// Run associates the gNodeB and reads commands from in until exit, the end
+// of the input or an interrupt signal, then deregisters the UEs.
+func Run(ctx context.Context, cfg control.Config, in io.Reader, out io.Writer) error {
+	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	controller, err := control.Start(cfg)
+	if err != nil {
+		return err
+	}
+
+	defer controller.Close()
+
+	s := &shell{controller: controller, out: out}
+	lines := readLines(in)
+
+	fmt.Fprintln(out, `Type "help" for the list of commands.`)
+
+	for {
+		fmt.Fprint(out, prompt)
+
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(out)
+			return nil
+		case line, ok := <-lines:
+			if !ok {
+				fmt.Fprintln(out)
+				return nil
+			}
+
+			quit, err := s.exec(strings.Fields(line))
+			if err != nil {
+				fmt.Fprintf(out, "error: %v\n", err)
+			}
+
+			if quit {
+				return nil
+			}
+		}
+	}
+}
+
+// readLines sends the lines of r until its end, so that a blocked read does
+// not keep the prompt from stopping on a signal.
+func readLines(r io.Reader) <-chan string {
+	lines := make(chan string)
+
+	go func() {
+		defer close(lines)
+
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	return lines
+}
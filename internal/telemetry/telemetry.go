@@ -0,0 +1,103 @@
+// Package telemetry exports the procedures of the UEs as OpenTelemetry
+// traces, to an OTLP collector or to a file, so that they can be lined up
+// with the traces of the core.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const serviceName = "ella-core-tester"
+
+// Attributes of the spans. Those of the gNodeB and the UEs correlate them
+// with the spans of the core.
+const (
+	AttributeGnbID       = attribute.Key("gnb.id")
+	AttributeSUPI        = attribute.Key("ue.supi")
+	AttributeRANUENGAPID = attribute.Key("ngap.ran_ue_ngap_id")
+	AttributeAMFUENGAPID = attribute.Key("ngap.amf_ue_ngap_id")
+	AttributeNASMessage  = attribute.Key("nas.message")
+	AttributeNASAnswer   = attribute.Key("nas.answer")
+)
+
+// Config selects where spans are exported. With neither set, spans are not
+// recorded.
+type Config struct {
+	// Endpoint is the URL of an OTLP gRPC collector, such as
+	// http://localhost:4317. The http scheme disables TLS.
+	Endpoint string
+	// File receives every span as a line of JSON.
+	File string
+}
+
+// Start installs the global tracer provider and returns the function that
+// flushes the pending spans and stops it.
+func Start(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	var (
+		opts    []sdktrace.TracerProviderOption
+		closers []func() error
+	)
+
+	if cfg.Endpoint != "" {
+		exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpointURL(cfg.Endpoint))
+		if err != nil {
+			return nil, fmt.Errorf("could not create OTLP exporter: %v", err)
+		}
+
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	if cfg.File != "" {
+		f, err := os.Create(cfg.File)
+		if err != nil {
+			return nil, fmt.Errorf("could not create span file: %v", err)
+		}
+
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("could not create file exporter: %v", err)
+		}
+
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+		closers = append(closers, f.Close)
+	}
+
+	if len(opts) == 0 {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", serviceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("could not build telemetry resource: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(append(opts, sdktrace.WithResource(res))...)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		errs := []error{provider.Shutdown(ctx)}
+		for _, c := range closers {
+			errs = append(errs, c())
+		}
+
+		err := errors.Join(errs...)
+		if err != nil {
+			return fmt.Errorf("could not flush spans: %v", err)
+		}
+
+		return nil
+	}, nil
+}
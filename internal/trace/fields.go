@@ -0,0 +1,121 @@
+package trace
+
+import (
+	"encoding/hex"
+	"reflect"
+)
+
+// Choice returns the name and value of the alternative selected in a
+// decoded ASN.1 CHOICE or NAS message union: the first non-nil pointer
+// field of the struct v points to.
+func Choice(v any) (string, any, bool) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return "", nil, false
+	}
+
+	for i := range rv.NumField() {
+		field := rv.Field(i)
+		if !rv.Type().Field(i).IsExported() || field.Kind() != reflect.Pointer || field.IsNil() {
+			continue
+		}
+
+		return rv.Type().Field(i).Name, field.Interface(), true
+	}
+
+	return "", nil, false
+}
+
+// Fields returns the fields of the struct v points to that hold a value,
+// keyed by field name and converted with Plain, except those skip matches.
+// Absent optional IEs, which decode to nil pointers, are left out.
+func Fields(v any, skip func(name string) bool) map[string]any {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields := make(map[string]any)
+
+	for i := range rv.NumField() {
+		sf := rv.Type().Field(i)
+		if !sf.IsExported() || skip != nil && skip(sf.Name) {
+			continue
+		}
+
+		field := rv.Field(i)
+		if isNil(field) {
+			continue
+		}
+
+		fields[sf.Name] = plain(field)
+	}
+
+	return fields
+}
+
+// Plain converts a decoded message value to JSON-friendly values: structs
+// become maps of their exported, non-nil fields and byte strings become
+// hex.
+func Plain(v any) any {
+	return plain(reflect.ValueOf(v))
+}
+
+func plain(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+
+		return plain(v.Elem())
+	case reflect.Struct:
+		m := make(map[string]any, v.NumField())
+
+		for i := range v.NumField() {
+			field := v.Field(i)
+			if !v.Type().Field(i).IsExported() || isNil(field) {
+				continue
+			}
+
+			m[v.Type().Field(i).Name] = plain(field)
+		}
+
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+
+			return hex.EncodeToString(b)
+		}
+
+		s := make([]any, v.Len())
+		for i := range v.Len() {
+			s[i] = plain(v.Index(i))
+		}
+
+		return s
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint()
+	case reflect.String:
+		return v.String()
+	default:
+		return v.String()
+	}
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
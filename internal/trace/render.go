@@ -0,0 +1,118 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+const (
+	timeLayout   = "15:04:05.000000"
+	minLaneWidth = 12
+)
+
+// participants returns the participants of the events, the network
+// elements first in their natural order.
+func participants(events []Event) []string {
+	names := []string{UE, GNB, AMF}
+
+	for _, e := range events {
+		for _, name := range []string{e.From, e.To} {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names
+}
+
+// WriteText renders the events as a text ladder diagram, one message per
+// line, with the time of each message in the left margin.
+func WriteText(w io.Writer, events []Event) error {
+	names := participants(events)
+
+	width := minLaneWidth
+	for _, e := range events {
+		width = max(width, len(e.Message)+6)
+	}
+
+	for _, name := range names {
+		width = max(width, len(name)+2)
+	}
+
+	margin := strings.Repeat(" ", len(timeLayout)+2)
+
+	header := []byte(strings.Repeat(" ", width*len(names)))
+	for i, name := range names {
+		copy(header[i*width+(width-len(name))/2:], name)
+	}
+
+	_, err := fmt.Fprintf(w, "%s%s\n", margin, strings.TrimRight(string(header), " "))
+	if err != nil {
+		return err
+	}
+
+	for _, e := range events {
+		line := []byte(strings.Repeat(" ", width*len(names)))
+		for i := range names {
+			line[i*width+width/2] = '|'
+		}
+
+		from := slices.Index(names, e.From)*width + width/2
+		to := slices.Index(names, e.To)*width + width/2
+		left, right := min(from, to), max(from, to)
+
+		for x := left + 1; x < right; x++ {
+			line[x] = '-'
+		}
+
+		if to > from {
+			line[right-1] = '>'
+		} else {
+			line[left+1] = '<'
+		}
+
+		label := " " + e.Message + " "
+		copy(line[left+1+(right-left-1-len(label))/2:], label)
+
+		_, err := fmt.Fprintf(w, "%s  %s\n", e.Time.Format(timeLayout), strings.TrimRight(string(line), " "))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteMermaid renders the events as a Mermaid sequence diagram. NGAP
+// messages are drawn as solid arrows and NAS messages as dotted ones.
+func WriteMermaid(w io.Writer, events []Event) error {
+	var b strings.Builder
+
+	b.WriteString("sequenceDiagram\n")
+
+	for _, name := range participants(events) {
+		fmt.Fprintf(&b, "    participant %s\n", name)
+	}
+
+	for _, e := range events {
+		arrow := "->>"
+		if e.Protocol == ProtocolNAS {
+			arrow = "-->>"
+		}
+
+		fmt.Fprintf(&b, "    %s%s%s: %s\n", e.From, arrow, e.To, mermaidText(e.Message))
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+// mermaidText removes the characters that end a Mermaid statement or start
+// an entity code.
+func mermaidText(s string) string {
+	return strings.NewReplacer(";", ",", "#", "", "\n", " ").Replace(s)
+}
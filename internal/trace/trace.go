@@ -0,0 +1,132 @@
+// Package trace records the NGAP procedures and NAS messages exchanged
+// during a run as structured events, written as JSON lines, and renders
+// them as sequence diagrams.
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Participants of the message sequence.
+const (
+	UE  = "UE"
+	GNB = "gNB"
+	AMF = "AMF"
+)
+
+// Protocols of the recorded messages.
+const (
+	ProtocolNGAP = "NGAP"
+	ProtocolNAS  = "NAS"
+)
+
+// Direction of a message relative to the core network.
+const (
+	Uplink   = "uplink"
+	Downlink = "downlink"
+)
+
+// Event is one NGAP or NAS message. NAS messages carry their plaintext IEs,
+// even when they travelled ciphered.
+type Event struct {
+	Time        time.Time      `json:"time"`
+	Protocol    string         `json:"protocol"`
+	Direction   string         `json:"direction"`
+	From        string         `json:"from"`
+	To          string         `json:"to"`
+	Message     string         `json:"message"`
+	RANUENGAPID *int64         `json:"ran_ue_ngap_id,omitempty"`
+	AMFUENGAPID *int64         `json:"amf_ue_ngap_id,omitempty"`
+	IMSI        string         `json:"imsi,omitempty"`
+	IEs         map[string]any `json:"ies,omitempty"`
+}
+
+// Recorder writes events as JSON lines as they happen, so that the trace of
+// a run that fails or hangs is complete up to that point. A nil Recorder
+// records nothing. It is safe for concurrent use.
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Record writes the event, stamping it with the current time if it has
+// none. The first write error is kept and returned by Err.
+func (r *Recorder) Record(e Event) {
+	if r == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	err := r.enc.Encode(e)
+	if err != nil {
+		r.err = fmt.Errorf("could not write trace event: %v", err)
+	}
+}
+
+// Err returns the first error met while writing events.
+func (r *Recorder) Err() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+// Enabled reports whether events are recorded, so that callers can skip
+// decoding IEs otherwise.
+func (r *Recorder) Enabled() bool {
+	return r != nil
+}
+
+// Read parses a trace written by a Recorder.
+func Read(r io.Reader) ([]Event, error) {
+	var events []Event
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var e Event
+
+		err := json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse trace event on line %d: %v", line, err)
+		}
+
+		events = append(events, e)
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("could not read trace: %v", err)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,182 @@
+package traffic
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+const (
+	protocolICMPv4 = 1
+	protocolUDP    = 17
+	protocolICMPv6 = 58
+
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	udpHeaderLen  = 8
+	icmpHeaderLen = 8
+	defaultTTL    = 64
+
+	icmpv4EchoRequest = 8
+	icmpv4EchoReply   = 0
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+)
+
+// probeMagic marks the payload of packets generated by the tester, so that
+// replies can be told apart from other downlink traffic.
+var probeMagic = [4]byte{'E', 'L', 'L', 'A'}
+
+// probeHeaderLen is the size of the probe header placed at the start of
+// every payload: magic(4) run ID(4) sequence number(8) send time(8).
+const probeHeaderLen = 24
+
+type probe struct {
+	runID  uint32
+	seq    uint64
+	sentAt int64 // Unix nanoseconds
+}
+
+func putProbe(b []byte, p probe) {
+	copy(b[0:4], probeMagic[:])
+	binary.BigEndian.PutUint32(b[4:8], p.runID)
+	binary.BigEndian.PutUint64(b[8:16], p.seq)
+	binary.BigEndian.PutUint64(b[16:24], uint64(p.sentAt))
+}
+
+func parseProbe(b []byte) (probe, bool) {
+	if len(b) < probeHeaderLen || [4]byte(b[0:4]) != probeMagic {
+		return probe{}, false
+	}
+
+	return probe{
+		runID:  binary.BigEndian.Uint32(b[4:8]),
+		seq:    binary.BigEndian.Uint64(b[8:16]),
+		sentAt: int64(binary.BigEndian.Uint64(b[16:24])),
+	}, true
+}
+
+// buildPacket wraps a transport segment (UDP datagram or ICMP message,
+// checksum field zeroed) in an IPv4 or IPv6 header and fills in the
+// checksums.
+func buildPacket(src, dst netip.Addr, protocol uint8, segment []byte, id uint16) []byte {
+	if src.Is4() {
+		packet := make([]byte, ipv4HeaderLen+len(segment))
+		packet[0] = 0x45                                             // Version 4, IHL 5
+		binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet))) // Total length
+		binary.BigEndian.PutUint16(packet[4:6], id)                  // Identification
+		packet[8] = defaultTTL                                       // TTL
+		packet[9] = protocol                                         // Protocol
+		copy(packet[12:16], src.AsSlice())                           // Source
+		copy(packet[16:20], dst.AsSlice())                           // Destination
+		binary.BigEndian.PutUint16(packet[10:12], checksum(packet[:ipv4HeaderLen], 0))
+		copy(packet[ipv4HeaderLen:], segment)
+
+		transport := packet[ipv4HeaderLen:]
+
+		switch protocol {
+		case protocolUDP:
+			putUDPChecksum(transport, pseudoHeaderSum(src, dst, protocol, len(transport)))
+		case protocolICMPv4:
+			binary.BigEndian.PutUint16(transport[2:4], checksum(transport, 0))
+		}
+
+		return packet
+	}
+
+	packet := make([]byte, ipv6HeaderLen+len(segment))
+	packet[0] = 0x60                                              // Version 6
+	binary.BigEndian.PutUint16(packet[4:6], uint16(len(segment))) // Payload length
+	packet[6] = protocol                                          // Next header
+	packet[7] = defaultTTL                                        // Hop limit
+	copy(packet[8:24], src.AsSlice())                             // Source
+	copy(packet[24:40], dst.AsSlice())                            // Destination
+	copy(packet[ipv6HeaderLen:], segment)
+
+	transport := packet[ipv6HeaderLen:]
+	sum := pseudoHeaderSum(src, dst, protocol, len(transport))
+
+	switch protocol {
+	case protocolUDP:
+		putUDPChecksum(transport, sum)
+	case protocolICMPv6:
+		binary.BigEndian.PutUint16(transport[2:4], checksum(transport, sum))
+	}
+
+	return packet
+}
+
+func putUDPChecksum(segment []byte, pseudo uint32) {
+	sum := checksum(segment, pseudo)
+	if sum == 0 {
+		sum = 0xffff // RFC 768: a computed checksum of zero is sent as all ones
+	}
+
+	binary.BigEndian.PutUint16(segment[6:8], sum)
+}
+
+func pseudoHeaderSum(src, dst netip.Addr, protocol uint8, length int) uint32 {
+	var sum uint32
+
+	for _, addr := range [][]byte{src.AsSlice(), dst.AsSlice()} {
+		for i := 0; i < len(addr); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(addr[i : i+2]))
+		}
+	}
+
+	return sum + uint32(protocol) + uint32(length)
+}
+
+// checksum computes the Internet checksum (RFC 1071) of b, starting from a
+// partial sum.
+func checksum(b []byte, sum uint32) uint16 {
+	for ; len(b) >= 2; b = b[2:] {
+		sum += uint32(binary.BigEndian.Uint16(b[:2]))
+	}
+
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+
+	return ^uint16(sum)
+}
+
+// parsePacket returns the source, protocol and transport segment of an IPv4
+// or IPv6 packet. IPv6 extension headers are not followed.
+func parsePacket(b []byte) (netip.Addr, uint8, []byte, bool) {
+	if len(b) == 0 {
+		return netip.Addr{}, 0, nil, false
+	}
+
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < ipv4HeaderLen {
+			return netip.Addr{}, 0, nil, false
+		}
+
+		ihl := int(b[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(b[2:4]))
+
+		if ihl < ipv4HeaderLen || total < ihl || total > len(b) {
+			return netip.Addr{}, 0, nil, false
+		}
+
+		return netip.AddrFrom4([4]byte(b[12:16])), b[9], b[ihl:total], true
+	case 6:
+		if len(b) < ipv6HeaderLen {
+			return netip.Addr{}, 0, nil, false
+		}
+
+		end := ipv6HeaderLen + int(binary.BigEndian.Uint16(b[4:6]))
+		if end > len(b) {
+			return netip.Addr{}, 0, nil, false
+		}
+
+		return netip.AddrFrom16([16]byte(b[8:24])), b[6], b[ipv6HeaderLen:end], true
+	default:
+		return netip.Addr{}, 0, nil, false
+	}
+}
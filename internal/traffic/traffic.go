@@ -0,0 +1,367 @@
+// Package traffic generates user plane flows through a GTP-U tunnel and
+// measures the throughput, loss, jitter and round-trip time of the replies.
+package traffic
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/gnb"
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	ProtocolUDP  = "udp"
+	ProtocolICMP = "icmp"
+
+	// sourcePort is the UDP port the flows are sent from on the UE side.
+	sourcePort = 50000
+
+	// drainTimeout is how long replies are still collected after the last
+	// packet has been sent.
+	drainTimeout = time.Second
+
+	// maxRate keeps the interval between two packets at a microsecond or
+	// more.
+	maxRate = 1_000_000
+
+	// defaultMTU is the MTU of a PDU session for which the core gave none.
+	defaultMTU = 1500
+)
+
+type Config struct {
+	Protocol    string
+	Source      netip.Addr     // UE address
+	Destination netip.AddrPort // reflector; the port is ignored for ICMP
+	Rate        int            // packets per second
+	PayloadSize int            // bytes of payload per packet, including the probe header
+	Duration    time.Duration
+	MTU         uint16 // of the PDU session; defaultMTU when unset
+}
+
+// Report summarizes a run. Throughput is measured on IP packets, without the
+// GTP-U encapsulation.
+type Report struct {
+	Protocol       string
+	Destination    string
+	Duration       time.Duration
+	Sent           uint64
+	Received       uint64
+	Duplicates     uint64
+	Late           uint64 // replies too far behind the newest to be told from duplicates
+	Lost           uint64
+	LossPercent    float64
+	UplinkBps      float64
+	DownlinkBps    float64
+	RTTMin         time.Duration
+	RTTAvg         time.Duration
+	RTTMax         time.Duration
+	Jitter         time.Duration // RFC 3550 interarrival jitter of the round-trip times
+	SentBytes      uint64
+	ReceivedBytes  uint64
+	TunnelCounters gnb.TunnelStats
+}
+
+func (c *Config) validate() error {
+	switch c.Protocol {
+	case ProtocolUDP, ProtocolICMP:
+	default:
+		return fmt.Errorf("invalid traffic protocol %q: must be udp or icmp", c.Protocol)
+	}
+
+	if !c.Source.IsValid() {
+		return fmt.Errorf("the tunnel has no UE address")
+	}
+
+	if c.Source.Is4() != c.Destination.Addr().Is4() {
+		return fmt.Errorf("destination %s and UE address %s are not of the same IP family", c.Destination.Addr(), c.Source)
+	}
+
+	if c.Protocol == ProtocolUDP && c.Destination.Port() == 0 {
+		return fmt.Errorf("a destination port is required for UDP traffic")
+	}
+
+	if c.Rate <= 0 || c.Rate > maxRate {
+		return fmt.Errorf("invalid traffic rate %d: must be between 1 and %d packets per second", c.Rate, maxRate)
+	}
+
+	if c.PayloadSize < probeHeaderLen || c.PayloadSize > c.maxPayloadSize() {
+		return fmt.Errorf("invalid payload size %d: must be between %d and %d bytes", c.PayloadSize, probeHeaderLen, c.maxPayloadSize())
+	}
+
+	if c.Duration <= 0 {
+		return fmt.Errorf("invalid traffic duration %s: must be positive", c.Duration)
+	}
+
+	return nil
+}
+
+// maxPayloadSize is the largest payload whose packet fits in the MTU of the
+// PDU session, so that the packets are not fragmented.
+func (c *Config) maxPayloadSize() int {
+	mtu := int(cmp.Or(c.MTU, defaultMTU))
+
+	headers := ipv4HeaderLen
+	if c.Source.Is6() {
+		headers = ipv6HeaderLen
+	}
+
+	if c.Protocol == ProtocolUDP {
+		headers += udpHeaderLen
+	} else {
+		headers += icmpHeaderLen
+	}
+
+	return mtu - headers
+}
+
+type collector struct {
+	mu            sync.Mutex
+	runID         uint32
+	cfg           Config
+	seen          *seqWindow
+	received      uint64
+	duplicates    uint64
+	late          uint64
+	receivedBytes uint64
+	rttSum        time.Duration
+	rttMin        time.Duration
+	rttMax        time.Duration
+	lastRTT       time.Duration
+	jitter        float64
+	first, last   time.Time
+}
+
+// Run sends the configured flow through the tunnel and collects the replies
+// until the duration has elapsed or ctx is cancelled.
+func Run(ctx context.Context, g *gnb.GnodeB, t *gnb.Tunnel, cfg Config) (*Report, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	c := &collector{
+		runID: rand.Uint32(),
+		cfg:   cfg,
+		seen:  newSeqWindow(),
+	}
+
+	t.SetDownlinkHook(c.handleDownlink)
+	defer t.SetDownlinkHook(nil)
+
+	interval := time.Second / time.Duration(cfg.Rate)
+	start := time.Now()
+	deadline := start.Add(cfg.Duration)
+
+	var (
+		sent      uint64
+		sentBytes uint64
+	)
+
+	logger.Logger.Info(
+		"Starting traffic generation",
+		zap.String("protocol", cfg.Protocol),
+		zap.String("source", cfg.Source.String()),
+		zap.String("destination", cfg.Destination.String()),
+		zap.Int("rate", cfg.Rate),
+		zap.Int("payload size", cfg.PayloadSize),
+		zap.Duration("duration", cfg.Duration),
+	)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+send:
+	for next := start; next.Before(deadline); next = next.Add(interval) {
+		timer.Reset(time.Until(next))
+
+		select {
+		case <-ctx.Done():
+			break send
+		case <-timer.C:
+		}
+
+		packet := c.buildRequest(sent)
+
+		err := g.SendUplink(t, packet)
+		if err != nil {
+			return nil, fmt.Errorf("could not send packet %d: %v", sent, err)
+		}
+
+		sent++
+		sentBytes += uint64(len(packet))
+	}
+
+	elapsed := time.Since(start)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(drainTimeout):
+	}
+
+	return c.report(sent, sentBytes, elapsed, t.Stats()), nil
+}
+
+func (c *collector) buildRequest(seq uint64) []byte {
+	payload := make([]byte, c.cfg.PayloadSize)
+	putProbe(payload, probe{runID: c.runID, seq: seq, sentAt: time.Now().UnixNano()})
+
+	if c.cfg.Protocol == ProtocolUDP {
+		segment := make([]byte, udpHeaderLen+len(payload))
+		binary.BigEndian.PutUint16(segment[0:2], sourcePort)
+		binary.BigEndian.PutUint16(segment[2:4], c.cfg.Destination.Port())
+		binary.BigEndian.PutUint16(segment[4:6], uint16(len(segment)))
+		copy(segment[udpHeaderLen:], payload)
+
+		return buildPacket(c.cfg.Source, c.cfg.Destination.Addr(), protocolUDP, segment, uint16(seq))
+	}
+
+	protocol := uint8(protocolICMPv4)
+	icmpType := uint8(icmpv4EchoRequest)
+
+	if c.cfg.Source.Is6() {
+		protocol = protocolICMPv6
+		icmpType = icmpv6EchoRequest
+	}
+
+	segment := make([]byte, icmpHeaderLen+len(payload))
+	segment[0] = icmpType
+	binary.BigEndian.PutUint16(segment[4:6], uint16(c.runID)) // Identifier
+	binary.BigEndian.PutUint16(segment[6:8], uint16(seq))     // Sequence number
+	copy(segment[icmpHeaderLen:], payload)
+
+	return buildPacket(c.cfg.Source, c.cfg.Destination.Addr(), protocol, segment, uint16(seq))
+}
+
+// handleDownlink consumes the replies to the generated flow. Any other
+// downlink packet is left to the tunnel's device.
+func (c *collector) handleDownlink(packet []byte) bool {
+	now := time.Now()
+
+	src, protocol, segment, ok := parsePacket(packet)
+	if !ok || src != c.cfg.Destination.Addr() {
+		return false
+	}
+
+	var payload []byte
+
+	switch {
+	case c.cfg.Protocol == ProtocolUDP && protocol == protocolUDP:
+		if len(segment) < udpHeaderLen ||
+			binary.BigEndian.Uint16(segment[0:2]) != c.cfg.Destination.Port() ||
+			binary.BigEndian.Uint16(segment[2:4]) != sourcePort {
+			return false
+		}
+
+		payload = segment[udpHeaderLen:]
+	case c.cfg.Protocol == ProtocolICMP && (protocol == protocolICMPv4 || protocol == protocolICMPv6):
+		if len(segment) < icmpHeaderLen ||
+			(segment[0] != icmpv4EchoReply && segment[0] != icmpv6EchoReply) ||
+			binary.BigEndian.Uint16(segment[4:6]) != uint16(c.runID) {
+			return false
+		}
+
+		payload = segment[icmpHeaderLen:]
+	default:
+		return false
+	}
+
+	p, ok := parseProbe(payload)
+	if !ok || p.runID != c.runID {
+		return false
+	}
+
+	rtt := now.Sub(time.Unix(0, p.sentAt))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.seen.add(p.seq) {
+	case seqDuplicate:
+		c.duplicates++
+		return true
+	case seqLate:
+		c.late++
+		return true
+	}
+
+	c.received++
+	c.receivedBytes += uint64(len(packet))
+	c.rttSum += rtt
+
+	if c.received == 1 {
+		c.rttMin, c.rttMax = rtt, rtt
+		c.first = now
+	} else {
+		c.rttMin = min(c.rttMin, rtt)
+		c.rttMax = max(c.rttMax, rtt)
+
+		d := float64(rtt - c.lastRTT)
+		if d < 0 {
+			d = -d
+		}
+
+		c.jitter += (d - c.jitter) / 16
+	}
+
+	c.lastRTT = rtt
+	c.last = now
+
+	return true
+}
+
+func (c *collector) report(sent uint64, sentBytes uint64, elapsed time.Duration, counters gnb.TunnelStats) *Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r := &Report{
+		Protocol:       c.cfg.Protocol,
+		Destination:    c.cfg.Destination.String(),
+		Duration:       elapsed,
+		Sent:           sent,
+		Received:       c.received,
+		Duplicates:     c.duplicates,
+		Late:           c.late,
+		SentBytes:      sentBytes,
+		ReceivedBytes:  c.receivedBytes,
+		RTTMin:         c.rttMin,
+		RTTMax:         c.rttMax,
+		Jitter:         time.Duration(c.jitter),
+		TunnelCounters: counters,
+	}
+
+	if sent > c.received+c.late {
+		r.Lost = sent - c.received - c.late
+	}
+
+	if sent > 0 {
+		r.LossPercent = float64(r.Lost) * 100 / float64(sent)
+	}
+
+	if elapsed > 0 {
+		r.UplinkBps = float64(sentBytes*8) / elapsed.Seconds()
+	}
+
+	if c.received > 0 {
+		r.RTTAvg = c.rttSum / time.Duration(c.received)
+
+		// Downlink throughput is measured over the reply window; a single
+		// reply falls back to the sending window.
+		window := c.last.Sub(c.first)
+		if window <= 0 {
+			window = elapsed
+		}
+
+		if window > 0 {
+			r.DownlinkBps = float64(c.receivedBytes*8) / window.Seconds()
+		}
+	}
+
+	return r
+}
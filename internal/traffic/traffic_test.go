@@ -0,0 +1,265 @@
+package traffic
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/gnb"
+)
+
+var (
+	ue4 = netip.MustParseAddr("10.45.0.2")
+	ue6 = netip.MustParseAddr("2001:db8::2")
+
+	reflector4 = netip.MustParseAddrPort("10.0.0.10:7")
+	reflector6 = netip.MustParseAddrPort("[2001:db8:1::10]:7")
+)
+
+func validConfig() Config {
+	return Config{
+		Protocol:    ProtocolUDP,
+		Source:      ue4,
+		Destination: reflector4,
+		Rate:        100,
+		PayloadSize: 100,
+		Duration:    time.Second,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		valid  bool
+	}{
+		{name: "valid", modify: func(*Config) {}, valid: true},
+		{name: "unknown protocol", modify: func(c *Config) { c.Protocol = "tcp" }},
+		{name: "no UE address", modify: func(c *Config) { c.Source = netip.Addr{} }},
+		{name: "mixed families", modify: func(c *Config) { c.Destination = reflector6 }},
+		{name: "UDP without port", modify: func(c *Config) { c.Destination = netip.AddrPortFrom(reflector4.Addr(), 0) }},
+		{name: "ICMP without port", modify: func(c *Config) { c.Protocol, c.Destination = ProtocolICMP, netip.AddrPortFrom(reflector4.Addr(), 0) }, valid: true},
+		{name: "zero rate", modify: func(c *Config) { c.Rate = 0 }},
+		{name: "maximum rate", modify: func(c *Config) { c.Rate = maxRate }, valid: true},
+		{name: "rate above maximum", modify: func(c *Config) { c.Rate = maxRate + 1 }},
+		{name: "payload smaller than the probe", modify: func(c *Config) { c.PayloadSize = probeHeaderLen - 1 }},
+		{name: "payload of the probe only", modify: func(c *Config) { c.PayloadSize = probeHeaderLen }, valid: true},
+		{name: "payload filling the MTU", modify: func(c *Config) { c.PayloadSize = 1472 }, valid: true},
+		{name: "payload above the MTU", modify: func(c *Config) { c.PayloadSize = 1473 }},
+		{name: "payload above a small MTU", modify: func(c *Config) { c.MTU, c.PayloadSize = 1400, 1373 }},
+		{name: "zero duration", modify: func(c *Config) { c.Duration = 0 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.modify(&cfg)
+
+			err := cfg.validate()
+			if tt.valid && err != nil {
+				t.Fatalf("expected a valid config, got %v", err)
+			}
+
+			if !tt.valid && err == nil {
+				t.Fatalf("expected an invalid config")
+			}
+		})
+	}
+}
+
+func TestMaxPayloadSize(t *testing.T) {
+	tests := []struct {
+		protocol string
+		source   netip.Addr
+		mtu      uint16
+		want     int
+	}{
+		{protocol: ProtocolUDP, source: ue4, want: 1500 - 20 - 8},
+		{protocol: ProtocolICMP, source: ue4, want: 1500 - 20 - 8},
+		{protocol: ProtocolUDP, source: ue6, want: 1500 - 40 - 8},
+		{protocol: ProtocolICMP, source: ue6, mtu: 1400, want: 1400 - 40 - 8},
+	}
+
+	for _, tt := range tests {
+		cfg := Config{Protocol: tt.protocol, Source: tt.source, MTU: tt.mtu}
+
+		if got := cfg.maxPayloadSize(); got != tt.want {
+			t.Errorf("%s from %s with MTU %d: expected %d, got %d", tt.protocol, tt.source, tt.mtu, tt.want, got)
+		}
+	}
+}
+
+// reflect answers a request as the reflector or the destination of a ping
+// would.
+func reflect(t *testing.T, request []byte) []byte {
+	t.Helper()
+
+	src, protocol, segment, ok := parsePacket(request)
+	if !ok {
+		t.Fatalf("could not parse request")
+	}
+
+	var dst netip.Addr
+	if src.Is4() {
+		dst = netip.AddrFrom4([4]byte(request[16:20]))
+	} else {
+		dst = netip.AddrFrom16([16]byte(request[24:40]))
+	}
+
+	reply := append([]byte(nil), segment...)
+
+	switch protocol {
+	case protocolUDP:
+		binary.BigEndian.PutUint16(reply[0:2], binary.BigEndian.Uint16(segment[2:4]))
+		binary.BigEndian.PutUint16(reply[2:4], binary.BigEndian.Uint16(segment[0:2]))
+		binary.BigEndian.PutUint16(reply[6:8], 0)
+	case protocolICMPv4:
+		reply[0] = icmpv4EchoReply
+		binary.BigEndian.PutUint16(reply[2:4], 0)
+	case protocolICMPv6:
+		reply[0] = icmpv6EchoReply
+		binary.BigEndian.PutUint16(reply[2:4], 0)
+	}
+
+	return buildPacket(dst, src, protocol, reply, 0)
+}
+
+func newTestCollector(cfg Config) *collector {
+	return &collector{runID: 0x1234, cfg: cfg, seen: newSeqWindow()}
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name        string
+		protocol    string
+		source      netip.Addr
+		destination netip.AddrPort
+	}{
+		{name: "UDP over IPv4", protocol: ProtocolUDP, source: ue4, destination: reflector4},
+		{name: "ICMP over IPv4", protocol: ProtocolICMP, source: ue4, destination: reflector4},
+		{name: "UDP over IPv6", protocol: ProtocolUDP, source: ue6, destination: reflector6},
+		{name: "ICMP over IPv6", protocol: ProtocolICMP, source: ue6, destination: reflector6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			cfg.Protocol, cfg.Source, cfg.Destination = tt.protocol, tt.source, tt.destination
+
+			c := newTestCollector(cfg)
+
+			request := c.buildRequest(7)
+			if len(request) != len(c.buildRequest(8)) {
+				t.Fatalf("expected requests of the same size")
+			}
+
+			if request[0]>>4 == 6 && tt.source.Is4() || request[0]>>4 == 4 && tt.source.Is6() {
+				t.Fatalf("request is of the wrong IP version")
+			}
+
+			if c.handleDownlink(request) {
+				t.Fatalf("expected the request itself not to be taken for a reply")
+			}
+
+			if !c.handleDownlink(reflect(t, request)) {
+				t.Fatalf("expected the reply to be consumed")
+			}
+
+			if c.received != 1 || c.receivedBytes != uint64(len(request)) {
+				t.Errorf("expected 1 reply of %d bytes, got %d replies of %d bytes", len(request), c.received, c.receivedBytes)
+			}
+
+			other := newTestCollector(cfg)
+			other.runID++
+
+			if other.handleDownlink(reflect(t, request)) {
+				t.Errorf("expected the reply to another run to be left to the device")
+			}
+		})
+	}
+}
+
+// replyAfter answers the request seq as if it had been sent rtt ago.
+func replyAfter(t *testing.T, c *collector, seq uint64, rtt time.Duration) []byte {
+	t.Helper()
+
+	request := c.buildRequest(seq)
+	putProbe(request[ipv4HeaderLen+udpHeaderLen:], probe{runID: c.runID, seq: seq, sentAt: time.Now().Add(-rtt).UnixNano()})
+
+	return reflect(t, request)
+}
+
+func TestReport(t *testing.T) {
+	c := newTestCollector(validConfig())
+
+	// Ten requests: 8 and 9 are lost, 3 is answered twice, and the
+	// round-trip time alternates between 10 and 30 ms.
+	for seq := range uint64(8) {
+		rtt := 10 * time.Millisecond
+		if seq%2 == 1 {
+			rtt = 30 * time.Millisecond
+		}
+
+		c.handleDownlink(replyAfter(t, c, seq, rtt))
+	}
+
+	c.handleDownlink(replyAfter(t, c, 3, 30*time.Millisecond))
+
+	r := c.report(10, 1280, time.Second, gnb.TunnelStats{})
+
+	if r.Sent != 10 || r.Received != 8 || r.Duplicates != 1 || r.Lost != 2 {
+		t.Fatalf("expected 10 sent, 8 received, 1 duplicate and 2 lost, got %d, %d, %d and %d", r.Sent, r.Received, r.Duplicates, r.Lost)
+	}
+
+	if r.LossPercent != 20 {
+		t.Errorf("expected 20%% loss, got %v", r.LossPercent)
+	}
+
+	// Each of the 7 variations of 20 ms moves the jitter 1/16 of the way
+	// towards it.
+	want := 0.0
+	for range 7 {
+		want += (float64(20*time.Millisecond) - want) / 16
+	}
+
+	if d := r.Jitter - time.Duration(want); d < -time.Millisecond || d > time.Millisecond {
+		t.Errorf("expected a jitter of %v, got %v", time.Duration(want), r.Jitter)
+	}
+
+	if r.RTTMin < 10*time.Millisecond || r.RTTMax < 30*time.Millisecond || r.RTTMax > 40*time.Millisecond {
+		t.Errorf("expected RTTs between 10 and 30 ms, got %v to %v", r.RTTMin, r.RTTMax)
+	}
+
+	if r.UplinkBps != 1280*8 {
+		t.Errorf("expected %d bps uplink, got %v", 1280*8, r.UplinkBps)
+	}
+}
+
+func TestSeqWindow(t *testing.T) {
+	w := newSeqWindow()
+
+	steps := []struct {
+		seq  uint64
+		want seqVerdict
+	}{
+		{seq: 5, want: seqNew},
+		{seq: 5, want: seqDuplicate},
+		{seq: 3, want: seqNew},
+		{seq: 3, want: seqDuplicate},
+		{seq: seqWindowSize + 3, want: seqNew},
+		{seq: 3, want: seqLate},
+		{seq: 5, want: seqDuplicate}, // still within the window
+		{seq: 4, want: seqNew},
+		{seq: 4 * seqWindowSize, want: seqNew},
+		{seq: 4*seqWindowSize - 1, want: seqNew},
+		{seq: 4*seqWindowSize - 1, want: seqDuplicate},
+		{seq: 3 * seqWindowSize, want: seqLate},
+	}
+
+	for i, step := range steps {
+		if got := w.add(step.seq); got != step.want {
+			t.Errorf("step %d: expected verdict %d for %d, got %d", i, step.want, step.seq, got)
+		}
+	}
+}
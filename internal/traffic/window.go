@@ -0,0 +1,64 @@
+package traffic
+
+// seqWindowSize is the number of sequence numbers, below the highest one
+// received, whose replies are told apart from duplicates: about a second of
+// reordering at maxRate, in 128 KiB.
+const seqWindowSize = 1 << 20
+
+// seqVerdict classifies a reply by its sequence number.
+type seqVerdict int
+
+const (
+	seqNew seqVerdict = iota
+	seqDuplicate
+	seqLate // older than the window, so it cannot be told from a duplicate
+)
+
+// seqWindow remembers which of the last seqWindowSize sequence numbers were
+// received, in a bitmap indexed by sequence number modulo its size, so that
+// its memory does not grow with the length of the run.
+type seqWindow struct {
+	bits    []uint64
+	highest uint64 // highest sequence number received
+	started bool
+}
+
+func newSeqWindow() *seqWindow {
+	return &seqWindow{bits: make([]uint64, seqWindowSize/64)}
+}
+
+// add records the reply of seq and tells whether it is new.
+func (w *seqWindow) add(seq uint64) seqVerdict {
+	switch {
+	case !w.started:
+		w.started = true
+		w.highest = seq
+	case seq > w.highest:
+		w.advance(seq)
+	case w.highest-seq >= seqWindowSize:
+		return seqLate
+	}
+
+	word, bit := (seq%seqWindowSize)/64, uint64(1)<<(seq%64)
+	if w.bits[word]&bit != 0 {
+		return seqDuplicate
+	}
+
+	w.bits[word] |= bit
+
+	return seqNew
+}
+
+// advance moves the window up to seq, forgetting the sequence numbers that
+// fall out of it.
+func (w *seqWindow) advance(seq uint64) {
+	if seq-w.highest >= seqWindowSize {
+		clear(w.bits)
+	} else {
+		for s := w.highest + 1; s <= seq; s++ {
+			w.bits[(s%seqWindowSize)/64] &^= uint64(1) << (s % 64)
+		}
+	}
+
+	w.highest = seq
+}
@@ -13,6 +13,7 @@ import (
 type PduSessionEstablishmentRequestOpts struct {
 	PDUSessionID   uint8
 	PDUSessionType uint8
+	SSCMode        uint8 // 0 omits the IE and lets the network select the SSC mode
 }
 
 func BuildPduSessionEstablishmentRequest(opts *PduSessionEstablishmentRequestOpts) ([]byte, error) {
@@ -35,13 +36,32 @@ func BuildPduSessionEstablishmentRequest(opts *PduSessionEstablishmentRequestOpt
 	pduSessionEstablishmentRequest.PDUSessionType = nasType.NewPDUSessionType(nasMessage.PDUSessionEstablishmentRequestPDUSessionTypeType)
 	pduSessionEstablishmentRequest.SetPDUSessionTypeValue(opts.PDUSessionType)
 
+	if opts.SSCMode != 0 {
+		pduSessionEstablishmentRequest.SSCMode = nasType.NewSSCMode(nasMessage.PDUSessionEstablishmentRequestSSCModeType)
+		pduSessionEstablishmentRequest.SSCMode.SetSSCMode(opts.SSCMode)
+	}
+
 	pduSessionEstablishmentRequest.ExtendedProtocolConfigurationOptions = nasType.NewExtendedProtocolConfigurationOptions(nasMessage.PDUSessionEstablishmentRequestExtendedProtocolConfigurationOptionsType)
 	protocolConfigurationOptions := nasConvert.NewProtocolConfigurationOptions()
-	protocolConfigurationOptions.AddIPAddressAllocationViaNASSignallingUL()
-	protocolConfigurationOptions.AddDNSServerIPv4AddressRequest()
 
-	if opts.PDUSessionType == nasMessage.PDUSessionTypeIPv6 || opts.PDUSessionType == nasMessage.PDUSessionTypeIPv4IPv6 {
-		protocolConfigurationOptions.AddDNSServerIPv6AddressRequest()
+	switch opts.PDUSessionType {
+	case nasMessage.PDUSessionTypeEthernet:
+		addPCORequest(protocolConfigurationOptions, nasMessage.EthernetFramePayloadMTURequestUL)
+	case nasMessage.PDUSessionTypeUnstructured:
+		addPCORequest(protocolConfigurationOptions, nasMessage.UnstructuredLinkMTURequestUL)
+	default:
+		protocolConfigurationOptions.AddIPAddressAllocationViaNASSignallingUL()
+		protocolConfigurationOptions.AddDNSServerIPv4AddressRequest()
+		addPCORequest(protocolConfigurationOptions, nasMessage.IPv4LinkMTURequestUL)
+
+		if opts.PDUSessionType == nasMessage.PDUSessionTypeIPv4 || opts.PDUSessionType == nasMessage.PDUSessionTypeIPv4IPv6 {
+			addPCORequest(protocolConfigurationOptions, nasMessage.PCSCFIPv4AddressRequestUL)
+		}
+
+		if opts.PDUSessionType == nasMessage.PDUSessionTypeIPv6 || opts.PDUSessionType == nasMessage.PDUSessionTypeIPv4IPv6 {
+			protocolConfigurationOptions.AddDNSServerIPv6AddressRequest()
+			addPCORequest(protocolConfigurationOptions, nasMessage.PCSCFIPv6AddressRequestUL)
+		}
 	}
 
 	pcoContents := protocolConfigurationOptions.Marshal()
@@ -60,3 +80,13 @@ func BuildPduSessionEstablishmentRequest(opts *PduSessionEstablishmentRequestOpt
 
 	return data.Bytes(), nil
 }
+
+// addPCORequest appends an empty container to the PCO, which is how the UE
+// requests a parameter from the network (TS 24.008 10.5.6.3).
+func addPCORequest(pco *nasConvert.ProtocolConfigurationOptions, containerID uint16) {
+	unit := nasConvert.NewProtocolOrContainerUnit()
+	unit.ProtocolOrContainerID = containerID
+	unit.LengthOfContents = 0
+
+	pco.ProtocolOrContainerList = append(pco.ProtocolOrContainerList, unit)
+}
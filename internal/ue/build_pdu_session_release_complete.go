@@ -0,0 +1,41 @@
+package ue
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/free5gc/nas"
+	"github.com/free5gc/nas/nasMessage"
+)
+
+type PDUSessionReleaseCompleteOpts struct {
+	PDUSessionID uint8
+	PTI          uint8 // the PTI of the PDU Session Release Command
+}
+
+func BuildPDUSessionReleaseComplete(opts *PDUSessionReleaseCompleteOpts) ([]byte, error) {
+	if opts == nil {
+		return nil, fmt.Errorf("PDUSessionReleaseCompleteOpts is nil")
+	}
+
+	m := nas.NewMessage()
+	m.GsmMessage = nas.NewGsmMessage()
+	m.GsmHeader.SetMessageType(nas.MsgTypePDUSessionReleaseComplete)
+
+	pduSessionReleaseComplete := nasMessage.NewPDUSessionReleaseComplete(0)
+	pduSessionReleaseComplete.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
+	pduSessionReleaseComplete.SetMessageType(nas.MsgTypePDUSessionReleaseComplete)
+	pduSessionReleaseComplete.SetPDUSessionID(opts.PDUSessionID)
+	pduSessionReleaseComplete.SetPTI(opts.PTI)
+
+	m.PDUSessionReleaseComplete = pduSessionReleaseComplete
+
+	data := new(bytes.Buffer)
+
+	err := m.GsmMessageEncode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode GSM message: %v", err)
+	}
+
+	return data.Bytes(), nil
+}
@@ -0,0 +1,51 @@
+package ue
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/free5gc/nas"
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/nas/nasType"
+)
+
+// ptiPDUSessionRelease is the procedure transaction identity of the PDU
+// session releases the UE requests, echoed in the network's answer.
+const ptiPDUSessionRelease = 0x02
+
+type PDUSessionReleaseRequestOpts struct {
+	PDUSessionID uint8
+	Cause        uint8 // 5GSM cause, 0 omits the IE
+}
+
+func BuildPDUSessionReleaseRequest(opts *PDUSessionReleaseRequestOpts) ([]byte, error) {
+	if opts == nil {
+		return nil, fmt.Errorf("PDUSessionReleaseRequestOpts is nil")
+	}
+
+	m := nas.NewMessage()
+	m.GsmMessage = nas.NewGsmMessage()
+	m.GsmHeader.SetMessageType(nas.MsgTypePDUSessionReleaseRequest)
+
+	pduSessionReleaseRequest := nasMessage.NewPDUSessionReleaseRequest(0)
+	pduSessionReleaseRequest.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
+	pduSessionReleaseRequest.SetMessageType(nas.MsgTypePDUSessionReleaseRequest)
+	pduSessionReleaseRequest.SetPDUSessionID(opts.PDUSessionID)
+	pduSessionReleaseRequest.SetPTI(ptiPDUSessionRelease)
+
+	if opts.Cause != 0 {
+		pduSessionReleaseRequest.Cause5GSM = nasType.NewCause5GSM(nasMessage.PDUSessionReleaseRequestCause5GSMType)
+		pduSessionReleaseRequest.SetCauseValue(opts.Cause)
+	}
+
+	m.PDUSessionReleaseRequest = pduSessionReleaseRequest
+
+	data := new(bytes.Buffer)
+
+	err := m.GsmMessageEncode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode GSM message: %v", err)
+	}
+
+	return data.Bytes(), nil
+}
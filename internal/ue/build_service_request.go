@@ -0,0 +1,98 @@
+package ue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/free5gc/nas"
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/nas/nasType"
+)
+
+type ServiceRequestOpts struct {
+	ServiceType uint8
+	Ksi         int32
+	Guti        *nasType.GUTI5G
+	// PDUSessions, when set, is sent as the PDU session status and, for the
+	// data service type, as the uplink data status.
+	PDUSessions *[16]bool
+	// NASMessageContainer holds the ciphered full message, next to the
+	// cleartext IEs (TS 24.501 4.4.6).
+	NASMessageContainer []byte
+}
+
+func BuildServiceRequest(opts *ServiceRequestOpts) ([]byte, error) {
+	if opts == nil {
+		return nil, fmt.Errorf("ServiceRequestOpts is nil")
+	}
+
+	if opts.Guti == nil {
+		return nil, fmt.Errorf("a 5G-GUTI is required to build a Service Request")
+	}
+
+	m := nas.NewMessage()
+	m.GmmMessage = nas.NewGmmMessage()
+	m.GmmHeader.SetMessageType(nas.MsgTypeServiceRequest)
+
+	serviceRequest := nasMessage.NewServiceRequest(0)
+	serviceRequest.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSMobilityManagementMessage)
+	serviceRequest.SetSecurityHeaderType(nas.SecurityHeaderTypePlainNas)
+	serviceRequest.SetSpareHalfOctet(0)
+	serviceRequest.SetMessageType(nas.MsgTypeServiceRequest)
+	serviceRequest.SetServiceTypeValue(opts.ServiceType)
+	serviceRequest.SetTSC(nasMessage.TypeOfSecurityContextFlagNative)
+	serviceRequest.SetNasKeySetIdentifiler(uint8(opts.Ksi))
+
+	// The 5G-S-TMSI is the AMF Set ID, AMF Pointer and 5G-TMSI of the GUTI.
+	serviceRequest.TMSI5GS.SetLen(7)
+	serviceRequest.TMSI5GS.Octet[0] = 0xf0
+	serviceRequest.TMSI5GS.SetTypeOfIdentity(nasMessage.MobileIdentity5GSType5gSTmsi)
+	copy(serviceRequest.TMSI5GS.Octet[1:], opts.Guti.Octet[5:11])
+
+	if opts.PDUSessions != nil {
+		status := pduSessionStatusBuffer(opts.PDUSessions)
+
+		serviceRequest.PDUSessionStatus = nasType.NewPDUSessionStatus(nasMessage.ServiceRequestPDUSessionStatusType)
+		serviceRequest.PDUSessionStatus.SetLen(2)
+		serviceRequest.PDUSessionStatus.Buffer = status
+
+		if opts.ServiceType == nasMessage.ServiceTypeData {
+			serviceRequest.UplinkDataStatus = nasType.NewUplinkDataStatus(nasMessage.ServiceRequestUplinkDataStatusType)
+			serviceRequest.UplinkDataStatus.SetLen(2)
+			serviceRequest.UplinkDataStatus.Buffer = status
+		}
+	}
+
+	if opts.NASMessageContainer != nil {
+		serviceRequest.NASMessageContainer = nasType.NewNASMessageContainer(nasMessage.ServiceRequestNASMessageContainerType)
+		serviceRequest.NASMessageContainer.SetLen(uint16(len(opts.NASMessageContainer)))
+		serviceRequest.NASMessageContainer.Buffer = opts.NASMessageContainer
+	}
+
+	m.ServiceRequest = serviceRequest
+
+	data := new(bytes.Buffer)
+
+	err := m.GmmMessageEncode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode GMM message: %v", err)
+	}
+
+	return data.Bytes(), nil
+}
+
+// pduSessionStatusBuffer encodes one bit per PDU session ID, as in the PDU
+// session status and uplink data status IEs.
+func pduSessionStatusBuffer(sessions *[16]bool) []byte {
+	var flags uint16
+
+	for i, active := range sessions {
+		flags += boolToUint16(active) << i
+	}
+
+	buffer := make([]byte, 2)
+	binary.LittleEndian.PutUint16(buffer, flags)
+
+	return buffer
+}
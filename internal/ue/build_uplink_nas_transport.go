@@ -16,6 +16,9 @@ type UplinkNasTransportOpts struct {
 	PayloadContainer []byte
 	DNN              string
 	SNSSAI           models.Snssai
+	// Established marks a message of an established PDU session, such as its
+	// release, which carries no Request type, DNN or S-NSSAI.
+	Established bool
 }
 
 func BuildUplinkNasTransport(opts *UplinkNasTransportOpts) ([]byte, error) {
@@ -42,6 +45,17 @@ func BuildUplinkNasTransport(opts *UplinkNasTransportOpts) ([]byte, error) {
 	ulNasTransport.PduSessionID2Value = new(nasType.PduSessionID2Value)
 	ulNasTransport.PduSessionID2Value.SetIei(nasMessage.ULNASTransportPduSessionID2ValueType)
 	ulNasTransport.SetPduSessionID2Value(opts.PDUSessionID)
+
+	ulNasTransport.SetPayloadContainerType(nasMessage.PayloadContainerTypeN1SMInfo)
+	ulNasTransport.PayloadContainer.SetLen(uint16(len(opts.PayloadContainer)))
+	ulNasTransport.SetPayloadContainerContents(opts.PayloadContainer)
+
+	m.ULNASTransport = ulNasTransport
+
+	if opts.Established {
+		return encodeULNASTransport(m)
+	}
+
 	ulNasTransport.RequestType = new(nasType.RequestType)
 	ulNasTransport.RequestType.SetIei(nasMessage.ULNASTransportRequestTypeType)
 	ulNasTransport.SetRequestTypeValue(nasMessage.ULNASTransportRequestTypeInitialRequest)
@@ -73,12 +87,10 @@ func BuildUplinkNasTransport(opts *UplinkNasTransportOpts) ([]byte, error) {
 
 	ulNasTransport.SetSST(uint8(opts.SNSSAI.Sst))
 
-	ulNasTransport.SetPayloadContainerType(nasMessage.PayloadContainerTypeN1SMInfo)
-	ulNasTransport.PayloadContainer.SetLen(uint16(len(opts.PayloadContainer)))
-	ulNasTransport.SetPayloadContainerContents(opts.PayloadContainer)
-
-	m.ULNASTransport = ulNasTransport
+	return encodeULNASTransport(m)
+}
 
+func encodeULNASTransport(m *nas.Message) ([]byte, error) {
 	data := new(bytes.Buffer)
 
 	err := m.GmmMessageEncode(data)
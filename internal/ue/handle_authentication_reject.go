@@ -3,9 +3,7 @@ package ue
 import (
 	"fmt"
 
-	"github.com/ellanetworks/core-tester/internal/logger"
 	"github.com/free5gc/nas"
-	"go.uber.org/zap"
 )
 
 func handleAuthenticationReject(ue *UE, msg *nas.Message) error {
@@ -13,7 +11,9 @@ func handleAuthenticationReject(ue *UE, msg *nas.Message) error {
 		return fmt.Errorf("received nil NAS message in Authentication Reject handler")
 	}
 
-	logger.UeLogger.Debug("Received Authentication Reject NAS message", zap.String("IMSI", ue.UeSecurity.Supi))
+	ue.log().Debug("Received Authentication Reject NAS message")
+
+	ue.registrationFailed("Authentication Reject")
 
 	return nil
 }
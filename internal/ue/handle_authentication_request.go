@@ -3,13 +3,11 @@ package ue
 import (
 	"fmt"
 
-	"github.com/ellanetworks/core-tester/internal/logger"
 	"github.com/free5gc/nas"
-	"go.uber.org/zap"
 )
 
 func handleAuthenticationRequest(ue *UE, msg *nas.Message, amfUENGAPID int64, ranUENGAPID int64) error {
-	logger.UeLogger.Debug("Received Authentication Request NAS message")
+	ue.log().Debug("Received Authentication Request NAS message")
 
 	rand := msg.GetRANDValue()
 	autn := msg.GetAUTN()
@@ -27,15 +25,14 @@ func handleAuthenticationRequest(ue *UE, msg *nas.Message, amfUENGAPID int64, ra
 		return fmt.Errorf("could not build authentication response: %v", err)
 	}
 
+	ue.observeNAS(true, authResp)
+
 	err = ue.Gnb.SendUplinkNAS(authResp, amfUENGAPID, ranUENGAPID)
 	if err != nil {
 		return fmt.Errorf("could not send Authentication Response: %v", err)
 	}
 
-	logger.UeLogger.Debug(
-		"Sent Authentication Response NAS message",
-		zap.String("IMSI", ue.UeSecurity.Supi),
-	)
+	ue.log().Debug("Sent Authentication Response NAS message")
 
 	return nil
 }
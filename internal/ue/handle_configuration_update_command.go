@@ -3,10 +3,8 @@ package ue
 import (
 	"fmt"
 
-	"github.com/ellanetworks/core-tester/internal/logger"
 	"github.com/free5gc/nas"
 	"github.com/free5gc/nas/nasMessage"
-	"go.uber.org/zap"
 )
 
 func handleConfigurationUpdateCommand(ue *UE, cfgUpdCmd *nasMessage.ConfigurationUpdateCommand, amfUENGAPID int64, ranUENGAPID int64) error {
@@ -29,10 +27,7 @@ func handleConfigurationUpdateCommand(ue *UE, cfgUpdCmd *nasMessage.Configuratio
 		return fmt.Errorf("could not send UplinkNASTransport: %v", err)
 	}
 
-	logger.UeLogger.Debug(
-		"Sent Configuration Update Complete NAS message",
-		zap.String("IMSI", ue.UeSecurity.Supi),
-	)
+	ue.log().Debug("Sent Configuration Update Complete NAS message")
 
 	return nil
 }
@@ -3,12 +3,11 @@ package ue
 import (
 	"fmt"
 
-	"github.com/ellanetworks/core-tester/internal/logger"
 	"github.com/free5gc/nas"
 	"go.uber.org/zap"
 )
 
-func handleDLNASTransport(ue *UE, msg *nas.Message) error {
+func handleDLNASTransport(ue *UE, msg *nas.Message, amfUENGAPID int64, ranUENGAPID int64) error {
 	pduSessionID := msg.DLNASTransport.GetPduSessionID2Value()
 
 	payloadContainer, err := getNasPduFromDLNASTransport(msg)
@@ -16,9 +15,8 @@ func handleDLNASTransport(ue *UE, msg *nas.Message) error {
 		return fmt.Errorf("could not get PDU Session establishment accept: %v", err)
 	}
 
-	logger.UeLogger.Debug(
+	ue.log().Debug(
 		"Received DL NAS Transport NAS message",
-		zap.String("IMSI", ue.UeSecurity.Supi),
 		zap.Uint8("PDU Session ID", pduSessionID),
 	)
 
@@ -35,8 +33,18 @@ func handleDLNASTransport(ue *UE, msg *nas.Message) error {
 		if err != nil {
 			return fmt.Errorf("could not handle PDU Session Establishment Reject: %v", err)
 		}
+	case nas.MsgTypePDUSessionReleaseCommand:
+		err := handlePDUSessionReleaseCommand(ue, payloadContainer.PDUSessionReleaseCommand, amfUENGAPID, ranUENGAPID)
+		if err != nil {
+			return fmt.Errorf("could not handle PDU Session Release Command: %v", err)
+		}
+	case nas.MsgTypePDUSessionReleaseReject:
+		err := handlePDUSessionReleaseReject(ue, payloadContainer.PDUSessionReleaseReject)
+		if err != nil {
+			return fmt.Errorf("could not handle PDU Session Release Reject: %v", err)
+		}
 	default:
-		logger.UeLogger.Warn("Message type not implemented", zap.String("Message Type", getGSMMessageName(pcMsgType)))
+		ue.log().Warn("Message type not implemented", zap.String("Message Type", getGSMMessageName(pcMsgType)))
 	}
 
 	updateReceivedGSMMessages(ue, payloadContainer)
@@ -3,19 +3,26 @@ package ue
 import (
 	"fmt"
 
-	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/metrics"
 	"github.com/free5gc/nas/nasMessage"
 	"go.uber.org/zap"
 )
 
 func handlePDUSessionEstablishmentAccept(ue *UE, msg *nasMessage.PDUSessionEstablishmentAccept) error {
-	addrInfo := msg.GetPDUAddressInformation()
+	ue.endProcedure(metrics.ProcedurePDUSessionEstablishment)
 
-	pduSessionType := msg.SelectedSSCModeAndSelectedPDUSessionType.Octet & 0x07
+	var addrInfo [12]uint8
 
-	logger.UeLogger.Debug(
+	// Ethernet and Unstructured sessions are accepted without a PDU address.
+	if msg.PDUAddress != nil {
+		addrInfo = msg.GetPDUAddressInformation()
+	}
+
+	pduSessionType := msg.SelectedSSCModeAndSelectedPDUSessionType.GetPDUSessionType()
+	sscMode := msg.SelectedSSCModeAndSelectedPDUSessionType.GetSSCMode()
+
+	ue.log().Debug(
 		"Received PDU Session Establishment Accept NAS message",
-		zap.String("IMSI", ue.UeSecurity.Supi),
 		zap.Uint8("PDU Session ID", msg.GetPDUSessionID()),
 		zap.Uint8("PTI", msg.GetPTI()),
 		zap.Uint8("Extended Protocol Discriminator", msg.GetExtendedProtocolDiscriminator()),
@@ -26,7 +33,7 @@ func handlePDUSessionEstablishmentAccept(ue *UE, msg *nasMessage.PDUSessionEstab
 	)
 
 	if msg.PDUAddress != nil {
-		logger.UeLogger.Debug(
+		ue.log().Debug(
 			"PDU Address IE details",
 			zap.Uint8("IEI", msg.PDUAddress.GetIei()),
 			zap.Uint8("Length", msg.PDUAddress.GetLen()),                                 //nolint:staticcheck // PDUAddress is a pointer field, not embedded
@@ -37,16 +44,16 @@ func handlePDUSessionEstablishmentAccept(ue *UE, msg *nasMessage.PDUSessionEstab
 	}
 
 	if msg.SelectedSSCModeAndSelectedPDUSessionType.Octet != 0 {
-		logger.UeLogger.Debug(
+		ue.log().Debug(
 			"SSC Mode and PDU Session Type",
 			zap.Uint8("Octet", msg.SelectedSSCModeAndSelectedPDUSessionType.Octet),
-			zap.Uint8("SSC Mode", msg.SelectedSSCModeAndSelectedPDUSessionType.Octet>>3),
+			zap.Uint8("SSC Mode", sscMode),
 			zap.Uint8("PDU Session Type", pduSessionType),
 		)
 	}
 
 	if msg.AuthorizedQosRules.Len != 0 {
-		logger.UeLogger.Debug(
+		ue.log().Debug(
 			"Authorized QoS Rules",
 			zap.Uint16("Length", msg.AuthorizedQosRules.GetLen()),
 			zap.Any("Buffer", msg.AuthorizedQosRules.Buffer[:msg.AuthorizedQosRules.GetLen()]),
@@ -55,7 +62,7 @@ func handlePDUSessionEstablishmentAccept(ue *UE, msg *nasMessage.PDUSessionEstab
 	}
 
 	if msg.SessionAMBR.GetLen() != 0 {
-		logger.UeLogger.Debug(
+		ue.log().Debug(
 			"Session AMBR",
 			zap.Uint8("Length", msg.SessionAMBR.GetLen()),
 			zap.Any("Octets", msg.SessionAMBR.Octet[:msg.SessionAMBR.GetLen()]),
@@ -64,7 +71,7 @@ func handlePDUSessionEstablishmentAccept(ue *UE, msg *nasMessage.PDUSessionEstab
 	}
 
 	if msg.Cause5GSM != nil {
-		logger.UeLogger.Debug(
+		ue.log().Debug(
 			"Cause 5GSM",
 			zap.Uint8("IEI", msg.Cause5GSM.GetIei()),
 			zap.Any("Octet", msg.Cause5GSM.Octet),
@@ -72,7 +79,7 @@ func handlePDUSessionEstablishmentAccept(ue *UE, msg *nasMessage.PDUSessionEstab
 	}
 
 	if msg.RQTimerValue != nil {
-		logger.UeLogger.Debug(
+		ue.log().Debug(
 			"RQ Timer Value",
 			zap.Uint8("IEI", msg.RQTimerValue.GetIei()),
 			zap.Any("Octet", msg.RQTimerValue.Octet),
@@ -80,7 +87,7 @@ func handlePDUSessionEstablishmentAccept(ue *UE, msg *nasMessage.PDUSessionEstab
 	}
 
 	if msg.SNSSAI != nil {
-		logger.UeLogger.Debug(
+		ue.log().Debug(
 			"SNSSAI",
 			zap.Uint8("IEI", msg.SNSSAI.GetIei()),
 			zap.Uint8("Length", msg.SNSSAI.GetLen()),
@@ -90,7 +97,7 @@ func handlePDUSessionEstablishmentAccept(ue *UE, msg *nasMessage.PDUSessionEstab
 	}
 
 	if msg.AlwaysonPDUSessionIndication != nil {
-		logger.UeLogger.Debug(
+		ue.log().Debug(
 			"Always-on PDU Session Indication",
 			zap.Uint8("IEI", msg.AlwaysonPDUSessionIndication.GetIei()),
 			zap.Any("Octet", msg.AlwaysonPDUSessionIndication.Octet),
@@ -98,7 +105,7 @@ func handlePDUSessionEstablishmentAccept(ue *UE, msg *nasMessage.PDUSessionEstab
 	}
 
 	if msg.MappedEPSBearerContexts != nil {
-		logger.UeLogger.Debug(
+		ue.log().Debug(
 			"Mapped EPS Bearer Contexts",
 			zap.Uint8("IEI", msg.MappedEPSBearerContexts.GetIei()),
 			zap.Any("Mapped EPS Bearer Context", msg.MappedEPSBearerContexts.GetMappedEPSBearerContext()), //nolint:staticcheck // MappedEPSBearerContexts is a pointer field, not embedded
@@ -106,7 +113,7 @@ func handlePDUSessionEstablishmentAccept(ue *UE, msg *nasMessage.PDUSessionEstab
 	}
 
 	if msg.EAPMessage != nil {
-		logger.UeLogger.Debug(
+		ue.log().Debug(
 			"EAP Message",
 			zap.Uint8("IEI", msg.EAPMessage.GetIei()),
 			zap.Any("EAP Message", msg.EAPMessage.GetEAPMessage()), //nolint:staticcheck // EAPMessage is a pointer field, not embedded
@@ -114,7 +121,7 @@ func handlePDUSessionEstablishmentAccept(ue *UE, msg *nasMessage.PDUSessionEstab
 	}
 
 	if msg.DNN != nil {
-		logger.UeLogger.Debug(
+		ue.log().Debug(
 			"DNN",
 			zap.Uint8("IEI", msg.DNN.GetIei()),
 			zap.String("DNN", msg.DNN.GetDNN()), //nolint:staticcheck // DNN is a pointer field, not embedded
@@ -123,7 +130,7 @@ func handlePDUSessionEstablishmentAccept(ue *UE, msg *nasMessage.PDUSessionEstab
 
 	pcoContents := msg.GetExtendedProtocolConfigurationOptionsContents()
 	if len(pcoContents) > 0 {
-		logger.UeLogger.Debug(
+		ue.log().Debug(
 			"Extended Protocol Configuration Options",
 			zap.Any("PCO Contents", pcoContents),
 			zap.Any("PCO Contents Hex", fmt.Sprintf("%#x", pcoContents)),
@@ -132,7 +139,7 @@ func handlePDUSessionEstablishmentAccept(ue *UE, msg *nasMessage.PDUSessionEstab
 
 	qosFlowDescsRaw := msg.GetQoSFlowDescriptions()
 	if len(qosFlowDescsRaw) > 0 {
-		logger.UeLogger.Debug(
+		ue.log().Debug(
 			"QoS Flow Descriptions (raw)",
 			zap.Any("QoS Flow Descriptions", qosFlowDescsRaw),
 			zap.Any("QoS Flow Descriptions Hex", fmt.Sprintf("%#x", qosFlowDescsRaw)),
@@ -144,9 +151,9 @@ func handlePDUSessionEstablishmentAccept(ue *UE, msg *nasMessage.PDUSessionEstab
 		return fmt.Errorf("could not parse PDU address from NAS: %v", err)
 	}
 
-	mtu, err := mtuFromExtendProtocolConfigurationOptionsContents(pcoContents)
+	pco, err := parseExtendedProtocolConfigurationOptions(pcoContents)
 	if err != nil {
-		return fmt.Errorf("could not get MTU from Extended Protocol Configuration Options: %v", err)
+		return fmt.Errorf("could not parse Extended Protocol Configuration Options: %v", err)
 	}
 
 	qosFlowDescs, err := parseAuthorizedQosFlowDescriptions(qosFlowDescsRaw)
@@ -174,23 +181,28 @@ func handlePDUSessionEstablishmentAccept(ue *UE, msg *nasMessage.PDUSessionEstab
 		}
 	}
 
-	logger.UeLogger.Debug(
+	ue.log().Debug(
 		"Parsed PDU Session info",
-		zap.String("IMSI", ue.UeSecurity.Supi),
 		zap.Uint8("PDU Session ID", msg.GetPDUSessionID()),
 		zap.String("UE IP", ipStr),
-		zap.Uint16("MTU", mtu),
+		zap.Uint16("MTU", pco.MTU),
+		zap.Any("DNS Servers", pco.DNSServers),
+		zap.Any("P-CSCF Addresses", pco.PCSCFAddresses),
 		zap.Uint8("QFI", qfi),
 		zap.Uint8("PDU Session Type", pduAddr.PDUSessionType),
+		zap.Uint8("SSC Mode", sscMode),
 	)
 
 	ue.SetPDUSession(PDUSessionInfo{
 		PDUSessionID:      msg.GetPDUSessionID(),
 		UEIP:              pduAddr.IP.String(),
 		UEIPV6:            pduAddr.IPV6.String(),
-		MTU:               mtu,
+		MTU:               pco.MTU,
+		DNSServers:        pco.DNSServers,
+		PCSCFAddresses:    pco.PCSCFAddresses,
 		QFI:               qfi,
 		PDUSessionVersion: pduAddr.PDUSessionType,
+		SSCMode:           sscMode,
 	})
 
 	return nil
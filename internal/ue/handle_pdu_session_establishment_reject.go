@@ -3,7 +3,7 @@ package ue
 import (
 	"fmt"
 
-	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/metrics"
 	"github.com/free5gc/nas/nasMessage"
 	"go.uber.org/zap"
 )
@@ -15,12 +15,22 @@ func handlePDUSessionEstablishmentReject(ue *UE, msg *nasMessage.PDUSessionEstab
 
 	cause := msg.GetCauseValue()
 
-	logger.UeLogger.Debug(
+	ue.cancelProcedure(metrics.ProcedurePDUSessionEstablishment, cause5GSMToString(cause))
+
+	ue.log().Debug(
 		"Received PDU Session Establishment Reject NAS message",
-		zap.String("IMSI", ue.UeSecurity.Supi),
 		zap.Uint8("PDU Session ID", msg.GetPDUSessionID()),
 		zap.String("Cause", cause5GSMToString(cause)),
 	)
 
+	if msg.AllowedSSCMode != nil {
+		ue.log().Warn(
+			"PDU Session Establishment Reject lists the allowed SSC modes",
+			zap.Uint8("SSC1", msg.AllowedSSCMode.GetSSC1()),
+			zap.Uint8("SSC2", msg.AllowedSSCMode.GetSSC2()),
+			zap.Uint8("SSC3", msg.AllowedSSCMode.GetSSC3()),
+		)
+	}
+
 	return nil
 }
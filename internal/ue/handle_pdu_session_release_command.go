@@ -0,0 +1,63 @@
+package ue
+
+import (
+	"fmt"
+
+	"github.com/ellanetworks/core-tester/internal/metrics"
+	"github.com/free5gc/nas"
+	"github.com/free5gc/nas/nasMessage"
+	"go.uber.org/zap"
+)
+
+// handlePDUSessionReleaseCommand forgets the PDU session, whether the UE or
+// the network asked for its release, and confirms it (TS 24.501 6.3.3 and
+// 6.4.3).
+func handlePDUSessionReleaseCommand(ue *UE, msg *nasMessage.PDUSessionReleaseCommand, amfUENGAPID int64, ranUENGAPID int64) error {
+	if msg == nil {
+		return fmt.Errorf("received nil NAS message in PDU Session Release Command handler")
+	}
+
+	ue.endProcedure(metrics.ProcedurePDUSessionRelease)
+
+	pduSessionID := msg.GetPDUSessionID()
+
+	ue.log().Debug(
+		"Received PDU Session Release Command NAS message",
+		zap.Uint8("PDU Session ID", pduSessionID),
+		zap.Uint8("PTI", msg.GetPTI()),
+		zap.String("Cause", cause5GSMToString(msg.GetCauseValue())),
+	)
+
+	ue.removePDUSession(pduSessionID)
+
+	complete, err := BuildPDUSessionReleaseComplete(&PDUSessionReleaseCompleteOpts{
+		PDUSessionID: pduSessionID,
+		PTI:          msg.GetPTI(),
+	})
+	if err != nil {
+		return fmt.Errorf("could not build PDU Session Release Complete: %v", err)
+	}
+
+	pduUplink, err := BuildUplinkNasTransport(&UplinkNasTransportOpts{
+		PDUSessionID:     pduSessionID,
+		PayloadContainer: complete,
+		Established:      true,
+	})
+	if err != nil {
+		return fmt.Errorf("could not build Uplink NAS Transport for PDU Session Release Complete: %v", err)
+	}
+
+	encodedPdu, err := ue.EncodeNasPduWithSecurity(pduUplink, nas.SecurityHeaderTypeIntegrityProtectedAndCiphered)
+	if err != nil {
+		return fmt.Errorf("error encoding %s IMSI UE NAS PDU Session Release Complete Msg", ue.UeSecurity.Supi)
+	}
+
+	err = ue.Gnb.SendUplinkNAS(encodedPdu, amfUENGAPID, ranUENGAPID)
+	if err != nil {
+		return fmt.Errorf("could not send UplinkNASTransport for PDU Session Release Complete: %v", err)
+	}
+
+	ue.log().Debug("Sent PDU Session Release Complete", zap.Uint8("PDU Session ID", pduSessionID))
+
+	return nil
+}
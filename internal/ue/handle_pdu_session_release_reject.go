@@ -0,0 +1,27 @@
+package ue
+
+import (
+	"fmt"
+
+	"github.com/ellanetworks/core-tester/internal/metrics"
+	"github.com/free5gc/nas/nasMessage"
+	"go.uber.org/zap"
+)
+
+func handlePDUSessionReleaseReject(ue *UE, msg *nasMessage.PDUSessionReleaseReject) error {
+	if msg == nil {
+		return fmt.Errorf("received nil NAS message in PDU Session Release Reject handler")
+	}
+
+	cause := cause5GSMToString(msg.GetCauseValue())
+
+	ue.cancelProcedure(metrics.ProcedurePDUSessionRelease, cause)
+
+	ue.log().Debug(
+		"Received PDU Session Release Reject NAS message",
+		zap.Uint8("PDU Session ID", msg.GetPDUSessionID()),
+		zap.String("Cause", cause),
+	)
+
+	return nil
+}
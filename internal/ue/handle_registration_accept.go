@@ -4,13 +4,17 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/metrics"
 	"github.com/free5gc/nas"
-	"go.uber.org/zap"
 )
 
 func handleRegistrationAccept(ue *UE, msg *nas.Message, amfUENGAPID int64, ranUENGAPID int64) error {
-	logger.UeLogger.Debug("Received Registration Accept NAS message", zap.String("IMSI", ue.UeSecurity.Supi))
+	ue.log().Debug("Received Registration Accept NAS message")
+
+	ue.endProcedure(metrics.ProcedureSecurityMode)
+	ue.endProcedure(metrics.ProcedureRegistration)
+	ue.setStateMM(MM5G_REGISTERED)
+	metrics.RegistrationSuccesses.Inc()
 
 	ue.Set5gGuti(msg.RegistrationAccept.GUTI5G)
 
@@ -36,14 +40,12 @@ func handleRegistrationAccept(ue *UE, msg *nas.Message, amfUENGAPID int64, ranUE
 	// the Core may not have finished processing the Registration Complete yet.
 	time.Sleep(500 * time.Millisecond)
 
-	logger.UeLogger.Debug(
-		"Sent Registration Complete NAS message",
-		zap.String("IMSI", ue.UeSecurity.Supi),
-	)
+	ue.log().Debug("Sent Registration Complete NAS message")
 
 	pduReq, err := BuildPduSessionEstablishmentRequest(&PduSessionEstablishmentRequestOpts{
 		PDUSessionID:   ue.PDUSessionID,
 		PDUSessionType: ue.PDUSessionType,
+		SSCMode:        ue.SSCMode,
 	})
 	if err != nil {
 		return fmt.Errorf("could not build PDU Session Establishment Request: %v", err)
@@ -64,15 +66,14 @@ func handleRegistrationAccept(ue *UE, msg *nas.Message, amfUENGAPID int64, ranUE
 		return fmt.Errorf("error encoding %s IMSI UE NAS Uplink NAS Transport for PDU Session Msg", ue.UeSecurity.Supi)
 	}
 
+	ue.startProcedure(metrics.ProcedurePDUSessionEstablishment)
+
 	err = ue.Gnb.SendUplinkNAS(encodedPdu, amfUENGAPID, ranUENGAPID)
 	if err != nil {
 		return fmt.Errorf("could not send UplinkNASTransport for PDU Session Establishment: %v", err)
 	}
 
-	logger.UeLogger.Debug(
-		"Sent PDU Session Establishment Request",
-		zap.String("IMSI", ue.UeSecurity.Supi),
-	)
+	ue.log().Debug("Sent PDU Session Establishment Request")
 
 	return nil
 }
@@ -3,7 +3,6 @@ package ue
 import (
 	"fmt"
 
-	"github.com/ellanetworks/core-tester/internal/logger"
 	"github.com/free5gc/nas"
 	"go.uber.org/zap"
 )
@@ -15,11 +14,12 @@ func handleRegistrationReject(ue *UE, msg *nas.Message) error {
 
 	cause := msg.RegistrationReject.GetCauseValue()
 
-	logger.UeLogger.Debug(
+	ue.log().Debug(
 		"Received Registration Reject NAS message",
-		zap.String("IMSI", ue.UeSecurity.Supi),
 		zap.String("Cause", cause5GMMToString(cause)),
 	)
 
+	ue.registrationFailed(cause5GMMToString(cause))
+
 	return nil
 }
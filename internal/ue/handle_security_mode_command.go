@@ -3,7 +3,7 @@ package ue
 import (
 	"fmt"
 
-	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/metrics"
 	"github.com/free5gc/nas"
 	"github.com/free5gc/nas/nasMessage"
 	"github.com/free5gc/openapi/models"
@@ -15,7 +15,9 @@ func handleSecurityModeCommand(ue *UE, msg *nas.Message, amfUENGAPID int64, ranU
 		return fmt.Errorf("GNB is not set for UE")
 	}
 
-	logger.UeLogger.Debug("Received Security Mode Command NAS message")
+	ue.log().Debug("Received Security Mode Command NAS message")
+
+	ue.endProcedure(metrics.ProcedureAuthentication)
 
 	ksi := int32(msg.SecurityModeCommand.GetNasKeySetIdentifiler())
 
@@ -31,7 +33,7 @@ func handleSecurityModeCommand(ue *UE, msg *nas.Message, amfUENGAPID int64, ranU
 	ue.UeSecurity.NgKsi.Ksi = ksi
 	ue.UeSecurity.NgKsi.Tsc = tsc
 
-	logger.UeLogger.Debug(
+	ue.log().Debug(
 		"Updated UE security NG KSI",
 		zap.Int32("KSI", ksi),
 		zap.String("TSC", string(tsc)),
@@ -50,15 +52,14 @@ func handleSecurityModeCommand(ue *UE, msg *nas.Message, amfUENGAPID int64, ranU
 		return fmt.Errorf("error encoding %s IMSI UE  NAS Security Mode Complete message: %v", ue.UeSecurity.Supi, err)
 	}
 
+	ue.startProcedure(metrics.ProcedureSecurityMode)
+
 	err = ue.Gnb.SendUplinkNAS(encodedPdu, amfUENGAPID, ranUENGAPID)
 	if err != nil {
 		return fmt.Errorf("could not send UplinkNASTransport: %v", err)
 	}
 
-	logger.UeLogger.Debug(
-		"Sent Security Mode Complete NAS message",
-		zap.String("IMSI", ue.UeSecurity.Supi),
-	)
+	ue.log().Debug("Sent Security Mode Complete NAS message")
 
 	return nil
 }
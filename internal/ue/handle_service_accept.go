@@ -3,17 +3,19 @@ package ue
 import (
 	"fmt"
 
-	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/metrics"
 	"github.com/free5gc/nas"
-	"go.uber.org/zap"
 )
 
 func handleServiceAccept(ue *UE, msg *nas.Message) error {
-	logger.UeLogger.Debug("Received Service Accept NAS message", zap.String("IMSI", ue.UeSecurity.Supi))
+	ue.log().Debug("Received Service Accept NAS message")
 
 	if msg == nil {
 		return fmt.Errorf("received nil NAS message in Service Accept handler")
 	}
 
+	ue.endProcedure(metrics.ProcedureServiceRequest)
+	ue.setStateMM(MM5G_REGISTERED)
+
 	return nil
 }
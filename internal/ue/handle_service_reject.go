@@ -0,0 +1,27 @@
+package ue
+
+import (
+	"fmt"
+
+	"github.com/ellanetworks/core-tester/internal/metrics"
+	"github.com/free5gc/nas"
+	"go.uber.org/zap"
+)
+
+func handleServiceReject(ue *UE, msg *nas.Message) error {
+	if msg == nil {
+		return fmt.Errorf("received nil NAS message in Service Reject handler")
+	}
+
+	cause := cause5GMMToString(msg.ServiceReject.GetCauseValue())
+
+	ue.log().Debug(
+		"Received Service Reject NAS message",
+		zap.String("Cause", cause),
+	)
+
+	ue.cancelProcedure(metrics.ProcedureServiceRequest, cause)
+	ue.setStateMM(MM5G_IDLE)
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package ue
+
+import (
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"go.uber.org/zap"
+)
+
+// log returns the logger of the UE, which names it by SUPI and, once they
+// are known, by its RAN and AMF UE NGAP IDs.
+func (ue *UE) log() *zap.Logger {
+	if l := ue.ueLogger.Load(); l != nil {
+		return l
+	}
+
+	return logger.UeLogger
+}
+
+// setNGAPIDs records the NGAP IDs of the UE's association with the AMF, for
+// its logger and spans. The AMF UE NGAP ID is not known before the first downlink
+// message; pass a negative value until then.
+func (ue *UE) setNGAPIDs(ranUENGAPID int64, amfUENGAPID int64) {
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	if ue.knownRANUENGAPID == ranUENGAPID && ue.knownAMFUENGAPID == amfUENGAPID {
+		return
+	}
+
+	ue.knownRANUENGAPID = ranUENGAPID
+	ue.knownAMFUENGAPID = amfUENGAPID
+
+	for _, span := range ue.spans {
+		span.SetAttributes(ue.spanAttributes()...)
+	}
+
+	fields := []zap.Field{
+		zap.String("SUPI", ue.UeSecurity.Supi),
+		zap.Int64("RAN UE NGAP ID", ranUENGAPID),
+	}
+
+	if amfUENGAPID >= 0 {
+		fields = append(fields, zap.Int64("AMF UE NGAP ID", amfUENGAPID))
+	}
+
+	ue.ueLogger.Store(logger.UeLogger.With(fields...))
+}
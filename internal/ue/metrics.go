@@ -0,0 +1,91 @@
+package ue
+
+import (
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/metrics"
+)
+
+// startProcedure notes the time a procedure starts, just before its first
+// message is sent, and opens its span.
+func (ue *UE) startProcedure(procedure string) {
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	if ue.procedureStarts == nil {
+		ue.procedureStarts = make(map[string]time.Time)
+	}
+
+	ue.procedureStarts[procedure] = time.Now()
+	ue.startSpan(procedure)
+}
+
+// endProcedure observes the latency of a procedure that completed. A
+// procedure that was not started, such as one initiated by the network, is
+// not observed.
+func (ue *UE) endProcedure(procedure string) {
+	ue.mu.Lock()
+	started, ok := ue.procedureStarts[procedure]
+	delete(ue.procedureStarts, procedure)
+	ue.endSpan(procedure, "")
+	ue.mu.Unlock()
+
+	if ok {
+		metrics.ObserveProcedure(procedure, started)
+	}
+}
+
+// cancelProcedure forgets a procedure that failed, so that its latency is not
+// observed, and ends its span with the cause.
+func (ue *UE) cancelProcedure(procedure string, cause string) {
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	delete(ue.procedureStarts, procedure)
+	ue.endSpan(procedure, cause)
+}
+
+// setStateMM moves the UE to a 5GMM state, counting it as active while it is
+// registered, connected or idle.
+func (ue *UE) setStateMM(state int) {
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	switch {
+	case registered(state) && !registered(ue.StateMM):
+		metrics.ActiveUEs.Inc()
+	case !registered(state) && registered(ue.StateMM):
+		metrics.ActiveUEs.Dec()
+	}
+
+	ue.StateMM = state
+}
+
+func registered(state int) bool {
+	return state == MM5G_REGISTERED || state == MM5G_IDLE || state == MM5G_SERVICE_REQ_INIT
+}
+
+// registrationFailed counts a registration that ended without a Registration
+// Accept, unless it was already counted.
+func (ue *UE) registrationFailed(cause string) {
+	ue.mu.Lock()
+	initiated := ue.StateMM == MM5G_REGISTERED_INITIATED
+	ue.mu.Unlock()
+
+	if !initiated {
+		return
+	}
+
+	metrics.RegistrationFailures.WithLabelValues(cause).Inc()
+
+	ue.cancelProcedure(metrics.ProcedureSecurityMode, cause)
+	ue.cancelProcedure(metrics.ProcedureAuthentication, cause)
+	ue.cancelProcedure(metrics.ProcedureRegistration, cause)
+	ue.setStateMM(MM5G_DEREGISTERED)
+}
+
+// AbandonRegistration counts the pending registration as failed when the
+// core did not answer it in time.
+func (ue *UE) AbandonRegistration() {
+	ue.registrationFailed(metrics.CauseTimeout)
+}
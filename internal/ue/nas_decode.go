@@ -25,6 +25,8 @@ func (ue *UE) DecodeNAS(message []byte) (*nas.Message, error) {
 			return nil, fmt.Errorf("decode NAS error: %v", err)
 		}
 
+		ue.observeNAS(false, message)
+
 		return m, nil
 	}
 
@@ -61,6 +63,8 @@ func (ue *UE) DecodeNAS(message []byte) (*nas.Message, error) {
 		}
 	}
 
+	plain := bytes.Clone(payload)
+
 	// decode NAS message.
 	err := m.PlainNasDecode(&payload)
 	if err != nil {
@@ -95,6 +99,9 @@ func (ue *UE) DecodeNAS(message []byte) (*nas.Message, error) {
 		return nil, fmt.Errorf("MAC verification failed")
 	}
 
+	ue.exportPlainNAS(false, message, plain)
+	ue.observeNAS(false, plain)
+
 	return m, nil
 }
 
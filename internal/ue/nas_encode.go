@@ -5,6 +5,7 @@
 package ue
 
 import (
+	"bytes"
 	"fmt"
 
 	"github.com/free5gc/nas"
@@ -49,6 +50,8 @@ func (ue *UE) NASEncode(msg *nas.Message, securityHeaderType uint8) ([]byte, err
 		return nil, fmt.Errorf("could not encode nas message: %v", err)
 	}
 
+	plain := bytes.Clone(payload)
+
 	if msg.SecurityHeaderType != nas.SecurityHeaderTypeIntegrityProtected && msg.SecurityHeaderType != nas.SecurityHeaderTypeIntegrityProtectedWithNew5gNasSecurityContext {
 		if err = security.NASEncrypt(ue.UeSecurity.CipheringAlg, ue.UeSecurity.KnasEnc, ue.UeSecurity.ULCount.Get(), security.Bearer3GPP,
 			security.DirectionUplink, payload); err != nil {
@@ -69,5 +72,8 @@ func (ue *UE) NASEncode(msg *nas.Message, securityHeaderType uint8) ([]byte, err
 
 	ue.UeSecurity.ULCount.AddOne()
 
+	ue.exportPlainNAS(true, payload, plain)
+	ue.observeNAS(true, plain)
+
 	return payload, nil
 }
@@ -0,0 +1,103 @@
+package ue
+
+import (
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/latency"
+)
+
+// nasLeg is a request of the UE and the answer of the core that ends it. The
+// answer of a 5GSM request is named after the 5GSM message carried by the DL
+// NAS Transport. A leg with a label is reported under it instead of its
+// request and answer.
+type nasLeg struct {
+	request string
+	answer  string
+	label   string
+}
+
+func (l nasLeg) name() string {
+	if l.label != "" {
+		return l.label
+	}
+
+	return l.request + " → " + l.answer
+}
+
+// nasLegs are the legs of registration, PDU session establishment and
+// service request, in the order they happen. Their NGAP carriers are noted when they differ from
+// UL and DL NAS Transport.
+var nasLegs = []nasLeg{
+	{request: "Registration Request", answer: "Authentication Request"}, // Initial UE Message
+	{request: "Authentication Response", answer: "Security Mode Command"},
+	{request: "Security Mode Complete", answer: "Registration Accept"}, // Initial Context Setup Request
+	{request: "Registration Complete", answer: "Configuration Update Command"},
+	{request: "Registration Complete", answer: "PDU Session Establishment Accept", label: "Registration Complete → PDU Session Resource Setup"}, // from the end of registration to the setup of the first session
+	{request: "PDU Session Establishment Request", answer: "PDU Session Establishment Accept"},                                                  // PDU Session Resource Setup Request
+	{request: "Service Request", answer: "Service Accept"},                                                                                      // Initial UE Message, Initial Context Setup Request
+}
+
+// observeNAS is called with the plaintext of every NAS message the UE sends,
+// just before it is sent, and receives, as soon as it is decoded.
+func (ue *UE) observeNAS(uplink bool, plain []byte) {
+	ue.traceNAS(uplink, plain)
+
+	name := innerNASMessageName(plain)
+	ue.timeNAS(uplink, name)
+	ue.spanNAS(uplink, name)
+}
+
+// timeNAS starts a leg when the UE sends its request and records its
+// duration when the answer arrives.
+func (ue *UE) timeNAS(uplink bool, name string) {
+	if ue.latency == nil {
+		return
+	}
+
+	now := time.Now()
+
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	for _, leg := range nasLegs {
+		if uplink && name == leg.request {
+			if ue.legStarts == nil {
+				ue.legStarts = make(map[nasLeg]time.Time)
+			}
+
+			ue.legStarts[leg] = now
+
+			continue
+		}
+
+		start, ok := ue.legStarts[leg]
+		if uplink || name != leg.answer || !ok {
+			continue
+		}
+
+		delete(ue.legStarts, leg)
+
+		ue.latency.Record(latency.Sample{
+			IMSI:     ue.UeSecurity.Supi,
+			Leg:      leg.name(),
+			Start:    start,
+			Duration: now.Sub(start),
+		})
+	}
+}
+
+// innerNASMessageName names a plain NAS message, or the 5GSM message an UL or
+// DL NAS Transport carries.
+func innerNASMessageName(plain []byte) string {
+	m, ok := decodePlainNAS(plain)
+	if !ok || m.GmmMessage == nil {
+		return plainNASMessageName(plain)
+	}
+
+	smMessage := n1SMMessage(m)
+	if smMessage == nil {
+		return plainNASMessageName(plain)
+	}
+
+	return plainNASMessageName(smMessage)
+}
@@ -0,0 +1,55 @@
+package ue
+
+import (
+	"encoding/hex"
+
+	"github.com/free5gc/nas"
+	"github.com/free5gc/nas/nasMessage"
+	"go.uber.org/zap"
+)
+
+// NASCapture receives the plaintext of the NAS messages the UE protects and
+// unprotects, such as a packet capture next to the N2 traffic.
+type NASCapture interface {
+	WriteNAS(uplink bool, plain []byte) error
+}
+
+// exportPlainNAS makes the plaintext of a protected NAS message readable:
+// it is logged next to the protected message and written to the NAS
+// capture, if any.
+func (ue *UE) exportPlainNAS(uplink bool, protected []byte, plain []byte) {
+	direction := "downlink"
+	if uplink {
+		direction = "uplink"
+	}
+
+	ue.log().Debug(
+		"NAS plaintext",
+		zap.String("direction", direction),
+		zap.String("message", plainNASMessageName(plain)),
+		zap.String("protected", hex.EncodeToString(protected)),
+		zap.String("plaintext", hex.EncodeToString(plain)),
+	)
+
+	if ue.nasCapture == nil {
+		return
+	}
+
+	err := ue.nasCapture.WriteNAS(uplink, plain)
+	if err != nil {
+		ue.log().Warn("could not capture NAS plaintext", zap.Error(err))
+	}
+}
+
+// plainNASMessageName names a plain 5GMM or 5GSM message from its header
+// (TS 24.501 section 9.1).
+func plainNASMessageName(plain []byte) string {
+	switch {
+	case len(plain) >= 3 && plain[0] == nasMessage.Epd5GSMobilityManagementMessage && plain[1]&0x0f == nas.SecurityHeaderTypePlainNas:
+		return getGMMMessageName(plain[2])
+	case len(plain) >= 4 && plain[0] == nasMessage.Epd5GSSessionManagementMessage:
+		return getGSMMessageName(plain[3])
+	default:
+		return "Unknown Message Type"
+	}
+}
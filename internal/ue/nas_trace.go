@@ -0,0 +1,87 @@
+package ue
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/ellanetworks/core-tester/internal/trace"
+	"github.com/free5gc/nas"
+	"github.com/free5gc/nas/nasMessage"
+)
+
+// traceNAS records a plain NAS message exchanged with the AMF. The 5GSM
+// message carried by an UL or DL NAS Transport is decoded as well, and
+// named next to the transport.
+func (ue *UE) traceNAS(uplink bool, plain []byte) {
+	if !ue.trace.Enabled() {
+		return
+	}
+
+	event := trace.Event{
+		Protocol:  trace.ProtocolNAS,
+		Direction: trace.Downlink,
+		From:      trace.AMF,
+		To:        trace.UE,
+		Message:   plainNASMessageName(plain),
+		IMSI:      ue.UeSecurity.Supi,
+	}
+
+	if uplink {
+		event.Direction, event.From, event.To = trace.Uplink, trace.UE, trace.AMF
+	}
+
+	m, ok := decodePlainNAS(plain)
+	if ok && m.GmmMessage != nil {
+		if _, message, ok := trace.Choice(m.GmmMessage); ok {
+			event.IEs = trace.Fields(message, isNASHeaderField)
+		}
+
+		smMessage := n1SMMessage(m)
+		if sm, ok := decodePlainNAS(smMessage); ok && sm.GsmMessage != nil {
+			event.Message += " (" + plainNASMessageName(smMessage) + ")"
+
+			if _, message, ok := trace.Choice(sm.GsmMessage); ok {
+				event.IEs["SMMessage"] = trace.Fields(message, isNASHeaderField)
+			}
+		}
+	}
+
+	ue.trace.Record(event)
+}
+
+// n1SMMessage returns the 5GSM message an UL or DL NAS Transport carries, if
+// any.
+func n1SMMessage(m *nas.Message) []byte {
+	switch {
+	case m.ULNASTransport != nil && m.ULNASTransport.GetPayloadContainerType() == nasMessage.PayloadContainerTypeN1SMInfo:
+		return m.ULNASTransport.GetPayloadContainerContents()
+	case m.DLNASTransport != nil && m.DLNASTransport.GetPayloadContainerType() == nasMessage.PayloadContainerTypeN1SMInfo:
+		return m.DLNASTransport.GetPayloadContainerContents()
+	default:
+		return nil
+	}
+}
+
+func decodePlainNAS(b []byte) (*nas.Message, bool) {
+	if len(b) == 0 {
+		return nil, false
+	}
+
+	m := nas.NewMessage()
+	payload := bytes.Clone(b)
+
+	err := m.PlainNasDecode(&payload)
+	if err != nil {
+		return nil, false
+	}
+
+	return m, true
+}
+
+// isNASHeaderField matches the header fields of a NAS message, which the
+// message name already conveys.
+func isNASHeaderField(name string) bool {
+	return name == "ExtendedProtocolDiscriminator" ||
+		name == "SpareHalfOctetAndSecurityHeaderType" ||
+		strings.HasSuffix(name, "MessageIdentity")
+}
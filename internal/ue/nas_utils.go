@@ -62,6 +62,9 @@ func parsePduAddressInformation(addrInfo [12]uint8, pduSessionType uint8) (PduAd
 		}
 
 		info.IP = ueIP
+
+	case nasMessage.PDUSessionTypeEthernet, nasMessage.PDUSessionTypeUnstructured:
+		// Non-IP sessions carry no PDU address.
 	}
 
 	return info, nil
@@ -89,22 +92,64 @@ func getNasPduFromDLNASTransport(dlNas *nas.Message) (*nas.Message, error) {
 	return m, nil
 }
 
-func mtuFromExtendProtocolConfigurationOptionsContents(pco_buf []byte) (uint16, error) {
+// PCOInfo holds the parameters the network returned in the Extended Protocol
+// Configuration Options of a PDU Session Establishment Accept.
+type PCOInfo struct {
+	DNSServers     []netip.Addr
+	PCSCFAddresses []netip.Addr
+	MTU            uint16
+}
+
+// parseExtendedProtocolConfigurationOptions decodes every downlink container
+// of the Extended Protocol Configuration Options (TS 24.008 10.5.6.3).
+// Containers the tester has no use for are ignored.
+func parseExtendedProtocolConfigurationOptions(pco_buf []byte) (PCOInfo, error) {
+	var info PCOInfo
+
+	if len(pco_buf) == 0 {
+		return info, nil
+	}
+
 	pco := nasConvert.NewProtocolConfigurationOptions()
 
 	err := pco.UnMarshal(pco_buf)
 	if err != nil {
-		return 0, fmt.Errorf("could not decode Extended Protocol Configuration Options: %v", err)
+		return PCOInfo{}, fmt.Errorf("could not decode Extended Protocol Configuration Options: %v", err)
 	}
 
 	for _, o := range pco.ProtocolOrContainerList {
 		switch o.ProtocolOrContainerID {
-		case nasMessage.IPv4LinkMTUDL:
-			return binary.BigEndian.Uint16(o.Contents), nil
-		default:
-			continue
+		case nasMessage.DNSServerIPv4AddressDL, nasMessage.DNSServerIPv6AddressDL:
+			addr, err := addrFromPCOContainer(o)
+			if err != nil {
+				return PCOInfo{}, err
+			}
+
+			info.DNSServers = append(info.DNSServers, addr)
+		case nasMessage.PCSCFIPv4AddressDL, nasMessage.PCSCFIPv6AddressDL:
+			addr, err := addrFromPCOContainer(o)
+			if err != nil {
+				return PCOInfo{}, err
+			}
+
+			info.PCSCFAddresses = append(info.PCSCFAddresses, addr)
+		case nasMessage.IPv4LinkMTUDL, nasMessage.EthernetFramePayloadMTU, nasMessage.UnstructuredLinkMTU:
+			if len(o.Contents) < 2 {
+				return PCOInfo{}, fmt.Errorf("MTU container %#04x is too short: %d bytes", o.ProtocolOrContainerID, len(o.Contents))
+			}
+
+			info.MTU = binary.BigEndian.Uint16(o.Contents)
 		}
 	}
 
-	return 0, nil
+	return info, nil
+}
+
+func addrFromPCOContainer(o *nasConvert.ProtocolOrContainerUnit) (netip.Addr, error) {
+	addr, ok := netip.AddrFromSlice(o.Contents)
+	if !ok {
+		return netip.Addr{}, fmt.Errorf("PCO container %#04x has an invalid address length: %d bytes", o.ProtocolOrContainerID, len(o.Contents))
+	}
+
+	return addr, nil
 }
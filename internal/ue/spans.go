@@ -0,0 +1,196 @@
+package ue
+
+import (
+	"context"
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/metrics"
+	"github.com/ellanetworks/core-tester/internal/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/ellanetworks/core-tester/internal/ue")
+
+// spanNames names the span of each procedure.
+var spanNames = map[string]string{
+	metrics.ProcedureRegistration:            "Registration",
+	metrics.ProcedureAuthentication:          "Authentication",
+	metrics.ProcedureSecurityMode:            "Security Mode",
+	metrics.ProcedurePDUSessionEstablishment: "PDU Session Establishment",
+	metrics.ProcedurePDUSessionRelease:       "PDU Session Release",
+	metrics.ProcedureDeregistration:          "Deregistration",
+	metrics.ProcedureServiceRequest:          "Service Request",
+}
+
+// spanParents nests the procedures that are steps of another one.
+var spanParents = map[string]string{
+	metrics.ProcedureAuthentication: metrics.ProcedureRegistration,
+	metrics.ProcedureSecurityMode:   metrics.ProcedureRegistration,
+}
+
+// spanOrder lists the procedures from the innermost, which holds the NAS
+// exchanges while it is open.
+var spanOrder = []string{
+	metrics.ProcedureSecurityMode,
+	metrics.ProcedureAuthentication,
+	metrics.ProcedureRegistration,
+	metrics.ProcedurePDUSessionEstablishment,
+	metrics.ProcedurePDUSessionRelease,
+	metrics.ProcedureDeregistration,
+	metrics.ProcedureServiceRequest,
+}
+
+// unsolicitedNAS lists the downlink messages the network sends on its own,
+// with the only request they may answer.
+var unsolicitedNAS = map[string]string{
+	"Configuration Update Command":                        "Registration Complete",
+	"Deregistration Request UE Terminated Deregistration": "",
+}
+
+// pendingNAS is an uplink NAS message whose span is created once it is
+// answered, under the procedure that is open by then.
+type pendingNAS struct {
+	name string
+	sent time.Time
+}
+
+// startSpan opens the span of a procedure, from the message that was just
+// sent to start it. Called with ue.mu held.
+func (ue *UE) startSpan(procedure string) {
+	ctx := context.Background()
+	if parent, ok := ue.spans[spanParents[procedure]]; ok {
+		ctx = oteltrace.ContextWithSpan(ctx, parent)
+	}
+
+	start := time.Now()
+	if ue.pendingNAS != nil {
+		start = ue.pendingNAS.sent
+	}
+
+	_, span := tracer.Start(ctx, spanNames[procedure],
+		oteltrace.WithTimestamp(start),
+		oteltrace.WithAttributes(ue.spanAttributes()...),
+	)
+
+	if ue.spans == nil {
+		ue.spans = make(map[string]oteltrace.Span)
+	}
+
+	if previous, ok := ue.spans[procedure]; ok {
+		previous.End()
+	}
+
+	ue.spans[procedure] = span
+
+	if _, nested := spanParents[procedure]; !nested {
+		ue.lastSpan = span
+	}
+}
+
+// endSpan closes the span of a procedure, as failed when cause is set.
+// Called with ue.mu held.
+func (ue *UE) endSpan(procedure string, cause string) {
+	span, ok := ue.spans[procedure]
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	ue.endPendingNAS("", now)
+
+	delete(ue.spans, procedure)
+
+	if cause != "" {
+		span.SetStatus(codes.Error, cause)
+	}
+
+	span.End(oteltrace.WithTimestamp(now))
+}
+
+// spanNAS turns each uplink NAS message and its answer into a span under
+// the current procedure. Downlink messages that answer nothing become events
+// of the procedure.
+func (ue *UE) spanNAS(uplink bool, name string) {
+	now := time.Now()
+
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	if uplink {
+		ue.endPendingNAS("", now)
+		ue.pendingNAS = &pendingNAS{name: name, sent: now}
+
+		return
+	}
+
+	request, unsolicited := unsolicitedNAS[name]
+	if ue.pendingNAS != nil && (!unsolicited || ue.pendingNAS.name == request) {
+		ue.endPendingNAS(name, now)
+		return
+	}
+
+	if parent := ue.currentSpan(); parent != nil {
+		parent.AddEvent(name, oteltrace.WithTimestamp(now))
+	}
+}
+
+// endPendingNAS records the span of the pending uplink message, ended by
+// answer or, when answer is empty, by the next uplink message or the end of
+// its procedure. Called with ue.mu held.
+func (ue *UE) endPendingNAS(answer string, end time.Time) {
+	pending := ue.pendingNAS
+	if pending == nil {
+		return
+	}
+
+	ue.pendingNAS = nil
+
+	parent := ue.currentSpan()
+	if parent == nil {
+		return
+	}
+
+	attrs := append(ue.spanAttributes(), telemetry.AttributeNASMessage.String(pending.name))
+	if answer != "" {
+		attrs = append(attrs, telemetry.AttributeNASAnswer.String(answer))
+	}
+
+	_, span := tracer.Start(oteltrace.ContextWithSpan(context.Background(), parent), pending.name,
+		oteltrace.WithSpanKind(oteltrace.SpanKindClient),
+		oteltrace.WithTimestamp(pending.sent),
+		oteltrace.WithAttributes(attrs...),
+	)
+	span.End(oteltrace.WithTimestamp(end))
+}
+
+// currentSpan returns the span of the innermost open procedure, or of the
+// last procedure when none is open, for the messages that follow it such as
+// Registration Complete. Called with ue.mu held.
+func (ue *UE) currentSpan() oteltrace.Span {
+	for _, procedure := range spanOrder {
+		if span, ok := ue.spans[procedure]; ok {
+			return span
+		}
+	}
+
+	return ue.lastSpan
+}
+
+// spanAttributes identifies the UE by SUPI and by the NGAP IDs known so far.
+// Called with ue.mu held.
+func (ue *UE) spanAttributes() []attribute.KeyValue {
+	attrs := []attribute.KeyValue{telemetry.AttributeSUPI.String(ue.UeSecurity.Supi)}
+
+	if ue.knownRANUENGAPID >= 0 {
+		attrs = append(attrs, telemetry.AttributeRANUENGAPID.Int64(ue.knownRANUENGAPID))
+	}
+
+	if ue.knownAMFUENGAPID >= 0 {
+		attrs = append(attrs, telemetry.AttributeAMFUENGAPID.Int64(ue.knownAMFUENGAPID))
+	}
+
+	return attrs
+}
@@ -0,0 +1,164 @@
+package ue
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/metrics"
+	"github.com/ellanetworks/core-tester/internal/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+)
+
+var (
+	spanRecorder     *tracetest.SpanRecorder
+	spanRecorderOnce sync.Once
+)
+
+// recordSpans installs a tracer provider that keeps the ended spans in
+// memory. The global provider can only be set once, so the tests share it
+// and tell their spans apart by SUPI.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	spanRecorderOnce.Do(func() {
+		spanRecorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+	})
+
+	return spanRecorder
+}
+
+// endedSpans returns the ended spans of the UE of supi, by name.
+func endedSpans(t *testing.T, recorder *tracetest.SpanRecorder, supi string) map[string]sdktrace.ReadOnlySpan {
+	t.Helper()
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+
+	for _, span := range recorder.Ended() {
+		if attributes(span)[telemetry.AttributeSUPI] == attribute.StringValue(supi) {
+			spans[span.Name()] = span
+		}
+	}
+
+	return spans
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+
+	return attrs
+}
+
+func newSpanTestUE(supi string) *UE {
+	logger.UeLogger = zap.NewNop()
+
+	return &UE{
+		UeSecurity:       &UESecurity{Supi: supi},
+		knownRANUENGAPID: -1,
+		knownAMFUENGAPID: -1,
+	}
+}
+
+func TestRegistrationSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	ue := newSpanTestUE("imsi-001010000000001")
+
+	// As SendRegistrationRequest and the handlers call them.
+	ue.setNGAPIDs(1, -1)
+	ue.startProcedure(metrics.ProcedureRegistration)
+	ue.startProcedure(metrics.ProcedureAuthentication)
+	ue.spanNAS(true, "Registration Request")
+	ue.setNGAPIDs(1, 7)
+	ue.spanNAS(false, "Authentication Request")
+	ue.spanNAS(true, "Authentication Response")
+	ue.spanNAS(false, "Security Mode Command")
+	ue.endProcedure(metrics.ProcedureAuthentication)
+	ue.endProcedure(metrics.ProcedureRegistration)
+
+	spans := endedSpans(t, recorder, ue.UeSecurity.Supi)
+
+	parents := map[string]string{
+		"Registration Request":    "Authentication",
+		"Authentication Response": "Authentication",
+		"Authentication":          "Registration",
+	}
+
+	for name, parent := range parents {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("expected a %q span, got %d spans", name, len(spans))
+		}
+
+		if span.Parent().SpanID() != spans[parent].SpanContext().SpanID() {
+			t.Errorf("expected %q to be a child of %q", name, parent)
+		}
+	}
+
+	registration := spans["Registration"]
+	if registration.Parent().IsValid() {
+		t.Errorf("expected Registration to be a root span")
+	}
+
+	if registration.Status().Code != codes.Unset {
+		t.Errorf("expected Registration to succeed, got status %v", registration.Status())
+	}
+
+	for _, name := range []string{"Registration", "Authentication", "Authentication Response"} {
+		attrs := attributes(spans[name])
+
+		if attrs[telemetry.AttributeRANUENGAPID] != attribute.Int64Value(1) {
+			t.Errorf("expected %q to carry RAN UE NGAP ID 1, got %v", name, attrs[telemetry.AttributeRANUENGAPID].Emit())
+		}
+
+		if attrs[telemetry.AttributeAMFUENGAPID] != attribute.Int64Value(7) {
+			t.Errorf("expected %q to carry AMF UE NGAP ID 7, got %v", name, attrs[telemetry.AttributeAMFUENGAPID].Emit())
+		}
+	}
+
+	answer := attributes(spans["Authentication Response"])[telemetry.AttributeNASAnswer]
+	if answer != attribute.StringValue("Security Mode Command") {
+		t.Errorf("expected Authentication Response to be answered by Security Mode Command, got %q", answer.Emit())
+	}
+}
+
+func TestFailedRegistrationSpan(t *testing.T) {
+	recorder := recordSpans(t)
+	ue := newSpanTestUE("imsi-001010000000002")
+
+	ue.startProcedure(metrics.ProcedureRegistration)
+	ue.spanNAS(true, "Registration Request")
+	ue.cancelProcedure(metrics.ProcedureRegistration, metrics.CauseTimeout)
+
+	spans := endedSpans(t, recorder, ue.UeSecurity.Supi)
+
+	registration, ok := spans["Registration"]
+	if !ok {
+		t.Fatalf("expected a Registration span")
+	}
+
+	if registration.Status().Code != codes.Error || registration.Status().Description != metrics.CauseTimeout {
+		t.Errorf("expected Registration to fail with %q, got status %v", metrics.CauseTimeout, registration.Status())
+	}
+
+	if _, ok := attributes(registration)[telemetry.AttributeRANUENGAPID]; ok {
+		t.Errorf("expected no RAN UE NGAP ID before it is known")
+	}
+
+	request, ok := spans["Registration Request"]
+	if !ok {
+		t.Fatalf("expected the unanswered Registration Request to end with its procedure")
+	}
+
+	if _, ok := attributes(request)[telemetry.AttributeNASAnswer]; ok {
+		t.Errorf("expected the unanswered Registration Request to have no answer")
+	}
+}
@@ -5,21 +5,30 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"maps"
+	"net/netip"
 	"regexp"
+	"slices"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ellanetworks/core-tester/internal/air"
+	"github.com/ellanetworks/core-tester/internal/latency"
 	"github.com/ellanetworks/core-tester/internal/logger"
+	"github.com/ellanetworks/core-tester/internal/metrics"
+	"github.com/ellanetworks/core-tester/internal/trace"
 	"github.com/ellanetworks/core-tester/internal/ue/sidf"
 	"github.com/free5gc/nas"
+	"github.com/free5gc/nas/nasMessage"
 	"github.com/free5gc/nas/nasType"
 	"github.com/free5gc/nas/security"
 	"github.com/free5gc/ngap/ngapType"
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/util/milenage"
 	"github.com/free5gc/util/ueauth"
+	oteltrace "go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -67,6 +76,9 @@ type PDUSessionInfo struct {
 	MTU               uint16
 	QFI               uint8
 	PDUSessionVersion uint8
+	SSCMode           uint8
+	DNSServers        []netip.Addr
+	PCSCFAddresses    []netip.Addr
 }
 
 type UE struct {
@@ -75,6 +87,8 @@ type UE struct {
 	DNN                    string
 	PDUSessionID           uint8
 	PDUSessionType         uint8
+	SSCMode                uint8
+	AllowedSSCModes        []uint8
 	Snssai                 models.Snssai
 	amfInfo                Amf
 	IMEISV                 string
@@ -85,6 +99,17 @@ type UE struct {
 	receivedNASGMMMessages map[uint8][]*nas.Message // msgType -> gmm messages
 	receivedNASGSMMessages map[uint8][]*nas.Message // msgType -> gsm messages
 	receivedRRCRelease     bool
+	nasCapture             NASCapture
+	trace                  *trace.Recorder
+	procedureStarts        map[string]time.Time // procedure -> time its first message was sent
+	latency                *latency.Recorder
+	legStarts              map[nasLeg]time.Time // leg -> time its request was sent
+	ueLogger               atomic.Pointer[zap.Logger]
+	knownRANUENGAPID       int64 // NGAP IDs of the UE, -1 until known
+	knownAMFUENGAPID       int64
+	spans                  map[string]oteltrace.Span // procedure -> its open span
+	lastSpan               oteltrace.Span            // span of the procedure started last
+	pendingNAS             *pendingNAS               // uplink NAS message awaiting its answer
 }
 
 func (ue *UE) SetPDUSession(pduSession PDUSessionInfo) {
@@ -95,6 +120,14 @@ func (ue *UE) SetPDUSession(pduSession PDUSessionInfo) {
 	ue.cond.Broadcast()
 }
 
+func (ue *UE) removePDUSession(pduSessionID uint8) {
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	delete(ue.PDUSessions, pduSessionID)
+	ue.cond.Broadcast()
+}
+
 func (ue *UE) GetPDUSession(pduSessionID uint8) PDUSessionInfo {
 	ue.mu.Lock()
 	defer ue.mu.Unlock()
@@ -102,6 +135,44 @@ func (ue *UE) GetPDUSession(pduSessionID uint8) PDUSessionInfo {
 	return ue.PDUSessions[pduSessionID]
 }
 
+// GetPDUSessions returns a copy of the PDU sessions of the UE.
+func (ue *UE) GetPDUSessions() map[uint8]PDUSessionInfo {
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	return maps.Clone(ue.PDUSessions)
+}
+
+func (ue *UE) GetStateMM() int {
+	ue.mu.Lock()
+	defer ue.mu.Unlock()
+
+	return ue.StateMM
+}
+
+// CheckSSCMode verifies the SSC mode selected by the network for a PDU
+// session (TS 24.501 6.4.1.2). It must be the mode the UE requested or, when
+// the UE let the network choose, one of the modes its policy allows.
+func (ue *UE) CheckSSCMode(session PDUSessionInfo) error {
+	if session.SSCMode < 1 || session.SSCMode > 3 {
+		return fmt.Errorf("network selected invalid SSC mode %d for PDU session %d", session.SSCMode, session.PDUSessionID)
+	}
+
+	if ue.SSCMode != 0 {
+		if session.SSCMode != ue.SSCMode {
+			return fmt.Errorf("network selected SSC mode %d for PDU session %d, but the UE requested SSC mode %d", session.SSCMode, session.PDUSessionID, ue.SSCMode)
+		}
+
+		return nil
+	}
+
+	if len(ue.AllowedSSCModes) == 0 || slices.Contains(ue.AllowedSSCModes, session.SSCMode) {
+		return nil
+	}
+
+	return fmt.Errorf("network selected SSC mode %d for PDU session %d, which is not in the allowed SSC modes %v", session.SSCMode, session.PDUSessionID, ue.AllowedSSCModes)
+}
+
 func (ue *UE) WaitForPDUSession(pduSessionID uint8, timeout time.Duration) (PDUSessionInfo, error) {
 	deadline := time.Now().Add(timeout)
 
@@ -129,6 +200,8 @@ func (ue *UE) WaitForPDUSession(pduSessionID uint8, timeout time.Duration) (PDUS
 type UEOpts struct {
 	PDUSessionID         uint8
 	PDUSessionType       uint8
+	SSCMode              uint8   // requested SSC mode, 0 to let the network select it
+	AllowedSSCModes      []uint8 // SSC modes accepted when none is requested, empty for any
 	Msin                 string
 	UeSecurityCapability *nasType.UESecurityCapability
 	K                    string
@@ -145,6 +218,9 @@ type UEOpts struct {
 	IMEISV               string
 	Guti                 *nasType.GUTI5G
 	GnodeB               air.UplinkSender
+	NASCapture           NASCapture        // receives the plaintext of protected NAS messages, if set
+	Trace                *trace.Recorder   // records the NAS messages exchanged with the AMF, if set
+	Latency              *latency.Recorder // records how long the core takes to answer each request, if set
 }
 
 func NewUE(opts *UEOpts) (*UE, error) {
@@ -153,8 +229,13 @@ func NewUE(opts *UEOpts) (*UE, error) {
 	ue.UeSecurity.Msin = opts.Msin
 	ue.UeSecurity.UeSecurityCapability = opts.UeSecurityCapability
 	ue.Gnb = opts.GnodeB
+	ue.nasCapture = opts.NASCapture
+	ue.trace = opts.Trace
+	ue.latency = opts.Latency
 	ue.PDUSessionID = opts.PDUSessionID
 	ue.PDUSessionType = opts.PDUSessionType
+	ue.SSCMode = opts.SSCMode
+	ue.AllowedSSCModes = opts.AllowedSSCModes
 
 	integAlg, cipherAlg, err := SelectAlgorithms(ue.UeSecurity.UeSecurityCapability)
 	if err != nil {
@@ -203,6 +284,9 @@ func NewUE(opts *UEOpts) (*UE, error) {
 	ue.StateMM = MM5G_NULL
 	ue.cond = sync.NewCond(&ue.mu)
 
+	ue.knownRANUENGAPID, ue.knownAMFUENGAPID = -1, -1
+	ue.ueLogger.Store(logger.UeLogger.With(zap.String("SUPI", ue.UeSecurity.Supi)))
+
 	return &ue, nil
 }
 
@@ -485,7 +569,13 @@ func (ue *UE) GetSuci() nasType.MobileIdentity5GS {
 	return ue.UeSecurity.Suci
 }
 
+func (ue *UE) GetSupi() string {
+	return ue.UeSecurity.Supi
+}
+
 func (ue *UE) SendDownlinkNAS(msg []byte, amfUENGAPID int64, ranUENGAPID int64) error {
+	ue.setNGAPIDs(ranUENGAPID, amfUENGAPID)
+
 	decodedMsg, err := ue.DecodeNAS(msg)
 	if err != nil {
 		return fmt.Errorf("could not decode NAS message: %v", err)
@@ -534,8 +624,13 @@ func (ue *UE) SendDownlinkNAS(msg []byte, amfUENGAPID int64, ranUENGAPID int64)
 		if err != nil {
 			return fmt.Errorf("could not handle Service Accept: %v", err)
 		}
+	case nas.MsgTypeServiceReject:
+		err := handleServiceReject(ue, decodedMsg)
+		if err != nil {
+			return fmt.Errorf("could not handle Service Reject: %v", err)
+		}
 	case nas.MsgTypeDLNASTransport:
-		err := handleDLNASTransport(ue, decodedMsg)
+		err := handleDLNASTransport(ue, decodedMsg, amfUENGAPID, ranUENGAPID)
 		if err != nil {
 			return fmt.Errorf("could not handle DL NAS Transport: %v", err)
 		}
@@ -545,7 +640,7 @@ func (ue *UE) SendDownlinkNAS(msg []byte, amfUENGAPID int64, ranUENGAPID int64)
 			return fmt.Errorf("could not handle Configuration Update Command: %v", err)
 		}
 	default:
-		logger.UeLogger.Warn("NAS message type not implemented", zap.Uint8("msgType", msgType))
+		ue.log().Warn("NAS message type not implemented", zap.Uint8("msgType", msgType))
 	}
 
 	updateReceivedGMMMessages(ue, decodedMsg)
@@ -553,10 +648,22 @@ func (ue *UE) SendDownlinkNAS(msg []byte, amfUENGAPID int64, ranUENGAPID int64)
 	return nil
 }
 
+// RRCRelease notes the release of the UE's connection. A registered UE
+// becomes idle and keeps its PDU sessions; a deregistered one loses them.
 func (ue *UE) RRCRelease() {
+	ue.endProcedure(metrics.ProcedureDeregistration)
+
+	if ue.GetStateMM() == MM5G_REGISTERED {
+		ue.setStateMM(MM5G_IDLE)
+	}
+
 	ue.mu.Lock()
 	defer ue.mu.Unlock()
 
+	if ue.StateMM == MM5G_DEREGISTERED {
+		clear(ue.PDUSessions)
+	}
+
 	ue.receivedRRCRelease = true
 	ue.cond.Broadcast()
 }
@@ -568,7 +675,7 @@ func updateReceivedGMMMessages(ue *UE, msg *nas.Message) {
 	msgType := msg.GmmMessage.GetMessageType()
 	ue.receivedNASGMMMessages[msgType] = append(ue.receivedNASGMMMessages[msgType], msg)
 
-	logger.UeLogger.Debug("Stored received NAS GMM Message", zap.String("msgType", getGMMMessageName(msgType)), zap.Int("totalFrames", len(ue.receivedNASGMMMessages[msgType])))
+	ue.log().Debug("Stored received NAS GMM Message", zap.String("msgType", getGMMMessageName(msgType)), zap.Int("totalFrames", len(ue.receivedNASGMMMessages[msgType])))
 	ue.cond.Broadcast()
 }
 
@@ -579,7 +686,7 @@ func updateReceivedGSMMessages(ue *UE, msg *nas.Message) {
 	msgType := msg.GsmMessage.GetMessageType()
 	ue.receivedNASGSMMessages[msgType] = append(ue.receivedNASGSMMessages[msgType], msg)
 
-	logger.UeLogger.Debug("Stored received NAS GSM Message", zap.String("msgType", getGSMMessageName(msgType)), zap.Int("totalFrames", len(ue.receivedNASGSMMessages[msgType])))
+	ue.log().Debug("Stored received NAS GSM Message", zap.String("msgType", getGSMMessageName(msgType)), zap.Int("totalFrames", len(ue.receivedNASGSMMessages[msgType])))
 	ue.cond.Broadcast()
 }
 
@@ -688,15 +795,27 @@ func (ue *UE) SendRegistrationRequest(ranUENGAPID int64, regType uint8) error {
 		return fmt.Errorf("could not build Registration Request NAS PDU: %v", err)
 	}
 
+	ue.setNGAPIDs(ranUENGAPID, -1)
+	ue.observeNAS(true, nasPDU)
+	ue.startProcedure(metrics.ProcedureRegistration)
+	ue.startProcedure(metrics.ProcedureAuthentication)
+
+	previous := ue.GetStateMM()
+	ue.setStateMM(MM5G_REGISTERED_INITIATED)
+
 	err = ue.Gnb.SendInitialUEMessage(nasPDU, ranUENGAPID, ue.UeSecurity.Guti, ngapType.RRCEstablishmentCausePresentMoSignalling)
 	if err != nil {
+		// The request never reached the core: the UE stays where it was.
+		ue.cancelProcedure(metrics.ProcedureAuthentication, err.Error())
+		ue.cancelProcedure(metrics.ProcedureRegistration, err.Error())
+		ue.setStateMM(previous)
+
 		return fmt.Errorf("could not send UplinkNASTransport: %v", err)
 	}
 
-	logger.UeLogger.Debug(
-		"Sent Registration Request NAS message",
-		zap.String("IMSI", ue.UeSecurity.Supi),
-	)
+	metrics.RegistrationAttempts.Inc()
+
+	ue.log().Debug("Sent Registration Request NAS message")
 
 	return nil
 }
@@ -715,15 +834,86 @@ func (ue *UE) SendDeregistrationRequest(amfUENGAPID int64, ranUENGAPID int64) er
 		return fmt.Errorf("error encoding %s IMSI UE NAS Deregistration Msg", ue.UeSecurity.Supi)
 	}
 
+	ue.startProcedure(metrics.ProcedureDeregistration)
+
 	err = ue.Gnb.SendUplinkNAS(encodedPdu, amfUENGAPID, ranUENGAPID)
 	if err != nil {
 		return fmt.Errorf("could not send UplinkNASTransport: %v", err)
 	}
 
-	logger.UeLogger.Debug(
-		"Sent Deregistration Request NAS message",
-		zap.String("IMSI", ue.UeSecurity.Supi),
-	)
+	ue.setStateMM(MM5G_DEREGISTERED)
+
+	ue.log().Debug("Sent Deregistration Request NAS message")
+
+	return nil
+}
+
+// SendServiceRequest asks the network to reconnect an idle UE and to
+// reactivate the user plane of its PDU sessions.
+func (ue *UE) SendServiceRequest(ranUENGAPID int64) error {
+	if ue.UeSecurity.Guti == nil {
+		return fmt.Errorf("UE has no 5G-GUTI: it must register first")
+	}
+
+	var sessions [16]bool
+
+	for id := range ue.GetPDUSessions() {
+		if id < 16 {
+			sessions[id] = true
+		}
+	}
+
+	serviceType := nasMessage.ServiceTypeSignalling
+	cause := ngapType.RRCEstablishmentCausePresentMoSignalling
+
+	if sessions != [16]bool{} {
+		serviceType = nasMessage.ServiceTypeData
+		cause = ngapType.RRCEstablishmentCausePresentMoData
+	}
+
+	full, err := BuildServiceRequest(&ServiceRequestOpts{
+		ServiceType: serviceType,
+		Ksi:         ue.UeSecurity.NgKsi.Ksi,
+		Guti:        ue.UeSecurity.Guti,
+		PDUSessions: &sessions,
+	})
+	if err != nil {
+		return fmt.Errorf("could not build Service Request NAS PDU: %v", err)
+	}
+
+	// The full message is ciphered with the count of the message that
+	// carries it.
+	err = security.NASEncrypt(ue.UeSecurity.CipheringAlg, ue.UeSecurity.KnasEnc, ue.UeSecurity.ULCount.Get(), security.Bearer3GPP, security.DirectionUplink, full)
+	if err != nil {
+		return fmt.Errorf("could not cipher Service Request NAS message container: %v", err)
+	}
+
+	cleartext, err := BuildServiceRequest(&ServiceRequestOpts{
+		ServiceType:         serviceType,
+		Ksi:                 ue.UeSecurity.NgKsi.Ksi,
+		Guti:                ue.UeSecurity.Guti,
+		NASMessageContainer: full,
+	})
+	if err != nil {
+		return fmt.Errorf("could not build Service Request NAS PDU: %v", err)
+	}
+
+	ue.setNGAPIDs(ranUENGAPID, -1)
+
+	encodedPdu, err := ue.EncodeNasPduWithSecurity(cleartext, nas.SecurityHeaderTypeIntegrityProtected)
+	if err != nil {
+		return fmt.Errorf("error encoding %s IMSI UE NAS Service Request Msg", ue.UeSecurity.Supi)
+	}
+
+	ue.startProcedure(metrics.ProcedureServiceRequest)
+	ue.setStateMM(MM5G_SERVICE_REQ_INIT)
+
+	err = ue.Gnb.SendInitialUEMessage(encodedPdu, ranUENGAPID, ue.UeSecurity.Guti, cause)
+	if err != nil {
+		return fmt.Errorf("could not send InitialUEMessage: %v", err)
+	}
+
+	ue.log().Debug("Sent Service Request NAS message", zap.Uint8("service type", serviceType))
 
 	return nil
 }
@@ -732,6 +922,7 @@ func (ue *UE) SendPDUSessionEstablishmentRequest(amfUENGAPID int64, ranUENGAPID
 	pduReq, err := BuildPduSessionEstablishmentRequest(&PduSessionEstablishmentRequestOpts{
 		PDUSessionID:   pduSessionID,
 		PDUSessionType: ue.PDUSessionType,
+		SSCMode:        ue.SSCMode,
 	})
 	if err != nil {
 		return fmt.Errorf("could not build PDU Session Establishment Request: %v", err)
@@ -752,15 +943,51 @@ func (ue *UE) SendPDUSessionEstablishmentRequest(amfUENGAPID int64, ranUENGAPID
 		return fmt.Errorf("error encoding %s IMSI UE NAS Uplink NAS Transport for PDU Session Msg", ue.UeSecurity.Supi)
 	}
 
+	ue.startProcedure(metrics.ProcedurePDUSessionEstablishment)
+
 	err = ue.Gnb.SendUplinkNAS(encodedPdu, amfUENGAPID, ranUENGAPID)
 	if err != nil {
 		return fmt.Errorf("could not send UplinkNASTransport for PDU Session Establishment: %v", err)
 	}
 
-	logger.UeLogger.Debug(
-		"Sent PDU Session Establishment Request",
-		zap.String("IMSI", ue.UeSecurity.Supi),
-	)
+	ue.log().Debug("Sent PDU Session Establishment Request")
+
+	return nil
+}
+
+// SendPDUSessionReleaseRequest asks the network to release a PDU session of
+// a connected UE (TS 24.501 6.4.3).
+func (ue *UE) SendPDUSessionReleaseRequest(amfUENGAPID int64, ranUENGAPID int64, pduSessionID uint8) error {
+	pduReq, err := BuildPDUSessionReleaseRequest(&PDUSessionReleaseRequestOpts{
+		PDUSessionID: pduSessionID,
+		Cause:        nasMessage.Cause5GSMRegularDeactivation,
+	})
+	if err != nil {
+		return fmt.Errorf("could not build PDU Session Release Request: %v", err)
+	}
+
+	pduUplink, err := BuildUplinkNasTransport(&UplinkNasTransportOpts{
+		PDUSessionID:     pduSessionID,
+		PayloadContainer: pduReq,
+		Established:      true,
+	})
+	if err != nil {
+		return fmt.Errorf("could not build Uplink NAS Transport for PDU Session Release: %v", err)
+	}
+
+	encodedPdu, err := ue.EncodeNasPduWithSecurity(pduUplink, nas.SecurityHeaderTypeIntegrityProtectedAndCiphered)
+	if err != nil {
+		return fmt.Errorf("error encoding %s IMSI UE NAS Uplink NAS Transport for PDU Session Release Msg", ue.UeSecurity.Supi)
+	}
+
+	ue.startProcedure(metrics.ProcedurePDUSessionRelease)
+
+	err = ue.Gnb.SendUplinkNAS(encodedPdu, amfUENGAPID, ranUENGAPID)
+	if err != nil {
+		return fmt.Errorf("could not send UplinkNASTransport for PDU Session Release: %v", err)
+	}
+
+	ue.log().Debug("Sent PDU Session Release Request", zap.Uint8("PDU Session ID", pduSessionID))
 
 	return nil
 }
@@ -0,0 +1,236 @@
+package uestack
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ellanetworks/core-tester/internal/logger"
+	"go.uber.org/zap"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// SOCKS5 protocol values, RFC 1928
+const (
+	socksVersion          = 0x05
+	socksMethodNoAuth     = 0x00
+	socksMethodNoneUsable = 0xff
+	socksCommandConnect   = 0x01
+	socksAddressIPv4      = 0x01
+	socksAddressDomain    = 0x03
+	socksAddressIPv6      = 0x04
+
+	socksReplySucceeded           = 0x00
+	socksReplyGeneralFailure      = 0x01
+	socksReplyHostUnreachable     = 0x04
+	socksReplyConnectionRefused   = 0x05
+	socksReplyCommandNotSupported = 0x07
+	socksReplyAddressNotSupported = 0x08
+
+	socksDialTimeout = 10 * time.Second
+)
+
+// ServeSOCKS5 accepts SOCKS5 clients on l and relays their CONNECT requests
+// from the UE address through the tunnel. Only the "no authentication"
+// method is offered, so l should not be reachable by untrusted clients.
+// It returns when l is closed.
+func (s *Stack) ServeSOCKS5(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return fmt.Errorf("could not accept SOCKS5 client: %v", err)
+		}
+
+		go func() {
+			err := s.handleSOCKS5(conn)
+			if err != nil {
+				logger.Logger.Debug("SOCKS5 request failed", zap.String("client", conn.RemoteAddr().String()), zap.Error(err))
+			}
+		}()
+	}
+}
+
+func (s *Stack) handleSOCKS5(client net.Conn) error {
+	defer func() {
+		_ = client.Close()
+	}()
+
+	// Method selection
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(client, header); err != nil {
+		return fmt.Errorf("could not read greeting: %v", err)
+	}
+
+	if header[0] != socksVersion {
+		return fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(client, methods); err != nil {
+		return fmt.Errorf("could not read authentication methods: %v", err)
+	}
+
+	method := byte(socksMethodNoneUsable)
+
+	for _, m := range methods {
+		if m == socksMethodNoAuth {
+			method = socksMethodNoAuth
+		}
+	}
+
+	if _, err := client.Write([]byte{socksVersion, method}); err != nil {
+		return fmt.Errorf("could not select authentication method: %v", err)
+	}
+
+	if method == socksMethodNoneUsable {
+		return fmt.Errorf("client does not support unauthenticated access")
+	}
+
+	// Request
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(client, request); err != nil {
+		return fmt.Errorf("could not read request: %v", err)
+	}
+
+	host, err := readSOCKS5Address(client, request[3])
+	if err != nil {
+		_ = writeSOCKS5Reply(client, socksReplyAddressNotSupported, nil)
+		return err
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(client, port); err != nil {
+		return fmt.Errorf("could not read destination port: %v", err)
+	}
+
+	if request[1] != socksCommandConnect {
+		_ = writeSOCKS5Reply(client, socksReplyCommandNotSupported, nil)
+		return fmt.Errorf("unsupported command %d", request[1])
+	}
+
+	address := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+
+	ctx, cancel := context.WithTimeout(context.Background(), socksDialTimeout)
+	defer cancel()
+
+	upstream, err := s.DialContext(ctx, "tcp", address)
+	if err != nil {
+		_ = writeSOCKS5Reply(client, dialErrorReply(err), nil)
+		return fmt.Errorf("could not connect to %s: %v", address, err)
+	}
+
+	defer func() {
+		_ = upstream.Close()
+	}()
+
+	if err := writeSOCKS5Reply(client, socksReplySucceeded, upstream.LocalAddr()); err != nil {
+		return err
+	}
+
+	relay(client, upstream)
+
+	return nil
+}
+
+func readSOCKS5Address(r io.Reader, addressType byte) (string, error) {
+	switch addressType {
+	case socksAddressIPv4, socksAddressIPv6:
+		size := 4
+		if addressType == socksAddressIPv6 {
+			size = 16
+		}
+
+		b := make([]byte, size)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", fmt.Errorf("could not read destination address: %v", err)
+		}
+
+		addr, _ := netip.AddrFromSlice(b)
+
+		return addr.String(), nil
+	case socksAddressDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return "", fmt.Errorf("could not read destination name length: %v", err)
+		}
+
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", fmt.Errorf("could not read destination name: %v", err)
+		}
+
+		return string(name), nil
+	default:
+		return "", fmt.Errorf("unsupported address type %d", addressType)
+	}
+}
+
+func writeSOCKS5Reply(w io.Writer, reply byte, bound net.Addr) error {
+	b := []byte{socksVersion, reply, 0x00}
+
+	addrPort := netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
+	if tcpAddr, ok := bound.(*net.TCPAddr); ok {
+		addrPort = tcpAddr.AddrPort()
+	}
+
+	if addrPort.Addr().Unmap().Is4() {
+		b = append(b, socksAddressIPv4)
+	} else {
+		b = append(b, socksAddressIPv6)
+	}
+
+	b = append(b, addrPort.Addr().Unmap().AsSlice()...)
+	b = binary.BigEndian.AppendUint16(b, addrPort.Port())
+
+	if _, err := w.Write(b); err != nil {
+		return fmt.Errorf("could not write reply: %v", err)
+	}
+
+	return nil
+}
+
+func dialErrorReply(err error) byte {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return socksReplyHostUnreachable
+	// netstack only reports its errors as text.
+	case strings.Contains(err.Error(), (&tcpip.ErrConnectionRefused{}).String()):
+		return socksReplyConnectionRefused
+	default:
+		return socksReplyGeneralFailure
+	}
+}
+
+// relay copies data in both directions until both sides are done.
+func relay(a, b net.Conn) {
+	var wg sync.WaitGroup
+
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+
+		_, _ = io.Copy(dst, src)
+
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
+		}
+	}
+
+	wg.Add(2)
+
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+
+	wg.Wait()
+}
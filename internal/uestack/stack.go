@@ -0,0 +1,268 @@
+// Package uestack runs a UE's IP stack in userspace on top of gVisor's
+// netstack. It stands in for a TUN device: the tunnel reads the packets the
+// stack sends and writes the downlink packets into it, while applications
+// reach the network through Go dialers or a SOCKS5 proxy bound to the UE
+// address. No kernel interface, route or capability is needed.
+package uestack
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/icmp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+const (
+	nicID          = 1
+	queueSize      = 1024
+	defaultMTU     = 1400
+	dnsServicePort = 53
+)
+
+type Opts struct {
+	IPv4       netip.Addr
+	IPv6       netip.Addr
+	MTU        uint16
+	DNSServers []netip.Addr // used to resolve host names given to DialContext
+}
+
+type Stack struct {
+	stack      *stack.Stack
+	ep         *channel.Endpoint
+	ipv4       netip.Addr
+	ipv6       netip.Addr
+	dnsServers []netip.Addr
+	ctx        context.Context
+	cancel     context.CancelFunc
+	closeOnce  sync.Once
+}
+
+func New(opts *Opts) (*Stack, error) {
+	if !opts.IPv4.IsValid() && !opts.IPv6.IsValid() {
+		return nil, fmt.Errorf("a UE address is required")
+	}
+
+	mtu := uint32(opts.MTU)
+	if mtu == 0 {
+		mtu = defaultMTU
+	}
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol, icmp.NewProtocol4, icmp.NewProtocol6},
+		HandleLocal:        true,
+	})
+
+	ep := channel.New(queueSize, mtu, "")
+
+	if err := s.CreateNIC(nicID, ep); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("could not create userspace NIC: %s", err)
+	}
+
+	var routes []tcpip.Route
+
+	if opts.IPv4.IsValid() {
+		err := s.AddProtocolAddress(nicID, tcpip.ProtocolAddress{
+			Protocol:          ipv4.ProtocolNumber,
+			AddressWithPrefix: tcpip.AddrFromSlice(opts.IPv4.AsSlice()).WithPrefix(),
+		}, stack.AddressProperties{})
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("could not assign UE IPv4 address %s: %s", opts.IPv4, err)
+		}
+
+		routes = append(routes, tcpip.Route{Destination: header.IPv4EmptySubnet, NIC: nicID})
+	}
+
+	if opts.IPv6.IsValid() {
+		err := s.AddProtocolAddress(nicID, tcpip.ProtocolAddress{
+			Protocol:          ipv6.ProtocolNumber,
+			AddressWithPrefix: tcpip.AddrFromSlice(opts.IPv6.AsSlice()).WithPrefix(),
+		}, stack.AddressProperties{})
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("could not assign UE IPv6 address %s: %s", opts.IPv6, err)
+		}
+
+		routes = append(routes, tcpip.Route{Destination: header.IPv6EmptySubnet, NIC: nicID})
+	}
+
+	s.SetRouteTable(routes)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Stack{
+		stack:      s,
+		ep:         ep,
+		ipv4:       opts.IPv4,
+		ipv6:       opts.IPv6,
+		dnsServers: opts.DNSServers,
+		ctx:        ctx,
+		cancel:     cancel,
+	}, nil
+}
+
+// Read returns the next packet sent by the stack, to be carried uplink.
+func (s *Stack) Read(p []byte) (int, error) {
+	pkt := s.ep.ReadContext(s.ctx)
+	if pkt == nil {
+		return 0, net.ErrClosed
+	}
+	defer pkt.DecRef()
+
+	view := pkt.ToView()
+	defer view.Release()
+
+	return copy(p, view.AsSlice()), nil
+}
+
+// Write delivers a downlink packet to the stack.
+func (s *Stack) Write(p []byte) (int, error) {
+	if s.ctx.Err() != nil {
+		return 0, net.ErrClosed
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	var proto tcpip.NetworkProtocolNumber
+
+	switch header.IPVersion(p) {
+	case header.IPv4Version:
+		proto = ipv4.ProtocolNumber
+	case header.IPv6Version:
+		proto = ipv6.ProtocolNumber
+	default:
+		return 0, fmt.Errorf("not an IP packet")
+	}
+
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(p),
+	})
+	s.ep.InjectInbound(proto, pkt)
+	pkt.DecRef()
+
+	return len(p), nil
+}
+
+func (s *Stack) Close() error {
+	s.closeOnce.Do(func() {
+		s.cancel()
+		s.ep.Close()
+		s.stack.Close()
+	})
+
+	return nil
+}
+
+// DialContext connects to the address on the named network ("tcp", "tcp4",
+// "tcp6", "udp", "udp4" or "udp6") from the UE address, with the same
+// semantics as net.Dialer.DialContext. Host names are resolved through the
+// DNS servers assigned to the UE.
+func (s *Stack) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q: %v", portString, err)
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		addr, err = s.resolve(ctx, network, host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.dial(ctx, network, netip.AddrPortFrom(addr.Unmap(), uint16(port)))
+}
+
+func (s *Stack) dial(ctx context.Context, network string, addrPort netip.AddrPort) (net.Conn, error) {
+	full := tcpip.FullAddress{
+		NIC:  nicID,
+		Addr: tcpip.AddrFromSlice(addrPort.Addr().AsSlice()),
+		Port: addrPort.Port(),
+	}
+
+	proto := ipv4.ProtocolNumber
+	if addrPort.Addr().Is6() {
+		proto = ipv6.ProtocolNumber
+	}
+
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return gonet.DialContextTCP(ctx, s.stack, full, proto)
+	case "udp", "udp4", "udp6":
+		return gonet.DialUDP(s.stack, nil, &full, proto)
+	default:
+		return nil, fmt.Errorf("unsupported network %q", network)
+	}
+}
+
+// Resolver returns a resolver that sends its queries from the UE through the
+// tunnel to the DNS servers assigned by the network.
+func (s *Stack) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+			if len(s.dnsServers) == 0 {
+				return nil, fmt.Errorf("the network has not assigned any DNS server")
+			}
+
+			var lastErr error
+
+			for _, server := range s.dnsServers {
+				conn, err := s.dial(ctx, network, netip.AddrPortFrom(server, dnsServicePort))
+				if err == nil {
+					return conn, nil
+				}
+
+				lastErr = err
+			}
+
+			return nil, lastErr
+		},
+	}
+}
+
+func (s *Stack) resolve(ctx context.Context, network string, host string) (netip.Addr, error) {
+	ipNetwork := "ip"
+
+	switch {
+	case network == "tcp4" || network == "udp4" || !s.ipv6.IsValid():
+		ipNetwork = "ip4"
+	case network == "tcp6" || network == "udp6" || !s.ipv4.IsValid():
+		ipNetwork = "ip6"
+	}
+
+	addrs, err := s.Resolver().LookupNetIP(ctx, ipNetwork, host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("could not resolve %s: %v", host, err)
+	}
+
+	if len(addrs) == 0 {
+		return netip.Addr{}, fmt.Errorf("no address found for %s", host)
+	}
+
+	return addrs[0], nil
+}